@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	abuseEnabled          bool          // Whether message heuristics throttle and restrict abusive clients
+	abuseRateLimit        int           // Messages allowed per window before it counts as a spike
+	abuseRepeatLimit      int           // Identical messages allowed per window
+	abuseMaxLength        int           // Longer messages count as abuse
+	abuseWindow           time.Duration // Window the rate and repeat heuristics look at
+	abuseThrottle         time.Duration // How long a first offender's messages are refused
+	abuseStrikes          int           // Offences before a client is shadow-restricted
+	abuseRestrictDuration time.Duration // How long a shadow restriction lasts
+)
+
+// abuseTracker is what the heuristics remember about one client
+type abuseTracker struct {
+	sent            []time.Time
+	hashes          map[[32]byte][]time.Time
+	strikes         int
+	throttledUntil  time.Time
+	restrictedUntil time.Time
+}
+
+// Trackers by client key
+var (
+	abuseMu       sync.Mutex
+	abuseTrackers = make(map[string]*abuseTracker)
+)
+
+// AbuseEvent is an automatic action waiting for an admin to review it
+type AbuseEvent struct {
+	ID         int        `json:"id"`
+	Client     string     `json:"client"` // "user:<name>" or "ip:<address>"
+	RoomID     int        `json:"room_id"`
+	Reason     string     `json:"reason"` // rate_spike, repeated_content or excessive_length
+	Action     string     `json:"action"` // throttle or restrict
+	Excerpt    string     `json:"excerpt"`
+	Status     string     `json:"status"` // pending, upheld or dismissed
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+}
+
+// initAbuse reads the abuse heuristics and creates the review queue table
+func initAbuse() {
+	abuseEnabled = getEnvBool("ABUSE_DETECTION_ENABLED", false)
+	abuseRateLimit = max(1, getEnvInt("ABUSE_RATE_LIMIT", 20))
+	abuseRepeatLimit = max(1, getEnvInt("ABUSE_REPEAT_LIMIT", 3))
+	abuseMaxLength = max(1, getEnvInt("ABUSE_MAX_LENGTH", 8000))
+	abuseWindow = getEnvDuration("ABUSE_WINDOW", time.Minute)
+	abuseThrottle = getEnvDuration("ABUSE_THROTTLE", 5*time.Minute)
+	abuseStrikes = max(1, getEnvInt("ABUSE_STRIKES", 3))
+	abuseRestrictDuration = getEnvDuration("ABUSE_RESTRICT_DURATION", 24*time.Hour)
+
+	if !abuseEnabled {
+		return
+	}
+	createAbuseEventsTable()
+	log.Printf("🛡️ Abuse detection enabled (%d messages or %d repeats per %s)", abuseRateLimit, abuseRepeatLimit, abuseWindow)
+}
+
+// Create `abuse_events` table if it doesn't exist
+func createAbuseEventsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS abuse_events (
+			id SERIAL PRIMARY KEY,
+			client TEXT NOT NULL,
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			reason TEXT NOT NULL,
+			action TEXT NOT NULL,
+			excerpt TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'pending',
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			reviewed_at TIMESTAMPTZ
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create abuse_events table:", err)
+	}
+	log.Println("✅ Table abuse_events is ready")
+}
+
+// checkAbuse runs the heuristics on an incoming message. It returns "" to let the
+// message through, "throttle" if it must be refused, or "restrict" if it should be
+// silently kept from the room and the AI.
+func checkAbuse(s *Session, text string) string {
+	if !abuseEnabled {
+		return ""
+	}
+	key := s.clientKey()
+	now := time.Now()
+
+	abuseMu.Lock()
+	if len(abuseTrackers) > 10000 {
+		pruneAbuseTrackers(now)
+	}
+	t, ok := abuseTrackers[key]
+	if !ok {
+		t = &abuseTracker{hashes: make(map[[32]byte][]time.Time)}
+		abuseTrackers[key] = t
+	}
+	if now.Before(t.restrictedUntil) {
+		abuseMu.Unlock()
+		return "restrict"
+	}
+	if now.Before(t.throttledUntil) {
+		abuseMu.Unlock()
+		return "throttle"
+	}
+
+	since := now.Add(-abuseWindow)
+	t.sent = append(recentTimes(t.sent, since), now)
+	hash := sha256.Sum256([]byte(strings.TrimSpace(strings.ToLower(text))))
+	for h, times := range t.hashes {
+		if recent := recentTimes(times, since); len(recent) > 0 {
+			t.hashes[h] = recent
+		} else {
+			delete(t.hashes, h)
+		}
+	}
+	t.hashes[hash] = append(t.hashes[hash], now)
+
+	reason := ""
+	switch {
+	case len(text) > abuseMaxLength:
+		reason = "excessive_length"
+	case len(t.sent) > abuseRateLimit:
+		reason = "rate_spike"
+	case len(t.hashes[hash]) > abuseRepeatLimit:
+		reason = "repeated_content"
+	}
+	if reason == "" {
+		abuseMu.Unlock()
+		return ""
+	}
+
+	t.strikes++
+	action := "throttle"
+	if t.strikes >= abuseStrikes {
+		action = "restrict"
+		t.restrictedUntil = now.Add(abuseRestrictDuration)
+	} else {
+		t.throttledUntil = now.Add(abuseThrottle)
+	}
+	abuseMu.Unlock()
+
+	log.Printf("🛡️ Abuse from %s in room %d (%s), action: %s", key, s.room, reason, action)
+	// Incognito messages are reviewed by their reason alone
+	excerpt := text
+	if s.incognito {
+		excerpt = ""
+	}
+	recordAbuseEvent(key, s.ip, s.room, reason, action, excerpt)
+	return action
+}
+
+// pruneAbuseTrackers forgets clients that have been quiet and unpunished for a window; abuseMu must be held
+func pruneAbuseTrackers(now time.Time) {
+	for key, t := range abuseTrackers {
+		quiet := len(t.sent) == 0 || now.Sub(t.sent[len(t.sent)-1]) > abuseWindow
+		if quiet && now.After(t.throttledUntil) && now.After(t.restrictedUntil) {
+			delete(abuseTrackers, key)
+		}
+	}
+}
+
+// recentTimes keeps the times after a cutoff
+func recentTimes(times []time.Time, since time.Time) []time.Time {
+	kept := times[:0]
+	for _, t := range times {
+		if t.After(since) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// recordAbuseEvent queues an automatic action for review and writes it to the audit log
+func recordAbuseEvent(client, ip string, roomID int, reason, action, text string) {
+	excerpt := text
+	if len(excerpt) > 500 {
+		excerpt = strings.ToValidUTF8(excerpt[:500], "")
+	}
+
+	var id int
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO abuse_events (client, room_id, reason, action, excerpt) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		client, roomID, reason, action, excerpt).Scan(&id)
+	if err != nil {
+		log.Println("Error recording abuse event:", err)
+	}
+	recordAudit("system", ip, "abuse."+action, client, map[string]interface{}{"room_id": roomID, "reason": reason, "abuse_event_id": id})
+	addCounter("cubbychat_abuse_actions_total", "Automatic abuse actions", 1, "reason", reason, "action", action)
+}
+
+// Handler for /api/admin/abuse: the review queue (status pending, upheld, dismissed or all; pending by default)
+func listAbuseEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !abuseEnabled {
+		http.Error(w, "Abuse detection is disabled", http.StatusServiceUnavailable)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "pending"
+	}
+	if status != "pending" && status != "upheld" && status != "dismissed" && status != "all" {
+		http.Error(w, "status must be pending, upheld, dismissed or all", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, client, room_id, reason, action, excerpt, status, created_at, reviewed_at FROM abuse_events
+		WHERE $1 = 'all' OR status = $1 ORDER BY id DESC LIMIT 200`, status)
+	if err != nil {
+		http.Error(w, "Failed to fetch abuse events", http.StatusInternalServerError)
+		log.Println("Error fetching abuse events:", err)
+		return
+	}
+	defer rows.Close()
+
+	events := []AbuseEvent{}
+	for rows.Next() {
+		var e AbuseEvent
+		if err := rows.Scan(&e.ID, &e.Client, &e.RoomID, &e.Reason, &e.Action, &e.Excerpt, &e.Status, &e.CreatedAt, &e.ReviewedAt); err != nil {
+			http.Error(w, "Error processing abuse events", http.StatusInternalServerError)
+			log.Println("Error scanning abuse events:", err)
+			return
+		}
+		events = append(events, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// Handler to review an abuse event ({"decision": "uphold" or "dismiss"}); dismissing lifts the client's restrictions
+func reviewAbuseEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !abuseEnabled {
+		http.Error(w, "Abuse detection is disabled", http.StatusServiceUnavailable)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Decision string `json:"decision"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Decision != "uphold" && req.Decision != "dismiss") {
+		http.Error(w, `decision must be "uphold" or "dismiss"`, http.StatusBadRequest)
+		return
+	}
+	status := map[string]string{"uphold": "upheld", "dismiss": "dismissed"}[req.Decision]
+
+	var e AbuseEvent
+	err = db.QueryRow(context.Background(), `
+		UPDATE abuse_events SET status = $2, reviewed_at = NOW() WHERE id = $1
+		RETURNING id, client, room_id, reason, action, excerpt, status, created_at, reviewed_at`, id, status).
+		Scan(&e.ID, &e.Client, &e.RoomID, &e.Reason, &e.Action, &e.Excerpt, &e.Status, &e.CreatedAt, &e.ReviewedAt)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Abuse event not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to review abuse event", http.StatusInternalServerError)
+		log.Println("Error reviewing abuse event:", err)
+		return
+	}
+
+	if status == "dismissed" {
+		abuseMu.Lock()
+		delete(abuseTrackers, e.Client)
+		abuseMu.Unlock()
+	}
+	recordAudit("admin", clientIP(r), "abuse."+req.Decision, e.Client, map[string]interface{}{"abuse_event_id": e.ID})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
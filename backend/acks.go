@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ackTimeout    time.Duration // How long to wait for a client to acknowledge an AI message
+	ackMaxRetries int           // Retransmissions before giving up on an unacknowledged message
+)
+
+// pendingAcks tracks AI messages a session has sent but the client hasn't confirmed
+type pendingAcks struct {
+	mu     sync.Mutex
+	timers map[int]Timer
+	closed bool
+}
+
+// initAcks reads acknowledgement settings and creates the receipts table
+func initAcks() {
+	ackTimeout = getEnvDuration("ACK_TIMEOUT", 10*time.Second)
+	ackMaxRetries = getEnvInt("ACK_MAX_RETRIES", 3)
+	createReceiptsTable()
+}
+
+// Create `message_receipts` table if it doesn't exist
+func createReceiptsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS message_receipts (
+			message_id INTEGER NOT NULL REFERENCES chat_history(id) ON DELETE CASCADE,
+			user_id TEXT NOT NULL,
+			delivered_at TIMESTAMPTZ DEFAULT NOW(),
+			PRIMARY KEY (message_id, user_id)
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create message_receipts table:", err)
+	}
+	log.Println("✅ Table message_receipts is ready")
+}
+
+// sendAIDone delivers an "ai_done" event and, for clients that acknowledge
+// messages, resends it until the client confirms delivery
+func sendAIDone(s *Session, event AIDoneEvent) {
+	if err := s.sendEvent("ai_done", event); err != nil {
+		log.Println("Error sending ai_done event:", err)
+	}
+	if s.acks && event.MessageID != 0 {
+		s.pending.schedule(s, event, 1)
+	}
+}
+
+// schedule arms the retransmission timer for an AI message
+func (p *pendingAcks) schedule(s *Session, event AIDoneEvent, attempt int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	if p.timers == nil {
+		p.timers = make(map[int]Timer)
+	}
+	p.timers[event.MessageID] = clock.AfterFunc(ackTimeout, func() {
+		p.mu.Lock()
+		_, waiting := p.timers[event.MessageID]
+		delete(p.timers, event.MessageID)
+		closed := p.closed
+		p.mu.Unlock()
+		if !waiting || closed {
+			return
+		}
+
+		if attempt > ackMaxRetries {
+			log.Printf("⚠️ Message %d was never acknowledged", event.MessageID)
+			return
+		}
+		log.Printf("🔁 Resending unacknowledged message %d (attempt %d)", event.MessageID, attempt)
+		if err := s.sendEvent("ai_done", event); err != nil {
+			log.Println("Error resending ai_done event:", err)
+			return
+		}
+		p.schedule(s, event, attempt+1)
+	})
+}
+
+// acknowledge stops retransmitting a message; it reports whether one was pending
+func (p *pendingAcks) acknowledge(messageID int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	timer, ok := p.timers[messageID]
+	if ok {
+		timer.Stop()
+		delete(p.timers, messageID)
+	}
+	return ok
+}
+
+// close cancels all retransmissions when the connection ends
+func (p *pendingAcks) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for id, timer := range p.timers {
+		timer.Stop()
+		delete(p.timers, id)
+	}
+}
+
+// handleClientAck records that the client received an AI message
+func handleClientAck(s *Session, messageID int) {
+	s.pending.acknowledge(messageID)
+	if s.user == "" || messageID == 0 {
+		return
+	}
+	_, err := db.Exec(context.Background(), `
+		INSERT INTO message_receipts (message_id, user_id)
+		SELECT id, $2 FROM chat_history WHERE id = $1 AND room_id = $3
+		ON CONFLICT DO NOTHING`, messageID, s.user, s.room)
+	if err != nil {
+		log.Println("Error recording message receipt:", err)
+	}
+}
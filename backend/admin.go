@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+var (
+	adminToken     string // Guards the /api/admin endpoints; they are disabled when it's empty
+	moderatorToken string // Lets clients moderate rooms; the admin token works too
+)
+
+// initAdmin reads the admin and moderator tokens
+func initAdmin() {
+	adminToken = getEnv("ADMIN_TOKEN", "")
+	moderatorToken = getEnv("MODERATOR_TOKEN", "")
+}
+
+// tokenMatches compares a presented token with a configured one, which must be set
+func tokenMatches(presented, configured string) bool {
+	return configured != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(configured)) == 1
+}
+
+// isModerator reports whether a request carries the moderator or admin token, as a
+// bearer token or, for WebSockets which can't send headers, a "mod_token" query parameter
+func isModerator(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("mod_token")
+	}
+	return token != "" && (tokenMatches(token, moderatorToken) || tokenMatches(token, adminToken))
+}
+
+// isAdmin reports whether a request carries the admin token as a bearer token
+func isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && tokenMatches(token, adminToken)
+}
+
+// moderatorOnly wraps a handler so it requires the moderator or admin token
+func moderatorOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" && moderatorToken == "" {
+			http.Error(w, "Moderation is disabled", http.StatusServiceUnavailable)
+			return
+		}
+		if !isModerator(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminOnly wraps a handler so it requires "Authorization: Bearer <ADMIN_TOKEN>"
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			http.Error(w, "Admin API is disabled", http.StatusServiceUnavailable)
+			return
+		}
+		if !isAdmin(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
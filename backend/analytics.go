@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Periods accepted by the analytics endpoint
+var analyticsPeriods = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+	"90d": 90 * 24 * time.Hour,
+}
+
+// AnalyticsBucket summarizes activity in one time bucket
+type AnalyticsBucket struct {
+	Start            time.Time `json:"start"`
+	Messages         int       `json:"messages"`
+	UserMessages     int       `json:"user_messages"`
+	AIMessages       int       `json:"ai_messages"`
+	ActiveUsers      int       `json:"active_users"` // Distinct named users who sent a message
+	Generations      int       `json:"generations"`
+	Errors           int       `json:"errors"`
+	ErrorRate        float64   `json:"error_rate"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	AvgLatencyMs     float64   `json:"avg_latency_ms"`
+	AvgFirstTokenMs  float64   `json:"avg_first_token_ms"`
+	CSATResponses    int       `json:"csat_responses"` // Satisfaction survey answers; see csat.go
+	AvgCSAT          float64   `json:"avg_csat"`
+}
+
+// CSATSummary sums up satisfaction survey answers over a report's period
+type CSATSummary struct {
+	Responses     int     `json:"responses"`
+	Average       float64 `json:"average"`
+	SatisfiedRate float64 `json:"satisfied_rate"` // Share of scores of 4 or 5
+}
+
+// AnalyticsReport is returned by GET /api/admin/analytics
+type AnalyticsReport struct {
+	From        time.Time         `json:"from"`
+	To          time.Time         `json:"to"`
+	Granularity string            `json:"granularity"`
+	Buckets     []AnalyticsBucket `json:"buckets"`
+	CSAT        CSATSummary       `json:"csat"`
+}
+
+// Handler for /api/admin/analytics: usage and customer satisfaction over time.
+// Query parameters: period (24h, 7d, 30d or 90d; 7d by default) and
+// granularity (hour, day or week; hourly for 24h, daily otherwise).
+func getAnalytics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "7d"
+	}
+	length, ok := analyticsPeriods[period]
+	if !ok {
+		http.Error(w, "period must be 24h, 7d, 30d or 90d", http.StatusBadRequest)
+		return
+	}
+	granularity := r.URL.Query().Get("granularity")
+	if granularity == "" {
+		granularity = "day"
+		if length <= 24*time.Hour {
+			granularity = "hour"
+		}
+	}
+	if granularity != "hour" && granularity != "day" && granularity != "week" {
+		http.Error(w, "granularity must be hour, day or week", http.StatusBadRequest)
+		return
+	}
+
+	report := AnalyticsReport{To: time.Now().UTC(), Granularity: granularity, Buckets: []AnalyticsBucket{}}
+	report.From = report.To.Add(-length)
+
+	rows, err := db.Query(context.Background(), `
+		WITH buckets AS (
+			SELECT generate_series(date_trunc($3, $1::timestamptz), $2::timestamptz, ('1 ' || $3)::interval) AS start
+		), messages AS (
+			SELECT date_trunc($3, timestamp) AS start,
+				COUNT(*) AS messages,
+				COUNT(*) FILTER (WHERE sender = 'User') AS user_messages,
+				COUNT(*) FILTER (WHERE sender = 'AI') AS ai_messages,
+				COUNT(DISTINCT NULLIF(user_id, '')) AS active_users
+			FROM chat_history WHERE timestamp >= $1 AND timestamp < $2 GROUP BY 1
+		), generations AS (
+			SELECT date_trunc($3, created_at) AS start,
+				COUNT(*) AS generations,
+				COUNT(*) FILTER (WHERE status = 'error') AS errors,
+				SUM(prompt_tokens) AS prompt_tokens,
+				SUM(completion_tokens) AS completion_tokens,
+				AVG(latency_ms) FILTER (WHERE status = 'ok') AS latency,
+				AVG(first_token_ms) FILTER (WHERE status = 'ok' AND first_token_ms >= 0) AS first_token
+			FROM generation_usage WHERE created_at >= $1 AND created_at < $2 GROUP BY 1
+		), csat AS (
+			SELECT date_trunc($3, created_at) AS start, COUNT(*) AS responses, AVG(score) AS score
+			FROM csat_responses WHERE created_at >= $1 AND created_at < $2 GROUP BY 1
+		)
+		SELECT b.start,
+			COALESCE(m.messages, 0), COALESCE(m.user_messages, 0), COALESCE(m.ai_messages, 0), COALESCE(m.active_users, 0),
+			COALESCE(g.generations, 0), COALESCE(g.errors, 0), COALESCE(g.prompt_tokens, 0), COALESCE(g.completion_tokens, 0),
+			COALESCE(g.latency, 0)::float8, COALESCE(g.first_token, 0)::float8,
+			COALESCE(c.responses, 0), COALESCE(c.score, 0)::float8
+		FROM buckets b
+		LEFT JOIN messages m ON m.start = b.start
+		LEFT JOIN generations g ON g.start = b.start
+		LEFT JOIN csat c ON c.start = b.start
+		ORDER BY b.start`, report.From, report.To, granularity)
+	if err != nil {
+		http.Error(w, "Failed to fetch analytics", http.StatusInternalServerError)
+		log.Println("Error fetching analytics:", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b AnalyticsBucket
+		if err := rows.Scan(&b.Start, &b.Messages, &b.UserMessages, &b.AIMessages, &b.ActiveUsers,
+			&b.Generations, &b.Errors, &b.PromptTokens, &b.CompletionTokens, &b.AvgLatencyMs, &b.AvgFirstTokenMs,
+			&b.CSATResponses, &b.AvgCSAT); err != nil {
+			http.Error(w, "Error processing analytics", http.StatusInternalServerError)
+			log.Println("Error scanning analytics:", err)
+			return
+		}
+		if b.Generations > 0 {
+			b.ErrorRate = float64(b.Errors) / float64(b.Generations)
+		}
+		report.Buckets = append(report.Buckets, b)
+	}
+
+	err = db.QueryRow(context.Background(), `
+		SELECT COUNT(*), COALESCE(AVG(score), 0)::float8, COALESCE(AVG((score >= $3)::int), 0)::float8
+		FROM csat_responses WHERE created_at >= $1 AND created_at < $2`, report.From, report.To, csatSatisfiedScore).
+		Scan(&report.CSAT.Responses, &report.CSAT.Average, &report.CSAT.SatisfiedRate)
+	if err != nil {
+		http.Error(w, "Failed to fetch analytics", http.StatusInternalServerError)
+		log.Println("Error fetching CSAT analytics:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Annotations are "System" rows in a room's history that explain why the AI may
+// behave differently from that point on
+const (
+	annotationModelChanged    = "model_changed"    // A different provider or model answered than last time
+	annotationFailover        = "failover"         // A provider failed and the next one answered
+	annotationSettingsChanged = "settings_changed" // A room setting that shapes answers changed
+	annotationSummary         = "summary"          // Old messages were replaced by a summary; see compression.go
+	annotationHandoff         = "handoff"          // An operator took over the room, or handed it back; see handoff.go
+)
+
+// Annotation describes a system event recorded in history
+type Annotation struct {
+	Kind   string `json:"kind"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Detail string `json:"detail,omitempty"` // The setting that changed, or why a provider failed
+}
+
+// text describes the annotation for readers of the conversation
+func (a Annotation) text() string {
+	switch a.Kind {
+	case annotationModelChanged:
+		return fmt.Sprintf("🔀 Answers now come from %s (previously %s)", a.To, a.From)
+	case annotationFailover:
+		return fmt.Sprintf("⚠️ %s was unavailable (%s), so %s answered instead", a.From, a.Detail, a.To)
+	case annotationSettingsChanged:
+		if a.To == "" {
+			return fmt.Sprintf("⚙️ Room setting %s was cleared (was %s)", a.Detail, a.From)
+		}
+		if a.From == "" {
+			return fmt.Sprintf("⚙️ Room setting %s set to %s", a.Detail, a.To)
+		}
+		return fmt.Sprintf("⚙️ Room setting %s changed from %s to %s", a.Detail, a.From, a.To)
+	case annotationSummary:
+		return fmt.Sprintf("🗜️ Summary of %s earlier messages from %s to %s", a.Detail, a.From, a.To)
+	case annotationHandoff:
+		if a.To == "" {
+			return "🤖 The AI assistant is answering again"
+		}
+		return fmt.Sprintf("🧑‍💼 %s joined the conversation; the AI assistant is paused", a.To)
+	}
+	return "ℹ️ " + a.Kind
+}
+
+// recordAnnotation stores an annotation in a room's history and shows it to the room's clients
+func recordAnnotation(roomID int, a Annotation) {
+	text := a.text()
+	metadata := &MessageMetadata{Annotation: &a}
+	messageID := saveMessageWithMetadata(roomID, "System", text, metadata)
+	if messageID == 0 {
+		return
+	}
+	publishRoomEvent(roomID, nil, "message", ChatMessage{ID: messageID, Sender: "System", Message: text, Timestamp: time.Now(), Metadata: metadata})
+	addCounter("cubbychat_annotations_total", "System annotations recorded in room history", 1, "kind", a.Kind)
+}
+
+// providerLabel names a provider and model for annotations
+func providerLabel(provider, model string) string {
+	if model == "" {
+		return provider
+	}
+	return provider + " (" + model + ")"
+}
+
+// annotateGeneration records a failover during the generation, or else a change from the
+// provider and model that answered the room's previous message. It runs just before the
+// answer is stored so the annotation comes first.
+func annotateGeneration(gen *generation) {
+	current := providerLabel(gen.provider, gen.model)
+	if len(gen.failedProviders) > 0 {
+		recordAnnotation(gen.roomID, Annotation{
+			Kind:   annotationFailover,
+			From:   strings.Join(gen.failedProviders, ", "),
+			To:     current,
+			Detail: strings.Join(gen.failureCodes, ", "),
+		})
+		return
+	}
+
+	var provider, model string
+	err := db.QueryRow(context.Background(), `
+		SELECT COALESCE(metadata->>'provider', ''), COALESCE(metadata->>'model', '') FROM chat_history
+		WHERE room_id = $1 AND sender = 'AI' ORDER BY id DESC LIMIT 1`, gen.roomID).Scan(&provider, &model)
+	if err == pgx.ErrNoRows || (err == nil && provider == "") {
+		return // Nothing to compare with
+	}
+	if err != nil {
+		log.Println("Error fetching the previous answer's model:", err)
+		return
+	}
+	if provider != gen.provider || model != gen.model {
+		recordAnnotation(gen.roomID, Annotation{Kind: annotationModelChanged, From: providerLabel(provider, model), To: current})
+	}
+}
+
+// annotateSettingsChanges records changes to the room settings that shape answers
+func annotateSettingsChanges(roomID int, before, after map[string]string) {
+	for key := range roomSettingValidators {
+		if before[key] != after[key] {
+			recordAnnotation(roomID, Annotation{Kind: annotationSettingsChanged, Detail: key, From: before[key], To: after[key]})
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Announcements currently showing; the scheduler refreshes them as they start and end
+var (
+	announcementsMu       sync.RWMutex
+	activeAnnouncements   = []Announcement{}
+	announcementWake      = make(chan struct{}, 1)
+	announcementsInterval time.Duration // How often the scheduler looks for announcements starting or ending
+)
+
+// Announcement is a banner shown to every client between its start and end times
+type Announcement struct {
+	ID        int        `json:"id"`
+	Message   string     `json:"message"`
+	Severity  string     `json:"severity"` // info, warning or critical
+	StartsAt  time.Time  `json:"starts_at"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"` // Nil for announcements that show until deleted
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// initAnnouncements creates the announcements table and starts the scheduler
+func initAnnouncements() {
+	announcementsInterval = getEnvDuration("ANNOUNCEMENTS_INTERVAL", 30*time.Second)
+	createAnnouncementsTable()
+	go runAnnouncementScheduler()
+}
+
+// Create `announcements` table if it doesn't exist
+func createAnnouncementsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS announcements (
+			id SERIAL PRIMARY KEY,
+			message TEXT NOT NULL,
+			severity TEXT NOT NULL DEFAULT 'info',
+			starts_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			ends_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create announcements table:", err)
+	}
+	log.Println("✅ Table announcements is ready")
+}
+
+// queryAnnouncements lists announcements, only the ones showing now if active is set
+func queryAnnouncements(active bool) ([]Announcement, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT id, message, severity, starts_at, ends_at, created_at FROM announcements
+		WHERE NOT $1 OR (starts_at <= NOW() AND (ends_at IS NULL OR ends_at > NOW()))
+		ORDER BY starts_at DESC, id DESC`, active)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	announcements := []Announcement{}
+	for rows.Next() {
+		var a Announcement
+		if err := rows.Scan(&a.ID, &a.Message, &a.Severity, &a.StartsAt, &a.EndsAt, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		announcements = append(announcements, a)
+	}
+	return announcements, rows.Err()
+}
+
+// currentAnnouncements returns the announcements showing now
+func currentAnnouncements() []Announcement {
+	announcementsMu.RLock()
+	defer announcementsMu.RUnlock()
+	return activeAnnouncements
+}
+
+// wakeAnnouncementScheduler asks the scheduler to refresh now, after an admin change
+func wakeAnnouncementScheduler() {
+	select {
+	case announcementWake <- struct{}{}:
+	default:
+	}
+}
+
+// runAnnouncementScheduler keeps the active announcements current and pushes an
+// "announcements" event to every client whenever the set changes
+func runAnnouncementScheduler() {
+	ticker := clock.NewTicker(announcementsInterval)
+	defer ticker.Stop()
+
+	for {
+		announcements, err := queryAnnouncements(true)
+		if err != nil {
+			log.Println("Error fetching announcements:", err)
+		} else {
+			announcementsMu.Lock()
+			changed := !reflect.DeepEqual(announcements, activeAnnouncements)
+			activeAnnouncements = announcements
+			announcementsMu.Unlock()
+
+			if changed {
+				for _, s := range connectedSessions(func(*Session) bool { return true }) {
+					sendAnnouncements(s)
+				}
+			}
+		}
+
+		select {
+		case <-announcementWake:
+		case <-ticker.C():
+		}
+	}
+}
+
+// sendAnnouncements sends a client the announcements showing now
+func sendAnnouncements(s *Session) {
+	if err := s.sendEvent("announcements", currentAnnouncements()); err != nil {
+		log.Println("Error sending announcements event:", err)
+	}
+}
+
+// Handler for /api/announcements: the announcements showing now
+func getAnnouncements(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentAnnouncements())
+}
+
+// Handler for /api/admin/announcements: list all with GET, schedule with POST
+// ({"message", "severity", "starts_at", "ends_at"}; starts now and never ends by default)
+func handleAnnouncements(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		announcements, err := queryAnnouncements(false)
+		if err != nil {
+			http.Error(w, "Failed to fetch announcements", http.StatusInternalServerError)
+			log.Println("Error fetching announcements:", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(announcements)
+		return
+	}
+
+	var req struct {
+		Message  string     `json:"message"`
+		Severity string     `json:"severity"`
+		StartsAt *time.Time `json:"starts_at"`
+		EndsAt   *time.Time `json:"ends_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Message) == "" {
+		http.Error(w, "An announcement message is required", http.StatusBadRequest)
+		return
+	}
+	if req.Severity == "" {
+		req.Severity = "info"
+	}
+	if req.Severity != "info" && req.Severity != "warning" && req.Severity != "critical" {
+		http.Error(w, `severity must be "info", "warning" or "critical"`, http.StatusBadRequest)
+		return
+	}
+	startsAt := time.Now()
+	if req.StartsAt != nil {
+		startsAt = *req.StartsAt
+	}
+	if req.EndsAt != nil && !req.EndsAt.After(startsAt) {
+		http.Error(w, "ends_at must be after starts_at", http.StatusBadRequest)
+		return
+	}
+
+	a := Announcement{Message: strings.TrimSpace(req.Message), Severity: req.Severity, StartsAt: startsAt, EndsAt: req.EndsAt}
+	err := db.QueryRow(context.Background(),
+		"INSERT INTO announcements (message, severity, starts_at, ends_at) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
+		a.Message, a.Severity, a.StartsAt, a.EndsAt).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		http.Error(w, "Failed to create announcement", http.StatusInternalServerError)
+		log.Println("Error creating announcement:", err)
+		return
+	}
+	recordAudit("admin", clientIP(r), "announcement.create", strconv.Itoa(a.ID), a)
+	wakeAnnouncementScheduler()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+// Handler to delete an announcement, taking it down if it's showing
+func deleteAnnouncement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid announcement id", http.StatusBadRequest)
+		return
+	}
+
+	var message string
+	err = db.QueryRow(context.Background(), "DELETE FROM announcements WHERE id = $1 RETURNING message", id).Scan(&message)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Announcement not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete announcement", http.StatusInternalServerError)
+		log.Println("Error deleting announcement:", err)
+		return
+	}
+	recordAudit("admin", clientIP(r), "announcement.delete", strconv.Itoa(id), map[string]string{"message": message})
+	wakeAnnouncementScheduler()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// anthropicVersion is the Messages API version the adapter speaks
+const anthropicVersion = "2023-06-01"
+
+// anthropicErrorStatuses are the HTTP statuses of the Messages API's error types
+var anthropicErrorStatuses = map[string]int{
+	"invalid_request_error": 400,
+	"not_found_error":       404,
+	"request_too_large":     413,
+	"rate_limit_error":      429,
+	"api_error":             500,
+	"overloaded_error":      529,
+}
+
+// AnthropicRequest is the body of a Messages API call
+type AnthropicRequest struct {
+	Model       string             `json:"model"`
+	MaxTokens   int                `json:"max_tokens"`
+	Messages    []AnthropicMessage `json:"messages"`
+	Tools       []AnthropicTool    `json:"tools,omitempty"`
+	Stream      bool               `json:"stream"`
+	Temperature *float64           `json:"temperature,omitempty"`
+	TopP        *float64           `json:"top_p,omitempty"`
+}
+
+// AnthropicMessage is one turn of the conversation
+type AnthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []AnthropicBlock `json:"content"`
+}
+
+// AnthropicBlock is a content block: text, a tool call the model made, or a tool's result
+type AnthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+}
+
+// AnthropicTool describes a tool the model may use
+type AnthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+// AnthropicStreamEvent is one server-sent event of a streamed message
+type AnthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		Usage AnthropicUsage `json:"usage"`
+	} `json:"message"` // message_start
+	ContentBlock AnthropicBlock `json:"content_block"` // content_block_start
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"` // content_block_delta
+	Usage AnthropicUsage `json:"usage"` // message_delta
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// AnthropicUsage is the token usage of a message
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// anthropicTools describes the registered tools in the format the Messages API expects
+func anthropicTools() []AnthropicTool {
+	var list []AnthropicTool
+	for _, tool := range registeredTools {
+		list = append(list, AnthropicTool{Name: tool.Name, Description: tool.Description, InputSchema: tool.Parameters})
+	}
+	return list
+}
+
+// streamAnthropic streams an answer from the Anthropic Messages API into gen.response,
+// running any tools the model calls and feeding their results back
+func streamAnthropic(s *Session, gen *generation, p *Provider) error {
+	messages := []AnthropicMessage{{Role: "user", Content: []AnthropicBlock{{Type: "text", Text: gen.modelPrompt()}}}}
+	params := gen.params()
+	maxTokens := p.MaxTokens
+	if params.MaxTokens != nil {
+		maxTokens = *params.MaxTokens
+	}
+
+	for round := 0; round <= maxToolRounds; round++ {
+		request := AnthropicRequest{
+			Model: gen.model, MaxTokens: maxTokens, Messages: messages, Stream: true,
+			Temperature: params.Temperature, TopP: params.TopP,
+		}
+		// On the last round the model has to answer with what it has
+		if round < maxToolRounds {
+			request.Tools = anthropicTools()
+		}
+
+		blocks, err := streamAnthropicRound(s, gen, p, request)
+		if err != nil {
+			return err
+		}
+		var calls []OllamaToolCall
+		for _, block := range blocks {
+			if block.Type == "tool_use" {
+				var call OllamaToolCall
+				call.Function.Name = block.Name
+				if err := json.Unmarshal(block.Input, &call.Function.Arguments); err != nil {
+					log.Printf("Error parsing %s tool input: %v", p.Name, err)
+				}
+				calls = append(calls, call)
+			}
+		}
+		if len(calls) == 0 {
+			return nil
+		}
+
+		// Empty text blocks are rejected, so only the non-empty ones are sent back
+		var content []AnthropicBlock
+		for _, block := range blocks {
+			if block.Type == "tool_use" || block.Text != "" {
+				content = append(content, block)
+			}
+		}
+		messages = append(messages, AnthropicMessage{Role: "assistant", Content: content})
+		var results []AnthropicBlock
+		i := 0
+		for _, result := range runToolCalls(s, gen, calls) {
+			for blocks[i].Type != "tool_use" {
+				i++
+			}
+			results = append(results, AnthropicBlock{Type: "tool_result", ToolUseID: blocks[i].ID, Content: result})
+			i++
+		}
+		messages = append(messages, AnthropicMessage{Role: "user", Content: results})
+	}
+	return nil
+}
+
+// streamAnthropicRound runs one Messages API call, streaming text to the client, and
+// returns the content blocks of the model's message with tool inputs filled in
+func streamAnthropicRound(s *Session, gen *generation, p *Provider, request AnthropicRequest) ([]AnthropicBlock, error) {
+	resp, err := newUpstreamClient().R().
+		SetContext(gen.context()).
+		SetHeader("Content-Type", "application/json").
+		SetHeader("x-api-key", p.APIKey).
+		SetHeader("anthropic-version", anthropicVersion).
+		SetBody(request).
+		SetDoNotParseResponse(true).
+		Post(p.URL + "/messages")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", p.Name, err)
+	}
+	defer resp.RawBody().Close()
+	if resp.StatusCode() != 200 {
+		body, _ := io.ReadAll(io.LimitReader(resp.RawBody(), 4096))
+		return nil, &upstreamStatusError{provider: p.Name, status: resp.StatusCode(), body: string(body)}
+	}
+
+	var blocks []AnthropicBlock
+	var inputs []string // Tool inputs arrive as JSON fragments, gathered per block
+	scanner := bufio.NewScanner(resp.RawBody())
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event AnthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			log.Printf("Error parsing %s response: %v", p.Name, err)
+			continue
+		}
+
+		switch event.Type {
+		case "message_start":
+			gen.promptTokens += event.Message.Usage.InputTokens
+		case "content_block_start":
+			for len(blocks) <= event.Index {
+				blocks = append(blocks, AnthropicBlock{})
+				inputs = append(inputs, "")
+			}
+			blocks[event.Index] = event.ContentBlock
+		case "content_block_delta":
+			if event.Index >= len(blocks) {
+				continue
+			}
+			switch event.Delta.Type {
+			case "text_delta":
+				blocks[event.Index].Text += event.Delta.Text
+				gen.response += event.Delta.Text
+				if err := gen.sendToken(s, event.Delta.Text); err != nil {
+					return nil, nil
+				}
+			case "input_json_delta":
+				inputs[event.Index] += event.Delta.PartialJSON
+			}
+		case "message_delta":
+			gen.completionTokens += event.Usage.OutputTokens
+		case "error":
+			// Errors mid-stream come as events, so they get the status the same error would have had
+			status, ok := anthropicErrorStatuses[event.Error.Type]
+			if !ok {
+				status = 500
+			}
+			return nil, &upstreamStatusError{provider: p.Name, status: status, body: event.Error.Type + ": " + event.Error.Message}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range blocks {
+		if blocks[i].Type == "tool_use" && inputs[i] != "" {
+			blocks[i].Input = json.RawMessage(inputs[i])
+		}
+		if blocks[i].Type == "tool_use" && len(blocks[i].Input) == 0 {
+			blocks[i].Input = json.RawMessage("{}")
+		}
+	}
+	return blocks, nil
+}
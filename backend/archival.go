@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Auto-archival: a scheduler archives rooms nobody has posted in for a while, optionally
+// leaving a summary of the conversation as the room's last message
+var (
+	archiveAfter           time.Duration // Inactivity after which a room is archived; 0 disables auto-archival
+	archiveInterval        time.Duration // How often the scheduler looks for inactive rooms
+	archiveBatch           int           // Most rooms archived per run, so summaries don't pile up
+	archiveSummary         bool          // Whether the AI writes a final summary before a room is archived
+	archiveSummaryMessages int           // Most recent messages the summary covers
+)
+
+const archiveSummaryInstructions = `This conversation is being archived after a period of inactivity. Write a short closing summary for anyone who reads it later:
+the topics discussed, any decisions or answers reached, and anything left open. Use a few bullet points and don't invent details.`
+
+// initArchival reads the auto-archival settings and starts the scheduler
+func initArchival() {
+	archiveAfter = getEnvDuration("AUTO_ARCHIVE_AFTER", 0)
+	if archiveAfter <= 0 {
+		return
+	}
+	archiveInterval = getEnvDuration("AUTO_ARCHIVE_INTERVAL", time.Hour)
+	archiveBatch = getEnvInt("AUTO_ARCHIVE_BATCH", 20)
+	archiveSummary = getEnvBool("AUTO_ARCHIVE_SUMMARY", false)
+	archiveSummaryMessages = getEnvInt("AUTO_ARCHIVE_SUMMARY_MESSAGES", 50)
+	go runArchiveScheduler()
+	log.Printf("🗄️ Rooms inactive for %v are archived", archiveAfter)
+}
+
+// runArchiveScheduler archives inactive rooms every AUTO_ARCHIVE_INTERVAL
+func runArchiveScheduler() {
+	ticker := clock.NewTicker(archiveInterval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		if err := archiveInactiveRooms(); err != nil {
+			log.Println("Error archiving inactive rooms:", err)
+		}
+	}
+}
+
+// inactiveRooms lists rooms with no messages or state changes for archiveAfter, oldest
+// activity first. The default room is never archived.
+func inactiveRooms() ([]int, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT r.id FROM rooms r
+		WHERE r.state <> 'archived' AND r.id <> $1
+			AND GREATEST(r.created_at, r.state_changed_at, (SELECT MAX(timestamp) FROM chat_history WHERE room_id = r.id))
+				< $2::timestamptz
+		ORDER BY GREATEST(r.created_at, r.state_changed_at, (SELECT MAX(timestamp) FROM chat_history WHERE room_id = r.id))
+		LIMIT $3`, defaultRoomID, clock.Now().Add(-archiveAfter), archiveBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// archiveInactiveRooms archives the rooms that have gone quiet, summarizing them first if enabled
+func archiveInactiveRooms() error {
+	ids, err := inactiveRooms()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		room, err := getRoom(id)
+		if err != nil {
+			log.Printf("Error fetching room %d to archive: %v", id, err)
+			continue
+		}
+		if archiveSummary {
+			summarizeRoomForArchive(room.ID)
+		}
+		if err := changeRoomState(room, roomArchived, "system", ""); err != nil {
+			log.Printf("Error archiving room %d: %v", id, err)
+			continue
+		}
+		addCounter("cubbychat_rooms_auto_archived_total", "Rooms archived for inactivity", 1)
+	}
+	return nil
+}
+
+// archiveTranscript is the room's most recent messages as plain text, oldest first
+func archiveTranscript(roomID int) (string, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT sender, message FROM (
+			SELECT id, sender, `+messageText("chat_history")+` AS message FROM chat_history
+			WHERE room_id = $1 AND sender <> 'System' ORDER BY id DESC LIMIT $2
+		) recent ORDER BY id`, roomID, archiveSummaryMessages)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var b strings.Builder
+	for rows.Next() {
+		var sender, message string
+		if err := rows.Scan(&sender, &message); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s: %s\n", sender, message)
+	}
+	return b.String(), rows.Err()
+}
+
+// summarizeRoomForArchive has the AI post a closing summary of the room's conversation.
+// Failures are logged; the room is archived either way.
+func summarizeRoomForArchive(roomID int) {
+	transcript, err := archiveTranscript(roomID)
+	if err != nil {
+		log.Printf("Error fetching room %d's history to summarize: %v", roomID, err)
+		return
+	}
+	if transcript == "" {
+		return // Nothing was said
+	}
+
+	const user = "archiver"
+	gen, err := prepareGeneration(roomID, user, "", archiveSummaryInstructions+"\n\nConversation:\n"+transcript)
+	if err != nil {
+		log.Printf("Error preparing the archive summary for room %d: %v", roomID, err)
+		return
+	}
+
+	// Nobody is streaming the answer; the room sees it once it's stored
+	s := &Session{room: roomID, user: user, sink: func(frame outboundFrame) error { return nil }}
+	defer s.close()
+	if err := generateWithFailover(s, gen); err != nil && !gen.partial() {
+		log.Printf("Error summarizing room %d before archiving it: %v", roomID, err)
+		recordUsage(gen, 0, err)
+		recordFailedGeneration(gen, err)
+		return
+	}
+
+	answer, metadata, messageID := storeAIResponse(gen)
+	publishRoomEvent(roomID, nil, "message", ChatMessage{ID: messageID, Sender: "AI", Message: answer, Timestamp: time.Now(), Metadata: metadata})
+}
+
+// Handler for /api/rooms/{id}/unarchive: make an archived room active again. The
+// inactivity clock restarts, so it isn't archived again on the next run.
+func unarchiveRoom(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+	if room.State != roomArchived {
+		http.Error(w, "Room is not archived", http.StatusConflict)
+		return
+	}
+
+	if err := changeRoomState(room, roomActive, "moderator", clientIP(r)); err != nil {
+		http.Error(w, "Failed to unarchive room", http.StatusInternalServerError)
+		log.Println("Error unarchiving room:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var attachmentMaxBytes int64 // Largest upload accepted by the attachment store
+
+// Attachment is a stored file referenced from chat messages
+type Attachment struct {
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"`
+	URL         string    `json:"url"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// initAttachments reads attachment settings and creates the storage table
+func initAttachments() {
+	attachmentMaxBytes = int64(getEnvInt("ATTACHMENT_MAX_BYTES", 10*1024*1024))
+	createAttachmentsTable()
+}
+
+// Create `attachments` table if it doesn't exist
+func createAttachmentsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS attachments (
+			id TEXT PRIMARY KEY,
+			filename TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			data BYTEA NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create attachments table:", err)
+	}
+	log.Println("✅ Table attachments is ready")
+}
+
+// newAttachmentID returns an unguessable id so attachment URLs can't be enumerated
+func newAttachmentID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Unable to generate attachment id: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// attachmentURL is the path clients use to download an attachment
+func attachmentURL(id string) string {
+	return "/api/attachments/" + id
+}
+
+// saveAttachment stores file contents and returns the attachment record
+func saveAttachment(filename, contentType string, data []byte) (*Attachment, error) {
+	if int64(len(data)) > attachmentMaxBytes {
+		return nil, fmt.Errorf("attachment exceeds %d bytes", attachmentMaxBytes)
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	att := &Attachment{
+		ID:          newAttachmentID(),
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        len(data),
+	}
+	att.URL = attachmentURL(att.ID)
+
+	err := db.QueryRow(context.Background(),
+		"INSERT INTO attachments (id, filename, content_type, size, data) VALUES ($1, $2, $3, $4, $5) RETURNING created_at",
+		att.ID, att.Filename, att.ContentType, att.Size, data).Scan(&att.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return att, nil
+}
+
+// Handler to upload an attachment (multipart form field "file")
+func uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, attachmentMaxBytes+1024*1024)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing or oversized file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, attachmentMaxBytes+1))
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+
+	att, err := saveAttachment(header.Filename, header.Header.Get("Content-Type"), data)
+	if err != nil {
+		http.Error(w, "Failed to store attachment", http.StatusBadRequest)
+		log.Println("Error saving attachment:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(att)
+}
+
+// Handler to download an attachment
+func getAttachment(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var filename, contentType string
+	var data []byte
+	err := db.QueryRow(context.Background(),
+		"SELECT filename, content_type, data FROM attachments WHERE id = $1", id).Scan(&filename, &contentType, &data)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch attachment", http.StatusInternalServerError)
+		log.Println("Error fetching attachment:", err)
+		return
+	}
+
+	// Only media is shown inline; anything else (HTML, SVG, ...) is downloaded
+	disposition := "attachment"
+	if isInlineContentType(contentType) {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, filename))
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400, immutable")
+	w.Write(data)
+}
+
+// isInlineContentType reports whether a content type is safe to render in the browser
+func isInlineContentType(contentType string) bool {
+	if strings.HasPrefix(contentType, "image/svg") {
+		return false
+	}
+	return strings.HasPrefix(contentType, "image/") ||
+		strings.HasPrefix(contentType, "audio/") ||
+		strings.HasPrefix(contentType, "video/") ||
+		contentType == "application/pdf"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// AuditEntry records an automated or administrative action
+type AuditEntry struct {
+	ID        int             `json:"id"`
+	Actor     string          `json:"actor"`        // "system" for automated actions, "admin" or "moderator" for the API used
+	IP        string          `json:"ip,omitempty"` // Client address of the admin, or of the client an automated action is about
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Details   json.RawMessage `json:"details"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// initAudit creates the audit log table
+func initAudit() {
+	createAuditLogTable()
+}
+
+// Create `audit_log` table if it doesn't exist
+func createAuditLogTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id SERIAL PRIMARY KEY,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			details JSONB NOT NULL DEFAULT '{}',
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS audit_log_action_idx ON audit_log (action, created_at);
+		ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create audit_log table:", err)
+	}
+	log.Println("✅ Table audit_log is ready")
+}
+
+// recordAudit appends an entry to the audit log; details is any JSON-encodable value
+func recordAudit(actor, ip, action, target string, details interface{}) {
+	if details == nil {
+		details = map[string]string{}
+	}
+	_, err := db.Exec(context.Background(),
+		"INSERT INTO audit_log (actor, ip, action, target, details) VALUES ($1, $2, $3, $4, $5)", actor, ip, action, target, details)
+	if err != nil {
+		log.Println("Error writing audit log:", err)
+	}
+}
+
+// Handler for /api/admin/audit: newest entries first, optionally filtered by action and target
+func listAuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, actor, ip, action, target, details, created_at FROM audit_log
+		WHERE ($1 = '' OR action = $1) AND ($2 = '' OR target = $2)
+		ORDER BY id DESC LIMIT 500`, query.Get("action"), query.Get("target"))
+	if err != nil {
+		http.Error(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		log.Println("Error fetching audit log:", err)
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Actor, &e.IP, &e.Action, &e.Target, &e.Details, &e.CreatedAt); err != nil {
+			http.Error(w, "Error processing audit log", http.StatusInternalServerError)
+			log.Println("Error scanning audit log:", err)
+			return
+		}
+		entries = append(entries, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// Avatars: users can upload a picture, stored as an attachment, and everyone else gets an
+// identicon drawn from their name, so every named user has something to show
+var avatarMaxBytes int64 // Largest avatar upload accepted
+
+const (
+	identiconGrid    = 5  // Cells per side; the left half is mirrored onto the right
+	identiconCell    = 12 // Pixels per cell
+	identiconPadding = 6  // Pixels around the grid
+)
+
+// avatarContentTypes are the image formats accepted as avatars
+var avatarContentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// initAvatars reads the avatar settings
+func initAvatars() {
+	avatarMaxBytes = min(int64(getEnvInt("AVATAR_MAX_BYTES", 512*1024)), attachmentMaxBytes)
+}
+
+// identiconURL is where a user's identicon is drawn
+func identiconURL(user string) string {
+	return "/api/avatars/" + url.PathEscape(user)
+}
+
+// avatarURL is a user's uploaded avatar, or their identicon; guests have none
+func avatarURL(user string, settings *UserSettings) string {
+	if settings != nil && settings.AvatarURL != "" {
+		return settings.AvatarURL
+	}
+	if user == "" {
+		return ""
+	}
+	return identiconURL(user)
+}
+
+// avatar is the session user's avatar
+func (s *Session) avatar() string {
+	return avatarURL(s.user, s.userSettings())
+}
+
+// drawIdenticon renders the symmetric pattern and color derived from a name's hash
+func drawIdenticon(user string) []byte {
+	hash := sha256.Sum256([]byte(user))
+	fg := color.RGBA{R: 40 + hash[0]%160, G: 40 + hash[1]%160, B: 40 + hash[2]%160, A: 255}
+	bg := color.RGBA{R: 240, G: 240, B: 240, A: 255}
+
+	size := 2*identiconPadding + identiconGrid*identiconCell
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, bg)
+		}
+	}
+	for row := 0; row < identiconGrid; row++ {
+		for col := 0; col < (identiconGrid+1)/2; col++ {
+			if hash[3+row*3+col]%2 == 0 {
+				continue
+			}
+			for _, c := range []int{col, identiconGrid - 1 - col} {
+				x0, y0 := identiconPadding+c*identiconCell, identiconPadding+row*identiconCell
+				for y := y0; y < y0+identiconCell; y++ {
+					for x := x0; x < x0+identiconCell; x++ {
+						img.Set(x, y, fg)
+					}
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// Handler for /api/avatars/{user}: the user's identicon as a PNG
+func getIdenticon(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := r.PathValue("user")
+	hash := sha256.Sum256([]byte(user))
+	etag := `"` + hex.EncodeToString(hash[:8]) + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// The picture only depends on the name, so it can be cached for a long time
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=604800")
+	w.Header().Set("ETag", etag)
+	w.Write(drawIdenticon(user))
+}
+
+// saveAvatarURL sets or (with "") clears a user's avatar and updates their sessions
+func saveAvatarURL(user, avatar string) (*UserSettings, error) {
+	settings, err := getUserSettings(user)
+	if err != nil {
+		return nil, err
+	}
+	settings.AvatarURL = avatar
+	settings.UpdatedAt = nil
+	_, err = db.Exec(context.Background(), `
+		INSERT INTO user_settings (user_id, settings) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET settings = EXCLUDED.settings, updated_at = NOW()`, user, settings)
+	if err != nil {
+		return nil, err
+	}
+	if settings, err = getUserSettings(user); err != nil {
+		return nil, err
+	}
+	for _, s := range userSessions(user) {
+		s.applySettings(settings, nil)
+		sendSettings(s)
+	}
+	return settings, nil
+}
+
+// Handler for /api/me/avatar: the avatar of the user whose token the request presents; upload
+// a picture (multipart field "file") with POST, go back to the identicon with DELETE
+func handleAvatar(w http.ResponseWriter, r *http.Request) {
+	user := verifiedUser(r)
+	if user == "" {
+		http.Error(w, "Present your user token (X-User-Token)", http.StatusUnauthorized)
+		return
+	}
+
+	avatar := ""
+	switch r.Method {
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, avatarMaxBytes+64*1024)
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Missing or oversized file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, avatarMaxBytes+1))
+		if err != nil || int64(len(data)) > avatarMaxBytes {
+			http.Error(w, fmt.Sprintf("Avatars are limited to %d bytes", avatarMaxBytes), http.StatusBadRequest)
+			return
+		}
+
+		// Trust the bytes rather than the declared type
+		contentType := http.DetectContentType(data)
+		if !containsString(avatarContentTypes, contentType) {
+			http.Error(w, "Avatars must be PNG, JPEG, GIF or WebP images", http.StatusBadRequest)
+			return
+		}
+		att, err := saveAttachment(header.Filename, contentType, data)
+		if err != nil {
+			http.Error(w, "Failed to store avatar", http.StatusInternalServerError)
+			log.Println("Error saving avatar:", err)
+			return
+		}
+		avatar = att.URL
+	case http.MethodDelete:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settings, err := saveAvatarURL(user, avatar)
+	if err != nil {
+		http.Error(w, "Failed to save avatar", http.StatusInternalServerError)
+		log.Println("Error saving avatar:", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		*UserSettings
+		Avatar string `json:"avatar"`
+	}{settings, avatarURL(user, settings)})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestOrderBackupTables(t *testing.T) {
+	tests := []struct {
+		name      string
+		tables    []string
+		dependsOn map[string][]string
+		want      []string
+	}{
+		{"no references keeps name order", []string{"a", "b", "c"}, nil, []string{"a", "b", "c"}},
+		{"referenced table first", []string{"chat_history", "rooms"},
+			map[string][]string{"chat_history": {"rooms"}}, []string{"rooms", "chat_history"}},
+		{"chain", []string{"a", "b", "c"},
+			map[string][]string{"a": {"b"}, "b": {"c"}}, []string{"c", "b", "a"}},
+		{"shared reference once", []string{"attachments", "chat_history", "rooms"},
+			map[string][]string{"attachments": {"chat_history", "rooms"}, "chat_history": {"rooms"}},
+			[]string{"rooms", "chat_history", "attachments"}},
+		{"cycle falls back to name order", []string{"a", "b"},
+			map[string][]string{"a": {"b"}, "b": {"a"}}, []string{"b", "a"}},
+		{"skipped tables left out", []string{"backup_runs", "rooms"}, nil, []string{"rooms"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := orderBackupTables(tt.tables, tt.dependsOn); !slices.Equal(got, tt.want) {
+				t.Errorf("orderBackupTables = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Branding for white-label deployments, kept in memory and edited through the admin API
+var (
+	brandingMu sync.RWMutex
+	branding   Branding
+)
+
+var colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// FooterLink is a link shown under the chat
+type FooterLink struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// Branding is how the chat looks and greets people; empty fields keep the built-in look
+type Branding struct {
+	Title          string       `json:"title,omitempty"` // Replaces CHAT_TITLE when set
+	LogoURL        string       `json:"logo_url,omitempty"`
+	PrimaryColor   string       `json:"primary_color,omitempty"` // Hex colours such as "#ec4899"
+	AccentColor    string       `json:"accent_color,omitempty"`
+	WelcomeMessage string       `json:"welcome_message,omitempty"`
+	FooterLinks    []FooterLink `json:"footer_links,omitempty"`
+	UpdatedAt      *time.Time   `json:"updated_at,omitempty"`
+}
+
+// initBranding creates the branding table and loads the current branding
+func initBranding() {
+	createBrandingTable()
+	if err := loadBranding(); err != nil {
+		log.Fatal("❌ Failed to load branding:", err)
+	}
+}
+
+// Create `branding` table if it doesn't exist; it holds a single row
+func createBrandingTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS branding (
+			id INTEGER PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+			settings JSONB NOT NULL DEFAULT '{}',
+			updated_at TIMESTAMPTZ DEFAULT NOW()
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create branding table:", err)
+	}
+	log.Println("✅ Table branding is ready")
+}
+
+// loadBranding reads the stored branding; none stored means the built-in look
+func loadBranding() error {
+	rows, err := db.Query(context.Background(), "SELECT settings, updated_at FROM branding WHERE id = 1")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var loaded Branding
+	for rows.Next() {
+		if err := rows.Scan(&loaded, &loaded.UpdatedAt); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	brandingMu.Lock()
+	defer brandingMu.Unlock()
+	branding = loaded
+	return nil
+}
+
+// currentBranding returns a copy of the branding that's in effect
+func currentBranding() Branding {
+	brandingMu.RLock()
+	defer brandingMu.RUnlock()
+	return branding
+}
+
+// brandingURL reports whether a URL is safe to put in the page: http(s) or a path on this site
+func brandingURL(value string) bool {
+	return value == "" || strings.HasPrefix(value, "https://") || strings.HasPrefix(value, "http://") ||
+		(strings.HasPrefix(value, "/") && !strings.HasPrefix(value, "//"))
+}
+
+// Handler for /api/admin/branding: read with GET, replace with PUT
+func handleBranding(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(currentBranding())
+		return
+	}
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req Branding
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	for _, color := range []string{req.PrimaryColor, req.AccentColor} {
+		if color != "" && !colorPattern.MatchString(color) {
+			http.Error(w, "Colors must be hex values such as #ec4899", http.StatusBadRequest)
+			return
+		}
+	}
+	if !brandingURL(req.LogoURL) {
+		http.Error(w, "logo_url must be an http(s) URL or a path", http.StatusBadRequest)
+		return
+	}
+	for _, link := range req.FooterLinks {
+		if strings.TrimSpace(link.Label) == "" || link.URL == "" || !brandingURL(link.URL) {
+			http.Error(w, "Footer links need a label and an http(s) URL or a path", http.StatusBadRequest)
+			return
+		}
+	}
+	req.UpdatedAt = nil
+
+	_, err := db.Exec(context.Background(), `
+		INSERT INTO branding (id, settings) VALUES (1, $1)
+		ON CONFLICT (id) DO UPDATE SET settings = EXCLUDED.settings, updated_at = NOW()`, req)
+	if err == nil {
+		err = loadBranding()
+	}
+	if err != nil {
+		http.Error(w, "Failed to save branding", http.StatusInternalServerError)
+		log.Println("Error saving branding:", err)
+		return
+	}
+	recordAudit("admin", clientIP(r), "branding.update", "", req)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentBranding())
+}
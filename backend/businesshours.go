@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Business hours say when a support team is around, for the whole deployment
+// (BUSINESS_HOURS_*) or one room. Outside them the responder either answers with an
+// after-hours persona or stays quiet and tells customers when someone will be back;
+// either way customers' messages are queued for the team, and SLA clocks only run
+// during business hours.
+var defaultBusinessHours *BusinessHours // nil when the deployment is always open
+
+// What happens to customers' messages outside business hours
+const (
+	afterHoursPersona = "persona" // The AI answers with the after-hours persona
+	afterHoursQueue   = "queue"   // The AI stays quiet; customers are told when the team is back
+)
+
+const (
+	defaultAfterHoursPersona = `The support team is offline right now. Tell the customer that a person will follow up during business hours, answer what you can, and invite them to leave any details that would help.`
+	defaultAfterHoursMessage = `We're offline right now. Leave us a message and we'll get back to you during business hours.`
+)
+
+// BusinessHours is a daily window when the team is around, like a do-not-disturb
+// schedule, and what happens outside it
+type BusinessHours struct {
+	Start    string   `json:"start"`              // "09:00"
+	End      string   `json:"end"`                // "17:30"
+	Timezone string   `json:"timezone,omitempty"` // IANA zone such as "America/New_York"; UTC when empty
+	Days     []string `json:"days,omitempty"`     // "mon".."sun" the window starts on; every day when empty
+	Mode     string   `json:"mode,omitempty"`     // persona (the default) or queue
+	Persona  string   `json:"persona,omitempty"`  // Replaces the room's persona after hours in persona mode
+	Message  string   `json:"message,omitempty"`  // Told to customers after hours in queue mode
+}
+
+// AfterHoursEvent tells a customer their message will wait for the team
+type AfterHoursEvent struct {
+	RoomID  int        `json:"room_id"`
+	Message string     `json:"message"`
+	OpensAt *time.Time `json:"opens_at,omitempty"`
+}
+
+// QueuedMessage is a customer's after-hours message no staff reply has followed yet
+type QueuedMessage struct {
+	ID        int       `json:"id"`
+	RoomID    int       `json:"room_id"`
+	User      string    `json:"user,omitempty"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// initBusinessHours reads the deployment's business hours
+func initBusinessHours() {
+	start := getEnv("BUSINESS_HOURS_START", "")
+	if start == "" {
+		return
+	}
+	h := &BusinessHours{
+		Start:    start,
+		End:      getEnv("BUSINESS_HOURS_END", "17:00"),
+		Timezone: getEnv("BUSINESS_HOURS_TIMEZONE", ""),
+		Days:     splitList(getEnv("BUSINESS_HOURS_DAYS", "mon,tue,wed,thu,fri")),
+		Mode:     getEnv("AFTER_HOURS_MODE", afterHoursPersona),
+		Persona:  getEnv("AFTER_HOURS_PERSONA", ""),
+		Message:  getEnv("AFTER_HOURS_MESSAGE", ""),
+	}
+	if err := h.validate(); err != nil {
+		log.Fatalf("❌ Invalid BUSINESS_HOURS_* settings: %v", err)
+	}
+	defaultBusinessHours = h
+	log.Printf("🕘 Business hours are %s-%s %s (%s)", h.Start, h.End, h.Timezone, strings.Join(h.Days, ","))
+}
+
+// schedule is the business hours' daily window
+func (h *BusinessHours) schedule() *DNDSchedule {
+	return &DNDSchedule{Start: h.Start, End: h.End, Timezone: h.Timezone, Days: h.Days}
+}
+
+// validate checks business hours and lowercases their days
+func (h *BusinessHours) validate() error {
+	schedule := h.schedule()
+	if err := schedule.validate("business hours"); err != nil {
+		return err
+	}
+	h.Days = schedule.Days
+	if h.Mode != "" && h.Mode != afterHoursPersona && h.Mode != afterHoursQueue {
+		return fmt.Errorf(`business hours mode must be "persona" or "queue"`)
+	}
+	h.Persona = strings.TrimSpace(h.Persona)
+	if len([]rune(h.Persona)) > maxPersonaChars {
+		return fmt.Errorf("the after-hours persona can be at most %d characters", maxPersonaChars)
+	}
+	h.Message = strings.TrimSpace(h.Message)
+	return nil
+}
+
+// open reports whether the team is around at a moment
+func (h *BusinessHours) open(now time.Time) bool {
+	return h.schedule().quiet(now)
+}
+
+// persona is what the AI is told after hours
+func (h *BusinessHours) persona() string {
+	if h.Persona != "" {
+		return h.Persona
+	}
+	return defaultAfterHoursPersona
+}
+
+// message is what customers are told after hours in queue mode
+func (h *BusinessHours) message() string {
+	if h.Message != "" {
+		return h.Message
+	}
+	return defaultAfterHoursMessage
+}
+
+// windows calls fn with each business-hours window, in order, from the one that may
+// cover from onwards, until fn returns false. It gives up after a year of days.
+func (h *BusinessHours) windows(from time.Time, fn func(start, end time.Time) bool) {
+	location, err := time.LoadLocation(h.Timezone)
+	if err != nil {
+		return
+	}
+	start, end := clockMinutes(h.Start), clockMinutes(h.End)
+	local := from.In(location)
+	for i := -1; i <= 366; i++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+i, 0, 0, 0, 0, location)
+		if len(h.Days) > 0 && !containsString(h.Days, weekdayNames[day.Weekday()]) {
+			continue
+		}
+		windowStart := time.Date(day.Year(), day.Month(), day.Day(), start/60, start%60, 0, 0, location)
+		windowEnd := time.Date(day.Year(), day.Month(), day.Day(), end/60, end%60, 0, 0, location)
+		if end < start {
+			windowEnd = windowEnd.AddDate(0, 0, 1)
+		}
+		if windowEnd.After(from) && !fn(windowStart, windowEnd) {
+			return
+		}
+	}
+}
+
+// nextOpening is when the team is next around after a moment they aren't
+func (h *BusinessHours) nextOpening(now time.Time) *time.Time {
+	var opens *time.Time
+	h.windows(now, func(start, end time.Time) bool {
+		if start.After(now) {
+			opens = &start
+			return false
+		}
+		return true
+	})
+	return opens
+}
+
+// addOpen is the moment d of business hours have passed since from
+func (h *BusinessHours) addOpen(from time.Time, d time.Duration) time.Time {
+	due := from.Add(d)
+	h.windows(from, func(start, end time.Time) bool {
+		if start.Before(from) {
+			start = from
+		}
+		if available := end.Sub(start); available < d {
+			d -= available
+			return true
+		}
+		due = start.Add(d)
+		return false
+	})
+	return due
+}
+
+// roomBusinessHours is a room's business hours, else the deployment's; nil when always open
+func roomBusinessHours(room *Room) *BusinessHours {
+	if room.Metadata.BusinessHours != nil {
+		return room.Metadata.BusinessHours
+	}
+	return defaultBusinessHours
+}
+
+// roomAfterHours returns the room's business hours when it's outside them, else nil
+func roomAfterHours(room *Room) *BusinessHours {
+	if h := roomBusinessHours(room); h != nil && !h.open(time.Now()) {
+		return h
+	}
+	return nil
+}
+
+// sessionAfterHours returns the business hours of a customer's room when it's outside
+// them, else nil; moderators are the team, so their messages are never after hours
+func sessionAfterHours(s *Session) *BusinessHours {
+	if s.moderator {
+		return nil
+	}
+	room, err := getRoom(s.room)
+	if err != nil {
+		log.Println("Error fetching room business hours:", err)
+		return nil
+	}
+	return roomAfterHours(room)
+}
+
+// tellAfterHours lets a customer know nobody will answer until the team is back
+func tellAfterHours(s *Session, h *BusinessHours) {
+	event := AfterHoursEvent{RoomID: s.room, Message: h.message(), OpensAt: h.nextOpening(time.Now())}
+	if err := s.sendEvent("after_hours", event); err != nil {
+		log.Println("Error sending after_hours event:", err)
+	}
+}
+
+// Handler for /api/rooms/{id}/business-hours: see the room's business hours with GET (the
+// deployment's if it has none; null when always open), set its own with PUT, go back to
+// the deployment's with DELETE
+func handleRoomBusinessHours(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req BusinessHours
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, err = db.Exec(context.Background(),
+			"UPDATE rooms SET metadata = metadata || jsonb_build_object('business_hours', $2::jsonb) WHERE id = $1", room.ID, req)
+		room.Metadata.BusinessHours = &req
+	case http.MethodDelete:
+		_, err = db.Exec(context.Background(), "UPDATE rooms SET metadata = metadata - 'business_hours' WHERE id = $1", room.ID)
+		room.Metadata.BusinessHours = nil
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update room business hours", http.StatusInternalServerError)
+		log.Println("Error updating room business hours:", err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		recordAudit("moderator", clientIP(r), "room.business_hours", strconv.Itoa(room.ID), room.Metadata.BusinessHours)
+	}
+
+	h := roomBusinessHours(room)
+	response := map[string]interface{}{"business_hours": h, "open": h == nil || h.open(time.Now())}
+	if h != nil && !h.open(time.Now()) {
+		response["opens_at"] = h.nextOpening(time.Now())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Handler for /api/after-hours-queue: customers' after-hours messages that no staff reply
+// has followed yet, oldest first (optionally ?room=id)
+func getAfterHoursQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	roomID := 0
+	if v := r.URL.Query().Get("room"); v != "" {
+		var err error
+		if roomID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid room", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT h.id, h.room_id, h.user_id, `+messageText("h")+`, h.timestamp FROM chat_history h
+		WHERE (h.metadata->>'after_hours')::boolean AND ($1 = 0 OR h.room_id = $1)
+			AND NOT EXISTS (SELECT 1 FROM chat_history reply
+				WHERE reply.room_id = h.room_id AND reply.id > h.id AND (reply.metadata->>'staff')::boolean)
+		ORDER BY h.id LIMIT 500`, roomID)
+	if err != nil {
+		http.Error(w, "Failed to fetch after-hours queue", http.StatusInternalServerError)
+		log.Println("Error fetching after-hours queue:", err)
+		return
+	}
+	defer rows.Close()
+
+	queue := []QueuedMessage{}
+	for rows.Next() {
+		var m QueuedMessage
+		if err := rows.Scan(&m.ID, &m.RoomID, &m.User, &m.Message, &m.Timestamp); err != nil {
+			http.Error(w, "Error processing after-hours queue", http.StatusInternalServerError)
+			log.Println("Error scanning after-hours queue:", err)
+			return
+		}
+		queue = append(queue, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queue)
+}
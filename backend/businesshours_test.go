@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// October 16, 2026 is a Friday
+func at(value string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04 MST", value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+var weekdays = []string{"mon", "tue", "wed", "thu", "fri"}
+
+func TestClockMinutes(t *testing.T) {
+	tests := []struct {
+		hhmm string
+		want int
+	}{
+		{"00:00", 0},
+		{"09:30", 570},
+		{"17:00", 1020},
+		{"23:59", 1439},
+	}
+	for _, tt := range tests {
+		if got := clockMinutes(tt.hhmm); got != tt.want {
+			t.Errorf("clockMinutes(%q) = %d, want %d", tt.hhmm, got, tt.want)
+		}
+	}
+}
+
+func TestBusinessHoursOpen(t *testing.T) {
+	office := &BusinessHours{Start: "09:00", End: "17:00", Timezone: "UTC", Days: weekdays}
+	night := &BusinessHours{Start: "22:00", End: "06:00", Timezone: "UTC", Days: []string{"fri"}}
+	newYork := &BusinessHours{Start: "09:00", End: "17:00", Timezone: "America/New_York"}
+
+	tests := []struct {
+		name  string
+		hours *BusinessHours
+		now   string
+		want  bool
+	}{
+		{"during the day", office, "2026-10-16 10:00 UTC", true},
+		{"at opening", office, "2026-10-16 09:00 UTC", true},
+		{"before opening", office, "2026-10-16 08:59 UTC", false},
+		{"at closing", office, "2026-10-16 17:00 UTC", false},
+		{"weekend", office, "2026-10-17 12:00 UTC", false},
+		{"overnight, evening", night, "2026-10-16 23:00 UTC", true},
+		{"overnight, early hours of the next day", night, "2026-10-17 03:00 UTC", true},
+		{"overnight window started the day before isn't listed", night, "2026-10-16 03:00 UTC", false},
+		{"local time, open", newYork, "2026-10-16 13:30 UTC", true},
+		{"local time, not yet open", newYork, "2026-10-16 12:30 UTC", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hours.open(at(tt.now)); got != tt.want {
+				t.Errorf("open(%s) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBusinessHoursNextOpening(t *testing.T) {
+	office := &BusinessHours{Start: "09:00", End: "17:00", Timezone: "UTC", Days: weekdays}
+
+	tests := []struct {
+		now, want string
+	}{
+		{"2026-10-16 08:00 UTC", "2026-10-16 09:00 UTC"},
+		{"2026-10-16 18:00 UTC", "2026-10-19 09:00 UTC"},
+		{"2026-10-17 12:00 UTC", "2026-10-19 09:00 UTC"},
+	}
+	for _, tt := range tests {
+		got := office.nextOpening(at(tt.now))
+		if got == nil || !got.Equal(at(tt.want)) {
+			t.Errorf("nextOpening(%s) = %v, want %s", tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestBusinessHoursAddOpen(t *testing.T) {
+	office := &BusinessHours{Start: "09:00", End: "17:00", Timezone: "UTC", Days: weekdays}
+
+	tests := []struct {
+		name string
+		from string
+		d    time.Duration
+		want string
+	}{
+		{"within the day", "2026-10-14 10:00 UTC", 3 * time.Hour, "2026-10-14 13:00 UTC"},
+		{"across the weekend", "2026-10-16 16:00 UTC", 2 * time.Hour, "2026-10-19 10:00 UTC"},
+		{"starting after hours", "2026-10-17 12:00 UTC", time.Hour, "2026-10-19 10:00 UTC"},
+		{"ending exactly at closing", "2026-10-15 16:00 UTC", 9 * time.Hour, "2026-10-16 17:00 UTC"},
+		{"starting before opening", "2026-10-14 07:00 UTC", 30 * time.Minute, "2026-10-14 09:30 UTC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := office.addOpen(at(tt.from), tt.d); !got.Equal(at(tt.want)) {
+				t.Errorf("addOpen(%s, %s) = %s, want %s", tt.from, tt.d, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSLADue(t *testing.T) {
+	oldDefault := defaultBusinessHours
+	t.Cleanup(func() { defaultBusinessHours = oldDefault })
+	office := &BusinessHours{Start: "09:00", End: "17:00", Timezone: "UTC", Days: weekdays}
+	started := at("2026-10-16 16:00 UTC")
+
+	tests := []struct {
+		name       string
+		deployment *BusinessHours
+		room       *BusinessHours
+		want       string
+	}{
+		{"always open", nil, nil, "2026-10-16 18:00 UTC"},
+		{"deployment business hours", office, nil, "2026-10-19 10:00 UTC"},
+		{"room business hours", nil, office, "2026-10-19 10:00 UTC"},
+		{"room hours override the deployment's", &BusinessHours{Start: "00:00", End: "23:59", Timezone: "UTC"}, office, "2026-10-19 10:00 UTC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultBusinessHours = tt.deployment
+			room := &Room{Metadata: RoomMetadata{BusinessHours: tt.room}}
+			if got := slaDue(room, started, 2*time.Hour); !got.Equal(at(tt.want)) {
+				t.Errorf("slaDue = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
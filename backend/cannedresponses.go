@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Canned responses are replies the support team saved under a shortcut. Operators send
+// one by typing "/canned shortcut" (the message is replaced by the response before it's
+// stored) or pick one from the list endpoint. Bodies may use {{variables}} like prompt
+// templates, filled from the room's settings and key=value arguments. Responses marked
+// as style examples are shown to the AI so its answers match the team's tone.
+var (
+	cannedStyleMax int // Most style examples put in a prompt, most recently updated first; 0 for none
+
+	cannedStyleMu       sync.RWMutex
+	cannedStyleExamples []string
+)
+
+// maxCannedResponseChars limits a canned response's body
+const maxCannedResponseChars = 4000
+
+// CannedResponse is a saved reply
+type CannedResponse struct {
+	Shortcut     string    `json:"shortcut"`
+	Title        string    `json:"title"`
+	Body         string    `json:"body"`
+	Variables    []string  `json:"variables"`
+	StyleExample bool      `json:"style_example"` // Shown to the AI as an example of the team's style
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// initCannedResponses creates the canned responses table and loads the style examples
+func initCannedResponses() {
+	cannedStyleMax = getEnvInt("CANNED_STYLE_EXAMPLES", 5)
+	createCannedResponsesTable()
+	if err := loadCannedStyleExamples(); err != nil {
+		log.Fatal("❌ Failed to load canned response style examples:", err)
+	}
+}
+
+// Create `canned_responses` table if it doesn't exist
+func createCannedResponsesTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS canned_responses (
+			shortcut TEXT PRIMARY KEY,
+			title TEXT NOT NULL DEFAULT '',
+			body TEXT NOT NULL,
+			style_example BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			updated_at TIMESTAMPTZ DEFAULT NOW()
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create canned_responses table:", err)
+	}
+	log.Println("✅ Table canned_responses is ready")
+}
+
+const cannedResponseColumns = "shortcut, title, body, style_example, created_at, updated_at"
+
+// scanCannedResponse reads a row of cannedResponseColumns
+func scanCannedResponse(row pgx.Row) (*CannedResponse, error) {
+	var c CannedResponse
+	if err := row.Scan(&c.Shortcut, &c.Title, &c.Body, &c.StyleExample, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return nil, err
+	}
+	c.Variables = templateVariables(c.Body)
+	return &c, nil
+}
+
+// getCannedResponse loads a canned response by shortcut
+func getCannedResponse(shortcut string) (*CannedResponse, error) {
+	return scanCannedResponse(db.QueryRow(context.Background(),
+		"SELECT "+cannedResponseColumns+" FROM canned_responses WHERE shortcut = $1", shortcut))
+}
+
+// loadCannedStyleExamples reloads the bodies the AI is shown as style examples
+func loadCannedStyleExamples() error {
+	rows, err := db.Query(context.Background(),
+		"SELECT body FROM canned_responses WHERE style_example ORDER BY updated_at DESC LIMIT $1", max(0, cannedStyleMax))
+	if err != nil {
+		return err
+	}
+	examples, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	cannedStyleMu.Lock()
+	defer cannedStyleMu.Unlock()
+	cannedStyleExamples = examples
+	return nil
+}
+
+// cannedStyleInstructions show the AI the team's example replies, or "" without any
+func cannedStyleInstructions() string {
+	cannedStyleMu.RLock()
+	defer cannedStyleMu.RUnlock()
+	if len(cannedStyleExamples) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Match the tone and style of these example replies from the support team; don't copy them unless they answer the question:\n")
+	for _, example := range cannedStyleExamples {
+		fmt.Fprintf(&b, "---\n%s\n", example)
+	}
+	return b.String() + "---\n\n"
+}
+
+// expandCannedMessage replaces an operator's "/canned shortcut key=value ..." message with
+// the response. ok is false for other messages; when it's true and text is empty the
+// session was already told why nothing was sent.
+func expandCannedMessage(s *Session, message string) (text string, ok bool) {
+	name, args, isCommand := parseCommand(message)
+	if !isCommand || name != "canned" {
+		return "", false
+	}
+	if !s.moderator {
+		s.sendError("forbidden", "Canned responses are for moderators")
+		return "", true
+	}
+	shortcut, rest, _ := strings.Cut(args, " ")
+	if shortcut == "" {
+		s.sendText("💬 Usage: /canned <shortcut> key=value key2=\"value with spaces\"")
+		return "", true
+	}
+
+	c, err := getCannedResponse(strings.ToLower(shortcut))
+	if err == pgx.ErrNoRows {
+		s.sendError("canned_not_found", fmt.Sprintf("No canned response has the shortcut %q", shortcut))
+		return "", true
+	}
+	if err != nil {
+		log.Println("Error fetching canned response:", err)
+		s.sendError("canned_failed", "Could not load that canned response, please try again")
+		return "", true
+	}
+
+	// The room's settings fill in variables the command doesn't give
+	values, err := roomSettings(s.room)
+	if err != nil {
+		log.Println("Error fetching room settings:", err)
+		values = map[string]string{}
+	}
+	for name, value := range parseTemplateArgs(rest) {
+		values[name] = value
+	}
+	text, err = renderTemplate(c.Body, values)
+	if err != nil {
+		s.sendError("canned_variables", fmt.Sprintf("%s (canned response %s uses: %s)", err, c.Shortcut, strings.Join(c.Variables, ", ")))
+		return "", true
+	}
+	addCounter("cubbychat_canned_responses_sent_total", "Canned responses operators sent", 1)
+	return text, true
+}
+
+// Handler for /api/canned-responses: create or replace with POST, list with GET
+// (optionally ?q= to match shortcuts, titles and bodies, for pickers)
+func handleCannedResponses(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		saveCannedResponse(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	rows, err := db.Query(context.Background(), "SELECT "+cannedResponseColumns+` FROM canned_responses
+		WHERE $1 = '' OR shortcut LIKE lower($1) || '%' OR title ILIKE '%' || $1 || '%' OR body ILIKE '%' || $1 || '%'
+		ORDER BY shortcut LIKE lower($1) || '%' DESC, shortcut`, q)
+	if err != nil {
+		http.Error(w, "Failed to fetch canned responses", http.StatusInternalServerError)
+		log.Println("Error fetching canned responses:", err)
+		return
+	}
+	defer rows.Close()
+
+	responses := []*CannedResponse{}
+	for rows.Next() {
+		c, err := scanCannedResponse(rows)
+		if err != nil {
+			http.Error(w, "Error processing canned responses", http.StatusInternalServerError)
+			log.Println("Error scanning canned responses:", err)
+			return
+		}
+		responses = append(responses, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responses)
+}
+
+// Handler to create or update a canned response
+func saveCannedResponse(w http.ResponseWriter, r *http.Request) {
+	var req CannedResponse
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Shortcut = strings.ToLower(strings.TrimSpace(req.Shortcut))
+	req.Title = strings.TrimSpace(req.Title)
+	req.Body = strings.TrimSpace(req.Body)
+	if !templateNamePattern.MatchString(req.Shortcut) {
+		http.Error(w, "Shortcuts use lowercase letters, digits, - and _", http.StatusBadRequest)
+		return
+	}
+	if req.Body == "" {
+		http.Error(w, "A body is required", http.StatusBadRequest)
+		return
+	}
+	if len([]rune(req.Body)) > maxCannedResponseChars {
+		http.Error(w, fmt.Sprintf("Canned responses are limited to %d characters", maxCannedResponseChars), http.StatusBadRequest)
+		return
+	}
+
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO canned_responses (shortcut, title, body, style_example) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (shortcut) DO UPDATE SET title = EXCLUDED.title, body = EXCLUDED.body,
+			style_example = EXCLUDED.style_example, updated_at = NOW()
+		RETURNING created_at, updated_at`, req.Shortcut, req.Title, req.Body, req.StyleExample).Scan(&req.CreatedAt, &req.UpdatedAt)
+	if err == nil {
+		err = loadCannedStyleExamples()
+	}
+	if err != nil {
+		http.Error(w, "Failed to save canned response", http.StatusInternalServerError)
+		log.Println("Error saving canned response:", err)
+		return
+	}
+	req.Variables = templateVariables(req.Body)
+	recordAudit("moderator", clientIP(r), "canned_response.save", req.Shortcut, map[string]interface{}{"title": req.Title, "style_example": req.StyleExample})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
+
+// Handler for /api/canned-responses/{shortcut}: fetch with GET, remove with DELETE
+func handleCannedResponse(w http.ResponseWriter, r *http.Request) {
+	shortcut := strings.ToLower(r.PathValue("shortcut"))
+
+	if r.Method == http.MethodDelete {
+		tag, err := db.Exec(context.Background(), "DELETE FROM canned_responses WHERE shortcut = $1", shortcut)
+		if err == nil && tag.RowsAffected() > 0 {
+			err = loadCannedStyleExamples()
+		}
+		if err != nil {
+			http.Error(w, "Failed to delete canned response", http.StatusInternalServerError)
+			log.Println("Error deleting canned response:", err)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			http.Error(w, "Canned response not found", http.StatusNotFound)
+			return
+		}
+		recordAudit("moderator", clientIP(r), "canned_response.delete", shortcut, nil)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	c, err := getCannedResponse(shortcut)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Canned response not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch canned response", http.StatusInternalServerError)
+		log.Println("Error fetching canned response:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
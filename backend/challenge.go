@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	challengeMode       string        // off, pow, hcaptcha or turnstile
+	challengeGuestsOnly bool          // Whether users with a user token skip the challenge
+	challengeSiteKey    string        // Public CAPTCHA site key handed to the frontend
+	challengeSecret     string        // CAPTCHA secret used to verify tokens
+	powDifficulty       int           // Leading zero bits a proof-of-work hash needs
+	challengePassTTL    time.Duration // How long a solved challenge admits a client
+	challengeKey        []byte        // Signs puzzles and passes; regenerated on restart
+)
+
+// Puzzles and passes are signed under separate domains, so neither can stand in for the other
+const (
+	puzzleDomain = "puzzle"
+	passDomain   = "pass"
+)
+
+// Solved puzzles until they expire, so each one is exchanged for a pass only once
+var (
+	redeemedPuzzlesMu sync.Mutex
+	redeemedPuzzles   = map[string]time.Time{}
+)
+
+// captchaVerifyURLs are the siteverify endpoints of the supported CAPTCHA services
+var captchaVerifyURLs = map[string]string{
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// ChallengeResponse tells the client what it has to solve
+type ChallengeResponse struct {
+	Mode       string `json:"mode"`
+	SiteKey    string `json:"site_key,omitempty"`
+	Challenge  string `json:"challenge,omitempty"`  // Proof-of-work puzzle
+	Difficulty int    `json:"difficulty,omitempty"` // Leading zero bits of sha256(challenge + ":" + solution)
+}
+
+// ChallengePass admits a client to the WebSocket ("pass" query parameter)
+type ChallengePass struct {
+	Pass      string    `json:"pass"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// initChallenge reads the guest challenge settings
+func initChallenge() {
+	challengeMode = getEnv("CHALLENGE_MODE", "off")
+	challengeGuestsOnly = getEnvBool("CHALLENGE_GUESTS_ONLY", true)
+	challengeSiteKey = getEnv("CHALLENGE_SITE_KEY", "")
+	challengeSecret = getEnv("CHALLENGE_SECRET", "")
+	powDifficulty = min(32, max(1, getEnvInt("POW_DIFFICULTY", 16)))
+	challengePassTTL = getEnvDuration("CHALLENGE_PASS_TTL", time.Hour)
+
+	switch challengeMode {
+	case "off":
+		return
+	case "pow":
+	case "hcaptcha", "turnstile":
+		if challengeSecret == "" {
+			log.Fatalf("❌ CHALLENGE_MODE=%s needs CHALLENGE_SECRET", challengeMode)
+		}
+	default:
+		log.Fatalf("❌ Unknown CHALLENGE_MODE %q (use off, pow, hcaptcha or turnstile)", challengeMode)
+	}
+
+	challengeKey = make([]byte, 32)
+	if _, err := rand.Read(challengeKey); err != nil {
+		log.Fatal("❌ Failed to generate challenge key:", err)
+	}
+	log.Printf("🧩 Clients must pass a %s challenge before chatting", challengeMode)
+}
+
+// challengeRequired reports whether a connecting client must present a pass; identity is
+// their verified user name, "" for guests
+func challengeRequired(identity string) bool {
+	return challengeMode != "off" && (identity == "" || !challengeGuestsOnly)
+}
+
+// passedChallenge checks a connecting client's pass, writing a 403 response if it needs one and has none
+func passedChallenge(w http.ResponseWriter, r *http.Request) bool {
+	if challengeRequired(verifiedUser(r)) && !validPass(r.URL.Query().Get("pass")) {
+		http.Error(w, "Solve the challenge at /api/challenge first", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// signChallenge returns an HMAC of a value under the challenge key in a domain
+func signChallenge(domain, value string) string {
+	mac := hmac.New(sha256.New, challengeKey)
+	mac.Write([]byte(domain + ":" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySigned checks a "<value>.<signature>" string signed in a domain and returns the value
+func verifySigned(domain, signed string) (string, bool) {
+	value, sig, ok := strings.Cut(signed, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signChallenge(domain, value))) {
+		return "", false
+	}
+	return value, true
+}
+
+// newPuzzle issues a signed proof-of-work puzzle of the form "<unix expiry>-<nonce>.<signature>"
+func newPuzzle() string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	value := fmt.Sprintf("%d-%s", time.Now().Add(5*time.Minute).Unix(), hex.EncodeToString(nonce))
+	return value + "." + signChallenge(puzzleDomain, value)
+}
+
+// expiry reads the unix expiry at the start of a signed value
+func expiry(value string) (time.Time, bool) {
+	prefix, _, _ := strings.Cut(value, "-")
+	unix, err := strconv.ParseInt(prefix, 10, 64)
+	return time.Unix(unix, 0), err == nil
+}
+
+// notExpired reports whether a signed value's expiry is still ahead
+func notExpired(value string) bool {
+	expires, ok := expiry(value)
+	return ok && time.Now().Before(expires)
+}
+
+// solvesPuzzle checks that a solution gives the puzzle's hash enough leading zero bits
+func solvesPuzzle(puzzle, solution string) bool {
+	value, ok := verifySigned(puzzleDomain, puzzle)
+	if !ok || !notExpired(value) || len(solution) > 64 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(puzzle + ":" + solution))
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros >= powDifficulty
+}
+
+// redeemPuzzle marks a solved puzzle as used, returning false if it already was
+func redeemPuzzle(puzzle string) bool {
+	value, _, _ := strings.Cut(puzzle, ".")
+	expires, _ := expiry(value)
+	now := time.Now()
+
+	redeemedPuzzlesMu.Lock()
+	defer redeemedPuzzlesMu.Unlock()
+	for p, at := range redeemedPuzzles {
+		if !now.Before(at) {
+			delete(redeemedPuzzles, p)
+		}
+	}
+	if _, used := redeemedPuzzles[value]; used {
+		return false
+	}
+	redeemedPuzzles[value] = expires
+	return true
+}
+
+// verifyCaptcha asks the CAPTCHA service whether a token is genuine
+func verifyCaptcha(token, remoteIP string) (bool, error) {
+	var result struct {
+		Success bool `json:"success"`
+	}
+	resp, err := newUpstreamClient().SetTimeout(10 * time.Second).R().
+		SetFormData(map[string]string{"secret": challengeSecret, "response": token, "remoteip": remoteIP}).
+		SetResult(&result).
+		Post(captchaVerifyURLs[challengeMode])
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode() != 200 {
+		return false, fmt.Errorf("%s returned status %d", challengeMode, resp.StatusCode())
+	}
+	return result.Success, nil
+}
+
+// newPass issues a pass that admits the client until it expires
+func newPass() ChallengePass {
+	expires := time.Now().Add(challengePassTTL)
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	value := fmt.Sprintf("%d-%s", expires.Unix(), hex.EncodeToString(nonce))
+	return ChallengePass{Pass: value + "." + signChallenge(passDomain, value), ExpiresAt: expires.UTC().Truncate(time.Second)}
+}
+
+// validPass checks a pass issued by newPass
+func validPass(pass string) bool {
+	value, ok := verifySigned(passDomain, pass)
+	return ok && notExpired(value)
+}
+
+// Handler for /api/challenge: GET describes the challenge, POST exchanges a solution for a pass
+func handleChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		resp := ChallengeResponse{Mode: challengeMode}
+		switch challengeMode {
+		case "pow":
+			resp.Challenge = newPuzzle()
+			resp.Difficulty = powDifficulty
+		case "hcaptcha", "turnstile":
+			resp.SiteKey = challengeSiteKey
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if challengeMode == "off" {
+		http.Error(w, "No challenge is required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Challenge string `json:"challenge"`
+		Solution  string `json:"solution"`
+		Token     string `json:"token"` // CAPTCHA response token
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if challengeMode == "pow" {
+		if !solvesPuzzle(req.Challenge, req.Solution) {
+			http.Error(w, "Invalid or expired solution", http.StatusForbidden)
+			return
+		}
+		if !redeemPuzzle(req.Challenge) {
+			http.Error(w, "This challenge has already been used", http.StatusForbidden)
+			return
+		}
+	} else {
+		ok, err := verifyCaptcha(req.Token, clientIP(r))
+		if err != nil {
+			http.Error(w, "Failed to verify challenge", http.StatusBadGateway)
+			log.Println("Error verifying CAPTCHA:", err)
+			return
+		}
+		if !ok {
+			http.Error(w, "Challenge failed", http.StatusForbidden)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newPass())
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// withChallenge sets up a proof-of-work challenge for one test and puts the old settings back after it
+func withChallenge(t *testing.T, guestsOnly bool) {
+	t.Helper()
+	oldMode, oldGuestsOnly, oldDifficulty, oldTTL, oldKey := challengeMode, challengeGuestsOnly, powDifficulty, challengePassTTL, challengeKey
+	t.Cleanup(func() {
+		challengeMode, challengeGuestsOnly, powDifficulty, challengePassTTL, challengeKey = oldMode, oldGuestsOnly, oldDifficulty, oldTTL, oldKey
+	})
+	challengeMode, challengeGuestsOnly, powDifficulty, challengePassTTL = "pow", guestsOnly, 4, time.Hour
+	challengeKey = []byte("test challenge key")
+}
+
+func solvePuzzle(t *testing.T, puzzle string) string {
+	t.Helper()
+	for i := 0; i < 1<<16; i++ {
+		if solution := strconv.Itoa(i); solvesPuzzle(puzzle, solution) {
+			return solution
+		}
+	}
+	t.Fatal("no solution found")
+	return ""
+}
+
+func TestChallengeRequired(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		guestsOnly bool
+		identity   string
+		want       bool
+	}{
+		{"off", "off", true, "", false},
+		{"guest", "pow", true, "", true},
+		{"verified user exempt", "pow", true, "alice", false},
+		{"verified user when everyone is challenged", "pow", false, "alice", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withChallenge(t, tt.guestsOnly)
+			challengeMode = tt.mode
+			if got := challengeRequired(tt.identity); got != tt.want {
+				t.Errorf("challengeRequired(%q) = %v, want %v", tt.identity, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPuzzleIsNotAPass(t *testing.T) {
+	withChallenge(t, true)
+	puzzle := newPuzzle()
+	if validPass(puzzle) {
+		t.Error("an unsolved puzzle is accepted as a pass")
+	}
+	pass := newPass().Pass
+	if !validPass(pass) {
+		t.Error("a fresh pass is rejected")
+	}
+	if solvesPuzzle(pass, "0") {
+		t.Error("a pass is accepted as a puzzle")
+	}
+}
+
+func TestPuzzleRedeemedOnce(t *testing.T) {
+	withChallenge(t, true)
+	puzzle := newPuzzle()
+	solution := solvePuzzle(t, puzzle)
+	if !solvesPuzzle(puzzle, solution) {
+		t.Fatal("solution rejected")
+	}
+	if !redeemPuzzle(puzzle) {
+		t.Fatal("first redemption refused")
+	}
+	if redeemPuzzle(puzzle) {
+		t.Error("a solved puzzle can be redeemed twice")
+	}
+}
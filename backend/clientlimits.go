@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	maxConnectionsPerUser int // Simultaneous WebSocket connections per named user; 0 means unlimited
+	maxConnectionsPerIP   int // Simultaneous WebSocket connections per client address; 0 means unlimited
+	maxGenerationsPerUser int // Concurrent AI generations per user (or address when anonymous); 0 means unlimited
+)
+
+// In-flight generations by client key
+var (
+	activeGenerationsMu sync.Mutex
+	activeGenerations   = make(map[string]int)
+)
+
+// initClientLimits reads the per-client connection and generation caps
+func initClientLimits() {
+	maxConnectionsPerUser = getEnvInt("MAX_CONNECTIONS_PER_USER", 0)
+	maxConnectionsPerIP = getEnvInt("MAX_CONNECTIONS_PER_IP", 0)
+	maxGenerationsPerUser = getEnvInt("MAX_GENERATIONS_PER_USER", 0)
+}
+
+// clientKey identifies who a session belongs to for per-user limits
+func (s *Session) clientKey() string {
+	if s.user != "" {
+		return "user:" + s.user
+	}
+	return "ip:" + s.ip
+}
+
+// connectionLimitExceeded reports why a new session can't be admitted, if it can't.
+// sessionsMu must be held so the check and the registration are atomic.
+func connectionLimitExceeded(s *Session) string {
+	users, ips := 0, 0
+	for other := range sessions {
+		if s.user != "" && other.user == s.user {
+			users++
+		}
+		if other.ip == s.ip {
+			ips++
+		}
+	}
+	if maxConnectionsPerUser > 0 && s.user != "" && users >= maxConnectionsPerUser {
+		return fmt.Sprintf("You already have %d connections open; close one and try again", users)
+	}
+	if maxConnectionsPerIP > 0 && ips >= maxConnectionsPerIP {
+		return fmt.Sprintf("Too many connections from your address (limit %d)", maxConnectionsPerIP)
+	}
+	return ""
+}
+
+// beginGeneration claims one of the client's generation slots, returning false when all are in use
+func beginGeneration(s *Session) bool {
+	if maxGenerationsPerUser <= 0 {
+		return true
+	}
+	activeGenerationsMu.Lock()
+	defer activeGenerationsMu.Unlock()
+	key := s.clientKey()
+	if activeGenerations[key] >= maxGenerationsPerUser {
+		return false
+	}
+	activeGenerations[key]++
+	return true
+}
+
+// endGeneration gives back a slot taken by beginGeneration
+func endGeneration(s *Session) {
+	if maxGenerationsPerUser <= 0 {
+		return
+	}
+	activeGenerationsMu.Lock()
+	defer activeGenerationsMu.Unlock()
+	key := s.clientKey()
+	if activeGenerations[key] <= 1 {
+		delete(activeGenerations, key)
+		return
+	}
+	activeGenerations[key]--
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// clock and rng are where time-dependent and random behavior (waiting messages,
+// retry backoff, schedulers, deadlines) gets its time and randomness. Tests swap in a
+// manualClock and a seeded RNG to make that behavior exact and repeatable.
+var (
+	clock Clock = realClock{}
+	rng   RNG   = newLockedRand(time.Now().UnixNano())
+)
+
+// Clock tells the time and waits
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Sleep(d time.Duration)
+	NewTicker(d time.Duration) Ticker
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Ticker delivers ticks on C every period until stopped
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Timer is a pending AfterFunc call
+type Timer interface {
+	Stop() bool
+}
+
+// RNG is the random number source
+type RNG interface {
+	Intn(n int) int
+	Int63n(n int64) int64
+	Float64() float64
+}
+
+// initClock makes the RNG deterministic when RANDOM_SEED is set, for integration tests
+func initClock() {
+	if seed := getEnvInt("RANDOM_SEED", 0); seed != 0 {
+		rng = newLockedRand(int64(seed))
+		log.Printf("🎲 Random numbers seeded with %d", seed)
+	}
+}
+
+// realClock is the system clock
+type realClock struct{}
+
+func (realClock) Now() time.Time                  { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+func (realClock) Sleep(d time.Duration)           { time.Sleep(d) }
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// lockedRand is a math/rand source that's safe to share between goroutines
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newLockedRand(seed int64) *lockedRand {
+	return &lockedRand{r: rand.New(rand.NewSource(seed))}
+}
+
+func (l *lockedRand) Intn(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Intn(n)
+}
+
+func (l *lockedRand) Int63n(n int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Int63n(n)
+}
+
+func (l *lockedRand) Float64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float64()
+}
+
+// manualClock only moves when Advance is called, firing the tickers, timers and
+// sleeps that fall due on the way
+type manualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*manualWaiter
+}
+
+// manualWaiter is a pending tick, timer or sleep
+type manualWaiter struct {
+	clock   *manualClock
+	at      time.Time
+	period  time.Duration // Non-zero for tickers
+	c       chan time.Time
+	f       func()
+	stopped bool
+}
+
+func newManualClock(now time.Time) *manualClock {
+	return &manualClock{now: now}
+}
+
+func (m *manualClock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+func (m *manualClock) Since(t time.Time) time.Duration { return m.Now().Sub(t) }
+
+func (m *manualClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	<-m.add(d, 0, nil).c
+}
+
+func (m *manualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return manualTicker{m.add(d, d, nil)}
+}
+
+func (m *manualClock) AfterFunc(d time.Duration, f func()) Timer {
+	return m.add(d, 0, f)
+}
+
+// add registers a waiter due after d
+func (m *manualClock) add(d, period time.Duration, f func()) *manualWaiter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w := &manualWaiter{clock: m, at: m.now.Add(d), period: period, c: make(chan time.Time, 1), f: f}
+	m.waiters = append(m.waiters, w)
+	return w
+}
+
+// Advance moves the clock forward, firing everything that falls due in order
+func (m *manualClock) Advance(d time.Duration) {
+	m.mu.Lock()
+	target := m.now.Add(d)
+	for {
+		var next *manualWaiter
+		for _, w := range m.waiters {
+			if !w.stopped && !w.at.After(target) && (next == nil || w.at.Before(next.at)) {
+				next = w
+			}
+		}
+		if next == nil {
+			break
+		}
+		m.now = next.at
+		if next.period > 0 {
+			next.at = next.at.Add(next.period)
+		} else {
+			next.stopped = true
+		}
+		fire, f, at := next.c, next.f, m.now
+		m.mu.Unlock()
+		if f != nil {
+			f()
+		} else {
+			select {
+			case fire <- at:
+			default: // Like time.Ticker, a slow reader misses ticks
+			}
+		}
+		m.mu.Lock()
+	}
+	m.now = target
+	live := m.waiters[:0]
+	for _, w := range m.waiters {
+		if !w.stopped {
+			live = append(live, w)
+		}
+	}
+	m.waiters = live
+	m.mu.Unlock()
+}
+
+// Stop cancels the waiter, reporting whether it was still pending
+func (w *manualWaiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	pending := !w.stopped
+	w.stopped = true
+	return pending
+}
+
+// manualTicker is a manualClock ticker
+type manualTicker struct{ w *manualWaiter }
+
+func (t manualTicker) C() <-chan time.Time { return t.w.c }
+func (t manualTicker) Stop()               { t.w.Stop() }
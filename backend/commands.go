@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// chatCommand handles a slash command typed in chat; args is the text after the command name
+type chatCommand func(s *Session, args string)
+
+var chatCommands = map[string]chatCommand{}
+
+// registerCommand makes "/name ..." available in chat
+func registerCommand(name string, handler chatCommand) {
+	chatCommands[name] = handler
+}
+
+// parseCommand splits "/name args" into its parts; ok is false for ordinary messages
+func parseCommand(text string) (name, args string, ok bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return "", "", false
+	}
+	name, args, _ = strings.Cut(text[1:], " ")
+	return strings.ToLower(name), strings.TrimSpace(args), name != ""
+}
+
+// handleCommand runs a registered or plugin slash command. It returns false when the message
+// is not a known command and should be answered by the AI as usual.
+func handleCommand(s *Session, text string) bool {
+	name, args, ok := parseCommand(text)
+	if !ok {
+		return false
+	}
+	handler, found := chatCommands[name]
+	if !found {
+		return runPluginCommand(s, name, args)
+	}
+
+	log.Printf("⚡ Running command /%s", name)
+	handler(s, args)
+	return true
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Conversation compression: a background job replaces very old messages in long-lived
+// rooms with a model-written summary. The originals move to chat_history_archive, so
+// nothing is lost, but the live history (and everything that reads it) stays bounded.
+var (
+	compressionEnabled    bool
+	compressionInterval   time.Duration // How often the job runs
+	compressionMinAge     time.Duration // Messages younger than this are never compressed
+	compressionKeepRecent int           // Each room's latest messages stay raw however old they are
+	compressionChunk      int           // Most messages one summary replaces
+	compressionMinChunk   int           // Fewer old messages than this aren't worth a summary
+	compressionMaxChunks  int           // Most summaries written per run
+	compressionTimeout    time.Duration // How long the model may take to write a summary
+)
+
+const compressionInstructions = `Summarize this excerpt from a long-running chat so it can replace the original messages.
+Keep the questions asked, the answers and decisions reached, names, numbers and anything left open. Write compact
+bullet points and reply with the summary only.`
+
+// initCompression reads the compression settings, adds the archive table and starts the job
+func initCompression() {
+	compressionEnabled = getEnvBool("COMPRESSION_ENABLED", false)
+	// The table is needed to read originals compressed while the job was on
+	createChatHistoryArchiveTable()
+	if !compressionEnabled {
+		return
+	}
+	compressionInterval = getEnvDuration("COMPRESSION_INTERVAL", 6*time.Hour)
+	compressionMinAge = getEnvDuration("COMPRESSION_MIN_AGE", 90*24*time.Hour)
+	compressionKeepRecent = getEnvInt("COMPRESSION_KEEP_RECENT", 200)
+	compressionChunk = getEnvInt("COMPRESSION_CHUNK", 100)
+	compressionMinChunk = min(getEnvInt("COMPRESSION_MIN_CHUNK", 20), compressionChunk)
+	compressionMaxChunks = getEnvInt("COMPRESSION_MAX_CHUNKS", 10)
+	compressionTimeout = getEnvDuration("COMPRESSION_TIMEOUT", 2*time.Minute)
+	go runCompressionJob()
+	log.Printf("🗜️ Messages older than %v are compressed into summaries", compressionMinAge)
+}
+
+// Create `chat_history_archive` table if it doesn't exist
+func createChatHistoryArchiveTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS chat_history_archive (
+			id INTEGER PRIMARY KEY,
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			sender TEXT NOT NULL,
+			message TEXT NOT NULL,
+			timestamp TIMESTAMPTZ,
+			metadata JSONB,
+			user_id TEXT NOT NULL DEFAULT '',
+			client_id TEXT,
+			summary_id INTEGER REFERENCES chat_history(id) ON DELETE SET NULL,
+			archived_at TIMESTAMPTZ DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS chat_history_archive_summary_idx ON chat_history_archive (summary_id);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create chat_history_archive table:", err)
+	}
+	log.Println("✅ Table chat_history_archive is ready")
+}
+
+// runCompressionJob compresses old messages every COMPRESSION_INTERVAL
+func runCompressionJob() {
+	ticker := clock.NewTicker(compressionInterval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		compressed, err := compressOldMessages()
+		if err != nil {
+			log.Println("Error compressing old messages:", err)
+		}
+		if compressed > 0 {
+			log.Printf("🗜️ Compressed %d old messages", compressed)
+		}
+	}
+}
+
+// compressionCandidate is a run of old messages in one room, oldest first
+type compressionCandidate struct {
+	roomID   int
+	messages []ChatMessage
+}
+
+// compressionCandidates finds the oldest compressible messages in each room: old enough,
+// not among the room's latest, not rated (ratings feed fine-tuning exports) and not
+// themselves summaries or annotations
+func compressionCandidates(ctx context.Context) ([]compressionCandidate, error) {
+	rows, err := db.Query(ctx, `
+		WITH ranked AS (
+			SELECT id, room_id, sender, `+messageText("chat_history")+` AS message, timestamp,
+				ROW_NUMBER() OVER (PARTITION BY room_id ORDER BY timestamp DESC, id DESC) AS recency
+			FROM chat_history
+		), old AS (
+			SELECT *, ROW_NUMBER() OVER (PARTITION BY room_id ORDER BY timestamp, id) AS age_rank FROM ranked
+			WHERE recency > $1 AND timestamp < $2 AND sender <> 'System'
+				AND NOT EXISTS (SELECT 1 FROM message_feedback WHERE message_id = ranked.id)
+		)
+		SELECT room_id, id, sender, message, timestamp FROM old
+		WHERE age_rank <= $3 AND room_id IN (SELECT room_id FROM old GROUP BY room_id HAVING COUNT(*) >= $4)
+		ORDER BY room_id, timestamp, id`,
+		compressionKeepRecent, clock.Now().Add(-compressionMinAge), compressionChunk, compressionMinChunk)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []compressionCandidate
+	for rows.Next() {
+		var roomID int
+		var msg ChatMessage
+		if err := rows.Scan(&roomID, &msg.ID, &msg.Sender, &msg.Message, &msg.Timestamp); err != nil {
+			return nil, err
+		}
+		if len(candidates) == 0 || candidates[len(candidates)-1].roomID != roomID {
+			if len(candidates) == compressionMaxChunks {
+				break
+			}
+			candidates = append(candidates, compressionCandidate{roomID: roomID})
+		}
+		last := &candidates[len(candidates)-1]
+		last.messages = append(last.messages, msg)
+	}
+	return candidates, rows.Err()
+}
+
+// compressOldMessages summarizes one chunk per room with old messages and reports how
+// many messages were replaced
+func compressOldMessages() (int, error) {
+	candidates, err := compressionCandidates(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	compressed := 0
+	for _, c := range candidates {
+		if err := compressChunk(c); err != nil {
+			log.Printf("Error compressing %d messages in room %d: %v", len(c.messages), c.roomID, err)
+			addCounter("cubbychat_compressions_total", "Chunks of old messages replaced by summaries, by result", 1, "result", "error")
+			continue
+		}
+		compressed += len(c.messages)
+		addCounter("cubbychat_compressions_total", "Chunks of old messages replaced by summaries, by result", 1, "result", "ok")
+		addCounter("cubbychat_compressed_messages_total", "Old messages moved to the archive", float64(len(c.messages)))
+	}
+	return compressed, nil
+}
+
+// compressChunk has the model summarize the messages, then in one transaction moves them
+// to the archive and puts the summary in their place
+func compressChunk(c compressionCandidate) error {
+	var transcript strings.Builder
+	ids := make([]int, len(c.messages))
+	for i, msg := range c.messages {
+		ids[i] = msg.ID
+		fmt.Fprintf(&transcript, "[%s] %s: %s\n", msg.Timestamp.Format("2006-01-02 15:04"), msg.Sender, msg.Message)
+	}
+	summary, err := generateOnce(ollamaModel, compressionInstructions+"\n\nExcerpt:\n"+transcript.String(), "", compressionTimeout)
+	if err != nil {
+		return err
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return fmt.Errorf("the model returned an empty summary")
+	}
+
+	first, last := c.messages[0].Timestamp, c.messages[len(c.messages)-1].Timestamp
+	annotation := Annotation{
+		Kind:   annotationSummary,
+		From:   first.Format("2 Jan 2006"),
+		To:     last.Format("2 Jan 2006"),
+		Detail: strconv.Itoa(len(c.messages)),
+	}
+	text := annotation.text() + "\n\n" + summary
+
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		// The summary takes the place of the last message it replaces
+		var summaryID int
+		err := tx.QueryRow(ctx,
+			"INSERT INTO chat_history (room_id, sender, message, metadata, timestamp) VALUES ($1, 'System', $2, $3, $4) RETURNING id",
+			c.roomID, text, &MessageMetadata{Annotation: &annotation}, last).Scan(&summaryID)
+		if err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO chat_history_archive (id, room_id, sender, message, timestamp, metadata, user_id, client_id, summary_id)
+			SELECT id, room_id, sender, `+messageText("chat_history")+`, timestamp, metadata, user_id, client_id, $2
+			FROM chat_history WHERE id = ANY($1)`, ids, summaryID)
+		if err != nil {
+			return err
+		}
+		if int(tag.RowsAffected()) != len(ids) {
+			return fmt.Errorf("%d of the %d messages were deleted meanwhile", len(ids)-int(tag.RowsAffected()), len(ids))
+		}
+		_, err = tx.Exec(ctx, "DELETE FROM chat_history WHERE id = ANY($1)", ids)
+		return err
+	})
+}
+
+// Handler for /api/admin/compressed/{id}: the original messages a summary replaced
+func getCompressedMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	summaryID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, sender, message, timestamp, metadata, COALESCE(client_id, '') FROM chat_history_archive
+		WHERE summary_id = $1 ORDER BY timestamp, id`, summaryID)
+	if err != nil {
+		http.Error(w, "Failed to fetch compressed messages", http.StatusInternalServerError)
+		log.Println("Error fetching compressed messages:", err)
+		return
+	}
+	defer rows.Close()
+
+	messages := []ChatMessage{}
+	for rows.Next() {
+		var msg ChatMessage
+		if err := rows.Scan(&msg.ID, &msg.Sender, &msg.Message, &msg.Timestamp, &msg.Metadata, &msg.ClientID); err != nil {
+			http.Error(w, "Error processing compressed messages", http.StatusInternalServerError)
+			log.Println("Error scanning compressed messages:", err)
+			return
+		}
+		messages = append(messages, msg)
+	}
+	if len(messages) == 0 {
+		http.Error(w, "No compressed messages for that summary", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
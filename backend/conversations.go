@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Support-style deployments work through rooms as conversations: each has a status,
+// labels and an optional assignee, and the room list can be filtered by them. Rooms
+// without a status are open.
+const (
+	conversationOpen     = "open"     // Waiting on the support team
+	conversationPending  = "pending"  // Waiting on the customer or someone else
+	conversationResolved = "resolved" // Nothing left to do
+)
+
+const (
+	maxRoomLabels     = 20 // Labels a room can have
+	maxRoomLabelChars = 50 // Characters in one label
+)
+
+// Conversation is a room's status, labels and assignee
+type Conversation struct {
+	Status   string   `json:"status"`
+	Labels   []string `json:"labels"`
+	Assignee string   `json:"assignee"`
+}
+
+// ConversationEvent tells a room's clients its status, labels or assignee changed
+type ConversationEvent struct {
+	RoomID int `json:"room_id"`
+	Conversation
+}
+
+// validConversationStatus reports whether status is open, pending or resolved
+func validConversationStatus(status string) bool {
+	return status == conversationOpen || status == conversationPending || status == conversationResolved
+}
+
+// roomConversation returns a room's conversation fields, open for rooms without a status
+func roomConversation(room *Room) Conversation {
+	conversation := Conversation{Status: room.Metadata.Status, Labels: room.Metadata.Labels, Assignee: room.Metadata.Assignee}
+	if conversation.Status == "" {
+		conversation.Status = conversationOpen
+	}
+	if conversation.Labels == nil {
+		conversation.Labels = []string{}
+	}
+	return conversation
+}
+
+// normalizeLabels trims, lowercases, sorts and deduplicates labels, rejecting empty or
+// overlong ones
+func normalizeLabels(labels []string) ([]string, error) {
+	normalized := []string{}
+	for _, label := range labels {
+		label = strings.ToLower(strings.TrimSpace(label))
+		if label == "" {
+			return nil, fmt.Errorf("labels can't be empty")
+		}
+		if len([]rune(label)) > maxRoomLabelChars {
+			return nil, fmt.Errorf("labels are limited to %d characters", maxRoomLabelChars)
+		}
+		normalized = append(normalized, label)
+	}
+	slices.Sort(normalized)
+	normalized = slices.Compact(normalized)
+	if len(normalized) > maxRoomLabels {
+		return nil, fmt.Errorf("rooms are limited to %d labels", maxRoomLabels)
+	}
+	return normalized, nil
+}
+
+// Handler for /api/rooms/{id}/conversation: get the room's status, labels and assignee
+// with GET, change some of them with PATCH ({"status": "open", "pending" or "resolved",
+// "labels": [...], "assignee": "name"}; an empty assignee unassigns the room)
+func handleRoomConversation(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(roomConversation(room))
+		return
+	case http.MethodPatch:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Status   *string   `json:"status"`
+		Labels   *[]string `json:"labels"`
+		Assignee *string   `json:"assignee"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	previous := roomConversation(room)
+	conversation := previous
+	if req.Status != nil {
+		if !validConversationStatus(*req.Status) {
+			http.Error(w, `status must be "open", "pending" or "resolved"`, http.StatusBadRequest)
+			return
+		}
+		conversation.Status = *req.Status
+	}
+	if req.Labels != nil {
+		labels, err := normalizeLabels(*req.Labels)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		conversation.Labels = labels
+	}
+	if req.Assignee != nil {
+		conversation.Assignee = strings.TrimSpace(*req.Assignee)
+	}
+
+	_, err := db.Exec(context.Background(), `
+		UPDATE rooms SET metadata = (metadata - 'status' - 'labels' - 'assignee')
+			|| jsonb_build_object('status', $2::text, 'labels', $3::jsonb)
+			|| CASE WHEN $4::text = '' THEN '{}'::jsonb ELSE jsonb_build_object('assignee', $4::text) END
+		WHERE id = $1`, room.ID, conversation.Status, conversation.Labels, conversation.Assignee)
+	if err != nil {
+		http.Error(w, "Failed to update conversation", http.StatusInternalServerError)
+		log.Println("Error updating conversation:", err)
+		return
+	}
+	recordAudit("moderator", clientIP(r), "room.conversation", strconv.Itoa(room.ID), map[string]interface{}{"from": previous, "to": conversation})
+	publishRoomEvent(room.ID, nil, "conversation", ConversationEvent{RoomID: room.ID, Conversation: conversation})
+	if conversation.Status == conversationResolved {
+		resolveSLATimer(room.ID)
+		if previous.Status != conversationResolved {
+			postCSATSurvey(room.ID)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// modelPricing maps a model to its price in USD per million tokens
+var modelPricing map[string]ModelPrice
+
+// ModelPrice is what a model costs per million prompt and completion tokens
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// CostRollup is one row of the cost report; only the grouped fields are set
+type CostRollup struct {
+	Day              string  `json:"day,omitempty"`
+	User             string  `json:"user,omitempty"`
+	RoomID           int     `json:"room_id,omitempty"`
+	Provider         string  `json:"provider,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// Columns the cost report can be grouped by, with the value used when not grouped
+var costGroupColumns = []struct{ name, expr, none string }{
+	{"day", "to_char(created_at, 'YYYY-MM-DD')", "''"},
+	{"user", "user_id", "''"},
+	{"room", "room_id", "0"},
+	{"provider", "provider", "''"},
+	{"model", "model", "''"},
+}
+
+// initCosts reads model pricing (MODEL_PRICING, JSON such as {"gpt-4o": {"input": 2.5, "output": 10}})
+// and creates the usage table
+func initCosts() {
+	modelPricing = make(map[string]ModelPrice)
+	if raw := getEnv("MODEL_PRICING", ""); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &modelPricing); err != nil {
+			log.Fatal("❌ Invalid MODEL_PRICING:", err)
+		}
+		log.Printf("💰 Tracking costs for %d priced models", len(modelPricing))
+	}
+	createUsageTable()
+}
+
+// Create `generation_usage` table if it doesn't exist
+func createUsageTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS generation_usage (
+			id SERIAL PRIMARY KEY,
+			message_id INTEGER REFERENCES chat_history(id) ON DELETE SET NULL,
+			room_id INTEGER NOT NULL,
+			user_id TEXT NOT NULL DEFAULT '',
+			provider TEXT NOT NULL,
+			model TEXT NOT NULL,
+			prompt_tokens INTEGER NOT NULL DEFAULT 0,
+			completion_tokens INTEGER NOT NULL DEFAULT 0,
+			cost_usd DOUBLE PRECISION NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS generation_usage_created_at_idx ON generation_usage (created_at);
+		ALTER TABLE generation_usage ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'ok';
+		ALTER TABLE generation_usage ADD COLUMN IF NOT EXISTS latency_ms INTEGER;
+		ALTER TABLE generation_usage ADD COLUMN IF NOT EXISTS first_token_ms INTEGER;
+		ALTER TABLE generation_usage ADD COLUMN IF NOT EXISTS error_code TEXT NOT NULL DEFAULT '';
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create generation_usage table:", err)
+	}
+	log.Println("✅ Table generation_usage is ready")
+}
+
+// generationCost prices token usage; models without a price cost nothing
+func generationCost(model string, promptTokens, completionTokens int) float64 {
+	price, ok := modelPricing[model]
+	if !ok {
+		return 0
+	}
+	return (float64(promptTokens)*price.Input + float64(completionTokens)*price.Output) / 1e6
+}
+
+// recordUsage stores a generation's outcome, token usage, latency and cost and updates the metrics
+func recordUsage(gen *generation, messageID int, genErr error) {
+	provider, status, errorCode := gen.provider, "ok", ""
+	if provider == "" {
+		provider = "none"
+	}
+	if genErr != nil {
+		status, errorCode = "error", classifyGenerationError(genErr)
+	}
+	cost := generationCost(gen.model, gen.promptTokens, gen.completionTokens)
+	latency := gen.latency()
+
+	var message *int
+	if messageID != 0 {
+		message = &messageID
+	}
+	ctx := context.Background()
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO generation_usage (message_id, room_id, user_id, provider, model, prompt_tokens, completion_tokens, cost_usd,
+				status, error_code, latency_ms, first_token_ms)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+			message, gen.roomID, gen.user, provider, gen.model, gen.promptTokens, gen.completionTokens, cost,
+			status, errorCode, latency.TotalMs, latency.FirstTokenMs)
+		if err != nil {
+			return err
+		}
+		return recordEvent(ctx, tx, "generation.completed", GenerationCompletedEvent{MessageID: messageID, RoomID: gen.roomID,
+			User: gen.user, Provider: provider, Model: gen.model, Status: status, ErrorCode: errorCode, PromptTokens: gen.promptTokens,
+			CompletionTokens: gen.completionTokens, CostUSD: cost, LatencyMs: latency.TotalMs})
+	})
+	if err != nil {
+		log.Println("Error recording usage:", err)
+	}
+
+	addCounter("cubbychat_generations_total", "Generations by outcome", 1,
+		"provider", provider, "model", gen.model, "status", status)
+	if genErr != nil {
+		addCounter("cubbychat_generation_errors_total", "Failed generations by error code", 1,
+			"provider", provider, "model", gen.model, "code", errorCode)
+		return
+	}
+
+	addCounter("cubbychat_llm_tokens_total", "Tokens processed by LLM providers", float64(gen.promptTokens),
+		"provider", gen.provider, "model", gen.model, "type", "prompt")
+	addCounter("cubbychat_llm_tokens_total", "Tokens processed by LLM providers", float64(gen.completionTokens),
+		"provider", gen.provider, "model", gen.model, "type", "completion")
+	addCounter("cubbychat_llm_cost_usd_total", "Estimated spend on LLM providers in USD", cost,
+		"provider", gen.provider, "model", gen.model)
+}
+
+// Handler for /api/admin/costs: usage and cost rollups.
+// Query parameters: from and to (YYYY-MM-DD, inclusive; the last 30 days by default)
+// and group_by (comma-separated: day, user, room, provider, model; day by default).
+func getCostReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, ok := reportPeriod(w, r)
+	if !ok {
+		return
+	}
+	groupBy := splitList(r.URL.Query().Get("group_by"))
+	if len(groupBy) == 0 {
+		groupBy = []string{"day"}
+	}
+
+	var selects, groups []string
+	for _, column := range costGroupColumns {
+		if containsString(groupBy, column.name) {
+			selects = append(selects, column.expr)
+			groups = append(groups, column.expr)
+		} else {
+			selects = append(selects, column.none)
+		}
+	}
+	if len(groups) == 0 {
+		http.Error(w, "group_by accepts day, user, room, provider and model", http.StatusBadRequest)
+		return
+	}
+
+	query := "SELECT " + strings.Join(selects, ", ") + `,
+			COUNT(*), COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0), COALESCE(SUM(cost_usd), 0)
+		FROM generation_usage WHERE created_at >= $1 AND created_at < $2
+		GROUP BY ` + strings.Join(groups, ", ") + " ORDER BY " + strings.Join(groups, ", ")
+	rows, err := db.Query(context.Background(), query, from, to)
+	if err != nil {
+		http.Error(w, "Failed to fetch costs", http.StatusInternalServerError)
+		log.Println("Error fetching costs:", err)
+		return
+	}
+	defer rows.Close()
+
+	report := []CostRollup{}
+	for rows.Next() {
+		var c CostRollup
+		if err := rows.Scan(&c.Day, &c.User, &c.RoomID, &c.Provider, &c.Model,
+			&c.Requests, &c.PromptTokens, &c.CompletionTokens, &c.CostUSD); err != nil {
+			http.Error(w, "Error processing costs", http.StatusInternalServerError)
+			log.Println("Error scanning costs:", err)
+			return
+		}
+		report = append(report, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// reportPeriod reads the from/to query parameters of an admin report, writing an error response if invalid
+func reportPeriod(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, to := today.AddDate(0, 0, -29), today
+	var err error
+	if value := r.URL.Query().Get("from"); value != "" {
+		if from, err = time.Parse(time.DateOnly, value); err != nil {
+			http.Error(w, "from must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+			return from, to, false
+		}
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		if to, err = time.Parse(time.DateOnly, value); err != nil {
+			http.Error(w, "to must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+			return from, to, false
+		}
+	}
+	return from, to.AddDate(0, 0, 1), true
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CSAT surveys ask customers to rate a support conversation once it's resolved: the
+// survey is a "System" message flagged csat, and clients answer it with a
+// "rate_conversation" frame or through the REST API. Scores show up in the analytics.
+var (
+	csatEnabled bool          // Whether resolving a conversation posts a survey
+	csatPrompt  string        // The survey's text
+	csatWindow  time.Duration // How long after a survey is posted it can be answered
+)
+
+// Scores run from csatMinScore (very unhappy) to csatMaxScore (very happy); 4 and up
+// count as satisfied
+const (
+	csatMinScore       = 1
+	csatMaxScore       = 5
+	csatSatisfiedScore = 4
+)
+
+var (
+	errCSATSurveyNotFound = errors.New("survey not found")
+	errCSATSurveyClosed   = errors.New("survey closed")
+)
+
+// CSATSurveyEvent asks a room's clients to show a rating prompt for the survey message
+type CSATSurveyEvent struct {
+	RoomID    int    `json:"room_id"`
+	MessageID int    `json:"message_id"`
+	Prompt    string `json:"prompt"`
+	MinScore  int    `json:"min_score"`
+	MaxScore  int    `json:"max_score"`
+}
+
+// CSATResponse is a customer's answer to a survey; answering again replaces it
+type CSATResponse struct {
+	MessageID int       `json:"message_id"` // The survey message
+	RoomID    int       `json:"room_id"`
+	User      string    `json:"user"` // Verified user name, or "ip:<address>" for guests
+	Score     int       `json:"score"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// initCSAT reads the survey settings and creates the responses table
+func initCSAT() {
+	csatEnabled = getEnvBool("CSAT_ENABLED", false)
+	csatPrompt = getEnv("CSAT_PROMPT", "How did we do? Please rate this conversation from 1 (poor) to 5 (great).")
+	csatWindow = getEnvDuration("CSAT_WINDOW", 7*24*time.Hour)
+	createCSATResponsesTable()
+}
+
+// Create `csat_responses` table if it doesn't exist
+func createCSATResponsesTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS csat_responses (
+			message_id INTEGER NOT NULL REFERENCES chat_history(id) ON DELETE CASCADE,
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			user_id TEXT NOT NULL DEFAULT '',
+			score SMALLINT NOT NULL CHECK (score BETWEEN 1 AND 5),
+			comment TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			PRIMARY KEY (message_id, user_id)
+		);
+		CREATE INDEX IF NOT EXISTS csat_responses_created_at_idx ON csat_responses (created_at);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create csat_responses table:", err)
+	}
+	log.Println("✅ Table csat_responses is ready")
+}
+
+// postCSATSurvey asks a resolved conversation's customer for a rating, if surveys are on
+func postCSATSurvey(roomID int) {
+	if !csatEnabled {
+		return
+	}
+	metadata := &MessageMetadata{CSAT: true}
+	messageID := saveMessageWithMetadata(roomID, "System", csatPrompt, metadata)
+	if messageID == 0 {
+		return // Incognito rooms aren't surveyed; nothing could be rated
+	}
+	publishRoomEvent(roomID, nil, "message", ChatMessage{ID: messageID, Sender: "System", Message: csatPrompt, Timestamp: time.Now(), Metadata: metadata})
+	publishRoomEvent(roomID, nil, "csat_survey", CSATSurveyEvent{RoomID: roomID, MessageID: messageID, Prompt: csatPrompt, MinScore: csatMinScore, MaxScore: csatMaxScore})
+	addCounter("cubbychat_csat_surveys_total", "Satisfaction surveys posted", 1)
+}
+
+// latestCSATSurvey is the id of the room's most recent survey message
+func latestCSATSurvey(roomID int) (int, error) {
+	var id int
+	err := db.QueryRow(context.Background(), `
+		SELECT id FROM chat_history WHERE room_id = $1 AND sender = 'System' AND (metadata->>'csat')::boolean
+		ORDER BY id DESC LIMIT 1`, roomID).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return 0, errCSATSurveyNotFound
+	}
+	return id, err
+}
+
+// saveCSATResponse records an answer to a survey in a room, the latest one when messageID
+// is 0. Surveys can only be answered for CSAT_WINDOW after they're posted.
+func saveCSATResponse(roomID, messageID int, user string, score int, comment string) (*CSATResponse, error) {
+	if messageID == 0 {
+		var err error
+		if messageID, err = latestCSATSurvey(roomID); err != nil {
+			return nil, err
+		}
+	}
+
+	var postedAt time.Time
+	err := db.QueryRow(context.Background(), `
+		SELECT timestamp FROM chat_history
+		WHERE id = $1 AND room_id = $2 AND sender = 'System' AND (metadata->>'csat')::boolean`, messageID, roomID).Scan(&postedAt)
+	if err == pgx.ErrNoRows {
+		return nil, errCSATSurveyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(postedAt) > csatWindow {
+		return nil, errCSATSurveyClosed
+	}
+
+	response := &CSATResponse{MessageID: messageID, RoomID: roomID, User: user, Score: score, Comment: comment}
+	err = db.QueryRow(context.Background(), `
+		INSERT INTO csat_responses (message_id, room_id, user_id, score, comment) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (message_id, user_id) DO UPDATE SET score = EXCLUDED.score, comment = EXCLUDED.comment, created_at = NOW()
+		RETURNING created_at`, messageID, roomID, user, score, comment).Scan(&response.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	addCounter("cubbychat_csat_responses_total", "Satisfaction survey answers, by score", 1, "score", strconv.Itoa(score))
+	return response, nil
+}
+
+// csatRespondent is who a survey answer is counted for: a verified user by name, anyone
+// else by address, so nobody can answer twice by picking another ?user= name
+func csatRespondent(identity, ip string) string {
+	if identity != "" {
+		return identity
+	}
+	return "ip:" + ip
+}
+
+// validateCSATResponse checks a score and comment, returning the trimmed comment
+func validateCSATResponse(score int, comment string) (string, error) {
+	if score < csatMinScore || score > csatMaxScore {
+		return "", fmt.Errorf("score must be between %d and %d", csatMinScore, csatMaxScore)
+	}
+	comment = strings.TrimSpace(comment)
+	if len(comment) > 2000 {
+		return "", fmt.Errorf("comments are limited to 2000 characters")
+	}
+	return comment, nil
+}
+
+// handleRateConversation answers a survey ("rate_conversation" frame with a score, the
+// survey's message id, or none for the latest, and an optional comment as its text)
+func handleRateConversation(s *Session, frame *ClientFrame) {
+	comment, err := validateCSATResponse(frame.Score, frame.Text)
+	if err != nil {
+		s.sendError("invalid_rating", err.Error())
+		return
+	}
+	response, err := saveCSATResponse(s.room, frame.MessageID, csatRespondent(s.identity, s.ip), frame.Score, comment)
+	switch {
+	case err == errCSATSurveyNotFound:
+		s.sendError("survey_not_found", "There is no survey to answer in this room")
+	case err == errCSATSurveyClosed:
+		s.sendError("survey_closed", "This survey can no longer be answered")
+	case err != nil:
+		log.Println("Error saving CSAT response:", err)
+		s.sendError("rating_failed", "Could not save your rating, please try again")
+	default:
+		if err := s.sendEvent("csat_recorded", response); err != nil {
+			log.Println("Error sending csat_recorded event:", err)
+		}
+	}
+}
+
+// Handler for /api/rooms/{id}/csat: answer the room's survey with POST ({"score": 1-5,
+// "comment": "...", "message_id": survey, or 0 for the latest}); members only in a managed room
+func submitCSAT(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	room, ok := pathRoom(w, r)
+	if !ok || !requireRoomRole(w, r, room, roleMember, true) {
+		return
+	}
+
+	var req struct {
+		MessageID int    `json:"message_id"`
+		Score     int    `json:"score"`
+		Comment   string `json:"comment"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	comment, err := validateCSATResponse(req.Score, req.Comment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := saveCSATResponse(room.ID, req.MessageID, csatRespondent(verifiedUser(r), clientIP(r)), req.Score, comment)
+	switch {
+	case err == errCSATSurveyNotFound:
+		http.Error(w, "Survey not found", http.StatusNotFound)
+	case err == errCSATSurveyClosed:
+		http.Error(w, "This survey can no longer be answered", http.StatusGone)
+	case err != nil:
+		http.Error(w, "Failed to save rating", http.StatusInternalServerError)
+		log.Println("Error saving CSAT response:", err)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// FailedGeneration is a question the AI couldn't answer, kept so it can be inspected and replayed
+type FailedGeneration struct {
+	ID              int               `json:"id"`
+	RoomID          int               `json:"room_id"`
+	User            string            `json:"user,omitempty"`
+	Prompt          string            `json:"prompt"`
+	Context         GenerationContext `json:"context"`
+	Provider        string            `json:"provider,omitempty"` // Last provider tried
+	Model           string            `json:"model,omitempty"`
+	Error           string            `json:"error"`
+	ErrorCode       string            `json:"error_code,omitempty"` // Stable code from classifyGenerationError
+	CreatedAt       time.Time         `json:"created_at"`
+	ReplayedAt      *time.Time        `json:"replayed_at,omitempty"`
+	ReplayMessageID *int              `json:"replay_message_id,omitempty"` // AI message produced by a successful replay
+	ReplayError     string            `json:"replay_error,omitempty"`      // Why the last replay failed
+}
+
+// GenerationContext is what the model was given besides the question
+type GenerationContext struct {
+	ModelPrompt string           `json:"model_prompt"`
+	Memories    []Memory         `json:"memories,omitempty"`
+	Retrieved   []RetrievedChunk `json:"retrieved,omitempty"`
+}
+
+const failedGenerationColumns = `id, room_id, user_id, prompt, context, provider, model, error, error_code, created_at,
+	replayed_at, replay_message_id, replay_error`
+
+// initDeadLetters creates the failed generations table
+func initDeadLetters() {
+	createFailedGenerationsTable()
+}
+
+// Create `failed_generations` table if it doesn't exist
+func createFailedGenerationsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS failed_generations (
+			id SERIAL PRIMARY KEY,
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			user_id TEXT NOT NULL DEFAULT '',
+			prompt TEXT NOT NULL,
+			context JSONB NOT NULL DEFAULT '{}',
+			provider TEXT NOT NULL DEFAULT '',
+			model TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			replayed_at TIMESTAMPTZ,
+			replay_message_id INTEGER REFERENCES chat_history(id) ON DELETE SET NULL,
+			replay_error TEXT NOT NULL DEFAULT ''
+		);
+		ALTER TABLE failed_generations ADD COLUMN IF NOT EXISTS error_code TEXT NOT NULL DEFAULT '';
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create failed_generations table:", err)
+	}
+	log.Println("✅ Table failed_generations is ready")
+}
+
+// recordFailedGeneration keeps a generation that failed for later inspection and replay
+func recordFailedGeneration(gen *generation, genErr error) {
+	// Incognito prompts aren't kept, even to replay them
+	if gen.incognito {
+		return
+	}
+	genContext := GenerationContext{ModelPrompt: gen.modelPrompt(), Memories: gen.memories, Retrieved: gen.retrieved}
+	_, err := db.Exec(context.Background(), `
+		INSERT INTO failed_generations (room_id, user_id, prompt, context, provider, model, error, error_code)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		gen.roomID, gen.user, gen.prompt, genContext, gen.provider, gen.model, genErr.Error(), classifyGenerationError(genErr))
+	if err != nil {
+		log.Println("Error recording failed generation:", err)
+	}
+}
+
+// scanFailedGeneration reads a row selected with failedGenerationColumns
+func scanFailedGeneration(row pgx.Row) (*FailedGeneration, error) {
+	var f FailedGeneration
+	err := row.Scan(&f.ID, &f.RoomID, &f.User, &f.Prompt, &f.Context, &f.Provider, &f.Model, &f.Error, &f.ErrorCode, &f.CreatedAt,
+		&f.ReplayedAt, &f.ReplayMessageID, &f.ReplayError)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// replayFailedGeneration asks the AI again and posts the answer to the room
+func replayFailedGeneration(f *FailedGeneration) (int, error) {
+	gen, err := prepareGeneration(f.RoomID, f.User, "", f.Prompt)
+	if err != nil {
+		return 0, err
+	}
+	if err := generateWithFailover(nil, gen); err != nil {
+		recordUsage(gen, 0, err)
+		return 0, err
+	}
+
+	text, metadata, messageID := storeAIResponse(gen)
+	if messageID == 0 {
+		return 0, errors.New("failed to save the answer")
+	}
+	publishRoomEvent(f.RoomID, nil, "message", ChatMessage{ID: messageID, Sender: "AI", Message: text, Timestamp: time.Now(), Metadata: metadata})
+	return messageID, nil
+}
+
+// Handler for /api/admin/failed-generations: list failures (status pending, replayed or all; pending by default)
+func listFailedGenerations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "pending"
+	}
+	if status != "pending" && status != "replayed" && status != "all" {
+		http.Error(w, "status must be pending, replayed or all", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT `+failedGenerationColumns+` FROM failed_generations
+		WHERE $1 = 'all' OR ($1 = 'pending' AND replay_message_id IS NULL) OR ($1 = 'replayed' AND replay_message_id IS NOT NULL)
+		ORDER BY id DESC LIMIT 200`, status)
+	if err != nil {
+		http.Error(w, "Failed to fetch failed generations", http.StatusInternalServerError)
+		log.Println("Error fetching failed generations:", err)
+		return
+	}
+	defer rows.Close()
+
+	failures := []*FailedGeneration{}
+	for rows.Next() {
+		f, err := scanFailedGeneration(rows)
+		if err != nil {
+			http.Error(w, "Error processing failed generations", http.StatusInternalServerError)
+			log.Println("Error scanning failed generations:", err)
+			return
+		}
+		failures = append(failures, f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(failures)
+}
+
+// pathFailedGeneration loads the failure named by the {id} path segment, writing an error response if invalid
+func pathFailedGeneration(w http.ResponseWriter, r *http.Request) (*FailedGeneration, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return nil, false
+	}
+	f, err := scanFailedGeneration(db.QueryRow(context.Background(),
+		"SELECT "+failedGenerationColumns+" FROM failed_generations WHERE id = $1", id))
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Failed generation not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch failed generation", http.StatusInternalServerError)
+		log.Println("Error fetching failed generation:", err)
+		return nil, false
+	}
+	return f, true
+}
+
+// Handler to inspect one failed generation
+func getFailedGeneration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f, ok := pathFailedGeneration(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f)
+}
+
+// Handler to replay a failed generation; the answer is posted to the original room
+func replayFailedGenerationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f, ok := pathFailedGeneration(w, r)
+	if !ok {
+		return
+	}
+	if f.ReplayMessageID != nil {
+		http.Error(w, "Already replayed", http.StatusConflict)
+		return
+	}
+
+	messageID, replayErr := replayFailedGeneration(f)
+	var message *int
+	replayError := ""
+	if replayErr != nil {
+		log.Println("Error replaying generation:", replayErr)
+		replayError = replayErr.Error()
+	} else {
+		message = &messageID
+	}
+
+	f, err := scanFailedGeneration(db.QueryRow(context.Background(), `
+		UPDATE failed_generations SET replayed_at = NOW(), replay_message_id = $2, replay_error = $3
+		WHERE id = $1 RETURNING `+failedGenerationColumns, f.ID, message, replayError))
+	if err != nil {
+		http.Error(w, "Failed to update failed generation", http.StatusInternalServerError)
+		log.Println("Error updating failed generation:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if replayErr != nil {
+		w.WriteHeader(generationErrorStatuses[classifyGenerationError(replayErr)])
+	}
+	json.NewEncoder(w).Encode(f)
+}
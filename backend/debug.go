@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"log"
+	"net/http"
+	_ "net/http/pprof" // Registers /debug/pprof on the default mux
+	"runtime"
+	"strings"
+)
+
+// debugEnabled exposes /debug (pprof, expvar and the connection dump) to admins
+var debugEnabled bool
+
+// SessionDump describes one open WebSocket connection
+type SessionDump struct {
+	Room   int    `json:"room"`
+	User   string `json:"user,omitempty"`
+	Voice  bool   `json:"voice"`
+	Acks   bool   `json:"acks"`
+	Queued int    `json:"queued_frames"`
+}
+
+// DebugDump is returned by /debug/dump
+type DebugDump struct {
+	Goroutines int           `json:"goroutines"`
+	Sessions   []SessionDump `json:"sessions"`
+	Stacks     string        `json:"stacks,omitempty"`
+}
+
+// initDebug reads the debug switch and publishes runtime counters to expvar
+func initDebug() {
+	debugEnabled = getEnvBool("DEBUG_ENABLED", false)
+	if !debugEnabled {
+		return
+	}
+
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("sessions", expvar.Func(func() any { return len(connectedSessions(func(*Session) bool { return true })) }))
+	http.HandleFunc("/debug/dump", dumpSessions)
+	log.Println("🐞 Debug endpoints enabled under /debug")
+}
+
+// guardDebug hides everything under /debug unless debugging is enabled, and then
+// requires the admin token. It wraps the whole mux because net/http/pprof and
+// expvar register themselves on the default mux.
+func guardDebug(next http.Handler) http.Handler {
+	admin := adminOnly(next.ServeHTTP)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/debug/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !debugEnabled {
+			http.NotFound(w, r)
+			return
+		}
+		admin(w, r)
+	})
+}
+
+// Handler for /debug/dump: open connections and goroutine count (?stacks=1 adds every goroutine's stack)
+func dumpSessions(w http.ResponseWriter, r *http.Request) {
+	dump := DebugDump{Goroutines: runtime.NumGoroutine(), Sessions: []SessionDump{}}
+	for _, s := range connectedSessions(func(*Session) bool { return true }) {
+		s.queueMu.Lock()
+		queued := len(s.queue)
+		s.queueMu.Unlock()
+		dump.Sessions = append(dump.Sessions, SessionDump{Room: s.room, User: s.user, Voice: s.voice, Acks: s.acks, Queued: queued})
+	}
+
+	if queryFlag(r, "stacks", false) {
+		buf := make([]byte, 1<<20)
+		for {
+			n := runtime.Stack(buf, true)
+			if n < len(buf) {
+				dump.Stacks = string(buf[:n])
+				break
+			}
+			buf = make([]byte, 2*len(buf))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dump)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Answer deduplication: kiosks and demos get asked the same questions over and over, so
+// identical AI answers are stored once in answer_contents, keyed by their SHA-256.
+// Their chat_history rows keep their own metadata but an empty message and the hash.
+var (
+	answerDedupEnabled    bool
+	answerDedupMinBytes   int           // Shorter answers are stored inline; a reference saves too little
+	answerDedupGCInterval time.Duration // How often contents no message refers to any more are removed
+)
+
+// initAnswerDedup reads the deduplication settings and adds the content table
+func initAnswerDedup() {
+	answerDedupEnabled = getEnvBool("ANSWER_DEDUP_ENABLED", false)
+	answerDedupMinBytes = getEnvInt("ANSWER_DEDUP_MIN_BYTES", 256)
+	answerDedupGCInterval = getEnvDuration("ANSWER_DEDUP_GC_INTERVAL", 24*time.Hour)
+	// The table is needed to read answers stored while deduplication was on
+	createAnswerContentsTable()
+	if !answerDedupEnabled {
+		return
+	}
+	if answerDedupGCInterval > 0 {
+		go runAnswerContentGC()
+	}
+	log.Printf("🧬 AI answers of %d bytes or more are deduplicated", answerDedupMinBytes)
+}
+
+// Create `answer_contents` table if it doesn't exist and let messages refer to it
+func createAnswerContentsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS answer_contents (
+			hash TEXT PRIMARY KEY,
+			content TEXT NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		);
+		ALTER TABLE chat_history ADD COLUMN IF NOT EXISTS content_hash TEXT REFERENCES answer_contents(hash);
+		CREATE INDEX IF NOT EXISTS chat_history_content_hash_idx ON chat_history (content_hash) WHERE content_hash IS NOT NULL;
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create answer_contents table:", err)
+	}
+	log.Println("✅ Table answer_contents is ready")
+}
+
+// messageText is the SQL for a chat_history row's text, following its content reference.
+// alias is the name the query gives chat_history.
+func messageText(alias string) string {
+	return fmt.Sprintf("COALESCE((SELECT content FROM answer_contents WHERE hash = %[1]s.content_hash), %[1]s.message)", alias)
+}
+
+// dedupAnswer stores an answer's content once and returns its hash, or "" when the answer
+// should be stored inline. The upsert locks the content row, so the garbage collector
+// can't remove it before the message referring to it commits.
+func dedupAnswer(ctx context.Context, tx pgx.Tx, sender, message string) (string, error) {
+	if !answerDedupEnabled || sender != "AI" || len(message) < answerDedupMinBytes {
+		return "", nil
+	}
+	sum := sha256.Sum256([]byte(message))
+	hash := hex.EncodeToString(sum[:])
+	var inserted bool
+	err := tx.QueryRow(ctx, `
+		INSERT INTO answer_contents (hash, content) VALUES ($1, $2)
+		ON CONFLICT (hash) DO UPDATE SET hash = EXCLUDED.hash
+		RETURNING xmax = 0`, hash, message).Scan(&inserted)
+	if err != nil {
+		return "", err
+	}
+	result := "hit"
+	if inserted {
+		result = "miss"
+	}
+	addCounter("cubbychat_answer_dedup_total", "Deduplicated AI answers by whether the content was already stored", 1, "result", result)
+	if !inserted {
+		addCounter("cubbychat_answer_dedup_bytes_saved_total", "Bytes of AI answers stored as references instead of copies", float64(len(message)))
+	}
+	return hash, nil
+}
+
+// runAnswerContentGC removes contents no message refers to every ANSWER_DEDUP_GC_INTERVAL
+func runAnswerContentGC() {
+	ticker := clock.NewTicker(answerDedupGCInterval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		tag, err := db.Exec(context.Background(), `
+			DELETE FROM answer_contents c
+			WHERE NOT EXISTS (SELECT 1 FROM chat_history WHERE content_hash = c.hash)`)
+		if err != nil {
+			log.Println("Error removing unreferenced answer contents:", err)
+			continue
+		}
+		if removed := tag.RowsAffected(); removed > 0 {
+			log.Printf("🧬 Removed %d unreferenced answer contents", removed)
+		}
+	}
+}
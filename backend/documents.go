@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	documentMaxBytes     int64         // Largest document accepted for ingestion
+	documentChunkSize    int           // Target chunk length in bytes
+	documentChunkOverlap int           // Bytes shared between consecutive chunks
+	documentPollInterval time.Duration // How often the ingestion worker looks for pending documents
+	documentWake         = make(chan struct{}, 1)
+)
+
+// Ingestion states of a document
+const (
+	documentPending    = "pending"
+	documentProcessing = "processing"
+	documentReady      = "ready"
+	documentFailed     = "failed"
+)
+
+// Document is an uploaded knowledge base file and its ingestion progress
+type Document struct {
+	ID              int       `json:"id"`
+	Filename        string    `json:"filename"`
+	Format          string    `json:"format"`
+	Size            int       `json:"size"`
+	ContentHash     string    `json:"content_hash"`
+	KnowledgeBaseID *int      `json:"knowledge_base_id,omitempty"`
+	Status          string    `json:"status"`
+	ChunksTotal     int       `json:"chunks_total"`
+	ChunksDone      int       `json:"chunks_done"`
+	Progress        float64   `json:"progress"`
+	Error           string    `json:"error,omitempty"`
+	Duplicate       bool      `json:"duplicate,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// documentChunk is a slice of a document's text with its position
+type documentChunk struct {
+	Index   int
+	Page    int
+	Start   int // Byte offset within the page (or whole document)
+	End     int
+	Content string
+}
+
+const documentColumns = "id, filename, format, size, content_hash, knowledge_base_id, status, chunks_total, chunks_done, COALESCE(error, ''), created_at, updated_at"
+
+// initDocuments reads ingestion settings, creates the tables and starts the ingestion worker
+func initDocuments() {
+	documentMaxBytes = int64(getEnvInt("RAG_MAX_BYTES", 20*1024*1024))
+	documentChunkSize = getEnvInt("RAG_CHUNK_SIZE", 1000)
+	documentChunkOverlap = getEnvInt("RAG_CHUNK_OVERLAP", 200)
+	documentPollInterval = getEnvDuration("RAG_POLL_INTERVAL", 30*time.Second)
+
+	if documentChunkSize < 100 {
+		documentChunkSize = 100
+	}
+	if documentChunkOverlap < 0 || documentChunkOverlap >= documentChunkSize {
+		log.Printf("⚠️ RAG_CHUNK_OVERLAP must be below RAG_CHUNK_SIZE, using %d", documentChunkSize/5)
+		documentChunkOverlap = documentChunkSize / 5
+	}
+
+	createDocumentTables()
+	createKnowledgeBaseTables()
+
+	// Documents interrupted by a restart are picked up again
+	if _, err := db.Exec(context.Background(),
+		"UPDATE documents SET status = $1 WHERE status = $2", documentPending, documentProcessing); err != nil {
+		log.Println("Error requeueing interrupted documents:", err)
+	}
+	go runDocumentWorker()
+
+	log.Printf("📚 Document ingestion enabled (chunk size: %d, overlap: %d)", documentChunkSize, documentChunkOverlap)
+}
+
+// Create `documents` and `document_chunks` tables if they don't exist
+func createDocumentTables() {
+	query := `
+		CREATE TABLE IF NOT EXISTS documents (
+			id SERIAL PRIMARY KEY,
+			filename TEXT NOT NULL,
+			format TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			content_hash TEXT NOT NULL,
+			data BYTEA NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			chunks_total INTEGER NOT NULL DEFAULT 0,
+			chunks_done INTEGER NOT NULL DEFAULT 0,
+			error TEXT,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			updated_at TIMESTAMPTZ DEFAULT NOW()
+		);
+		CREATE TABLE IF NOT EXISTS document_chunks (
+			id SERIAL PRIMARY KEY,
+			document_id INTEGER NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+			chunk_index INTEGER NOT NULL,
+			page INTEGER NOT NULL DEFAULT 0,
+			start_offset INTEGER NOT NULL,
+			end_offset INTEGER NOT NULL,
+			content TEXT NOT NULL,
+			embedding REAL[] NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS document_chunks_document_id_idx ON document_chunks (document_id);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create document tables:", err)
+	}
+	log.Println("✅ Tables documents and document_chunks are ready")
+}
+
+// scanDocument reads a row selected with documentColumns
+func scanDocument(row pgx.Row) (*Document, error) {
+	var d Document
+	err := row.Scan(&d.ID, &d.Filename, &d.Format, &d.Size, &d.ContentHash, &d.KnowledgeBaseID, &d.Status,
+		&d.ChunksTotal, &d.ChunksDone, &d.Error, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case d.Status == documentReady:
+		d.Progress = 1
+	case d.ChunksTotal > 0:
+		d.Progress = float64(d.ChunksDone) / float64(d.ChunksTotal)
+	}
+	return &d, nil
+}
+
+// wakeDocumentWorker tells the worker there is something to ingest
+func wakeDocumentWorker() {
+	select {
+	case documentWake <- struct{}{}:
+	default:
+	}
+}
+
+// runDocumentWorker ingests pending documents one at a time
+func runDocumentWorker() {
+	ticker := clock.NewTicker(documentPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			var id int
+			err := db.QueryRow(context.Background(), `
+				UPDATE documents SET status = $1, error = NULL, chunks_done = 0, updated_at = NOW()
+				WHERE id = (SELECT id FROM documents WHERE status = $2 ORDER BY id LIMIT 1)
+				RETURNING id`, documentProcessing, documentPending).Scan(&id)
+			if err == pgx.ErrNoRows {
+				break
+			}
+			if err != nil {
+				log.Println("Error claiming pending document:", err)
+				break
+			}
+			ingestDocument(id)
+		}
+
+		select {
+		case <-documentWake:
+		case <-ticker.C():
+		}
+	}
+}
+
+// ingestDocument extracts, chunks and embeds one claimed document
+func ingestDocument(id int) {
+	var filename, format string
+	var data []byte
+	err := db.QueryRow(context.Background(),
+		"SELECT filename, format, data FROM documents WHERE id = $1", id).Scan(&filename, &format, &data)
+	if err != nil {
+		log.Println("Error loading document:", err)
+		return
+	}
+
+	start := time.Now()
+	log.Printf("📚 Ingesting document %d (%s)", id, filename)
+	if err := processDocument(id, format, data); err != nil {
+		log.Printf("❌ Failed to ingest document %d (%s): %v", id, filename, err)
+		db.Exec(context.Background(), "DELETE FROM document_chunks WHERE document_id = $1", id)
+		db.Exec(context.Background(),
+			"UPDATE documents SET status = $2, error = $3, updated_at = NOW() WHERE id = $1",
+			id, documentFailed, err.Error())
+		return
+	}
+
+	db.Exec(context.Background(), "UPDATE documents SET status = $2, updated_at = NOW() WHERE id = $1", id, documentReady)
+	log.Printf("✅ Document %d (%s) ingested in %v", id, filename, time.Since(start).Round(time.Millisecond))
+}
+
+// processDocument does the actual work, recording progress after each embedding batch
+func processDocument(id int, format string, data []byte) error {
+	pages, err := extractText(format, data)
+	if err != nil {
+		return fmt.Errorf("text extraction failed: %v", err)
+	}
+
+	chunks := chunkText(pages, documentChunkSize, documentChunkOverlap)
+	if len(chunks) == 0 {
+		return errors.New("no text found in document")
+	}
+
+	ctx := context.Background()
+	if _, err := db.Exec(ctx, "DELETE FROM document_chunks WHERE document_id = $1", id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(ctx, "UPDATE documents SET chunks_total = $2, updated_at = NOW() WHERE id = $1", id, len(chunks)); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(chunks); start += ragEmbedBatch {
+		batch := chunks[start:min(start+ragEmbedBatch, len(chunks))]
+		texts := make([]string, len(batch))
+		for i, c := range batch {
+			texts[i] = c.Content
+		}
+
+		embeddings, err := embedTexts(texts)
+		if err != nil {
+			return fmt.Errorf("embedding failed: %v", err)
+		}
+
+		rows := make([][]interface{}, len(batch))
+		for i, c := range batch {
+			rows[i] = []interface{}{id, c.Index, c.Page, c.Start, c.End, c.Content, embeddings[i]}
+		}
+		_, err = db.CopyFrom(ctx, pgx.Identifier{"document_chunks"},
+			[]string{"document_id", "chunk_index", "page", "start_offset", "end_offset", "content", "embedding"},
+			pgx.CopyFromRows(rows))
+		if err != nil {
+			return fmt.Errorf("failed to store chunks: %v", err)
+		}
+
+		if _, err := db.Exec(ctx, "UPDATE documents SET chunks_done = $2, updated_at = NOW() WHERE id = $1", id, start+len(batch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkText splits page text into overlapping chunks, preferring to break at whitespace
+func chunkText(pages []documentPage, size, overlap int) []documentChunk {
+	var chunks []documentChunk
+	for _, page := range pages {
+		text := page.Text
+		start := 0
+		for start < len(text) {
+			end := start + size
+			if end >= len(text) {
+				end = len(text)
+			} else {
+				for end > start && !utf8.RuneStart(text[end]) {
+					end--
+				}
+				if cut := strings.LastIndexAny(text[start:end], " \t\n"); cut > size/2 {
+					end = start + cut
+				}
+			}
+
+			if content := strings.Join(strings.Fields(text[start:end]), " "); content != "" {
+				chunks = append(chunks, documentChunk{Index: len(chunks), Page: page.Number, Start: start, End: end, Content: content})
+			}
+			if end == len(text) {
+				break
+			}
+
+			// Start the overlap on a word boundary
+			next := end - overlap
+			if next <= start {
+				next = end
+			} else if space := strings.IndexAny(text[next:end], " \t\n"); space >= 0 {
+				next += space + 1
+			}
+			for next < len(text) && !utf8.RuneStart(text[next]) {
+				next++
+			}
+			start = next
+		}
+	}
+	return chunks
+}
+
+// Handler to upload a document for ingestion (multipart form field "file")
+func uploadDocument(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ragEnabled {
+		http.Error(w, "Knowledge base is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, documentMaxBytes+1024*1024)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing or oversized file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	format := documentFormat(header.Filename, header.Header.Get("Content-Type"))
+	if format == "" {
+		http.Error(w, "Supported formats are PDF, DOCX, HTML and Markdown", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// Documents go to the shared pool unless a knowledge base is named
+	var knowledgeBaseID *int
+	if value := r.FormValue("knowledge_base_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || !knowledgeBaseExists(id) {
+			http.Error(w, "Unknown knowledge base", http.StatusBadRequest)
+			return
+		}
+		knowledgeBaseID = &id
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, documentMaxBytes+1))
+	if err != nil || int64(len(data)) > documentMaxBytes {
+		http.Error(w, "Missing or oversized file", http.StatusBadRequest)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	// Identical content is only ingested once per knowledge base; a failed copy is retried
+	doc, err := scanDocument(db.QueryRow(context.Background(), `
+		INSERT INTO documents (filename, format, size, content_hash, data, knowledge_base_id) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (COALESCE(knowledge_base_id, 0), content_hash) DO NOTHING
+		RETURNING `+documentColumns, header.Filename, format, len(data), hash, data, knowledgeBaseID))
+	status := http.StatusAccepted
+	if err == pgx.ErrNoRows {
+		doc, err = scanDocument(db.QueryRow(context.Background(), `
+			UPDATE documents SET status = CASE WHEN status = $2 THEN $3 ELSE status END, updated_at = NOW()
+			WHERE content_hash = $1 AND knowledge_base_id IS NOT DISTINCT FROM $4
+			RETURNING `+documentColumns, hash, documentFailed, documentPending, knowledgeBaseID))
+		if doc != nil {
+			doc.Duplicate = true
+			if doc.Status != documentPending {
+				status = http.StatusOK
+			}
+		}
+	}
+	if err != nil {
+		http.Error(w, "Failed to store document", http.StatusInternalServerError)
+		log.Println("Error saving document:", err)
+		return
+	}
+	wakeDocumentWorker()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(doc)
+}
+
+// Handler to list knowledge base documents
+func listDocuments(w http.ResponseWriter, r *http.Request) {
+	if !ragEnabled {
+		http.Error(w, "Knowledge base is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	rows, err := db.Query(context.Background(), "SELECT "+documentColumns+" FROM documents ORDER BY id DESC")
+	if err != nil {
+		http.Error(w, "Failed to fetch documents", http.StatusInternalServerError)
+		log.Println("Error fetching documents:", err)
+		return
+	}
+	defer rows.Close()
+
+	documents := []*Document{}
+	for rows.Next() {
+		doc, err := scanDocument(rows)
+		if err != nil {
+			http.Error(w, "Error processing documents", http.StatusInternalServerError)
+			log.Println("Error scanning documents:", err)
+			return
+		}
+		documents = append(documents, doc)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(documents)
+}
+
+// Handler to report the ingestion status of one document
+func getDocument(w http.ResponseWriter, r *http.Request) {
+	if !ragEnabled {
+		http.Error(w, "Knowledge base is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid document id", http.StatusBadRequest)
+		return
+	}
+
+	doc, err := scanDocument(db.QueryRow(context.Background(),
+		"SELECT "+documentColumns+" FROM documents WHERE id = $1", id))
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch document", http.StatusInternalServerError)
+		log.Println("Error fetching document:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(doc)
+}
+
+// Handler for /api/documents: upload with POST, list with GET
+func handleDocuments(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		uploadDocument(w, r)
+		return
+	}
+	listDocuments(w, r)
+}
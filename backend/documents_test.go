@@ -0,0 +1,55 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunkText(t *testing.T) {
+	tests := []struct {
+		name          string
+		pages         []documentPage
+		size, overlap int
+		want          []string
+	}{
+		{"empty", []documentPage{{Number: 1, Text: ""}}, 10, 0, nil},
+		{"whitespace only", []documentPage{{Number: 1, Text: " \n\t "}}, 10, 0, nil},
+		{"fits in one chunk", []documentPage{{Number: 1, Text: "short text"}}, 100, 10, []string{"short text"}},
+		{"splits without overlap", []documentPage{{Number: 1, Text: "hello world foo bar"}}, 11, 0, []string{"hello world", "foo bar"}},
+		{"overlap starts on a word", []documentPage{{Number: 1, Text: "aaaa bbbb cccc dddd"}}, 10, 5, []string{"aaaa bbbb", "bbbb cccc", "cccc dddd"}},
+		{"whitespace is collapsed", []documentPage{{Number: 1, Text: "one\n\ntwo\tthree"}}, 100, 0, []string{"one two three"}},
+		{"cuts keep whole runes", []documentPage{{Number: 1, Text: "ééééé"}}, 3, 0, []string{"é", "é", "é", "é", "é"}},
+		{"pages are chunked separately", []documentPage{{Number: 1, Text: "first page"}, {Number: 2, Text: "second page"}}, 100, 0, []string{"first page", "second page"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := chunkText(tt.pages, tt.size, tt.overlap)
+			var got []string
+			for i, c := range chunks {
+				got = append(got, c.Content)
+				if c.Index != i {
+					t.Errorf("chunk %d has index %d", i, c.Index)
+				}
+				if !utf8.ValidString(c.Content) {
+					t.Errorf("chunk %d is not valid UTF-8: %q", i, c.Content)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("chunkText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkTextPositions(t *testing.T) {
+	pages := []documentPage{{Number: 3, Text: "hello world foo bar"}, {Number: 4, Text: "next"}}
+	want := []documentChunk{
+		{Index: 0, Page: 3, Start: 0, End: 11, Content: "hello world"},
+		{Index: 1, Page: 3, Start: 11, End: 19, Content: "foo bar"},
+		{Index: 2, Page: 4, Start: 0, End: 4, Content: "next"},
+	}
+	if got := chunkText(pages, 11, 0); !slices.Equal(got, want) {
+		t.Errorf("chunkText = %+v, want %+v", got, want)
+	}
+}
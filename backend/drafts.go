@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// draftMaxBytes bounds a stored draft
+const draftMaxBytes = 64 * 1024
+
+// Draft is a half-written message kept per user and room
+type Draft struct {
+	RoomID    int       `json:"room_id"`
+	Content   string    `json:"content"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// initDrafts creates the drafts table
+func initDrafts() {
+	createDraftsTable()
+}
+
+// Create `drafts` table if it doesn't exist
+func createDraftsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS drafts (
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			user_id TEXT NOT NULL,
+			content TEXT NOT NULL,
+			updated_at TIMESTAMPTZ DEFAULT NOW(),
+			PRIMARY KEY (room_id, user_id)
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create drafts table:", err)
+	}
+	log.Println("✅ Table drafts is ready")
+}
+
+// getDraft returns a user's draft for a room, or nil if there is none
+func getDraft(roomID int, user string) (*Draft, error) {
+	d := Draft{RoomID: roomID}
+	err := db.QueryRow(context.Background(),
+		"SELECT content, updated_at FROM drafts WHERE room_id = $1 AND user_id = $2", roomID, user).
+		Scan(&d.Content, &d.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// sendDraft restores a user's draft when they (re)connect to a room; drafts belong to
+// verified users, so sessions without a user token get none
+func sendDraft(s *Session) {
+	if s.identity == "" {
+		return
+	}
+	draft, err := getDraft(s.room, s.identity)
+	if err != nil {
+		log.Println("Error fetching draft:", err)
+		return
+	}
+	if draft == nil {
+		return
+	}
+	if err := s.sendEvent("draft", draft); err != nil {
+		log.Println("Error sending draft event:", err)
+	}
+}
+
+// broadcastDraft tells every verified session of the user about a changed draft
+func broadcastDraft(user string, draft Draft) {
+	for _, s := range userSessions(user) {
+		if err := s.sendEvent("draft", draft); err != nil {
+			log.Println("Error sending draft event:", err)
+		}
+	}
+}
+
+// Handler for /api/rooms/{id}/draft: the draft of the user whose token the request presents;
+// fetch with GET, save with PUT, discard with DELETE
+func handleDraft(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+	user := verifiedUser(r)
+	if user == "" {
+		http.Error(w, "Present your user token (X-User-Token)", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		draft, err := getDraft(room.ID, user)
+		if err != nil {
+			http.Error(w, "Failed to fetch draft", http.StatusInternalServerError)
+			log.Println("Error fetching draft:", err)
+			return
+		}
+		if draft == nil {
+			draft = &Draft{RoomID: room.ID}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(draft)
+
+	case http.MethodPut:
+		if room.Metadata.Incognito {
+			http.Error(w, "Drafts aren't kept in incognito rooms", http.StatusConflict)
+			return
+		}
+		var req struct {
+			Content string `json:"content"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, draftMaxBytes+1024)).Decode(&req); err != nil || len(req.Content) > draftMaxBytes {
+			http.Error(w, "Invalid or oversized draft", http.StatusBadRequest)
+			return
+		}
+
+		draft := Draft{RoomID: room.ID, Content: req.Content, UpdatedAt: time.Now()}
+		var err error
+		if strings.TrimSpace(req.Content) == "" {
+			draft.Content = ""
+			_, err = db.Exec(context.Background(), "DELETE FROM drafts WHERE room_id = $1 AND user_id = $2", room.ID, user)
+		} else {
+			err = db.QueryRow(context.Background(), `
+				INSERT INTO drafts (room_id, user_id, content) VALUES ($1, $2, $3)
+				ON CONFLICT (room_id, user_id) DO UPDATE SET content = EXCLUDED.content, updated_at = NOW()
+				RETURNING updated_at`, room.ID, user, req.Content).Scan(&draft.UpdatedAt)
+		}
+		if err != nil {
+			http.Error(w, "Failed to save draft", http.StatusInternalServerError)
+			log.Println("Error saving draft:", err)
+			return
+		}
+		broadcastDraft(user, draft)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(draft)
+
+	case http.MethodDelete:
+		if _, err := db.Exec(context.Background(), "DELETE FROM drafts WHERE room_id = $1 AND user_id = $2", room.ID, user); err != nil {
+			http.Error(w, "Failed to delete draft", http.StatusInternalServerError)
+			log.Println("Error deleting draft:", err)
+			return
+		}
+		broadcastDraft(user, Draft{RoomID: room.ID, UpdatedAt: time.Now()})
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// clearDraft discards the user's draft once the message has been sent
+func clearDraft(s *Session) {
+	if s.identity == "" {
+		return
+	}
+	tag, err := db.Exec(context.Background(), "DELETE FROM drafts WHERE room_id = $1 AND user_id = $2", s.room, s.identity)
+	if err != nil {
+		log.Println("Error clearing draft:", err)
+		return
+	}
+	if tag.RowsAffected() > 0 {
+		broadcastDraft(s.identity, Draft{RoomID: s.room, UpdatedAt: time.Now()})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	embeddingsEnabled   bool          // Whether POST /api/embeddings is exposed
+	embeddingsModel     string        // Model used when a request doesn't name one
+	embeddingsBatch     int           // Texts sent to Ollama per request
+	embeddingsMaxInputs int           // Most texts accepted in one API call
+	embeddingsTimeout   time.Duration // Upper bound for one Ollama embed call
+	embeddingCache      *embeddingLRU // Shared by the API and retrieval; nil when disabled
+)
+
+// EmbeddingsRequest is the body of POST /api/embeddings; input is a string or a list of strings
+type EmbeddingsRequest struct {
+	Model string          `json:"model,omitempty"`
+	Input json.RawMessage `json:"input"`
+}
+
+// EmbeddingsResponse is returned by POST /api/embeddings
+type EmbeddingsResponse struct {
+	Model      string      `json:"model"`
+	Embeddings [][]float32 `json:"embeddings"`
+	Cached     int         `json:"cached"`
+}
+
+// OllamaEmbedRequest is the body of Ollama's /api/embed
+type OllamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// OllamaEmbedResponse is returned by Ollama's /api/embed
+type OllamaEmbedResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// embeddingLRU is a fixed-size least-recently-used cache of embedding vectors
+type embeddingLRU struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[[32]byte]*list.Element
+}
+
+type embeddingEntry struct {
+	key    [32]byte
+	vector []float32
+}
+
+// initEmbeddings reads embedding settings and sets up the cache
+func initEmbeddings() {
+	embeddingsEnabled = getEnvBool("EMBEDDINGS_ENABLED", false)
+	embeddingsModel = getEnv("EMBEDDINGS_MODEL", getEnv("RAG_EMBED_MODEL", "nomic-embed-text"))
+	embeddingsBatch = max(1, getEnvInt("EMBEDDINGS_BATCH", 32))
+	embeddingsMaxInputs = max(1, getEnvInt("EMBEDDINGS_MAX_INPUTS", 256))
+	embeddingsTimeout = getEnvDuration("EMBEDDINGS_TIMEOUT", 60*time.Second)
+
+	if size := getEnvInt("EMBEDDINGS_CACHE_SIZE", 5000); size > 0 {
+		embeddingCache = &embeddingLRU{size: size, order: list.New(), entries: make(map[[32]byte]*list.Element)}
+	}
+
+	if embeddingsEnabled {
+		log.Printf("🧮 Embeddings endpoint enabled (model: %s, cache: %d)", embeddingsModel, getEnvInt("EMBEDDINGS_CACHE_SIZE", 5000))
+	}
+}
+
+// embeddingKey identifies a text embedded with a given model
+func embeddingKey(model, text string) [32]byte {
+	return sha256.Sum256([]byte(model + "\x00" + text))
+}
+
+// get returns a cached vector and marks it recently used
+func (c *embeddingLRU) get(key [32]byte) ([]float32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*embeddingEntry).vector, true
+}
+
+// put stores a vector, evicting the least recently used one when full
+func (c *embeddingLRU) put(key [32]byte, vector []float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&embeddingEntry{key: key, vector: vector})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*embeddingEntry).key)
+	}
+}
+
+// embedWithModel returns one vector per text, serving repeats from the cache and
+// sending the rest to Ollama in batches. It also reports how many were cached.
+func embedWithModel(model string, texts []string) ([][]float32, int, error) {
+	vectors := make([][]float32, len(texts))
+	var missing []int
+	for i, text := range texts {
+		if embeddingCache != nil {
+			if vector, ok := embeddingCache.get(embeddingKey(model, text)); ok {
+				vectors[i] = vector
+				continue
+			}
+		}
+		missing = append(missing, i)
+	}
+	cached := len(texts) - len(missing)
+
+	for start := 0; start < len(missing); start += embeddingsBatch {
+		batch := missing[start:min(start+embeddingsBatch, len(missing))]
+		inputs := make([]string, len(batch))
+		for i, idx := range batch {
+			inputs[i] = texts[idx]
+		}
+
+		embeddings, err := requestEmbeddings(model, inputs)
+		if err != nil {
+			return nil, cached, err
+		}
+		for i, idx := range batch {
+			vectors[idx] = embeddings[i]
+			if embeddingCache != nil {
+				embeddingCache.put(embeddingKey(model, texts[idx]), embeddings[i])
+			}
+		}
+	}
+	return vectors, cached, nil
+}
+
+// requestEmbeddings makes a single call to Ollama's /api/embed
+func requestEmbeddings(model string, texts []string) ([][]float32, error) {
+	client := newOllamaClient()
+	client.SetTimeout(embeddingsTimeout)
+
+	var result OllamaEmbedResponse
+	resp, err := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(OllamaEmbedRequest{Model: model, Input: texts}).
+		SetResult(&result).
+		Post(fmt.Sprintf("%s/api/embed", ollamaURL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ollama: %v", err)
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("ollama returned status %d: %s", resp.StatusCode(), resp.String())
+	}
+	if len(result.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(result.Embeddings))
+	}
+	return result.Embeddings, nil
+}
+
+// Handler to compute embeddings on behalf of the frontend and other services
+func createEmbeddings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !embeddingsEnabled {
+		http.Error(w, "Embeddings are disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req EmbeddingsRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8*1024*1024)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var texts []string
+	var single string
+	if err := json.Unmarshal(req.Input, &single); err == nil {
+		texts = []string{single}
+	} else if err := json.Unmarshal(req.Input, &texts); err != nil {
+		http.Error(w, "input must be a string or a list of strings", http.StatusBadRequest)
+		return
+	}
+	if len(texts) == 0 || len(texts) > embeddingsMaxInputs {
+		http.Error(w, fmt.Sprintf("input must contain between 1 and %d texts", embeddingsMaxInputs), http.StatusBadRequest)
+		return
+	}
+
+	model := req.Model
+	if model == "" {
+		model = embeddingsModel
+	}
+
+	vectors, cached, err := embedWithModel(model, texts)
+	if err != nil {
+		http.Error(w, "Failed to compute embeddings", http.StatusBadGateway)
+		log.Println("Error computing embeddings:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(EmbeddingsResponse{Model: model, Embeddings: vectors, Cached: cached})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// The embedding worker embeds stored messages in the background for semantic search, and
+// fills in memories saved while the embedding model was unavailable. It works through
+// chat_history in id order from a cursor kept in the database, so it resumes where it
+// stopped after a restart and a new embedding model starts a fresh backfill.
+var (
+	embedWorkerEnabled  bool
+	embedWorkerInterval time.Duration // How often the worker looks for new messages
+	embedWorkerBatch    int           // Messages embedded per model call
+	embedWorkerBackfill bool          // Whether messages from before the worker first ran are embedded too
+	embedWorkerMaxChars int           // Longer messages are embedded by their beginning
+)
+
+// initEmbedWorker reads the worker settings, adds the cursor table and starts the worker
+func initEmbedWorker() {
+	embedWorkerEnabled = getEnvBool("EMBED_WORKER_ENABLED", semanticSearchEnabled)
+	if !embedWorkerEnabled {
+		return
+	}
+	if !messageEmbeddingsReady {
+		createMessageEmbeddingsTable()
+	}
+	embedWorkerInterval = getEnvDuration("EMBED_WORKER_INTERVAL", 15*time.Second)
+	embedWorkerBatch = max(1, getEnvInt("EMBED_WORKER_BATCH", 64))
+	embedWorkerBackfill = getEnvBool("EMBED_WORKER_BACKFILL", true)
+	embedWorkerMaxChars = max(100, getEnvInt("EMBED_WORKER_MAX_CHARS", 4000))
+	createEmbeddingCursorsTable()
+	go runEmbedWorker()
+	log.Printf("🧮 Embedding stored messages in the background (model: %s, backfill: %v)", ragEmbedModel, embedWorkerBackfill)
+}
+
+// Create `embedding_cursors` table if it doesn't exist
+func createEmbeddingCursorsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS embedding_cursors (
+			name TEXT PRIMARY KEY,
+			last_id INTEGER NOT NULL,
+			updated_at TIMESTAMPTZ DEFAULT NOW()
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create embedding_cursors table:", err)
+	}
+	log.Println("✅ Table embedding_cursors is ready")
+}
+
+// runEmbedWorker catches up on new messages and unembedded memories every EMBED_WORKER_INTERVAL
+func runEmbedWorker() {
+	ticker := clock.NewTicker(embedWorkerInterval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		for {
+			embedded, caughtUp, err := embedMessageBatch()
+			if err != nil {
+				log.Println("Error embedding messages:", err)
+				break
+			}
+			if embedded > 0 {
+				addCounter("cubbychat_embedded_messages_total", "Stored messages embedded for semantic search", float64(embedded))
+			}
+			if caughtUp {
+				break
+			}
+		}
+		if memoryEnabled {
+			if err := embedMissingMemories(); err != nil {
+				log.Println("Error embedding memories:", err)
+			}
+		}
+	}
+}
+
+// embeddingCursor returns the last message id embedded with the current model, starting
+// the cursor at the beginning or (without backfill) at the latest message
+func embeddingCursor(ctx context.Context, name string) (int, error) {
+	var lastID int
+	err := db.QueryRow(ctx, "SELECT last_id FROM embedding_cursors WHERE name = $1", name).Scan(&lastID)
+	if err != pgx.ErrNoRows {
+		return lastID, err
+	}
+	if !embedWorkerBackfill {
+		if err := db.QueryRow(ctx, "SELECT COALESCE(MAX(id), 0) FROM chat_history").Scan(&lastID); err != nil {
+			return 0, err
+		}
+	}
+	_, err = db.Exec(ctx, "INSERT INTO embedding_cursors (name, last_id) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING", name, lastID)
+	return lastID, err
+}
+
+// embedMessageBatch embeds the next batch of messages after the cursor and moves the cursor
+// past them in the same transaction. It reports whether there was nothing more to do.
+func embedMessageBatch() (int, bool, error) {
+	ctx := context.Background()
+	name := "messages:" + ragEmbedModel
+	lastID, err := embeddingCursor(ctx, name)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Ids are handed out before commit, so the newest messages wait a moment in case one
+	// with a lower id is still being saved
+	rows, err := db.Query(ctx, `
+		SELECT id, room_id, sender, `+messageText("chat_history")+` FROM chat_history
+		WHERE id > $1 AND timestamp < NOW() - INTERVAL '10 seconds' ORDER BY id LIMIT $2`, lastID, embedWorkerBatch)
+	if err != nil {
+		return 0, false, err
+	}
+	type pending struct {
+		id, roomID int
+	}
+	var batch []pending
+	var texts []string
+	seen := 0
+	for rows.Next() {
+		var id, roomID int
+		var sender, text string
+		if err := rows.Scan(&id, &roomID, &sender, &text); err != nil {
+			rows.Close()
+			return 0, false, err
+		}
+		seen++
+		lastID = id
+		// Summaries and annotations describe the conversation rather than being part of it
+		if (sender != "User" && sender != "AI") || text == "" {
+			continue
+		}
+		if len(text) > embedWorkerMaxChars {
+			text = strings.ToValidUTF8(text[:embedWorkerMaxChars], "")
+		}
+		batch = append(batch, pending{id, roomID})
+		texts = append(texts, text)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, false, err
+	}
+	if seen == 0 {
+		return 0, true, nil
+	}
+
+	var vectors [][]float32
+	if len(texts) > 0 {
+		// A failed call leaves the cursor where it was, so the batch is retried next time
+		if vectors, err = embedTexts(texts); err != nil {
+			return 0, false, err
+		}
+	}
+
+	err = pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		for i, p := range batch {
+			if len(vectors[i]) != messageEmbeddingDims {
+				return fmt.Errorf("%s returns %d dimensions, but SEMANTIC_SEARCH_DIMENSIONS is %d", ragEmbedModel, len(vectors[i]), messageEmbeddingDims)
+			}
+			// The message may have been deleted since it was read
+			_, err := tx.Exec(ctx, `
+				INSERT INTO message_embeddings (message_id, room_id, model, embedding)
+				SELECT id, room_id, $2, $3::vector FROM chat_history WHERE id = $1
+				ON CONFLICT (message_id) DO UPDATE SET model = EXCLUDED.model, embedding = EXCLUDED.embedding, created_at = NOW()`,
+				p.id, ragEmbedModel, vectorLiteral(vectors[i]))
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(ctx, "UPDATE embedding_cursors SET last_id = $2, updated_at = NOW() WHERE name = $1", name, lastID)
+		return err
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return len(batch), seen < embedWorkerBatch, nil
+}
+
+// embedMissingMemories embeds remembered facts that were saved without an embedding
+func embedMissingMemories() error {
+	ctx := context.Background()
+	rows, err := db.Query(ctx, "SELECT id, fact FROM memories WHERE embedding IS NULL ORDER BY id LIMIT $1", embedWorkerBatch)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	var facts []string
+	for rows.Next() {
+		var id int
+		var fact string
+		if err := rows.Scan(&id, &fact); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		facts = append(facts, fact)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(facts) == 0 {
+		return err
+	}
+
+	vectors, err := embedTexts(facts)
+	if err != nil {
+		return err
+	}
+	for i, id := range ids {
+		if _, err := db.Exec(ctx, "UPDATE memories SET embedding = $2 WHERE id = $1", id, vectors[i]); err != nil {
+			return err
+		}
+	}
+	log.Printf("🧠 Embedded %d remembered facts", len(ids))
+	return nil
+}
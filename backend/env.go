@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// getEnv returns the value of an environment variable or a fallback when unset
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvBool parses a boolean environment variable ("true", "1", "false", ...)
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("⚠️ Invalid boolean for %s=%q, using default %v", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// getEnvInt parses an integer environment variable
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("⚠️ Invalid integer for %s=%q, using default %d", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// getEnvDuration parses a duration environment variable such as "15s" or "2m"
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("⚠️ Invalid duration for %s=%q, using default %v", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// getEnvFloat parses a floating point environment variable
+func getEnvFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("⚠️ Invalid number for %s=%q, using default %v", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
@@ -0,0 +1,25 @@
+package main
+
+// WSEvent is a structured frame sent to WebSocket clients alongside the raw
+// token stream. Tokens are still sent as plain text frames; anything that is
+// not part of the AI response text goes out as a JSON event.
+type WSEvent struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// AIDoneEvent marks the end of an AI response and carries the stored, processed text
+type AIDoneEvent struct {
+	MessageID int              `json:"message_id"`
+	Message   string           `json:"message"`
+	Metadata  *MessageMetadata `json:"metadata,omitempty"`
+	Latency   *Latency         `json:"latency,omitempty"`
+	Incognito bool             `json:"incognito,omitempty"` // Not stored, so there is no message id to acknowledge
+}
+
+// ErrorEvent reports a problem handling the client's last message
+type ErrorEvent struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Limit   int    `json:"limit,omitempty"` // The limit that was exceeded, for message_too_large
+}
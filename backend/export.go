@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Conversation exports: JSON and Markdown for tools and developers, and a styled PDF
+// for sharing with people who'd rather not read either
+var exportMaxMessages int // Most messages in one export, the latest ones
+
+var filenameUnsafePattern = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// ConversationExport is a room's conversation as exported
+type ConversationExport struct {
+	Room       string        `json:"room"`
+	ExportedAt time.Time     `json:"exported_at"`
+	Messages   []ChatMessage `json:"messages"`
+}
+
+// initExport reads the export settings
+func initExport() {
+	exportMaxMessages = getEnvInt("EXPORT_MAX_MESSAGES", 5000)
+}
+
+// loadRoomMessages returns a room's latest messages up to and including message upTo, oldest first
+func loadRoomMessages(roomID, upTo, limit int) ([]ChatMessage, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT id, sender, message, timestamp, metadata FROM (
+			SELECT id, sender, `+messageText("chat_history")+` AS message, timestamp, metadata FROM chat_history
+			WHERE room_id = $1 AND id <= $2 ORDER BY timestamp DESC, id DESC LIMIT $3
+		) recent ORDER BY timestamp ASC, id ASC`, roomID, upTo, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []ChatMessage{}
+	for rows.Next() {
+		var msg ChatMessage
+		if err := rows.Scan(&msg.ID, &msg.Sender, &msg.Message, &msg.Timestamp, &msg.Metadata); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// exportTitle is the product name exports are branded with
+func exportTitle() string {
+	if title := currentBranding().Title; title != "" {
+		return title
+	}
+	if title := os.Getenv("CHAT_TITLE"); title != "" {
+		return title
+	}
+	return "Cubby Chat"
+}
+
+// exportSender is how a message's sender is shown in exports
+func exportSender(sender string) string {
+	if sender == "AI" {
+		return "Cubby"
+	}
+	return sender
+}
+
+// exportFilename makes a download name from the room name
+func exportFilename(room, extension string) string {
+	name := strings.Trim(filenameUnsafePattern.ReplaceAllString(room, "-"), "-")
+	if name == "" {
+		name = "conversation"
+	}
+	return name + "." + extension
+}
+
+// Handler for /api/rooms/{id}/export?format=json|markdown|pdf: download the room's conversation
+func exportConversation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	room, ok := pathRoom(w, r)
+	if !ok || !requireRoomRole(w, r, room, roleMember, true) {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "markdown" && format != "pdf" {
+		http.Error(w, `format must be "json", "markdown" or "pdf"`, http.StatusBadRequest)
+		return
+	}
+
+	messages, err := loadRoomMessages(room.ID, math.MaxInt32, exportMaxMessages)
+	if err != nil {
+		http.Error(w, "Failed to fetch messages", http.StatusInternalServerError)
+		log.Println("Error fetching messages to export:", err)
+		return
+	}
+	export := &ConversationExport{Room: room.Name, ExportedAt: time.Now(), Messages: messages}
+	writeConversationExport(w, export, format)
+	addCounter("cubbychat_exports_total", "Conversation exports by format", 1, "format", format)
+}
+
+// writeConversationExport sends an export as a download in the given format
+func writeConversationExport(w http.ResponseWriter, export *ConversationExport, format string) {
+	switch format {
+	case "markdown":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+exportFilename(export.Room, "md")+`"`)
+		w.Write([]byte(conversationMarkdown(export)))
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+exportFilename(export.Room, "pdf")+`"`)
+		if err := writeConversationPDF(w, export); err != nil {
+			log.Println("Error writing PDF export:", err)
+		}
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+exportFilename(export.Room, "json")+`"`)
+		json.NewEncoder(w).Encode(export)
+	}
+}
+
+// conversationMarkdown renders an export as Markdown
+func conversationMarkdown(export *ConversationExport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n_Exported from %s on %s_\n", export.Room, exportTitle(), export.ExportedAt.Format("2 Jan 2006 15:04 MST"))
+	for _, msg := range export.Messages {
+		if msg.Sender == "System" {
+			fmt.Fprintf(&b, "\n> %s\n", strings.ReplaceAll(msg.Message, "\n", "\n> "))
+			continue
+		}
+		fmt.Fprintf(&b, "\n---\n\n**%s** · %s\n\n%s\n", exportSender(msg.Sender), msg.Timestamp.Format("2 Jan 2006 15:04"), msg.Message)
+	}
+	return b.String()
+}
+
+// pdfPlainInline strips inline markdown, keeping link targets in brackets
+func pdfPlainInline(text string) string {
+	text = mdLinkPattern.ReplaceAllString(text, "$1 ($2)")
+	text = mdBoldPattern.ReplaceAllString(text, "$1")
+	text = mdItalicPattern.ReplaceAllString(text, "$1$2")
+	return mdInlineCodePattern.ReplaceAllString(text, "$1")
+}
+
+// writeConversationPDF lays out an export as a PDF: a branded header on each page, then
+// each message's sender and time above its text, with code blocks set in a monospaced font
+func writeConversationPDF(w io.Writer, export *ConversationExport) error {
+	brand := currentBranding()
+	primary := pdfHexColor(brand.PrimaryColor, pdfColor{0.93, 0.29, 0.6})
+	accent := pdfHexColor(brand.AccentColor, pdfColor{0.23, 0.51, 0.96})
+	title := exportTitle()
+
+	d := &pdfDocument{newPage: func(d *pdfDocument) {
+		d.rect(0, pdfPageHeight-6, pdfPageWidth, 6, primary)
+		d.y -= 4
+		d.text(pdfMargin, d.y, pdfBold, 16, pdfBlack, pdfEncode(export.Room))
+		header := pdfEncode(title)
+		d.text(pdfPageWidth-pdfMargin-pdfTextWidth(header, pdfBold, 10), d.y, pdfBold, 10, primary, header)
+		d.y -= 16
+		d.text(pdfMargin, d.y, pdfRegular, 9, pdfGray, pdfEncode("Exported "+export.ExportedAt.Format("2 Jan 2006 15:04 MST")))
+		d.y -= 8
+		d.rect(pdfMargin, d.y, pdfPageWidth-2*pdfMargin, 0.5, pdfColor{0.9, 0.9, 0.9})
+		d.y -= 6
+	}}
+	d.addPage()
+
+	if len(export.Messages) == 0 {
+		d.y -= 12
+		d.paragraph("This conversation has no messages.", pdfItalic, 10.5, 0, pdfGray)
+	}
+	for _, msg := range export.Messages {
+		if msg.Sender == "System" {
+			d.y -= 6
+			d.centered(msg.Message, pdfItalic, 9, pdfGray)
+			d.y -= 4
+			continue
+		}
+
+		// Keep the sender line with at least the first line of text
+		d.ensureSpace(40)
+		d.y -= 22
+		color := accent
+		if msg.Sender == "AI" {
+			color = primary
+		}
+		sender := pdfEncode(exportSender(msg.Sender))
+		d.text(pdfMargin, d.y, pdfBold, 10.5, color, sender)
+		d.text(pdfMargin+pdfTextWidth(sender, pdfBold, 10.5)+8, d.y, pdfRegular, 8.5, pdfGray, pdfEncode(msg.Timestamp.Format("2 Jan 2006 15:04")))
+		d.y -= 2
+		writeMessagePDF(d, msg.Message)
+	}
+
+	return d.writeTo(w, export.Room, func(page, pages int) string {
+		return fmt.Sprintf("%s · %s · page %d of %d", export.Room, title, page, pages)
+	})
+}
+
+// writeMessagePDF lays out one message's markdown: headings, list items, quotes and code blocks
+func writeMessagePDF(d *pdfDocument, text string) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		if m := fencePattern.FindStringSubmatch(line); m != nil {
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), m[2]); i++ {
+				code = append(code, lines[i])
+			}
+			d.codeBlock(strings.Join(code, "\n"), 8.5)
+			continue
+		}
+
+		switch {
+		case trimmed == "":
+			d.y -= 5
+		case mdHeadingPattern.MatchString(trimmed):
+			d.y -= 3
+			d.paragraph(pdfPlainInline(mdHeadingPattern.FindStringSubmatch(trimmed)[2]), pdfBold, 11.5, 0, pdfBlack)
+		case strings.HasPrefix(trimmed, "> "):
+			d.paragraph(pdfPlainInline(strings.TrimPrefix(trimmed, "> ")), pdfItalic, 10.5, 12, pdfGray)
+		case strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* "):
+			d.paragraph("• "+pdfPlainInline(trimmed[2:]), pdfRegular, 10.5, 10, pdfBlack)
+		case mdOrderedPattern.MatchString(trimmed):
+			d.paragraph(pdfPlainInline(trimmed), pdfRegular, 10.5, 10, pdfBlack)
+		default:
+			d.paragraph(pdfPlainInline(trimmed), pdfRegular, 10.5, 0, pdfBlack)
+		}
+	}
+}
+
+// Handler for /t/{token}/pdf: a shared conversation as a PDF
+func renderTranscriptPDF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	link, err := resolveShareLink(r.PathValue("token"))
+	if err == errShareLinkInvalid {
+		http.Error(w, "This link is invalid, has expired or was revoked", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch share link", http.StatusInternalServerError)
+		log.Println("Error fetching share link:", err)
+		return
+	}
+	transcript, err := loadSharedTranscript(link)
+	if err != nil {
+		http.Error(w, "Failed to fetch transcript", http.StatusInternalServerError)
+		log.Println("Error fetching shared transcript:", err)
+		return
+	}
+
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	writeConversationExport(w, &ConversationExport{Room: transcript.Room, ExportedAt: time.Now(), Messages: transcript.Messages}, "pdf")
+	addCounter("cubbychat_exports_total", "Conversation exports by format", 1, "format", "pdf")
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// maxExtractedBytes caps how much text a single document may expand to (zip/deflate bombs)
+const maxExtractedBytes = 64 * 1024 * 1024
+
+var errUnsupportedFormat = errors.New("unsupported document format")
+
+// documentPage is the text of one page; Number is 0 for formats without pages
+type documentPage struct {
+	Number int
+	Text   string
+}
+
+// documentFormat identifies a supported upload from its filename and content type
+func documentFormat(filename, contentType string) string {
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".pdf":
+		return "pdf"
+	case ".docx":
+		return "docx"
+	case ".html", ".htm":
+		return "html"
+	case ".md", ".markdown", ".txt":
+		return "markdown"
+	}
+	switch contentType {
+	case "application/pdf":
+		return "pdf"
+	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return "docx"
+	case "text/html":
+		return "html"
+	case "text/markdown", "text/plain":
+		return "markdown"
+	}
+	return ""
+}
+
+// extractText returns the plain text of a document, split into pages where the format has them
+func extractText(format string, data []byte) ([]documentPage, error) {
+	switch format {
+	case "pdf":
+		return extractPDF(data)
+	case "docx":
+		text, err := extractDOCX(data)
+		return []documentPage{{Text: text}}, err
+	case "html":
+		text, err := extractHTML(data)
+		return []documentPage{{Text: text}}, err
+	case "markdown":
+		if !utf8.Valid(data) {
+			return nil, errors.New("document is not valid UTF-8 text")
+		}
+		return []documentPage{{Text: string(data)}}, nil
+	}
+	return nil, errUnsupportedFormat
+}
+
+// extractDOCX reads the paragraphs of word/document.xml
+func extractDOCX(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("invalid DOCX archive: %v", err)
+	}
+
+	for _, f := range zr.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+
+		var text strings.Builder
+		inText := false
+		decoder := xml.NewDecoder(io.LimitReader(rc, maxExtractedBytes))
+		for {
+			token, err := decoder.Token()
+			if err == io.EOF {
+				return text.String(), nil
+			}
+			if err != nil {
+				return "", fmt.Errorf("invalid DOCX document: %v", err)
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				switch t.Name.Local {
+				case "t":
+					inText = true
+				case "tab":
+					text.WriteString("\t")
+				case "br", "cr":
+					text.WriteString("\n")
+				}
+			case xml.EndElement:
+				switch t.Name.Local {
+				case "t":
+					inText = false
+				case "p":
+					text.WriteString("\n")
+				}
+			case xml.CharData:
+				if inText {
+					text.Write(t)
+				}
+			}
+		}
+	}
+	return "", errors.New("DOCX archive has no word/document.xml")
+}
+
+// Elements whose content is never part of the readable text
+var htmlSkippedElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "svg": true, "iframe": true,
+}
+
+// Elements that start a new line of text
+var htmlBlockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "pre": true, "blockquote": true, "section": true, "article": true,
+	"header": true, "footer": true, "table": true, "ul": true, "ol": true, "hr": true, "title": true,
+}
+
+// extractHTML returns the visible text of an HTML page
+func extractHTML(data []byte) (string, error) {
+	var text strings.Builder
+	skipDepth := 0
+
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		tt := tokenizer.Next()
+		switch tt {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return "", err
+			}
+			return text.String(), nil
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			if htmlSkippedElements[string(name)] {
+				skipDepth++
+			} else if htmlBlockElements[string(name)] {
+				text.WriteString("\n")
+			}
+		case html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			if htmlBlockElements[string(name)] {
+				text.WriteString("\n")
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if htmlSkippedElements[string(name)] && skipDepth > 0 {
+				skipDepth--
+			} else if htmlBlockElements[string(name)] {
+				text.WriteString("\n")
+			}
+		case html.TextToken:
+			if skipDepth == 0 {
+				text.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+// pdfObject is an indirect object: its dictionary source and raw (still encoded) stream
+type pdfObject struct {
+	dict   string
+	stream []byte
+}
+
+var (
+	pdfObjectPattern   = regexp.MustCompile(`(?s)(\d+)\s+\d+\s+obj\b(.*?)\bendobj`)
+	pdfRefPattern      = regexp.MustCompile(`(\d+)\s+\d+\s+R`)
+	pdfPagesRefPattern = regexp.MustCompile(`/Pages\s+(\d+)\s+\d+\s+R`)
+	pdfKidsPattern     = regexp.MustCompile(`(?s)/Kids\s*\[(.*?)\]`)
+	pdfContentsPattern = regexp.MustCompile(`(?s)/Contents\s*(\[.*?\]|\d+\s+\d+\s+R)`)
+	pdfIntPattern      = regexp.MustCompile(`/(N|First)\s+(\d+)`)
+)
+
+// extractPDF pulls the text operators out of each page's content streams. It handles
+// uncompressed and Flate-compressed streams and object streams, which covers most
+// generated PDFs; scanned documents and exotic font encodings yield little or no text.
+func extractPDF(data []byte) ([]documentPage, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return nil, errors.New("not a PDF file")
+	}
+
+	objects := make(map[int]*pdfObject)
+	for _, m := range pdfObjectPattern.FindAllSubmatch(data, -1) {
+		num, _ := strconv.Atoi(string(m[1]))
+		objects[num] = parsePDFObject(m[2])
+	}
+
+	// Page dictionaries in PDF 1.5+ often live inside compressed object streams
+	for _, obj := range objects {
+		if strings.Contains(obj.dict, "/ObjStm") {
+			expandPDFObjectStream(obj, objects)
+		}
+	}
+
+	var pages []documentPage
+	for i, num := range pdfPageOrder(objects) {
+		var text strings.Builder
+		refs := pdfContentsPattern.FindStringSubmatch(objects[num].dict)
+		if refs == nil {
+			continue
+		}
+		for _, ref := range pdfRefPattern.FindAllStringSubmatch(refs[1], -1) {
+			id, _ := strconv.Atoi(ref[1])
+			if content, ok := objects[id]; ok {
+				text.WriteString(pdfContentText(pdfStreamData(content)))
+				text.WriteString("\n")
+			}
+		}
+		pages = append(pages, documentPage{Number: i + 1, Text: text.String()})
+	}
+
+	if len(pages) == 0 {
+		return nil, errors.New("no pages with text found in PDF")
+	}
+	return pages, nil
+}
+
+// parsePDFObject splits an object body into its dictionary and stream
+func parsePDFObject(body []byte) *pdfObject {
+	obj := &pdfObject{dict: string(body)}
+	start := bytes.Index(body, []byte("stream"))
+	if start < 0 {
+		return obj
+	}
+	obj.dict = string(body[:start])
+	stream := body[start+len("stream"):]
+	stream = bytes.TrimPrefix(stream, []byte("\r"))
+	stream = bytes.TrimPrefix(stream, []byte("\n"))
+	if end := bytes.LastIndex(stream, []byte("endstream")); end >= 0 {
+		stream = stream[:end]
+	}
+	obj.stream = stream
+	return obj
+}
+
+// pdfStreamData decodes a stream, returning nil for filters we can't read
+func pdfStreamData(obj *pdfObject) []byte {
+	if !strings.Contains(obj.dict, "/Filter") {
+		return obj.stream
+	}
+	if !strings.Contains(obj.dict, "/FlateDecode") {
+		return nil
+	}
+	r, err := zlib.NewReader(bytes.NewReader(obj.stream))
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	// Streams are often slightly truncated; keep whatever inflated cleanly
+	data, _ := io.ReadAll(io.LimitReader(r, maxExtractedBytes))
+	return data
+}
+
+// expandPDFObjectStream adds the objects packed in an /ObjStm stream to the object table
+func expandPDFObjectStream(obj *pdfObject, objects map[int]*pdfObject) {
+	var count, first int
+	for _, m := range pdfIntPattern.FindAllStringSubmatch(obj.dict, -1) {
+		value, _ := strconv.Atoi(m[2])
+		if m[1] == "N" {
+			count = value
+		} else {
+			first = value
+		}
+	}
+
+	data := pdfStreamData(obj)
+	if data == nil || first > len(data) {
+		return
+	}
+	header := strings.Fields(string(data[:first]))
+	for i := 0; i+1 < len(header) && i/2 < count; i += 2 {
+		num, err1 := strconv.Atoi(header[i])
+		offset, err2 := strconv.Atoi(header[i+1])
+		if err1 != nil || err2 != nil || first+offset > len(data) {
+			return
+		}
+		end := len(data)
+		if i+3 < len(header) {
+			if next, err := strconv.Atoi(header[i+3]); err == nil && first+next <= len(data) && next >= offset {
+				end = first + next
+			}
+		}
+		if _, exists := objects[num]; !exists {
+			objects[num] = &pdfObject{dict: string(data[first+offset : end])}
+		}
+	}
+}
+
+// pdfPageOrder walks the page tree from the catalog and returns page object numbers in reading order
+func pdfPageOrder(objects map[int]*pdfObject) []int {
+	var order []int
+	visited := make(map[int]bool)
+
+	var walk func(num int)
+	walk = func(num int) {
+		obj, ok := objects[num]
+		if !ok || visited[num] {
+			return
+		}
+		visited[num] = true
+		if kids := pdfKidsPattern.FindStringSubmatch(obj.dict); kids != nil {
+			for _, ref := range pdfRefPattern.FindAllStringSubmatch(kids[1], -1) {
+				id, _ := strconv.Atoi(ref[1])
+				walk(id)
+			}
+			return
+		}
+		order = append(order, num)
+	}
+
+	for _, obj := range objects {
+		if strings.Contains(obj.dict, "/Catalog") {
+			if m := pdfPagesRefPattern.FindStringSubmatch(obj.dict); m != nil {
+				root, _ := strconv.Atoi(m[1])
+				walk(root)
+				break
+			}
+		}
+	}
+	return order
+}
+
+// pdfContentText interprets the text-showing operators (Tj, TJ, ', ") of a content stream
+func pdfContentText(data []byte) string {
+	var text strings.Builder
+	var strs []string    // String operands since the last operator
+	var numbers []string // Numeric operands since the last operator
+	inArray := false
+
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '(':
+			s, n := pdfLiteralString(data[i:])
+			strs = append(strs, s)
+			i += n
+		case c == '<' && i+1 < len(data) && data[i+1] == '<':
+			i += 2
+		case c == '<':
+			end := bytes.IndexByte(data[i:], '>')
+			if end < 0 {
+				end = len(data) - i
+			}
+			strs = append(strs, pdfHexString(data[i+1:i+end]))
+			i += end + 1
+		case c == '>' || c == '{' || c == '}':
+			i++
+		case c == '[':
+			inArray = true
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case c == '%':
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+		case c == '/':
+			i++
+			for i < len(data) && !pdfDelimiter(data[i]) {
+				i++
+			}
+		case pdfWhitespace(c):
+			i++
+		default:
+			start := i
+			for i < len(data) && !pdfDelimiter(data[i]) {
+				i++
+			}
+			if i == start {
+				i++
+				continue
+			}
+			token := string(data[start:i])
+			if value, err := strconv.ParseFloat(token, 64); err == nil {
+				// Large negative kerning inside a TJ array separates words
+				if inArray && value < -200 {
+					strs = append(strs, " ")
+				}
+				numbers = append(numbers, token)
+				continue
+			}
+
+			switch token {
+			case "Tj", "TJ":
+				text.WriteString(strings.Join(strs, ""))
+			case "'", "\"":
+				text.WriteString("\n")
+				text.WriteString(strings.Join(strs, ""))
+			case "T*":
+				text.WriteString("\n")
+			case "Td", "TD":
+				// A vertical move starts a new line; a horizontal one separates words
+				ty := 0.0
+				if len(numbers) >= 2 {
+					ty, _ = strconv.ParseFloat(numbers[len(numbers)-1], 64)
+				}
+				if ty != 0 {
+					text.WriteString("\n")
+				} else {
+					text.WriteString(" ")
+				}
+			case "ET":
+				text.WriteString("\n")
+			case "ID":
+				// Skip inline image data up to the EI operator
+				if end := bytes.Index(data[i:], []byte("EI")); end >= 0 {
+					i += end + 2
+				} else {
+					i = len(data)
+				}
+			}
+			strs = strs[:0]
+			numbers = numbers[:0]
+		}
+	}
+	return text.String()
+}
+
+// pdfLiteralString decodes a (...) string and returns it with the number of bytes consumed
+func pdfLiteralString(data []byte) (string, int) {
+	var out []byte
+	depth := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch c {
+		case '(':
+			if depth > 0 {
+				out = append(out, c)
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfDecodeString(out), i + 1
+			}
+			out = append(out, c)
+		case '\\':
+			i++
+			if i >= len(data) {
+				break
+			}
+			switch e := data[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					value := 0
+					j := i
+					for ; j < len(data) && j < i+3 && data[j] >= '0' && data[j] <= '7'; j++ {
+						value = value*8 + int(data[j]-'0')
+					}
+					out = append(out, byte(value))
+					i = j - 1
+				} else {
+					out = append(out, e)
+				}
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return pdfDecodeString(out), len(data)
+}
+
+// pdfHexString decodes a <...> string
+func pdfHexString(data []byte) string {
+	var digits []byte
+	for _, c := range data {
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		value, _ := strconv.ParseUint(string(digits[i*2:i*2+2]), 16, 8)
+		out[i] = byte(value)
+	}
+	return pdfDecodeString(out)
+}
+
+// pdfDecodeString converts UTF-16BE (with BOM) or single-byte text to UTF-8
+func pdfDecodeString(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		var runes []rune
+		for i := 2; i+1 < len(b); i += 2 {
+			runes = append(runes, rune(b[i])<<8|rune(b[i+1]))
+		}
+		return string(runes)
+	}
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	// Treat anything else as Latin-1, the closest match to PDFDocEncoding
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func pdfWhitespace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func pdfDelimiter(c byte) bool {
+	return pdfWhitespace(c) || strings.IndexByte("()<>[]{}/%", c) >= 0
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Feedback is a user's rating of an AI message
+type Feedback struct {
+	MessageID int       `json:"message_id"`
+	User      string    `json:"user"`
+	Rating    int       `json:"rating"` // 1 for helpful, -1 for unhelpful
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// initFeedback creates the feedback table
+func initFeedback() {
+	createFeedbackTable()
+}
+
+// Create `message_feedback` table if it doesn't exist
+func createFeedbackTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS message_feedback (
+			message_id INTEGER NOT NULL REFERENCES chat_history(id) ON DELETE CASCADE,
+			user_id TEXT NOT NULL DEFAULT '',
+			rating SMALLINT NOT NULL CHECK (rating IN (-1, 1)),
+			comment TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			PRIMARY KEY (message_id, user_id)
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create message_feedback table:", err)
+	}
+	log.Println("✅ Table message_feedback is ready")
+}
+
+// Handler to rate an AI message ({"rating": 1 or -1, "comment": "..."}); rating again replaces the earlier rating
+func submitFeedback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	messageID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Rating  int    `json:"rating"`
+		Comment string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Rating != 1 && req.Rating != -1) {
+		http.Error(w, "rating must be 1 or -1", http.StatusBadRequest)
+		return
+	}
+	if len(req.Comment) > 2000 {
+		http.Error(w, "Comments are limited to 2000 characters", http.StatusBadRequest)
+		return
+	}
+
+	feedback := Feedback{MessageID: messageID, User: requestUser(r), Rating: req.Rating, Comment: strings.TrimSpace(req.Comment)}
+	ctx := context.Background()
+	err = pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+			INSERT INTO message_feedback (message_id, user_id, rating, comment)
+			SELECT id, $2, $3, $4 FROM chat_history WHERE id = $1 AND sender = 'AI'
+			ON CONFLICT (message_id, user_id) DO UPDATE SET rating = EXCLUDED.rating, comment = EXCLUDED.comment, created_at = NOW()
+			RETURNING created_at`, messageID, feedback.User, feedback.Rating, feedback.Comment).Scan(&feedback.CreatedAt)
+		if err != nil {
+			return err
+		}
+		return recordEvent(ctx, tx, "feedback.given", feedback)
+	})
+	if err == pgx.ErrNoRows {
+		http.Error(w, "AI message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save feedback", http.StatusInternalServerError)
+		log.Println("Error saving feedback:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(feedback)
+}
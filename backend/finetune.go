@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// fineTuneSystemPrompt opens every exported conversation
+var fineTuneSystemPrompt string
+
+// FineTuneExample is one line of the fine-tuning export: a rated exchange in chat format
+type FineTuneExample struct {
+	Messages []FineTuneMessage `json:"messages"`
+	Feedback FineTuneFeedback  `json:"feedback"`
+	RoomID   int               `json:"room_id"`
+	ID       int               `json:"message_id"` // The rated AI message
+}
+
+// FineTuneMessage is a turn of an exported conversation
+type FineTuneMessage struct {
+	Role    string `json:"role"` // system, user or assistant
+	Content string `json:"content"`
+}
+
+// FineTuneFeedback summarizes the ratings of an exported answer
+type FineTuneFeedback struct {
+	Label    string   `json:"label"` // positive, negative or mixed
+	Up       int      `json:"up"`
+	Down     int      `json:"down"`
+	Comments []string `json:"comments,omitempty"`
+}
+
+// initFineTuneExport reads the system prompt used in exports
+func initFineTuneExport() {
+	fineTuneSystemPrompt = getEnv("FINE_TUNE_SYSTEM_PROMPT", "You are Cubby, a friendly chat assistant.")
+}
+
+// Handler for /api/admin/exports/fine-tune: rated exchanges as JSONL.
+// Query parameters: from and to (YYYY-MM-DD, inclusive; the last 30 days by default),
+// room (a room id) and rating (positive, negative or all; positive by default).
+func exportFineTune(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, ok := reportPeriod(w, r)
+	if !ok {
+		return
+	}
+	var room *int
+	if value := r.URL.Query().Get("room"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid room id", http.StatusBadRequest)
+			return
+		}
+		room = &id
+	}
+	rating := r.URL.Query().Get("rating")
+	if rating == "" {
+		rating = "positive"
+	}
+	if rating != "positive" && rating != "negative" && rating != "all" {
+		http.Error(w, "rating must be positive, negative or all", http.StatusBadRequest)
+		return
+	}
+
+	// Each rated AI message is paired with the user message right before it in the room
+	rows, err := db.Query(context.Background(), `
+		WITH rated AS (
+			SELECT message_id,
+				COUNT(*) FILTER (WHERE rating = 1) AS up,
+				COUNT(*) FILTER (WHERE rating = -1) AS down,
+				ARRAY_REMOVE(ARRAY_AGG(NULLIF(comment, '')), NULL) AS comments
+			FROM message_feedback GROUP BY message_id
+		)
+		SELECT a.id, a.room_id, q.message, `+messageText("a")+`, rated.up, rated.down, rated.comments
+		FROM rated
+		JOIN chat_history a ON a.id = rated.message_id
+		JOIN LATERAL (
+			SELECT message FROM chat_history
+			WHERE room_id = a.room_id AND sender = 'User' AND id < a.id
+			ORDER BY id DESC LIMIT 1
+		) q ON TRUE
+		WHERE a.timestamp >= $1 AND a.timestamp < $2 AND ($3::int IS NULL OR a.room_id = $3)
+			AND ($4 = 'all' OR ($4 = 'positive' AND rated.up > rated.down) OR ($4 = 'negative' AND rated.down > rated.up))
+		ORDER BY a.id`, from, to, room, rating)
+	if err != nil {
+		http.Error(w, "Failed to export conversations", http.StatusInternalServerError)
+		log.Println("Error exporting conversations:", err)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="fine-tune.jsonl"`)
+	encoder := json.NewEncoder(w)
+	for rows.Next() {
+		var example FineTuneExample
+		var question, answer string
+		if err := rows.Scan(&example.ID, &example.RoomID, &question, &answer,
+			&example.Feedback.Up, &example.Feedback.Down, &example.Feedback.Comments); err != nil {
+			log.Println("Error scanning exported conversation:", err)
+			return
+		}
+		example.Messages = []FineTuneMessage{
+			{Role: "system", Content: fineTuneSystemPrompt},
+			{Role: "user", Content: question},
+			{Role: "assistant", Content: answer},
+		}
+		switch {
+		case example.Feedback.Up > example.Feedback.Down:
+			example.Feedback.Label = "positive"
+		case example.Feedback.Down > example.Feedback.Up:
+			example.Feedback.Label = "negative"
+		default:
+			example.Feedback.Label = "mixed"
+		}
+		if err := encoder.Encode(example); err != nil {
+			log.Println("Error writing export:", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Feature flags: defaults come from the server's own settings and FEATURE_FLAGS, and
+// the admin API stores flags that override them without a redeploy
+var (
+	flagsMu      sync.RWMutex
+	flagDefaults map[string]bool
+	storedFlags  map[string]FeatureFlag
+)
+
+var flagNamePattern = regexp.MustCompile(`^[a-z0-9_.-]{1,64}$`)
+
+// FeatureFlag turns a UI feature on or off, optionally for some rooms, users or a share of users
+type FeatureFlag struct {
+	Name      string          `json:"name"`
+	Enabled   bool            `json:"enabled"`
+	Rollout   int             `json:"rollout"`         // Percentage of users that get Enabled; the rest get it off
+	Rooms     map[string]bool `json:"rooms,omitempty"` // Per-room overrides, keyed by room id
+	Users     map[string]bool `json:"users,omitempty"` // Per-user overrides, which beat room overrides
+	Note      string          `json:"note,omitempty"`
+	UpdatedAt *time.Time      `json:"updated_at,omitempty"` // Nil for defaults that aren't stored
+}
+
+// initFeatureFlags works out the default flags and loads the stored ones
+func initFeatureFlags() {
+	flagDefaults = map[string]bool{
+		"voice":     ttsEnabled && sttEnabled,
+		"rag":       ragEnabled,
+		"feedback":  true,
+		"followups": followUpsEnabled,
+		"images":    imageGenEnabled,
+	}
+	// FEATURE_FLAGS adds or changes defaults: "feedback=false,new_sidebar=true"
+	for _, entry := range splitList(getEnv("FEATURE_FLAGS", "")) {
+		name, value, _ := strings.Cut(entry, "=")
+		enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil || !flagNamePattern.MatchString(strings.TrimSpace(name)) {
+			log.Fatalf("❌ Invalid FEATURE_FLAGS entry %q (want name=true|false)", entry)
+		}
+		flagDefaults[strings.TrimSpace(name)] = enabled
+	}
+
+	createFeatureFlagsTable()
+	if err := loadFeatureFlags(); err != nil {
+		log.Fatal("❌ Failed to load feature flags:", err)
+	}
+}
+
+// Create `feature_flags` table if it doesn't exist
+func createFeatureFlagsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS feature_flags (
+			name TEXT PRIMARY KEY,
+			enabled BOOLEAN NOT NULL,
+			rollout INTEGER NOT NULL DEFAULT 100,
+			rooms JSONB NOT NULL DEFAULT '{}',
+			users JSONB NOT NULL DEFAULT '{}',
+			note TEXT NOT NULL DEFAULT '',
+			updated_at TIMESTAMPTZ DEFAULT NOW()
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create feature_flags table:", err)
+	}
+	log.Println("✅ Table feature_flags is ready")
+}
+
+// loadFeatureFlags reloads the stored flags from the database
+func loadFeatureFlags() error {
+	rows, err := db.Query(context.Background(), "SELECT name, enabled, rollout, rooms, users, note, updated_at FROM feature_flags")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	flags := map[string]FeatureFlag{}
+	for rows.Next() {
+		var flag FeatureFlag
+		if err := rows.Scan(&flag.Name, &flag.Enabled, &flag.Rollout, &flag.Rooms, &flag.Users, &flag.Note, &flag.UpdatedAt); err != nil {
+			return err
+		}
+		flags[flag.Name] = flag
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	flagsMu.Lock()
+	defer flagsMu.Unlock()
+	storedFlags = flags
+	return nil
+}
+
+// rolloutBucket places a user in 0-99 for a flag, the same bucket every time
+func rolloutBucket(name, user string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + user))
+	return int(h.Sum32() % 100)
+}
+
+// evaluate decides the flag for a room and user: user override, then room
+// override, then the rollout (anonymous users only get fully rolled-out flags)
+func (f FeatureFlag) evaluate(roomID int, user string) bool {
+	if enabled, ok := f.Users[user]; ok && user != "" {
+		return enabled
+	}
+	if enabled, ok := f.Rooms[strconv.Itoa(roomID)]; ok {
+		return enabled
+	}
+	if f.Enabled && f.Rollout < 100 {
+		return user != "" && rolloutBucket(f.Name, user) < f.Rollout
+	}
+	return f.Enabled
+}
+
+// flagEnabled decides one flag for a room and user; unknown flags are off
+func flagEnabled(name string, roomID int, user string) bool {
+	flagsMu.RLock()
+	defer flagsMu.RUnlock()
+	if flag, ok := storedFlags[name]; ok {
+		return flag.evaluate(roomID, user)
+	}
+	return flagDefaults[name]
+}
+
+// setFlagDefault adds or changes a flag default at runtime, for flags that belong to loaded components
+func setFlagDefault(name string, enabled bool) {
+	flagsMu.Lock()
+	defer flagsMu.Unlock()
+	flagDefaults[name] = enabled
+}
+
+// evaluateFlags returns every flag's value for a room and user
+func evaluateFlags(roomID int, user string) map[string]bool {
+	flagsMu.RLock()
+	defer flagsMu.RUnlock()
+
+	features := make(map[string]bool, len(flagDefaults)+len(storedFlags))
+	for name, enabled := range flagDefaults {
+		features[name] = enabled
+	}
+	for name, flag := range storedFlags {
+		features[name] = flag.evaluate(roomID, user)
+	}
+	return features
+}
+
+// Handler for /api/admin/feature-flags: list with GET, create or replace with PUT
+func handleFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut || r.Method == http.MethodPost {
+		saveFeatureFlag(w, r)
+		return
+	}
+
+	flagsMu.RLock()
+	flags := []FeatureFlag{}
+	for name, enabled := range flagDefaults {
+		if _, stored := storedFlags[name]; !stored {
+			flags = append(flags, FeatureFlag{Name: name, Enabled: enabled, Rollout: 100, Note: "default"})
+		}
+	}
+	for _, flag := range storedFlags {
+		flags = append(flags, flag)
+	}
+	flagsMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flags)
+}
+
+// Handler to store a flag, overriding its default
+func saveFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	req := FeatureFlag{Rollout: 100}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if !flagNamePattern.MatchString(req.Name) {
+		http.Error(w, "name must be 1-64 lowercase letters, digits, '.', '_' or '-'", http.StatusBadRequest)
+		return
+	}
+	if req.Rollout < 0 || req.Rollout > 100 {
+		http.Error(w, "rollout must be between 0 and 100", http.StatusBadRequest)
+		return
+	}
+	for room := range req.Rooms {
+		if _, err := strconv.Atoi(room); err != nil {
+			http.Error(w, fmt.Sprintf("rooms key %q is not a room id", room), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Rooms == nil {
+		req.Rooms = map[string]bool{}
+	}
+	if req.Users == nil {
+		req.Users = map[string]bool{}
+	}
+
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO feature_flags (name, enabled, rollout, rooms, users, note) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (name) DO UPDATE SET enabled = EXCLUDED.enabled, rollout = EXCLUDED.rollout,
+			rooms = EXCLUDED.rooms, users = EXCLUDED.users, note = EXCLUDED.note, updated_at = NOW()
+		RETURNING updated_at`, req.Name, req.Enabled, req.Rollout, req.Rooms, req.Users, req.Note).Scan(&req.UpdatedAt)
+	if err == nil {
+		err = loadFeatureFlags()
+	}
+	if err != nil {
+		http.Error(w, "Failed to save feature flag", http.StatusInternalServerError)
+		log.Println("Error saving feature flag:", err)
+		return
+	}
+	recordAudit("admin", clientIP(r), "feature_flag.save", req.Name, req)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
+
+// Handler to delete a stored flag, which puts its default back
+func deleteFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.PathValue("name")
+
+	err := db.QueryRow(context.Background(), "DELETE FROM feature_flags WHERE name = $1 RETURNING name", name).Scan(&name)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Feature flag not found", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = loadFeatureFlags()
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete feature flag", http.StatusInternalServerError)
+		log.Println("Error deleting feature flag:", err)
+		return
+	}
+	recordAudit("admin", clientIP(r), "feature_flag.delete", name, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	followUpsEnabled bool          // Whether to suggest follow-up questions after each answer
+	followUpModel    string        // Small model used for suggestions (defaults to the chat model)
+	followUpCount    int           // Number of suggestions to request
+	followUpTimeout  time.Duration // Upper bound on how long suggestions may take
+)
+
+// FollowUpsEvent is delivered on the "follow_ups" WebSocket event
+type FollowUpsEvent struct {
+	Suggestions []string `json:"suggestions"`
+}
+
+// initFollowUps reads the follow-up suggestion settings from the environment
+func initFollowUps() {
+	followUpsEnabled = getEnvBool("FOLLOWUPS_ENABLED", false)
+	followUpModel = getEnv("FOLLOWUPS_MODEL", "")
+	followUpCount = getEnvInt("FOLLOWUPS_COUNT", 3)
+	followUpTimeout = getEnvDuration("FOLLOWUPS_TIMEOUT", 15*time.Second)
+
+	if followUpCount < 1 {
+		followUpCount = 1
+	}
+
+	if followUpsEnabled {
+		log.Printf("💡 Follow-up suggestions enabled (count: %d, model: %s)", followUpCount, getEnv("FOLLOWUPS_MODEL", "chat model"))
+	}
+}
+
+// suggestFollowUps asks the model for short follow-up questions to a completed exchange
+func suggestFollowUps(question, answer string) ([]string, error) {
+	model := followUpModel
+	if model == "" {
+		model = ollamaModel
+	}
+
+	prompt := fmt.Sprintf(`Suggest %d short follow-up questions the user might ask next.
+Reply only with JSON in the form {"questions": ["...", "..."]}.
+
+User: %s
+
+Assistant: %s`, followUpCount, question, answer)
+
+	raw, err := generateOnce(model, prompt, "json", followUpTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed struct {
+		Questions []string `json:"questions"`
+	}
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse suggestions: %v", err)
+	}
+
+	var suggestions []string
+	for _, q := range parsed.Questions {
+		q = strings.TrimSpace(q)
+		if q == "" {
+			continue
+		}
+		suggestions = append(suggestions, q)
+		if len(suggestions) == followUpCount {
+			break
+		}
+	}
+	return suggestions, nil
+}
+
+// sendFollowUps generates suggestions and delivers them as a "follow_ups" event
+func sendFollowUps(s *Session, question, answer string) {
+	suggestions, err := suggestFollowUps(question, answer)
+	if err != nil {
+		log.Println("Error generating follow-up suggestions:", err)
+		return
+	}
+	if len(suggestions) == 0 {
+		return
+	}
+
+	if err := s.sendEvent("follow_ups", FollowUpsEvent{Suggestions: suggestions}); err != nil {
+		log.Println("Error sending follow-up suggestions:", err)
+	}
+}
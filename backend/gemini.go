@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// GeminiRequest is the body of a streamGenerateContent call
+type GeminiRequest struct {
+	Contents         []GeminiContent        `json:"contents"`
+	Tools            []GeminiTool           `json:"tools,omitempty"`
+	GenerationConfig GeminiGenerationConfig `json:"generationConfig"`
+}
+
+// GeminiGenerationConfig holds the sampling settings of a call
+type GeminiGenerationConfig struct {
+	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"topP,omitempty"`
+	Seed            *int     `json:"seed,omitempty"`
+}
+
+// GeminiContent is one turn of the conversation; the model's role is "model"
+type GeminiContent struct {
+	Role  string       `json:"role"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+// GeminiPart is text, a function call the model made, or a function's result
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+// GeminiFunctionCall is a tool call from the model
+type GeminiFunctionCall struct {
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args"`
+}
+
+// GeminiFunctionResponse gives a tool's output back to the model
+type GeminiFunctionResponse struct {
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+// GeminiTool lists the functions the model may call
+type GeminiTool struct {
+	FunctionDeclarations []OllamaToolFunction `json:"functionDeclarations"`
+}
+
+// GeminiStreamChunk is one server-sent event of a streamed answer
+type GeminiStreamChunk struct {
+	Candidates []struct {
+		Content GeminiContent `json:"content"`
+	} `json:"candidates"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"` // Running totals, so the last one counts
+}
+
+// geminiTools describes the registered tools in the format Gemini expects
+func geminiTools() []GeminiTool {
+	if len(registeredTools) == 0 {
+		return nil
+	}
+	var functions []OllamaToolFunction
+	for _, tool := range registeredTools {
+		functions = append(functions, OllamaToolFunction{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters})
+	}
+	return []GeminiTool{{FunctionDeclarations: functions}}
+}
+
+// streamGemini streams an answer from the Gemini API into gen.response, running
+// any functions the model calls and feeding their results back
+func streamGemini(s *Session, gen *generation, p *Provider) error {
+	contents := []GeminiContent{{Role: "user", Parts: []GeminiPart{{Text: gen.modelPrompt()}}}}
+	params := gen.params()
+	config := GeminiGenerationConfig{MaxOutputTokens: p.MaxTokens, Temperature: params.Temperature, TopP: params.TopP, Seed: params.Seed}
+	if params.MaxTokens != nil {
+		config.MaxOutputTokens = *params.MaxTokens
+	}
+
+	for round := 0; round <= maxToolRounds; round++ {
+		request := GeminiRequest{Contents: contents, GenerationConfig: config}
+		// On the last round the model has to answer with what it has
+		if round < maxToolRounds {
+			request.Tools = geminiTools()
+		}
+
+		parts, err := streamGeminiRound(s, gen, p, request)
+		if err != nil {
+			return err
+		}
+		var calls []OllamaToolCall
+		for _, part := range parts {
+			if part.FunctionCall != nil {
+				var call OllamaToolCall
+				call.Function.Name, call.Function.Arguments = part.FunctionCall.Name, part.FunctionCall.Args
+				calls = append(calls, call)
+			}
+		}
+		if len(calls) == 0 {
+			return nil
+		}
+
+		contents = append(contents, GeminiContent{Role: "model", Parts: parts})
+		var results []GeminiPart
+		for i, result := range runToolCalls(s, gen, calls) {
+			results = append(results, GeminiPart{FunctionResponse: &GeminiFunctionResponse{
+				Name: calls[i].Function.Name, Response: map[string]interface{}{"output": result},
+			}})
+		}
+		contents = append(contents, GeminiContent{Role: "user", Parts: results})
+	}
+	return nil
+}
+
+// streamGeminiRound runs one streamGenerateContent call, streaming text to the client,
+// and returns the parts of the model's answer with consecutive text merged
+func streamGeminiRound(s *Session, gen *generation, p *Provider, request GeminiRequest) ([]GeminiPart, error) {
+	resp, err := newUpstreamClient().R().
+		SetContext(gen.context()).
+		SetHeader("Content-Type", "application/json").
+		SetHeader("x-goog-api-key", p.APIKey).
+		SetBody(request).
+		SetDoNotParseResponse(true).
+		Post(p.URL + "/models/" + url.PathEscape(gen.model) + ":streamGenerateContent?alt=sse")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", p.Name, err)
+	}
+	defer resp.RawBody().Close()
+	if resp.StatusCode() != 200 {
+		body, _ := io.ReadAll(io.LimitReader(resp.RawBody(), 4096))
+		return nil, &upstreamStatusError{provider: p.Name, status: resp.StatusCode(), body: string(body)}
+	}
+
+	var parts []GeminiPart
+	promptTokens, completionTokens := 0, 0
+	scanner := bufio.NewScanner(resp.RawBody())
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var chunk GeminiStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			log.Printf("Error parsing %s response: %v", p.Name, err)
+			continue
+		}
+		if chunk.UsageMetadata != nil {
+			promptTokens, completionTokens = chunk.UsageMetadata.PromptTokenCount, chunk.UsageMetadata.CandidatesTokenCount
+		}
+		if len(chunk.Candidates) == 0 {
+			continue
+		}
+
+		for _, part := range chunk.Candidates[0].Content.Parts {
+			if part.FunctionCall != nil {
+				parts = append(parts, part)
+				continue
+			}
+			if part.Text == "" {
+				continue
+			}
+			if err := gen.sendToken(s, part.Text); err != nil {
+				gen.promptTokens += promptTokens
+				gen.completionTokens += completionTokens
+				return nil, nil
+			}
+			gen.response += part.Text
+			if last := len(parts) - 1; last >= 0 && parts[last].FunctionCall == nil {
+				parts[last].Text += part.Text
+			} else {
+				parts = append(parts, GeminiPart{Text: part.Text})
+			}
+		}
+	}
+	gen.promptTokens += promptTokens
+	gen.completionTokens += completionTokens
+	return parts, scanner.Err()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// Stable codes for why a generation failed. They're the "code" of WebSocket error
+// events and REST errors and the "code" label of cubbychat_generation_errors_total.
+const (
+	errCodeModelNotFound    = "model_not_found"
+	errCodeContextOverflow  = "context_overflow"
+	errCodeTimeout          = "timeout"
+	errCodeRateLimited      = "rate_limited"
+	errCodeProviderDown     = "provider_down"
+	errCodeGenerationFailed = "generation_failed" // Anything that doesn't fit the others
+)
+
+// generationErrorMessages are shown to users for each code
+var generationErrorMessages = map[string]string{
+	errCodeModelNotFound:    "The AI model isn't available on the server",
+	errCodeContextOverflow:  "The conversation is too long for the model",
+	errCodeTimeout:          "The AI took too long to answer",
+	errCodeRateLimited:      "The AI provider is rate limiting requests, try again shortly",
+	errCodeProviderDown:     "The AI service is unavailable right now",
+	errCodeGenerationFailed: "Error processing request",
+}
+
+// generationErrorStatuses are the HTTP statuses REST endpoints answer with for each code
+var generationErrorStatuses = map[string]int{
+	errCodeModelNotFound:    http.StatusNotFound,
+	errCodeContextOverflow:  http.StatusBadRequest,
+	errCodeTimeout:          http.StatusGatewayTimeout,
+	errCodeRateLimited:      http.StatusTooManyRequests,
+	errCodeProviderDown:     http.StatusServiceUnavailable,
+	errCodeGenerationFailed: http.StatusBadGateway,
+}
+
+// contextOverflowHints are phrases providers use when a prompt exceeds the model's context
+var contextOverflowHints = []string{"context length", "context_length", "context window", "maximum context", "prompt is too long", "too many tokens"}
+
+// classifyGenerationError maps an error from generating an answer onto a stable code
+func classifyGenerationError(err error) string {
+	if errors.Is(err, errPromptTooLarge) {
+		return errCodeContextOverflow
+	}
+	if errors.Is(err, errNoProvider) {
+		return errCodeProviderDown
+	}
+	if errors.Is(err, errGenerationTimeout) || errors.Is(err, errFirstTokenTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return errCodeTimeout
+	}
+
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		body := strings.ToLower(statusErr.body)
+		switch {
+		case statusErr.status == http.StatusTooManyRequests:
+			return errCodeRateLimited
+		case statusErr.status == http.StatusRequestTimeout || statusErr.status == http.StatusGatewayTimeout:
+			return errCodeTimeout
+		case statusErr.status == http.StatusNotFound && strings.Contains(body, "model"):
+			return errCodeModelNotFound
+		case statusErr.status == http.StatusRequestEntityTooLarge:
+			return errCodeContextOverflow
+		case statusErr.status == http.StatusBadRequest:
+			for _, hint := range contextOverflowHints {
+				if strings.Contains(body, hint) {
+					return errCodeContextOverflow
+				}
+			}
+		case statusErr.status >= 500:
+			return errCodeProviderDown // Including Anthropic's 529 overloaded
+		}
+		return errCodeGenerationFailed
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errCodeTimeout
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errCodeProviderDown
+	}
+	return errCodeGenerationFailed
+}
+
+// generationErrorMessage is the user-facing message for a code
+func generationErrorMessage(code string) string {
+	if message, ok := generationErrorMessages[code]; ok {
+		return message
+	}
+	return generationErrorMessages[errCodeGenerationFailed]
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Grounding estimates how well an answer is supported by the knowledge base excerpts it
+// was given, so UIs can ask people to check answers that may not be. It is a heuristic:
+// how relevant the best excerpt was, how much of the answer's wording comes from the
+// excerpts, and whether the answer cites them.
+var (
+	groundingLowScore  float64 // Answers scoring below this are "low"
+	groundingHighScore float64 // Answers scoring at least this are "high"; those between are "medium"
+)
+
+var citationRefPattern = regexp.MustCompile(`\[(\d{1,2})\]`)
+
+// Grounding is stored with AI answers given while retrieval is enabled
+type Grounding struct {
+	Score     float64 `json:"score"`            // From 0 (unsupported) to 1 (well supported)
+	Level     string  `json:"level"`            // "high", "medium" or "low"
+	Retrieval float64 `json:"retrieval"`        // The best excerpt's relevance, reranked if reranking is on
+	Overlap   float64 `json:"overlap"`          // Share of the answer's distinctive words found in the excerpts
+	Cited     int     `json:"cited"`            // Excerpts the answer cites by number
+	Reason    string  `json:"reason,omitempty"` // Why the score is low when it isn't a matter of degree
+}
+
+// initGrounding reads the thresholds for grounding levels
+func initGrounding() {
+	groundingLowScore = getEnvFloat("GROUNDING_LOW_SCORE", 0.4)
+	groundingHighScore = max(groundingLowScore, getEnvFloat("GROUNDING_HIGH_SCORE", 0.7))
+}
+
+// groundingWords returns the distinct words of at least four letters, lowercased, which
+// leaves out most of the words any answer would share with any excerpt
+func groundingWords(text string) map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if len([]rune(word)) >= 4 {
+			words[word] = true
+		}
+	}
+	return words
+}
+
+// assessGrounding scores an answer against the excerpts it was given
+func assessGrounding(answer string, chunks []RetrievedChunk) *Grounding {
+	if len(chunks) == 0 {
+		return &Grounding{Level: "low", Reason: "no_excerpts"}
+	}
+
+	g := &Grounding{}
+	var excerpts strings.Builder
+	for _, c := range chunks {
+		relevance := c.Score
+		if rerankEnabled && c.RerankScore > 0 {
+			relevance = c.RerankScore
+		}
+		g.Retrieval = max(g.Retrieval, min(max(relevance, 0), 1))
+		excerpts.WriteString(c.Content)
+		excerpts.WriteByte('\n')
+	}
+
+	answerWords := groundingWords(answer)
+	if len(answerWords) > 0 {
+		excerptWords := groundingWords(excerpts.String())
+		shared := 0
+		for word := range answerWords {
+			if excerptWords[word] {
+				shared++
+			}
+		}
+		g.Overlap = float64(shared) / float64(len(answerWords))
+	}
+
+	cited := map[int]bool{}
+	for _, m := range citationRefPattern.FindAllStringSubmatch(answer, -1) {
+		if n, _ := strconv.Atoi(m[1]); n >= 1 && n <= len(chunks) {
+			cited[n] = true
+		}
+	}
+	g.Cited = len(cited)
+
+	score := 0.5*g.Retrieval + 0.4*g.Overlap
+	if g.Cited > 0 {
+		score += 0.1
+	}
+	g.Score = math.Round(score*1000) / 1000
+	g.Retrieval = math.Round(g.Retrieval*1000) / 1000
+	g.Overlap = math.Round(g.Overlap*1000) / 1000
+
+	switch {
+	case g.Score >= groundingHighScore:
+		g.Level = "high"
+	case g.Score >= groundingLowScore:
+		g.Level = "medium"
+	default:
+		g.Level = "low"
+	}
+	return g
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handoff lets a human operator take over a room, as on a support desk: the AI stops
+// answering, messages from moderators are marked as staff replies, and the operator can
+// privately ask the AI to suggest a reply, then approve it to send it as their own.
+// Suggestions are kept as reply drafts; see replydrafts.go.
+var (
+	handoffSuggestModel   string        // Model that suggests replies (defaults to the chat model)
+	handoffSuggestTimeout time.Duration // Upper bound on how long a suggestion may take
+	handoffHistory        int           // Recent messages the suggestion is based on
+)
+
+// Handoff records who took over a room
+type Handoff struct {
+	Operator string    `json:"operator"`
+	Since    time.Time `json:"since"`
+}
+
+// HandoffEvent tells a room's clients an operator took over (Handoff set) or handed back
+type HandoffEvent struct {
+	RoomID  int      `json:"room_id"`
+	Handoff *Handoff `json:"handoff"`
+}
+
+// SuggestedReplyEvent is sent only to the operator who asked for a suggestion
+type SuggestedReplyEvent struct {
+	SuggestionID int    `json:"suggestion_id"`
+	RoomID       int    `json:"room_id"`
+	Text         string `json:"text"`
+}
+
+// initHandoff reads the reply suggestion settings
+func initHandoff() {
+	handoffSuggestModel = getEnv("HANDOFF_SUGGEST_MODEL", "")
+	handoffSuggestTimeout = getEnvDuration("HANDOFF_SUGGEST_TIMEOUT", 30*time.Second)
+	handoffHistory = max(1, getEnvInt("HANDOFF_HISTORY", 20))
+}
+
+// roomHandoff returns the room's handoff, or nil while the AI is in charge
+func roomHandoff(roomID int) *Handoff {
+	room, err := getRoom(roomID)
+	if err != nil {
+		log.Println("Error fetching room handoff:", err)
+		return nil
+	}
+	return room.Metadata.Handoff
+}
+
+// setHandoff hands a room to an operator, or back to the AI with a nil handoff, telling
+// the room's clients, its history and the audit log
+func setHandoff(roomID int, handoff *Handoff, actor, ip string) error {
+	var err error
+	if handoff != nil {
+		_, err = db.Exec(context.Background(),
+			"UPDATE rooms SET metadata = metadata || jsonb_build_object('handoff', $2::jsonb) WHERE id = $1", roomID, handoff)
+	} else {
+		_, err = db.Exec(context.Background(), "UPDATE rooms SET metadata = metadata - 'handoff' WHERE id = $1", roomID)
+	}
+	if err != nil {
+		return err
+	}
+
+	operator := ""
+	if handoff != nil {
+		operator = handoff.Operator
+	}
+	recordAudit(actor, ip, "room.handoff", strconv.Itoa(roomID), map[string]string{"operator": operator})
+	recordAnnotation(roomID, Annotation{Kind: annotationHandoff, To: operator})
+	publishRoomEvent(roomID, nil, "handoff", HandoffEvent{RoomID: roomID, Handoff: handoff})
+	return nil
+}
+
+// isStaffReply reports whether a session's message is an operator's reply: sent by a
+// moderator while their room is taken over
+func isStaffReply(s *Session) bool {
+	return s.moderator && roomHandoff(s.room) != nil
+}
+
+// handleTakeover hands the session's room to its user ("takeover" frame) or back to the AI ("release")
+func handleTakeover(s *Session, takeover bool) {
+	if !s.moderator || s.user == "" {
+		s.sendError("forbidden", "Only named moderators can take over a room")
+		return
+	}
+	var handoff *Handoff
+	if takeover {
+		handoff = &Handoff{Operator: s.user, Since: time.Now()}
+	}
+	if err := setHandoff(s.room, handoff, "moderator", s.ip); err != nil {
+		log.Println("Error updating room handoff:", err)
+		s.sendError("handoff_failed", "Could not update the room's handoff, please try again")
+	}
+}
+
+// handoffTranscript formats the room's latest messages for a suggestion prompt, naming
+// the customer, the operator and the AI, and returns the latest customer message's id
+func handoffTranscript(roomID int) (string, int, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT id, sender, `+messageText("chat_history")+`, COALESCE((metadata->>'staff')::boolean, false) FROM chat_history
+		WHERE room_id = $1 AND sender IN ('User', 'AI') ORDER BY id DESC LIMIT $2`, roomID, handoffHistory)
+	if err != nil {
+		return "", 0, err
+	}
+	defer rows.Close()
+
+	var lines []string
+	latestCustomer := 0
+	for rows.Next() {
+		var id int
+		var sender, text string
+		var staff bool
+		if err := rows.Scan(&id, &sender, &text, &staff); err != nil {
+			return "", 0, err
+		}
+		role := "Customer"
+		switch {
+		case staff:
+			role = "Agent"
+		case sender == "AI":
+			role = "Assistant"
+		case latestCustomer == 0:
+			latestCustomer = id
+		}
+		lines = append(lines, role+": "+text)
+	}
+	if err := rows.Err(); err != nil {
+		return "", 0, err
+	}
+	// Oldest first
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return strings.Join(lines, "\n\n"), latestCustomer, nil
+}
+
+// draftReply asks the model for the agent's next reply in a room, answering the latest
+// customer message, whose id it returns
+func draftReply(roomID int) (string, int, error) {
+	transcript, messageID, err := handoffTranscript(roomID)
+	if err != nil {
+		return "", 0, err
+	}
+	model := handoffSuggestModel
+	if model == "" {
+		model = ollamaModel
+	}
+	prompt := fmt.Sprintf(`You help a support agent reply to a customer. Write the agent's next reply to the
+conversation below: friendly, accurate and concise. Reply with the message text only.
+
+%s
+
+Agent:`, transcript)
+	text, err := generateOnce(model, cannedStyleInstructions()+prompt, "", handoffSuggestTimeout)
+	if err != nil {
+		return "", 0, err
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", 0, fmt.Errorf("the model suggested an empty reply")
+	}
+	return text, messageID, nil
+}
+
+// handleSuggestReply privately sends the operator a suggested reply ("suggest_reply" frame)
+func handleSuggestReply(s *Session) {
+	if !s.moderator || roomHandoff(s.room) == nil {
+		s.sendError("forbidden", "Suggestions are for operators of a room that was taken over")
+		return
+	}
+	go func() {
+		draft, err := createReplyDraft(s.room, s.user)
+		if err != nil {
+			log.Println("Error suggesting a reply:", err)
+			s.sendError("suggestion_failed", "Could not suggest a reply, please try again")
+			return
+		}
+		if err := s.sendEvent("suggested_reply", SuggestedReplyEvent{SuggestionID: draft.ID, RoomID: s.room, Text: draft.Draft}); err != nil {
+			log.Println("Error sending suggested_reply event:", err)
+		}
+	}()
+}
+
+// handleApproveReply sends a suggestion as the operator's reply ("approve_reply" frame); a
+// text in the frame replaces the suggestion's, for operators who edited it
+func handleApproveReply(s *Session, frame *ClientFrame) {
+	if !s.moderator || roomHandoff(s.room) == nil {
+		s.sendError("forbidden", "Suggestions are for operators of a room that was taken over")
+		return
+	}
+	draft, err := pendingReplyDraft(s.room, frame.SuggestionID)
+	if err != nil {
+		log.Println("Error fetching reply draft:", err)
+		s.sendError("suggestion_failed", "Could not send the reply, please try again")
+		return
+	}
+	if draft == nil {
+		s.sendError("suggestion_not_found", "That suggestion was already sent or discarded")
+		return
+	}
+
+	text := strings.TrimSpace(frame.Text)
+	if text == "" {
+		text = draft.Draft
+	}
+	if !s.checkMessageLength(text) {
+		return
+	}
+	claimed, err := claimReplyDraft(draft, "sent")
+	if err != nil {
+		log.Println("Error claiming reply draft:", err)
+		s.sendError("suggestion_failed", "Could not send the reply, please try again")
+		return
+	}
+	if !claimed {
+		s.sendError("suggestion_not_found", "That suggestion was already sent or discarded")
+		return
+	}
+	ack, duplicate := postStaffReply(s.room, s, s.user, text, frame.ClientID)
+	if ack.MessageID == 0 && !ack.Incognito {
+		releaseReplyDraft(draft)
+		s.sendError("suggestion_failed", "Could not send the reply, please try again")
+		return
+	}
+	if err := s.sendEvent("message_ack", ack); err != nil {
+		log.Println("Error sending message_ack event:", err)
+	}
+	if !duplicate {
+		if err := resolveReplyDraft(draft, text, ack.MessageID); err != nil {
+			log.Println("Error recording reply draft outcome:", err)
+		}
+	}
+}
+
+// Handler for /api/rooms/{id}/handoff: an operator takes over with POST ({"operator": "name"}),
+// the AI takes back over with DELETE
+func handleRoomHandoff(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+
+	var handoff *Handoff
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Operator string `json:"operator"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&req); err != nil || strings.TrimSpace(req.Operator) == "" {
+			http.Error(w, "An operator is required", http.StatusBadRequest)
+			return
+		}
+		handoff = &Handoff{Operator: strings.TrimSpace(req.Operator), Since: time.Now()}
+	case http.MethodDelete:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := setHandoff(room.ID, handoff, "moderator", clientIP(r)); err != nil {
+		http.Error(w, "Failed to update room handoff", http.StatusInternalServerError)
+		log.Println("Error updating room handoff:", err)
+		return
+	}
+	room.Metadata.Handoff = handoff
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// hooks is the pipeline prompts and responses pass through, in HOOKS order
+var hooks []*Hook
+
+// Hook is a processor in the pipeline. Either function may be nil when the hook
+// only cares about one side.
+type Hook struct {
+	Name string
+	// RewritePrompt changes the prompt the model is sent (the stored message is left as typed)
+	RewritePrompt func(gen *generation, prompt string) string
+	// TransformResponse changes the finished answer before it's stored and shown
+	TransformResponse func(gen *generation, response string) string
+}
+
+// hookFactories build the built-in hooks from their HOOK_<NAME>_* settings
+var hookFactories = map[string]func() *Hook{
+	"date":            newDateHook,
+	"context":         newContextHook,
+	"strip_signature": newStripSignatureHook,
+	"regex":           newRegexHook,
+}
+
+// signOffPattern matches the first line of a letter-style closing
+var signOffPattern = regexp.MustCompile(`(?i)^\s*(--\s*$|(best|kind|warm|warmest)?\s*(regards|wishes)\b|cheers\b|sincerely\b|yours truly\b|all the best\b|hope this helps[.!]?\s*$)`)
+
+// initHooks builds the pipeline from HOOKS, a comma-separated list of hook names
+func initHooks() {
+	hooks = nil
+	for _, name := range splitList(getEnv("HOOKS", "")) {
+		factory, ok := hookFactories[name]
+		if !ok {
+			log.Fatalf("❌ Unknown hook %q in HOOKS", name)
+		}
+		hooks = append(hooks, factory())
+	}
+	if len(hooks) > 0 {
+		names := make([]string, len(hooks))
+		for i, hook := range hooks {
+			names[i] = hook.Name
+		}
+		log.Printf("🪝 Hook pipeline: %s", strings.Join(names, " → "))
+	}
+}
+
+// applyPromptHooks runs a prompt through every hook that rewrites prompts, then any prompt plugins
+func applyPromptHooks(gen *generation, prompt string) string {
+	for _, hook := range hooks {
+		if hook.RewritePrompt != nil {
+			prompt = hook.RewritePrompt(gen, prompt)
+		}
+	}
+	return applyPluginText(gen, pluginHookPrompt, prompt)
+}
+
+// applyResponseHooks runs a finished answer through every hook that transforms responses, then any response plugins
+func applyResponseHooks(gen *generation, response string) string {
+	for _, hook := range hooks {
+		if hook.TransformResponse != nil {
+			response = hook.TransformResponse(gen, response)
+		}
+	}
+	return applyPluginText(gen, pluginHookResponse, response)
+}
+
+// newDateHook tells the model the current date and time (HOOK_DATE_TIMEZONE, UTC by default)
+func newDateHook() *Hook {
+	location, err := time.LoadLocation(getEnv("HOOK_DATE_TIMEZONE", "UTC"))
+	if err != nil {
+		log.Fatal("❌ Invalid HOOK_DATE_TIMEZONE:", err)
+	}
+	return &Hook{
+		Name: "date",
+		RewritePrompt: func(gen *generation, prompt string) string {
+			return "Current date and time: " + time.Now().In(location).Format("Monday, 2 January 2006 15:04 MST") + "\n\n" + prompt
+		},
+	}
+}
+
+// newContextHook puts fixed background (HOOK_CONTEXT_TEXT) in front of every prompt
+func newContextHook() *Hook {
+	text := strings.TrimSpace(getEnv("HOOK_CONTEXT_TEXT", ""))
+	if text == "" {
+		log.Fatal("❌ The context hook needs HOOK_CONTEXT_TEXT")
+	}
+	return &Hook{
+		Name: "context",
+		RewritePrompt: func(gen *generation, prompt string) string {
+			return text + "\n\n" + prompt
+		},
+	}
+}
+
+// newStripSignatureHook drops a letter-style closing ("Best regards, ...") from the end of answers
+func newStripSignatureHook() *Hook {
+	return &Hook{
+		Name: "strip_signature",
+		TransformResponse: func(gen *generation, response string) string {
+			lines := strings.Split(strings.TrimRight(response, " \n"), "\n")
+			// Only the last few lines can be a closing
+			for i := max(0, len(lines)-4); i < len(lines); i++ {
+				if i > 0 && signOffPattern.MatchString(lines[i]) {
+					return strings.TrimRight(strings.Join(lines[:i], "\n"), " \n")
+				}
+			}
+			return response
+		},
+	}
+}
+
+// newRegexHook replaces HOOK_REGEX_PATTERN with HOOK_REGEX_REPLACEMENT ($1 expands groups)
+// in responses, or in prompts when HOOK_REGEX_TARGET is "prompt"
+func newRegexHook() *Hook {
+	pattern, err := regexp.Compile(getEnv("HOOK_REGEX_PATTERN", ""))
+	if err != nil || pattern.String() == "" {
+		log.Fatal("❌ The regex hook needs a valid HOOK_REGEX_PATTERN:", err)
+	}
+	replacement := getEnv("HOOK_REGEX_REPLACEMENT", "")
+	replace := func(gen *generation, text string) string {
+		return pattern.ReplaceAllString(text, replacement)
+	}
+
+	hook := &Hook{Name: "regex"}
+	switch target := getEnv("HOOK_REGEX_TARGET", "response"); target {
+	case "prompt":
+		hook.RewritePrompt = replace
+	case "response":
+		hook.TransformResponse = replace
+	default:
+		log.Fatalf("❌ HOOK_REGEX_TARGET must be prompt or response, not %q", target)
+	}
+	return hook
+}
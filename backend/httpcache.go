@@ -0,0 +1,158 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyCache keeps encoded history responses for the most-requested rooms; nil when disabled
+var historyCache *historyLRU
+
+// historyLRU is a fixed-size least-recently-used cache of history responses by room
+type historyLRU struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[int]*list.Element
+}
+
+type historyEntry struct {
+	roomID int
+	etag   string
+	body   []byte
+}
+
+// historyVersion identifies the state of a room's history
+type historyVersion struct {
+	etag         string
+	lastModified time.Time
+}
+
+// initHTTPCache sets up the history cache and the rooms' history revisions
+func initHTTPCache() {
+	migrateHistoryRevisions()
+	if size := getEnvInt("HISTORY_CACHE_SIZE", 100); size > 0 {
+		historyCache = &historyLRU{size: size, order: list.New(), entries: make(map[int]*list.Element)}
+	}
+}
+
+// Add the rooms.history_revision column if it doesn't exist. It's stored so every
+// instance, and a restarted one, hands out the same ETags.
+func migrateHistoryRevisions() {
+	query := `ALTER TABLE rooms ADD COLUMN IF NOT EXISTS history_revision INTEGER NOT NULL DEFAULT 0`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to add history revisions:", err)
+	}
+}
+
+// get returns the cached response for a room if it is still the given version
+func (c *historyLRU) get(roomID int, etag string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[roomID]
+	if !ok || el.Value.(*historyEntry).etag != etag {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*historyEntry).body, true
+}
+
+// put stores a room's response, evicting the least recently used room when full
+func (c *historyLRU) put(roomID int, etag string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[roomID]; ok {
+		el.Value = &historyEntry{roomID: roomID, etag: etag, body: body}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[roomID] = c.order.PushFront(&historyEntry{roomID: roomID, etag: etag, body: body})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*historyEntry).roomID)
+	}
+}
+
+// historyChanged marks a room's history as changed by an update that doesn't add a message
+// (new messages change the ETag by themselves), bumping its stored revision
+func historyChanged(roomID int) {
+	if _, err := db.Exec(context.Background(), "UPDATE rooms SET history_revision = history_revision + 1 WHERE id = $1", roomID); err != nil {
+		log.Println("Error bumping history revision:", err)
+	}
+}
+
+// currentHistoryVersion works out a room's history ETag from its latest message id,
+// message count (which catches deletions) and revision, without loading the messages
+func currentHistoryVersion(roomID int) (historyVersion, error) {
+	var latestID, count, revision int
+	var latest *time.Time
+	err := db.QueryRow(context.Background(), `
+		SELECT COALESCE(MAX(id), 0), COUNT(*), MAX(timestamp),
+			COALESCE((SELECT history_revision FROM rooms WHERE id = $1), 0)
+		FROM chat_history WHERE room_id = $1`, roomID).
+		Scan(&latestID, &count, &latest, &revision)
+	if err != nil {
+		return historyVersion{}, err
+	}
+	v := historyVersion{etag: fmt.Sprintf(`"h%d-%d-%d-%d"`, roomID, latestID, count, revision)}
+	if latest != nil {
+		v.lastModified = *latest
+	}
+	return v, nil
+}
+
+// notModified sets the validators on a response and answers 304 Not Modified when the
+// client's copy (If-None-Match, or If-Modified-Since without it) is current
+func notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache") // Clients may keep it but must revalidate
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.IsZero() &&
+		!lastModified.Truncate(time.Second).After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// writeJSONWithETag answers with v, tagged with a hash of its encoding, or 304 if the client has it already
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(body)
+	if notModified(w, r, `"`+hex.EncodeToString(sum[:16])+`"`, time.Time{}) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// Connected sessions, so events can reach every tab and device a user or room has open
+var (
+	sessionsMu sync.Mutex
+	sessions   = make(map[*Session]bool)
+)
+
+// registerSession adds a connected session to the registry, refusing it when
+// the user or address already has as many connections as allowed
+func registerSession(s *Session) error {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if reason := connectionLimitExceeded(s); reason != "" {
+		return errors.New(reason)
+	}
+	sessions[s] = true
+	presenceJoined(s)
+	return nil
+}
+
+// unregisterSession removes a session once its connection closes, queueing
+// room events for the user until they reconnect
+func unregisterSession(s *Session) {
+	sessionsMu.Lock()
+	delete(sessions, s)
+	sessionsMu.Unlock()
+	presenceLeft(s)
+	startOfflineQueue(s)
+}
+
+// connectedSessions returns a snapshot of the sessions matching a filter
+func connectedSessions(match func(s *Session) bool) []*Session {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	var matched []*Session
+	for s := range sessions {
+		if match(s) {
+			matched = append(matched, s)
+		}
+	}
+	return matched
+}
+
+// userSessions returns every connected session that proved it belongs to a named user;
+// sessions merely claiming the name with ?user= aren't theirs
+func userSessions(user string) []*Session {
+	if user == "" {
+		return nil
+	}
+	return connectedSessions(func(s *Session) bool { return s.identity == user })
+}
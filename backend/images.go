@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	imageGenEnabled    bool          // Whether image generation is available
+	imageGenBackend    string        // "automatic1111" (Stable Diffusion web UI API) or "comfyui"
+	imageGenURL        string        // Base URL of the image backend
+	imageGenCheckpoint string        // Checkpoint name used by the ComfyUI workflow
+	imageGenWidth      int           // Default image width
+	imageGenHeight     int           // Default image height
+	imageGenSteps      int           // Default sampling steps
+	imageGenTimeout    time.Duration // Upper bound for one generation
+	imageGenSlots      chan struct{} // Limits concurrent generations on the GPU
+)
+
+// ImageRequest is the body of POST /api/images
+type ImageRequest struct {
+	RoomID         int    `json:"room_id,omitempty"`
+	Prompt         string `json:"prompt"`
+	NegativePrompt string `json:"negative_prompt,omitempty"`
+	Width          int    `json:"width,omitempty"`
+	Height         int    `json:"height,omitempty"`
+	Steps          int    `json:"steps,omitempty"`
+}
+
+// ImageResponse is returned by POST /api/images
+type ImageResponse struct {
+	MessageID   int           `json:"message_id"`
+	Message     string        `json:"message"`
+	Attachments []*Attachment `json:"attachments"`
+}
+
+// initImageGen reads image generation settings and registers the /imagine command
+func initImageGen() {
+	imageGenEnabled = getEnvBool("IMAGE_GEN_ENABLED", false)
+	imageGenBackend = strings.ToLower(getEnv("IMAGE_GEN_BACKEND", "automatic1111"))
+	imageGenURL = getEnv("IMAGE_GEN_URL", "http://stable-diffusion:7860")
+	imageGenCheckpoint = getEnv("IMAGE_GEN_CHECKPOINT", "v1-5-pruned-emaonly.safetensors")
+	imageGenWidth = getEnvInt("IMAGE_GEN_WIDTH", 512)
+	imageGenHeight = getEnvInt("IMAGE_GEN_HEIGHT", 512)
+	imageGenSteps = getEnvInt("IMAGE_GEN_STEPS", 20)
+	imageGenTimeout = getEnvDuration("IMAGE_GEN_TIMEOUT", 3*time.Minute)
+	imageGenSlots = make(chan struct{}, max(1, getEnvInt("IMAGE_GEN_CONCURRENCY", 1)))
+
+	if !imageGenEnabled {
+		return
+	}
+	if imageGenBackend != "automatic1111" && imageGenBackend != "comfyui" {
+		log.Printf("⚠️ Unknown IMAGE_GEN_BACKEND %q, image generation disabled", imageGenBackend)
+		imageGenEnabled = false
+		return
+	}
+
+	registerCommand("imagine", imagineCommand)
+	log.Printf("🎨 Image generation enabled (backend: %s)", imageGenBackend)
+}
+
+// generateImage produces PNG images for a request using the configured backend
+func generateImage(req ImageRequest) ([][]byte, error) {
+	if req.Width <= 0 || req.Width > 2048 {
+		req.Width = imageGenWidth
+	}
+	if req.Height <= 0 || req.Height > 2048 {
+		req.Height = imageGenHeight
+	}
+	if req.Steps <= 0 || req.Steps > 150 {
+		req.Steps = imageGenSteps
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), imageGenTimeout)
+	defer cancel()
+
+	// Wait for a free generation slot
+	select {
+	case imageGenSlots <- struct{}{}:
+		defer func() { <-imageGenSlots }()
+	case <-ctx.Done():
+		return nil, errors.New("image generator is busy, try again later")
+	}
+
+	if imageGenBackend == "comfyui" {
+		return generateComfyUI(ctx, req)
+	}
+	return generateAutomatic1111(ctx, req)
+}
+
+// generateAutomatic1111 calls the Stable Diffusion web UI txt2img API
+func generateAutomatic1111(ctx context.Context, req ImageRequest) ([][]byte, error) {
+	var result struct {
+		Images []string `json:"images"`
+	}
+
+	resp, err := newUpstreamClient().R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(map[string]interface{}{
+			"prompt":          req.Prompt,
+			"negative_prompt": req.NegativePrompt,
+			"width":           req.Width,
+			"height":          req.Height,
+			"steps":           req.Steps,
+		}).
+		SetResult(&result).
+		Post(imageGenURL + "/sdapi/v1/txt2img")
+	if err != nil {
+		return nil, fmt.Errorf("image backend unavailable: %v", err)
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("image backend returned status %d", resp.StatusCode())
+	}
+
+	var images [][]byte
+	for _, encoded := range result.Images {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image data: %v", err)
+		}
+		images = append(images, data)
+	}
+	return images, nil
+}
+
+// comfyUIWorkflow builds the default text-to-image graph in ComfyUI's API format
+func comfyUIWorkflow(req ImageRequest) map[string]interface{} {
+	node := func(classType string, inputs map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"class_type": classType, "inputs": inputs}
+	}
+	return map[string]interface{}{
+		"3": node("KSampler", map[string]interface{}{
+			"seed": rng.Int63n(1 << 48), "steps": req.Steps, "cfg": 7, "sampler_name": "euler",
+			"scheduler": "normal", "denoise": 1, "model": []interface{}{"4", 0},
+			"positive": []interface{}{"6", 0}, "negative": []interface{}{"7", 0}, "latent_image": []interface{}{"5", 0},
+		}),
+		"4": node("CheckpointLoaderSimple", map[string]interface{}{"ckpt_name": imageGenCheckpoint}),
+		"5": node("EmptyLatentImage", map[string]interface{}{"width": req.Width, "height": req.Height, "batch_size": 1}),
+		"6": node("CLIPTextEncode", map[string]interface{}{"text": req.Prompt, "clip": []interface{}{"4", 1}}),
+		"7": node("CLIPTextEncode", map[string]interface{}{"text": req.NegativePrompt, "clip": []interface{}{"4", 1}}),
+		"8": node("VAEDecode", map[string]interface{}{"samples": []interface{}{"3", 0}, "vae": []interface{}{"4", 2}}),
+		"9": node("SaveImage", map[string]interface{}{"filename_prefix": "cubbychat", "images": []interface{}{"8", 0}}),
+	}
+}
+
+// generateComfyUI queues the workflow, polls until it finishes and downloads the outputs
+func generateComfyUI(ctx context.Context, req ImageRequest) ([][]byte, error) {
+	client := newUpstreamClient()
+
+	var queued struct {
+		PromptID string `json:"prompt_id"`
+	}
+	resp, err := client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(map[string]interface{}{"prompt": comfyUIWorkflow(req)}).
+		SetResult(&queued).
+		Post(imageGenURL + "/prompt")
+	if err != nil {
+		return nil, fmt.Errorf("image backend unavailable: %v", err)
+	}
+	if resp.StatusCode() != 200 || queued.PromptID == "" {
+		return nil, fmt.Errorf("image backend rejected workflow (status %d): %s", resp.StatusCode(), resp.String())
+	}
+
+	type comfyImage struct {
+		Filename  string `json:"filename"`
+		Subfolder string `json:"subfolder"`
+		Type      string `json:"type"`
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, errors.New("image generation timed out")
+		case <-time.After(time.Second):
+		}
+
+		var history map[string]struct {
+			Outputs map[string]struct {
+				Images []comfyImage `json:"images"`
+			} `json:"outputs"`
+		}
+		resp, err := client.R().SetContext(ctx).SetResult(&history).Get(imageGenURL + "/history/" + queued.PromptID)
+		if err != nil || resp.StatusCode() != 200 {
+			continue
+		}
+		entry, done := history[queued.PromptID]
+		if !done {
+			continue
+		}
+
+		var images [][]byte
+		for _, output := range entry.Outputs {
+			for _, img := range output.Images {
+				resp, err := client.R().
+					SetContext(ctx).
+					SetQueryParams(map[string]string{"filename": img.Filename, "subfolder": img.Subfolder, "type": img.Type}).
+					Get(imageGenURL + "/view")
+				if err != nil || resp.StatusCode() != 200 {
+					return nil, fmt.Errorf("failed to download generated image %s", img.Filename)
+				}
+				images = append(images, resp.Body())
+			}
+		}
+		if len(images) == 0 {
+			return nil, errors.New("workflow finished without images")
+		}
+		return images, nil
+	}
+}
+
+// createImageMessage generates images, stores them as attachments and saves the AI message
+// referencing them, showing it to the room's other clients; from is the session that
+// asked, nil for the REST API
+func createImageMessage(req ImageRequest, from *Session) (*ImageResponse, error) {
+	images, err := generateImage(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var attachments []*Attachment
+	var message strings.Builder
+	alt := strings.NewReplacer("[", "", "]", "", "\n", " ").Replace(req.Prompt)
+	for i, data := range images {
+		att, err := saveAttachment(fmt.Sprintf("image-%d.png", i+1), "image/png", data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store image: %v", err)
+		}
+		attachments = append(attachments, att)
+		fmt.Fprintf(&message, "![%s](%s)\n", alt, att.URL)
+	}
+
+	metadata := &MessageMetadata{Attachments: attachments}
+	text := strings.TrimSpace(message.String())
+	roomID := req.RoomID
+	if roomID == 0 {
+		roomID = defaultRoomID
+	}
+	messageID := saveMessageWithMetadata(roomID, "AI", text, metadata)
+	publishRoomEvent(roomID, from, "message", ChatMessage{ID: messageID, Sender: "AI", Message: text, Timestamp: time.Now(), Metadata: metadata})
+	return &ImageResponse{MessageID: messageID, Message: text, Attachments: attachments}, nil
+}
+
+// imagineCommand handles "/imagine <prompt>" in chat
+func imagineCommand(s *Session, args string) {
+	if args == "" {
+		s.sendText("🎨 Usage: /imagine <description of the image>")
+		return
+	}
+	if s.incognito {
+		s.sendText("🎨 Images are stored as attachments, so /imagine isn't available in incognito chats.")
+		return
+	}
+
+	s.sendText("🎨 Painting your picture...")
+	result, err := createImageMessage(ImageRequest{RoomID: s.room, Prompt: args}, s)
+	if err != nil {
+		log.Println("Error generating image:", err)
+		s.sendText("\n\n😵 Image generation failed, please try again later.")
+		return
+	}
+
+	sendAIDone(s, AIDoneEvent{
+		MessageID: result.MessageID,
+		Message:   result.Message,
+		Metadata:  &MessageMetadata{Attachments: result.Attachments},
+	})
+}
+
+// Handler to generate images via REST
+func createImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !imageGenEnabled {
+		http.Error(w, "Image generation is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req ImageRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil || strings.TrimSpace(req.Prompt) == "" {
+		http.Error(w, "A prompt is required", http.StatusBadRequest)
+		return
+	}
+	if req.RoomID == 0 {
+		req.RoomID = defaultRoomID
+	}
+	room, err := getRoom(req.RoomID)
+	if err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	// The image is posted to the room, so it takes what posting there does
+	if !requireRoomRole(w, r, room, roleMember, true) {
+		return
+	}
+	if code, message := roomStateRefuses(room, isModerator(r)); code != "" {
+		http.Error(w, message, http.StatusForbidden)
+		return
+	}
+
+	result, err := createImageMessage(req, nil)
+	if err != nil {
+		http.Error(w, "Image generation failed", http.StatusBadGateway)
+		log.Println("Error generating image:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	importMaxBytes         int64 // Bounds the size of an uploaded history file
+	importMaxConversations int   // Most rooms one export can create
+)
+
+// importRow is one message read from an import file
+type importRow struct {
+	row       int // Line, record or message number in the file, for error reports
+	sender    string
+	user      string
+	message   string
+	timestamp time.Time
+}
+
+// ImportError reports a row that couldn't be imported
+type ImportError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// ImportResult summarises an import
+type ImportResult struct {
+	Format   string        `json:"format"`
+	Imported int           `json:"imported"`
+	Errors   []ImportError `json:"errors"`
+}
+
+// initImport reads the import settings
+func initImport() {
+	importMaxBytes = int64(getEnvInt("IMPORT_MAX_BYTES", 50<<20))
+	importMaxConversations = getEnvInt("IMPORT_MAX_CONVERSATIONS", 1000)
+}
+
+// normalizeSender maps the sender names other systems use onto ours
+func normalizeSender(sender string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(sender)) {
+	case "user", "human", "you":
+		return "User", nil
+	case "ai", "assistant", "bot", "model", "cubby":
+		return "AI", nil
+	}
+	return "", fmt.Errorf("unknown sender %q (want user or assistant)", sender)
+}
+
+// newImportRow validates the fields of one row
+func newImportRow(row int, sender, user, message, timestamp string) (importRow, error) {
+	r := importRow{row: row, user: strings.TrimSpace(user), message: message}
+	var err error
+	if r.sender, err = normalizeSender(sender); err != nil {
+		return r, err
+	}
+	if strings.TrimSpace(message) == "" {
+		return r, errors.New("message is empty")
+	}
+	if timestamp != "" {
+		if r.timestamp, err = time.Parse(time.RFC3339, timestamp); err != nil {
+			return r, fmt.Errorf("timestamp %q is not RFC 3339", timestamp)
+		}
+	}
+	return r, nil
+}
+
+// parseJSONLImport reads one {"sender", "message", "user", "timestamp"} object per line
+func parseJSONLImport(body io.Reader) ([]importRow, []ImportError, error) {
+	var rows []importRow
+	var rowErrors []ImportError
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), int(importMaxBytes))
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record struct {
+			Sender    string `json:"sender"`
+			User      string `json:"user"`
+			Message   string `json:"message"`
+			Timestamp string `json:"timestamp"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			rowErrors = append(rowErrors, ImportError{Row: line, Error: "invalid JSON: " + err.Error()})
+			continue
+		}
+		row, err := newImportRow(line, record.Sender, record.User, record.Message, record.Timestamp)
+		if err != nil {
+			rowErrors = append(rowErrors, ImportError{Row: line, Error: err.Error()})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors, scanner.Err()
+}
+
+// parseCSVImport reads a CSV file whose header names the sender, message, user and timestamp columns
+func parseCSVImport(body io.Reader) ([]importRow, []ImportError, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading CSV header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["sender"]; !ok {
+		return nil, nil, errors.New(`CSV header needs "sender" and "message" columns`)
+	}
+	if _, ok := columns["message"]; !ok {
+		return nil, nil, errors.New(`CSV header needs "sender" and "message" columns`)
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var rows []importRow
+	var rowErrors []ImportError
+	for n := 2; ; n++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// A malformed record can leave the reader out of step, so stop there
+			rowErrors = append(rowErrors, ImportError{Row: n, Error: err.Error()})
+			break
+		}
+		row, err := newImportRow(n, field(record, "sender"), field(record, "user"), field(record, "message"), field(record, "timestamp"))
+		if err != nil {
+			rowErrors = append(rowErrors, ImportError{Row: n, Error: err.Error()})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors, nil
+}
+
+// chatGPTConversation is one conversation in a ChatGPT data export (conversations.json)
+type chatGPTConversation struct {
+	Title       string  `json:"title"`
+	CreateTime  float64 `json:"create_time"`
+	CurrentNode string  `json:"current_node"`
+	Mapping     map[string]struct {
+		Parent  string `json:"parent"`
+		Message *struct {
+			Author struct {
+				Role string `json:"role"`
+			} `json:"author"`
+			CreateTime *float64 `json:"create_time"`
+			Content    struct {
+				Parts []interface{} `json:"parts"`
+			} `json:"content"`
+		} `json:"message"`
+	} `json:"mapping"`
+}
+
+// decodeChatGPTExport reads a conversations.json export, or a single conversation from one
+func decodeChatGPTExport(raw []byte) ([]chatGPTConversation, error) {
+	var conversations []chatGPTConversation
+	if err := json.Unmarshal(raw, &conversations); err != nil {
+		var single chatGPTConversation
+		if err := json.Unmarshal(raw, &single); err != nil {
+			return nil, fmt.Errorf("not a ChatGPT export: %v", err)
+		}
+		conversations = []chatGPTConversation{single}
+	}
+	return conversations, nil
+}
+
+// rows flattens the conversation's current branch (the one the user last saw) into messages.
+// Edits and regenerations leave other branches in the mapping, which are skipped.
+func (c chatGPTConversation) rows(start int) ([]importRow, []ImportError) {
+	var path []string
+	for id := c.CurrentNode; id != "" && len(path) <= len(c.Mapping); id = c.Mapping[id].Parent {
+		path = append(path, id)
+	}
+
+	var rows []importRow
+	var rowErrors []ImportError
+	n := start
+	for i := len(path) - 1; i >= 0; i-- {
+		msg := c.Mapping[path[i]].Message
+		if msg == nil || (msg.Author.Role != "user" && msg.Author.Role != "assistant") {
+			continue // System prompts and tool output aren't part of the visible conversation
+		}
+		var parts []string
+		for _, part := range msg.Content.Parts {
+			if text, ok := part.(string); ok && text != "" {
+				parts = append(parts, text)
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		n++
+		row, err := newImportRow(n, msg.Author.Role, "", strings.Join(parts, "\n\n"), "")
+		if err != nil {
+			rowErrors = append(rowErrors, ImportError{Row: n, Error: err.Error()})
+			continue
+		}
+		if msg.CreateTime != nil {
+			sec, frac := math.Modf(*msg.CreateTime)
+			row.timestamp = time.Unix(int64(sec), int64(frac*1e9))
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors
+}
+
+// claudeConversation is one conversation in a Claude data export (conversations.json)
+type claudeConversation struct {
+	Name         string `json:"name"`
+	CreatedAt    string `json:"created_at"`
+	ChatMessages []struct {
+		Sender    string `json:"sender"`
+		Text      string `json:"text"`
+		CreatedAt string `json:"created_at"`
+		Content   []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	} `json:"chat_messages"`
+}
+
+// decodeClaudeExport reads a Claude conversations.json export, or a single conversation from one
+func decodeClaudeExport(raw []byte) ([]claudeConversation, error) {
+	var conversations []claudeConversation
+	if err := json.Unmarshal(raw, &conversations); err != nil {
+		var single claudeConversation
+		if err := json.Unmarshal(raw, &single); err != nil {
+			return nil, fmt.Errorf("not a Claude export: %v", err)
+		}
+		conversations = []claudeConversation{single}
+	}
+	return conversations, nil
+}
+
+// rows turns the conversation's messages into import rows. Newer exports split a message
+// into content blocks; only the text ones are kept (tool use and thinking aren't shown).
+func (c claudeConversation) rows(start int) ([]importRow, []ImportError) {
+	var rows []importRow
+	var rowErrors []ImportError
+	n := start
+	for _, msg := range c.ChatMessages {
+		text := msg.Text
+		if len(msg.Content) > 0 {
+			var parts []string
+			for _, block := range msg.Content {
+				if block.Type == "text" && block.Text != "" {
+					parts = append(parts, block.Text)
+				}
+			}
+			text = strings.Join(parts, "\n\n")
+		}
+		if strings.TrimSpace(text) == "" {
+			continue // Only tool use or attachments, which aren't imported
+		}
+		n++
+		row, err := newImportRow(n, msg.Sender, "", text, msg.CreatedAt)
+		if err != nil {
+			rowErrors = append(rowErrors, ImportError{Row: n, Error: err.Error()})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors
+}
+
+// importConversation is one conversation read from a ChatGPT or Claude export
+type importConversation struct {
+	title   string
+	created time.Time
+	rows    []importRow
+	errors  []ImportError
+}
+
+// readExport reads an uploaded export: conversations.json itself, or the .zip archive
+// ChatGPT and Claude email, from which conversations.json is taken
+func readExport(body io.Reader) ([]byte, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(raw, []byte("PK\x03\x04")) {
+		return raw, nil
+	}
+	archive, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("reading archive: %v", err)
+	}
+	for _, f := range archive.File {
+		if path.Base(f.Name) != "conversations.json" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(io.LimitReader(rc, importMaxBytes))
+	}
+	return nil, errors.New("the archive has no conversations.json")
+}
+
+// detectExportFormat tells a ChatGPT export from a Claude one by their conversations' fields
+func detectExportFormat(raw []byte) string {
+	var probe []map[string]json.RawMessage
+	if json.Unmarshal(raw, &probe) != nil || len(probe) == 0 {
+		var single map[string]json.RawMessage
+		json.Unmarshal(raw, &single)
+		probe = []map[string]json.RawMessage{single}
+	}
+	if _, ok := probe[0]["chat_messages"]; ok {
+		return "claude"
+	}
+	return "chatgpt"
+}
+
+// exportConversations reads the conversations of a ChatGPT or Claude export ("export"
+// detects which), oldest first
+func exportConversations(format string, body io.Reader) (string, []importConversation, error) {
+	raw, err := readExport(body)
+	if err != nil {
+		return format, nil, err
+	}
+	if format == "export" {
+		format = detectExportFormat(raw)
+	}
+
+	var conversations []importConversation
+	switch format {
+	case "claude":
+		decoded, err := decodeClaudeExport(raw)
+		if err != nil {
+			return format, nil, err
+		}
+		for _, c := range decoded {
+			rows, rowErrors := c.rows(0)
+			created, _ := time.Parse(time.RFC3339, c.CreatedAt)
+			conversations = append(conversations, importConversation{title: c.Name, created: created, rows: rows, errors: rowErrors})
+		}
+	default:
+		decoded, err := decodeChatGPTExport(raw)
+		if err != nil {
+			return format, nil, err
+		}
+		for _, c := range decoded {
+			rows, rowErrors := c.rows(0)
+			sec, frac := math.Modf(c.CreateTime)
+			conversations = append(conversations, importConversation{title: c.Title, created: time.Unix(int64(sec), int64(frac*1e9)), rows: rows, errors: rowErrors})
+		}
+	}
+	sort.SliceStable(conversations, func(i, j int) bool { return conversations[i].created.Before(conversations[j].created) })
+	return format, conversations, nil
+}
+
+// parseExportImport reads a ChatGPT or Claude export into one stream of messages, oldest
+// conversation first, numbering rows across the whole export
+func parseExportImport(format string, body io.Reader) (string, []importRow, []ImportError, error) {
+	format, conversations, err := exportConversations(format, body)
+	if err != nil {
+		return format, nil, nil, err
+	}
+	var rows []importRow
+	var rowErrors []ImportError
+	for _, c := range conversations {
+		offset := len(rows) + len(rowErrors)
+		for _, row := range c.rows {
+			row.row += offset
+			rows = append(rows, row)
+		}
+		for _, rowError := range c.errors {
+			rowError.Row += offset
+			rowErrors = append(rowErrors, rowError)
+		}
+	}
+	return format, rows, rowErrors, nil
+}
+
+// importFormat picks the parser from ?format= or the request's content type
+func importFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case strings.Contains(contentType, "csv"):
+		return "csv"
+	case strings.Contains(contentType, "ndjson"), strings.Contains(contentType, "jsonl"):
+		return "jsonl"
+	case strings.Contains(contentType, "json"), strings.Contains(contentType, "zip"):
+		return "export"
+	}
+	return "jsonl"
+}
+
+// importCopier is a pool or transaction that can bulk-insert with COPY
+type importCopier interface {
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+// copyImportRows bulk-inserts messages into a room with COPY. Rows without a timestamp
+// are spaced a microsecond apart so they keep their order in the history.
+func copyImportRows(db importCopier, roomID int, rows []importRow) (int64, error) {
+	base := time.Now()
+	values := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		timestamp := row.timestamp
+		if timestamp.IsZero() {
+			timestamp = base.Add(time.Duration(i) * time.Microsecond)
+		}
+		values[i] = []interface{}{roomID, row.sender, row.user, row.message, timestamp}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	return db.CopyFrom(ctx, pgx.Identifier{"chat_history"},
+		[]string{"room_id", "sender", "user_id", "message", "timestamp"}, pgx.CopyFromRows(values))
+}
+
+// Handler for /api/rooms/{id}/import: backfill a room's history from a JSONL, CSV, ChatGPT
+// or Claude export file. Valid rows are imported and the rest reported by row number.
+func importRoomHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, importMaxBytes)
+	result := ImportResult{Format: importFormat(r), Errors: []ImportError{}}
+	var rows []importRow
+	var rowErrors []ImportError
+	var err error
+	switch result.Format {
+	case "jsonl":
+		rows, rowErrors, err = parseJSONLImport(body)
+	case "csv":
+		rows, rowErrors, err = parseCSVImport(body)
+	case "chatgpt", "claude", "export":
+		result.Format, rows, rowErrors, err = parseExportImport(result.Format, body)
+	default:
+		http.Error(w, `format must be "jsonl", "csv", "chatgpt", "claude" or "export"`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read import: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	result.Errors = append(result.Errors, rowErrors...)
+
+	if len(rows) > 0 {
+		imported, err := copyImportRows(db, room.ID, rows)
+		if err != nil {
+			http.Error(w, "Failed to import messages", http.StatusInternalServerError)
+			log.Println("Error importing messages:", err)
+			return
+		}
+		result.Imported = int(imported)
+	}
+	log.Printf("📥 Imported %d messages into room %d (%d rows rejected)", result.Imported, room.ID, len(result.Errors))
+	recordAudit("moderator", clientIP(r), "room.import", strconv.Itoa(room.ID), map[string]interface{}{"format": result.Format, "imported": result.Imported, "rejected": len(result.Errors)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ImportedRoom reports the room one imported conversation became
+type ImportedRoom struct {
+	RoomID   int           `json:"room_id"`
+	Name     string        `json:"name"`
+	Imported int           `json:"imported"`
+	Errors   []ImportError `json:"errors"`
+}
+
+// ArchiveImportResult summarises the import of a whole export
+type ArchiveImportResult struct {
+	Format string         `json:"format"`
+	Rooms  []ImportedRoom `json:"rooms"`
+}
+
+// importConversationRoom creates a room for a conversation and fills it, in one transaction
+func importConversationRoom(c importConversation) (ImportedRoom, error) {
+	imported := ImportedRoom{Name: strings.TrimSpace(c.title), Errors: c.errors}
+	if imported.Name == "" {
+		imported.Name = "Imported conversation"
+	}
+	if imported.Errors == nil {
+		imported.Errors = []ImportError{}
+	}
+
+	ctx := context.Background()
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx, "INSERT INTO rooms (name) VALUES ($1) RETURNING id", imported.Name).Scan(&imported.RoomID); err != nil {
+			return err
+		}
+		n, err := copyImportRows(tx, imported.RoomID, c.rows)
+		imported.Imported = int(n)
+		return err
+	})
+	return imported, err
+}
+
+// Handler for /api/import: migrate a ChatGPT or Claude export (conversations.json or the
+// .zip archive) into new rooms, one per conversation. ?format=chatgpt|claude skips detection.
+func importConversationArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "export"
+	}
+	if format != "chatgpt" && format != "claude" && format != "export" {
+		http.Error(w, `format must be "chatgpt" or "claude"`, http.StatusBadRequest)
+		return
+	}
+
+	format, conversations, err := exportConversations(format, http.MaxBytesReader(w, r.Body, importMaxBytes))
+	if err != nil {
+		http.Error(w, "Failed to read import: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(conversations) > importMaxConversations {
+		http.Error(w, fmt.Sprintf("The export has %d conversations; at most %d can be imported at once", len(conversations), importMaxConversations), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	result := ArchiveImportResult{Format: format, Rooms: []ImportedRoom{}}
+	messages := 0
+	for _, c := range conversations {
+		if len(c.rows) == 0 {
+			continue // Nothing visible was said, usually an abandoned chat
+		}
+		room, err := importConversationRoom(c)
+		if err != nil {
+			http.Error(w, "Failed to import conversations", http.StatusInternalServerError)
+			log.Println("Error importing conversation:", err)
+			return
+		}
+		result.Rooms = append(result.Rooms, room)
+		messages += room.Imported
+	}
+	log.Printf("📥 Imported %d messages into %d new rooms from a %s export", messages, len(result.Rooms), format)
+	recordAudit("moderator", clientIP(r), "room.import_archive", "", map[string]interface{}{"format": format, "rooms": len(result.Rooms), "imported": messages})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+)
+
+// Incognito chats: messages are answered and streamed as usual but never written to
+// Postgres. A room can be created incognito, or a single connection can ask for it with
+// ?incognito=1. Either way the session is flagged so every persistence path skips it:
+// chat history, failed generations, tool calls, memories, drafts and abuse excerpts.
+// Usage rows (tokens and cost, no content) are still recorded so budgets hold.
+
+// IncognitoEvent tells a client its messages in this session won't be stored
+type IncognitoEvent struct {
+	Room bool `json:"room"` // Whether the room itself is incognito, rather than just this connection
+}
+
+// incognitoRoom reports whether a room was created incognito
+func incognitoRoom(roomID int) bool {
+	room, err := getRoom(roomID)
+	if err != nil {
+		// Storing a message is safer to refuse than to leak into an incognito room
+		log.Println("Error fetching room to check incognito mode:", err)
+		return err != errRoomNotFound
+	}
+	return room.Metadata.Incognito
+}
+
+// sendIncognito flags the session as incognito to its client
+func sendIncognito(s *Session) {
+	if !s.incognito {
+		return
+	}
+	if err := s.sendEvent("incognito", IncognitoEvent{Room: incognitoRoom(s.room)}); err != nil {
+		log.Println("Error sending incognito event:", err)
+	}
+}
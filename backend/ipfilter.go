@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// IP rules: static ones come from the environment, the rest are managed through the admin API
+var (
+	ipRulesMu sync.RWMutex
+	ipAllow   []IPRule
+	ipDeny    []IPRule
+)
+
+// IPRule admits or refuses a range of client addresses
+type IPRule struct {
+	ID        int          `json:"id,omitempty"` // Zero for rules from the environment
+	CIDR      string       `json:"cidr"`
+	List      string       `json:"list"` // allow or deny
+	Note      string       `json:"note,omitempty"`
+	CreatedAt *time.Time   `json:"created_at,omitempty"`
+	prefix    netip.Prefix // Parsed CIDR
+}
+
+// initIPFilter creates the rules table and loads the static and managed rules
+func initIPFilter() {
+	createIPRulesTable()
+	if err := loadIPRules(); err != nil {
+		log.Fatal("❌ Failed to load IP rules:", err)
+	}
+	if len(ipAllow) > 0 || len(ipDeny) > 0 {
+		log.Printf("🚧 IP filtering enabled (%d allowed ranges, %d denied)", len(ipAllow), len(ipDeny))
+	}
+}
+
+// Create `ip_rules` table if it doesn't exist
+func createIPRulesTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS ip_rules (
+			id SERIAL PRIMARY KEY,
+			cidr TEXT NOT NULL,
+			list TEXT NOT NULL,
+			note TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			UNIQUE (cidr, list)
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create ip_rules table:", err)
+	}
+	log.Println("✅ Table ip_rules is ready")
+}
+
+// parsePrefix reads a CIDR range or a single address
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// loadIPRules rebuilds the rule lists from the environment and the database
+func loadIPRules() error {
+	var allow, deny []IPRule
+	for list, env := range map[string]string{"allow": "IP_ALLOWLIST", "deny": "IP_DENYLIST"} {
+		for _, value := range splitList(getEnv(env, "")) {
+			prefix, err := parsePrefix(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s entry %q: %v", env, value, err)
+			}
+			rule := IPRule{CIDR: prefix.String(), List: list, Note: "from " + env, prefix: prefix}
+			if list == "allow" {
+				allow = append(allow, rule)
+			} else {
+				deny = append(deny, rule)
+			}
+		}
+	}
+
+	rows, err := db.Query(context.Background(), "SELECT id, cidr, list, note, created_at FROM ip_rules ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rule IPRule
+		if err := rows.Scan(&rule.ID, &rule.CIDR, &rule.List, &rule.Note, &rule.CreatedAt); err != nil {
+			return err
+		}
+		if rule.prefix, err = parsePrefix(rule.CIDR); err != nil {
+			log.Printf("⚠️ Skipping invalid IP rule %d (%s): %v", rule.ID, rule.CIDR, err)
+			continue
+		}
+		if rule.List == "allow" {
+			allow = append(allow, rule)
+		} else {
+			deny = append(deny, rule)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	ipRulesMu.Lock()
+	defer ipRulesMu.Unlock()
+	ipAllow, ipDeny = allow, deny
+	return nil
+}
+
+// inPrefixes reports whether an address falls in any of the ranges
+func inPrefixes(addr netip.Addr, prefixes []netip.Prefix) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesRule reports whether an address falls in any rule's range
+func matchesRule(addr netip.Addr, rules []IPRule) bool {
+	for _, rule := range rules {
+		if rule.prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAllowed applies the deny list, then the allow list when it isn't empty
+func ipAllowed(ip string) bool {
+	ipRulesMu.RLock()
+	defer ipRulesMu.RUnlock()
+	if len(ipAllow) == 0 && len(ipDeny) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	if matchesRule(addr, ipDeny) {
+		return false
+	}
+	return len(ipAllow) == 0 || matchesRule(addr, ipAllow)
+}
+
+// filterIPs refuses requests from addresses the rules don't admit, on every endpoint
+func filterIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ipAllowed(clientIP(r)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handler for /api/admin/ip-rules: list with GET, add with POST ({"cidr", "list", "note"})
+func handleIPRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		addIPRule(w, r)
+		return
+	}
+
+	ipRulesMu.RLock()
+	rules := append(append([]IPRule{}, ipAllow...), ipDeny...)
+	ipRulesMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// Handler to add a managed IP rule
+func addIPRule(w http.ResponseWriter, r *http.Request) {
+	var req IPRule
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.List != "allow" && req.List != "deny" {
+		http.Error(w, `list must be "allow" or "deny"`, http.StatusBadRequest)
+		return
+	}
+	prefix, err := parsePrefix(strings.TrimSpace(req.CIDR))
+	if err != nil {
+		http.Error(w, "cidr must be an address or a CIDR range", http.StatusBadRequest)
+		return
+	}
+	req.CIDR = prefix.String()
+
+	err = db.QueryRow(context.Background(), `
+		INSERT INTO ip_rules (cidr, list, note) VALUES ($1, $2, $3)
+		ON CONFLICT (cidr, list) DO UPDATE SET note = EXCLUDED.note
+		RETURNING id, created_at`, req.CIDR, req.List, req.Note).Scan(&req.ID, &req.CreatedAt)
+	if err == nil {
+		err = loadIPRules()
+	}
+	if err != nil {
+		http.Error(w, "Failed to save IP rule", http.StatusInternalServerError)
+		log.Println("Error saving IP rule:", err)
+		return
+	}
+	recordAudit("admin", clientIP(r), "ip_rule.add", req.CIDR, map[string]interface{}{"list": req.List, "note": req.Note})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(req)
+}
+
+// Handler to remove a managed IP rule
+func deleteIPRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var cidr, list string
+	err = db.QueryRow(context.Background(), "DELETE FROM ip_rules WHERE id = $1 RETURNING cidr, list", id).Scan(&cidr, &list)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "IP rule not found", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = loadIPRules()
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete IP rule", http.StatusInternalServerError)
+		log.Println("Error deleting IP rule:", err)
+		return
+	}
+	recordAudit("admin", clientIP(r), "ip_rule.delete", cidr, map[string]interface{}{"list": list})
+	w.WriteHeader(http.StatusNoContent)
+}
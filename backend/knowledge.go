@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KnowledgeBase groups documents so rooms can retrieve from them selectively
+type KnowledgeBase struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Documents   int       `json:"documents"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Create knowledge base tables if they don't exist. Documents uploaded without a
+// knowledge base form the shared pool used by rooms that have none bound.
+func createKnowledgeBaseTables() {
+	query := `
+		CREATE TABLE IF NOT EXISTS knowledge_bases (
+			id SERIAL PRIMARY KEY,
+			name TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		);
+		CREATE TABLE IF NOT EXISTS room_knowledge_bases (
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			knowledge_base_id INTEGER NOT NULL REFERENCES knowledge_bases(id) ON DELETE CASCADE,
+			PRIMARY KEY (room_id, knowledge_base_id)
+		);
+		ALTER TABLE documents ADD COLUMN IF NOT EXISTS knowledge_base_id INTEGER REFERENCES knowledge_bases(id) ON DELETE CASCADE;
+		ALTER TABLE documents DROP CONSTRAINT IF EXISTS documents_content_hash_key;
+		CREATE UNIQUE INDEX IF NOT EXISTS documents_knowledge_base_hash_idx ON documents (COALESCE(knowledge_base_id, 0), content_hash);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create knowledge base tables:", err)
+	}
+	log.Println("✅ Tables knowledge_bases and room_knowledge_bases are ready")
+}
+
+// knowledgeBaseExists reports whether a knowledge base id is valid
+func knowledgeBaseExists(id int) bool {
+	var exists bool
+	db.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM knowledge_bases WHERE id = $1)", id).Scan(&exists)
+	return exists
+}
+
+// refreshRoomKnowledgeFlag records the room's bound knowledge bases in its metadata
+func refreshRoomKnowledgeFlag(roomID int) error {
+	_, err := db.Exec(context.Background(), `
+		UPDATE rooms SET metadata = metadata || jsonb_build_object(
+			'knowledge_base', EXISTS (SELECT 1 FROM room_knowledge_bases WHERE room_id = $1),
+			'knowledge_base_ids', (SELECT COALESCE(jsonb_agg(knowledge_base_id ORDER BY knowledge_base_id), '[]'::jsonb)
+				FROM room_knowledge_bases WHERE room_id = $1))
+		WHERE id = $1`, roomID)
+	return err
+}
+
+// Handler for /api/knowledge-bases: create with POST, list with GET
+func handleKnowledgeBases(w http.ResponseWriter, r *http.Request) {
+	if !ragEnabled {
+		http.Error(w, "Knowledge base is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+			http.Error(w, "A knowledge base name is required", http.StatusBadRequest)
+			return
+		}
+
+		kb := KnowledgeBase{Name: strings.TrimSpace(req.Name), Description: req.Description}
+		err := db.QueryRow(context.Background(),
+			"INSERT INTO knowledge_bases (name, description) VALUES ($1, $2) RETURNING id, created_at",
+			kb.Name, kb.Description).Scan(&kb.ID, &kb.CreatedAt)
+		if err != nil {
+			http.Error(w, "Failed to create knowledge base", http.StatusInternalServerError)
+			log.Println("Error creating knowledge base:", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(kb)
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT k.id, k.name, k.description, COUNT(d.id), k.created_at
+		FROM knowledge_bases k LEFT JOIN documents d ON d.knowledge_base_id = k.id
+		GROUP BY k.id ORDER BY k.id`)
+	if err != nil {
+		http.Error(w, "Failed to fetch knowledge bases", http.StatusInternalServerError)
+		log.Println("Error fetching knowledge bases:", err)
+		return
+	}
+	defer rows.Close()
+
+	kbs := []KnowledgeBase{}
+	for rows.Next() {
+		var kb KnowledgeBase
+		if err := rows.Scan(&kb.ID, &kb.Name, &kb.Description, &kb.Documents, &kb.CreatedAt); err != nil {
+			http.Error(w, "Error processing knowledge bases", http.StatusInternalServerError)
+			log.Println("Error scanning knowledge bases:", err)
+			return
+		}
+		kbs = append(kbs, kb)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(kbs)
+}
+
+// Handler to bind a knowledge base to a room ({"knowledge_base_id": n}); a room with an
+// owner needs its moderator role
+func attachRoomKnowledgeBase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ragEnabled {
+		http.Error(w, "Knowledge base is disabled", http.StatusServiceUnavailable)
+		return
+	}
+	room, ok := pathRoom(w, r)
+	if !ok || !requireRoomRole(w, r, room, roleModerator, true) {
+		return
+	}
+
+	var req struct {
+		KnowledgeBaseID int `json:"knowledge_base_id"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&req); err != nil || !knowledgeBaseExists(req.KnowledgeBaseID) {
+		http.Error(w, "A valid knowledge_base_id is required", http.StatusBadRequest)
+		return
+	}
+
+	_, err := db.Exec(context.Background(),
+		"INSERT INTO room_knowledge_bases (room_id, knowledge_base_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		room.ID, req.KnowledgeBaseID)
+	if err == nil {
+		err = refreshRoomKnowledgeFlag(room.ID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to attach knowledge base", http.StatusInternalServerError)
+		log.Println("Error attaching knowledge base:", err)
+		return
+	}
+
+	room, _ = getRoom(room.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
+
+// Handler to unbind a knowledge base from a room; a room with an owner needs its moderator role
+func detachRoomKnowledgeBase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ragEnabled {
+		http.Error(w, "Knowledge base is disabled", http.StatusServiceUnavailable)
+		return
+	}
+	room, ok := pathRoom(w, r)
+	if !ok || !requireRoomRole(w, r, room, roleModerator, true) {
+		return
+	}
+	kbID, err := strconv.Atoi(r.PathValue("kb"))
+	if err != nil {
+		http.Error(w, "Invalid knowledge base id", http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.Exec(context.Background(),
+		"DELETE FROM room_knowledge_bases WHERE room_id = $1 AND knowledge_base_id = $2", room.ID, kbID)
+	if err == nil {
+		err = refreshRoomKnowledgeFlag(room.ID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to detach knowledge base", http.StatusInternalServerError)
+		log.Println("Error detaching knowledge base:", err)
+		return
+	}
+
+	room, _ = getRoom(room.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
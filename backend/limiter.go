@@ -0,0 +1,127 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// ollamaLimiter bounds concurrent Ollama generations; nil when unlimited
+var ollamaLimiter *generationLimiter
+
+var queuePositionInterval time.Duration // How often queued clients hear their position
+
+// recentDurationsKept is how many generation durations feed the ETA estimate
+const recentDurationsKept = 20
+
+// QueuePositionEvent tells a client its generation is waiting for a free slot.
+// Position 0 means the wait is over.
+type QueuePositionEvent struct {
+	Position   int `json:"position"`
+	ETASeconds int `json:"eta_seconds"`
+}
+
+// generationLimiter is a FIFO semaphore that remembers how long generations take
+type generationLimiter struct {
+	mu      sync.Mutex
+	slots   int
+	active  int
+	waiting []chan struct{}
+	recent  []time.Duration
+}
+
+// initLimiter reads the Ollama concurrency limit
+func initLimiter() {
+	queuePositionInterval = getEnvDuration("QUEUE_POSITION_INTERVAL", 3*time.Second)
+	if slots := getEnvInt("OLLAMA_MAX_CONCURRENCY", 0); slots > 0 {
+		ollamaLimiter = &generationLimiter{slots: slots}
+		log.Printf("🚦 At most %d concurrent Ollama generations", slots)
+	}
+}
+
+// acquire waits for a slot, keeping the client informed of its place in line,
+// and returns the function that frees the slot
+func (l *generationLimiter) acquire(s *Session) func() {
+	if l == nil {
+		return func() {}
+	}
+
+	l.mu.Lock()
+	if l.active < l.slots && len(l.waiting) == 0 {
+		l.active++
+		l.mu.Unlock()
+		return l.releaser()
+	}
+	ticket := make(chan struct{})
+	l.waiting = append(l.waiting, ticket)
+	l.mu.Unlock()
+
+	ticker := clock.NewTicker(queuePositionInterval)
+	defer ticker.Stop()
+	l.sendPosition(s, ticket)
+	for {
+		select {
+		case <-ticket:
+			if err := s.sendEvent("queue_position", QueuePositionEvent{}); err != nil {
+				log.Println("Error sending queue_position event:", err)
+			}
+			return l.releaser()
+		case <-ticker.C():
+			l.sendPosition(s, ticket)
+		}
+	}
+}
+
+// releaser frees a slot once, handing it to the next in line, and records how long it was held
+func (l *generationLimiter) releaser() func() {
+	started := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.recent = append(l.recent, time.Since(started))
+			if len(l.recent) > recentDurationsKept {
+				l.recent = l.recent[1:]
+			}
+			if len(l.waiting) > 0 {
+				next := l.waiting[0]
+				l.waiting = l.waiting[1:]
+				close(next)
+				return
+			}
+			l.active--
+		})
+	}
+}
+
+// sendPosition tells a queued client where it stands
+func (l *generationLimiter) sendPosition(s *Session, ticket chan struct{}) {
+	l.mu.Lock()
+	position := 0
+	for i, t := range l.waiting {
+		if t == ticket {
+			position = i + 1
+			break
+		}
+	}
+	var average time.Duration
+	for _, d := range l.recent {
+		average += d
+	}
+	if len(l.recent) > 0 {
+		average /= time.Duration(len(l.recent))
+	}
+	slots := l.slots
+	l.mu.Unlock()
+	if position == 0 {
+		return
+	}
+
+	// Slots free up in rounds; this request starts after ceil(position / slots) of them
+	rounds := (position + slots - 1) / slots
+	event := QueuePositionEvent{Position: position, ETASeconds: int((average * time.Duration(rounds)).Seconds())}
+	if err := s.sendEvent("queue_position", event); err != nil {
+		log.Println("Error sending queue_position event:", err)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// loadTestPatterns are the built-in prompt scripts; {n} is replaced by the client number
+var loadTestPatterns = map[string][]string{
+	"short": {
+		"Hi! What can you do?",
+		"What is the capital of France?",
+		"Give me a synonym for happy.",
+		"What's 17 times 23?",
+		"Name three primary colors.",
+	},
+	"long": {
+		"Explain how a hash map works, including collisions, resizing and the trade-offs of open addressing versus chaining. Use an example.",
+		"Write a detailed plan for migrating a monolithic web application to services, covering data ownership, deployment and rollback.",
+		"Summarize the causes and consequences of the industrial revolution in about five paragraphs.",
+	},
+	"conversation": {
+		"I'm planning a trip for client {n}. Suggest a destination for a long weekend.",
+		"What should I pack for that?",
+		"Give me a rough day-by-day itinerary.",
+		"Thanks! Any tips for saving money there?",
+	},
+}
+
+// loadTestOptions configure a load test run
+type loadTestOptions struct {
+	url      string
+	clients  int
+	duration time.Duration
+	messages int // Per client; 0 sends until duration passes
+	ramp     time.Duration
+	think    time.Duration
+	timeout  time.Duration
+	prompts  []string
+	json     bool
+}
+
+// loadTestResult is the outcome of one prompt
+type loadTestResult struct {
+	ack        time.Duration // Until the server stored the message
+	firstToken time.Duration // Until the first token arrived; 0 if none did
+	total      time.Duration
+	err        string // Error code; "" for success
+}
+
+// LoadTestReport summarizes a run
+type LoadTestReport struct {
+	Clients       int                `json:"clients"`
+	Duration      float64            `json:"duration_seconds"`
+	Requests      int                `json:"requests"`
+	Succeeded     int                `json:"succeeded"`
+	Failed        int                `json:"failed"`
+	ErrorRate     float64            `json:"error_rate"`
+	Throughput    float64            `json:"requests_per_second"`
+	Errors        map[string]int     `json:"errors,omitempty"` // By error code
+	AckMs         map[string]float64 `json:"ack_ms"`
+	FirstTokenMs  map[string]float64 `json:"first_token_ms"`
+	TotalMs       map[string]float64 `json:"total_ms"`
+	ConnectErrors int                `json:"connect_errors"`
+}
+
+// runLoadTest is the "server loadtest" command: it opens simulated WebSocket clients
+// that send scripted prompts to a server and reports latency percentiles and error rates
+func runLoadTest(args []string) {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	opts := loadTestOptions{}
+	fs.StringVar(&opts.url, "url", "ws://localhost:8080/api/ws", "WebSocket URL of the server to test")
+	room := fs.Int("room", 0, "Room to chat in (the server's default room if 0)")
+	fs.IntVar(&opts.clients, "clients", 10, "Number of simulated clients")
+	fs.DurationVar(&opts.duration, "duration", time.Minute, "How long to keep sending")
+	fs.IntVar(&opts.messages, "messages", 0, "Prompts each client sends before stopping (0 sends until -duration passes)")
+	fs.DurationVar(&opts.ramp, "ramp", 10*time.Second, "Time over which the clients connect")
+	fs.DurationVar(&opts.think, "think", 2*time.Second, "Average pause between a client's answer and its next prompt")
+	fs.DurationVar(&opts.timeout, "timeout", 2*time.Minute, "How long to wait for one answer")
+	pattern := fs.String("pattern", "short", "Built-in prompt script: short, long or conversation")
+	promptsFile := fs.String("prompts", "", "File of prompts, one per line, used instead of -pattern")
+	fs.BoolVar(&opts.json, "json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	if *promptsFile != "" {
+		prompts, err := readLoadTestPrompts(*promptsFile)
+		if err != nil {
+			log.Fatal("❌ Failed to read prompts:", err)
+		}
+		opts.prompts = prompts
+	} else if opts.prompts = loadTestPatterns[*pattern]; opts.prompts == nil {
+		log.Fatalf("Unknown pattern %q (want short, long or conversation)", *pattern)
+	}
+	if opts.clients < 1 {
+		log.Fatal("-clients must be at least 1")
+	}
+	if *room > 0 {
+		target, err := url.Parse(opts.url)
+		if err != nil {
+			log.Fatal("❌ Invalid -url:", err)
+		}
+		query := target.Query()
+		query.Set("room", strconv.Itoa(*room))
+		target.RawQuery = query.Encode()
+		opts.url = target.String()
+	}
+
+	log.Printf("🚀 Load testing %s with %d clients for %v", opts.url, opts.clients, opts.duration)
+	report := loadTest(opts)
+	if opts.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return
+	}
+	printLoadTestReport(report)
+}
+
+// readLoadTestPrompts reads non-empty lines from a file
+func readLoadTestPrompts(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var prompts []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			prompts = append(prompts, line)
+		}
+	}
+	if len(prompts) == 0 && scanner.Err() == nil {
+		return nil, fmt.Errorf("%s has no prompts", path)
+	}
+	return prompts, scanner.Err()
+}
+
+// loadTest runs the clients and gathers their results
+func loadTest(opts loadTestOptions) LoadTestReport {
+	var (
+		mu             sync.Mutex
+		results        []loadTestResult
+		connectErrors  int
+		wg             sync.WaitGroup
+		started        = time.Now()
+		deadline       = started.Add(opts.duration)
+		rampStep       = opts.ramp / time.Duration(opts.clients)
+		recordResult   = func(r loadTestResult) { mu.Lock(); results = append(results, r); mu.Unlock() }
+		recordConnFail = func() { mu.Lock(); connectErrors++; mu.Unlock() }
+	)
+
+	for i := 0; i < opts.clients; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			time.Sleep(time.Duration(n) * rampStep)
+			runLoadTestClient(opts, n, deadline, recordResult, recordConnFail)
+		}(i)
+	}
+	wg.Wait()
+	return summarizeLoadTest(opts.clients, time.Since(started), results, connectErrors)
+}
+
+// runLoadTestClient is one simulated user: connect, then prompt, wait for the answer and pause, until done
+func runLoadTestClient(opts loadTestOptions, n int, deadline time.Time, record func(loadTestResult), connectFailed func()) {
+	target, err := url.Parse(opts.url)
+	if err != nil {
+		connectFailed()
+		return
+	}
+	query := target.Query()
+	query.Set("user", fmt.Sprintf("loadtest-%d", n))
+	target.RawQuery = query.Encode()
+
+	conn, _, err := websocket.DefaultDialer.Dial(target.String(), http.Header{})
+	if err != nil {
+		log.Printf("Client %d failed to connect: %v", n, err)
+		connectFailed()
+		return
+	}
+	defer conn.Close()
+
+	for sent := 0; time.Now().Before(deadline) && (opts.messages == 0 || sent < opts.messages); sent++ {
+		prompt := strings.ReplaceAll(opts.prompts[sent%len(opts.prompts)], "{n}", strconv.Itoa(n))
+		result := loadTestPrompt(conn, prompt, fmt.Sprintf("lt-%d-%d-%d", n, sent, time.Now().UnixNano()), opts.timeout)
+		record(result)
+		if result.err == "connection_lost" {
+			return
+		}
+		if opts.think > 0 {
+			// Jitter the pause so clients don't fall into lockstep
+			time.Sleep(time.Duration(float64(opts.think) * (0.5 + rand.Float64())))
+		}
+	}
+}
+
+// loadTestPrompt sends one prompt and times its acknowledgement, first token and completion
+func loadTestPrompt(conn *websocket.Conn, prompt, clientID string, timeout time.Duration) loadTestResult {
+	var result loadTestResult
+	start := time.Now()
+	frame, _ := json.Marshal(ClientFrame{Type: "message", ClientID: clientID, Text: prompt})
+	if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+		result.err = "connection_lost"
+		return result
+	}
+
+	conn.SetReadDeadline(start.Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if netErr, ok := err.(interface{ Timeout() bool }); ok && netErr.Timeout() {
+				result.err = "client_timeout"
+			} else {
+				result.err = "connection_lost"
+			}
+			result.total = time.Since(start)
+			return result
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+
+		event, ok := parseLoadTestEvent(data)
+		switch {
+		case !ok:
+			// A plain text frame is a token of the answer
+			if result.firstToken == 0 {
+				result.firstToken = time.Since(start)
+			}
+		case event.Type == "message_ack":
+			var ack MessageAckEvent
+			if json.Unmarshal(event.Data, &ack) == nil && ack.ClientID == clientID {
+				result.ack = time.Since(start)
+			}
+		case event.Type == "ai_done":
+			result.total = time.Since(start)
+			return result
+		case event.Type == "error":
+			var e ErrorEvent
+			json.Unmarshal(event.Data, &e)
+			result.err = e.Code
+			if result.err == "" {
+				result.err = "error"
+			}
+			result.total = time.Since(start)
+			return result
+		}
+	}
+}
+
+// loadTestEvent is a server event with its data left encoded
+type loadTestEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// parseLoadTestEvent decodes a structured server event; ok is false for token frames
+func parseLoadTestEvent(data []byte) (loadTestEvent, bool) {
+	var event loadTestEvent
+	if !strings.HasPrefix(string(data), `{"type":`) || json.Unmarshal(data, &event) != nil || event.Type == "" {
+		return event, false
+	}
+	return event, true
+}
+
+// summarizeLoadTest works out the report's rates and percentiles
+func summarizeLoadTest(clients int, elapsed time.Duration, results []loadTestResult, connectErrors int) LoadTestReport {
+	report := LoadTestReport{
+		Clients:       clients,
+		Duration:      elapsed.Seconds(),
+		Requests:      len(results),
+		Errors:        map[string]int{},
+		ConnectErrors: connectErrors,
+	}
+	var acks, firstTokens, totals []time.Duration
+	for _, r := range results {
+		if r.err != "" {
+			report.Failed++
+			report.Errors[r.err]++
+			continue
+		}
+		report.Succeeded++
+		totals = append(totals, r.total)
+		if r.ack > 0 {
+			acks = append(acks, r.ack)
+		}
+		if r.firstToken > 0 {
+			firstTokens = append(firstTokens, r.firstToken)
+		}
+	}
+	if report.Requests > 0 {
+		report.ErrorRate = float64(report.Failed) / float64(report.Requests)
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(report.Succeeded) / elapsed.Seconds()
+	}
+	report.AckMs = latencyPercentiles(acks)
+	report.FirstTokenMs = latencyPercentiles(firstTokens)
+	report.TotalMs = latencyPercentiles(totals)
+	return report
+}
+
+// latencyPercentiles returns p50, p90, p95, p99 and max in milliseconds (nearest-rank)
+func latencyPercentiles(durations []time.Duration) map[string]float64 {
+	percentiles := map[string]float64{}
+	if len(durations) == 0 {
+		return percentiles
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	for _, p := range []struct {
+		name string
+		rank float64
+	}{{"p50", 0.50}, {"p90", 0.90}, {"p95", 0.95}, {"p99", 0.99}, {"max", 1}} {
+		index := int(math.Ceil(p.rank*float64(len(durations)))) - 1
+		percentiles[p.name] = float64(durations[max(index, 0)].Microseconds()) / 1000
+	}
+	return percentiles
+}
+
+// printLoadTestReport writes the report as a readable table
+func printLoadTestReport(r LoadTestReport) {
+	fmt.Printf("Clients:      %d (%d failed to connect)\n", r.Clients, r.ConnectErrors)
+	fmt.Printf("Duration:     %.1fs\n", r.Duration)
+	fmt.Printf("Requests:     %d (%d succeeded, %d failed, %.1f%% errors)\n", r.Requests, r.Succeeded, r.Failed, r.ErrorRate*100)
+	fmt.Printf("Throughput:   %.2f answers/s\n", r.Throughput)
+	if len(r.Errors) > 0 {
+		codes := make([]string, 0, len(r.Errors))
+		for code := range r.Errors {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			fmt.Printf("  %-22s %d\n", code, r.Errors[code])
+		}
+	}
+	fmt.Printf("\n%-14s %10s %10s %10s %10s %10s\n", "Latency (ms)", "p50", "p90", "p95", "p99", "max")
+	for _, row := range []struct {
+		name   string
+		values map[string]float64
+	}{{"ack", r.AckMs}, {"first token", r.FirstTokenMs}, {"total", r.TotalMs}} {
+		fmt.Printf("%-14s", row.name)
+		for _, p := range []string{"p50", "p90", "p95", "p99", "max"} {
+			if value, ok := row.values[p]; ok {
+				fmt.Printf(" %10.1f", value)
+			} else {
+				fmt.Printf(" %10s", "-")
+			}
+		}
+		fmt.Println()
+	}
+}
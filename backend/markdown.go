@@ -0,0 +1,210 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+var markdownSanitize bool // Whether AI output is sanitized and annotated before storage
+
+// ContentInfo describes what kinds of content a message contains so clients can
+// pick the right renderers (syntax highlighting, tables, math)
+type ContentInfo struct {
+	CodeBlocks []CodeBlockInfo `json:"code_blocks,omitempty"`
+	HasTable   bool            `json:"has_table,omitempty"`
+	HasMath    bool            `json:"has_math,omitempty"`
+	HasLinks   bool            `json:"has_links,omitempty"`
+}
+
+// CodeBlockInfo describes a fenced code block
+type CodeBlockInfo struct {
+	Language string `json:"language"`
+	Lines    int    `json:"lines"`
+}
+
+// Elements removed together with everything inside them
+var dangerousElements = []string{"script", "style", "iframe", "object", "embed", "frame", "frameset", "applet", "noscript", "template", "svg", "math"}
+
+// Inline tags kept (without attributes) because markdown renderers handle them safely
+var allowedTags = map[string]bool{
+	"b": true, "i": true, "em": true, "strong": true, "code": true, "br": true,
+	"sub": true, "sup": true, "kbd": true, "del": true, "s": true, "u": true,
+}
+
+// Common aliases normalized on code fences
+var languageAliases = map[string]string{
+	"js":     "javascript",
+	"ts":     "typescript",
+	"py":     "python",
+	"sh":     "bash",
+	"shell":  "bash",
+	"zsh":    "bash",
+	"golang": "go",
+	"yml":    "yaml",
+	"rb":     "ruby",
+	"rs":     "rust",
+	"c++":    "cpp",
+	"cs":     "csharp",
+	"md":     "markdown",
+}
+
+var (
+	dangerousElementPatterns []*regexp.Regexp
+	htmlCommentPattern       = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlTagPattern           = regexp.MustCompile(`(?i)<(/?)([a-z][a-z0-9-]*)\b[^>]*?(/?)>`)
+	autolinkPattern          = regexp.MustCompile(`(?i)^<(https?|mailto):[^\s<>]*>$`)
+	inlineCodePattern        = regexp.MustCompile("`[^`\n]+`")
+	unsafeLinkPattern        = regexp.MustCompile(`(?i)\]\(\s*<?\s*(javascript|vbscript|data|file):[^()]*(?:\([^()]*\)[^()]*)*\)`)
+	fencePattern             = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})\\s*([^\\s`]*)")
+	tableSeparatorPattern    = regexp.MustCompile(`^\s*\|?\s*:?-{3,}:?\s*(\|\s*:?-{3,}:?\s*)+\|?\s*$`)
+	mathPattern              = regexp.MustCompile(`(?s)\$\$.+?\$\$|\\\[.+?\\\]|\\\(.+?\\\)`)
+	markdownLinkPattern      = regexp.MustCompile(`\[[^\]]+\]\([^)]+\)|https?://\S+`)
+)
+
+// initMarkdown reads the markdown processing settings and compiles patterns
+func initMarkdown() {
+	markdownSanitize = getEnvBool("MARKDOWN_SANITIZE", true)
+
+	for _, tag := range dangerousElements {
+		dangerousElementPatterns = append(dangerousElementPatterns,
+			regexp.MustCompile(`(?is)<`+tag+`\b[^>]*>.*?</\s*`+tag+`\s*>`))
+	}
+
+	if !markdownSanitize {
+		log.Printf("⚠️ Markdown sanitization disabled - AI output is stored as generated")
+	}
+}
+
+// sanitizeMarkdown strips dangerous HTML from prose and normalizes code fences.
+// Code blocks and inline code are left untouched so examples survive intact.
+func sanitizeMarkdown(text string) string {
+	lines := strings.Split(text, "\n")
+	var out []string
+	var prose []string
+
+	flushProse := func() {
+		if len(prose) > 0 {
+			out = append(out, sanitizeProse(strings.Join(prose, "\n")))
+			prose = nil
+		}
+	}
+
+	var fence string // Closing marker of the open code block, empty when outside one
+	for _, line := range lines {
+		if fence == "" {
+			if m := fencePattern.FindStringSubmatch(line); m != nil {
+				flushProse()
+				fence = m[2]
+				out = append(out, m[1]+m[2]+normalizeLanguage(m[3]))
+				continue
+			}
+			prose = append(prose, line)
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			out = append(out, strings.TrimRight(line, " \t"))
+			fence = ""
+			continue
+		}
+		out = append(out, line)
+	}
+	flushProse()
+
+	// Close a code block the model never finished
+	if fence != "" {
+		out = append(out, fence)
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// sanitizeProse cleans a markdown fragment outside of fenced code blocks
+func sanitizeProse(text string) string {
+	// Protect inline code spans from HTML stripping
+	var result strings.Builder
+	last := 0
+	for _, loc := range inlineCodePattern.FindAllStringIndex(text, -1) {
+		result.WriteString(stripHTML(text[last:loc[0]]))
+		result.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	result.WriteString(stripHTML(text[last:]))
+	return result.String()
+}
+
+// stripHTML removes dangerous elements, comments, attributes and unsafe link schemes
+func stripHTML(text string) string {
+	for _, pattern := range dangerousElementPatterns {
+		text = pattern.ReplaceAllString(text, "")
+	}
+	text = htmlCommentPattern.ReplaceAllString(text, "")
+	text = htmlTagPattern.ReplaceAllStringFunc(text, func(tag string) string {
+		// Markdown autolinks look like tags but are safe
+		if autolinkPattern.MatchString(tag) {
+			return tag
+		}
+		m := htmlTagPattern.FindStringSubmatch(tag)
+		name := strings.ToLower(m[2])
+		if !allowedTags[name] {
+			return ""
+		}
+		return "<" + m[1] + name + m[3] + ">"
+	})
+	return unsafeLinkPattern.ReplaceAllString(text, "](#)")
+}
+
+// normalizeLanguage lower-cases a fence info string and resolves common aliases
+func normalizeLanguage(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if alias, ok := languageAliases[lang]; ok {
+		return alias
+	}
+	return lang
+}
+
+// analyzeContent detects code blocks, tables, math and links in a markdown message
+func analyzeContent(text string) *ContentInfo {
+	info := &ContentInfo{}
+	var prose []string
+
+	var fence string
+	var current *CodeBlockInfo
+	for _, line := range strings.Split(text, "\n") {
+		if fence == "" {
+			if m := fencePattern.FindStringSubmatch(line); m != nil {
+				fence = m[2]
+				lang := normalizeLanguage(m[3])
+				if lang == "" {
+					lang = "plaintext"
+				}
+				current = &CodeBlockInfo{Language: lang}
+				continue
+			}
+			prose = append(prose, line)
+			if tableSeparatorPattern.MatchString(line) {
+				info.HasTable = true
+			}
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			info.CodeBlocks = append(info.CodeBlocks, *current)
+			fence = ""
+			current = nil
+			continue
+		}
+		current.Lines++
+	}
+	if current != nil {
+		info.CodeBlocks = append(info.CodeBlocks, *current)
+	}
+
+	proseText := strings.Join(prose, "\n")
+	info.HasMath = mathPattern.MatchString(proseText)
+	info.HasLinks = markdownLinkPattern.MatchString(proseText)
+	return info
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestSanitizeMarkdown(t *testing.T) {
+	if len(dangerousElementPatterns) == 0 {
+		initMarkdown()
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain prose", "Just **bold** text", "Just **bold** text"},
+		{"script removed with its content", "Hi <script>alert(1)</script>there", "Hi there"},
+		{"nested dangerous element", "a<svg><g onload=x></g></svg>b", "ab"},
+		{"comments removed", "a<!-- hidden -->b", "ab"},
+		{"allowed tag loses attributes", `<b onclick="x()">bold</b>`, "<b>bold</b>"},
+		{"unknown tag dropped", `<div class="x">text</div>`, "text"},
+		{"autolink kept", "<https://example.com>", "<https://example.com>"},
+		{"javascript link neutralized", "[click](javascript:alert(1))", "[click](#)"},
+		{"data link neutralized", "[x]( data:text/html,hi)", "[x](#)"},
+		{"safe link kept", "[site](https://example.com)", "[site](https://example.com)"},
+		{"inline code untouched", "use `<script>` tags", "use `<script>` tags"},
+		{"code block untouched", "```html\n<script>x()</script>\n```", "```html\n<script>x()</script>\n```"},
+		{"fence language alias", "```JS\nlet a\n```", "```javascript\nlet a\n```"},
+		{"tilde fence", "~~~py\nx = 1\n~~~", "~~~python\nx = 1\n~~~"},
+		{"unclosed fence closed", "```go\nfunc main() {}", "```go\nfunc main() {}\n```"},
+		{"prose after a block sanitized", "```\ncode\n```\n<iframe src=x></iframe>done", "```\ncode\n```\ndone"},
+		{"shorter fence doesn't close a longer one", "````\n```\n````", "````\n```\n````"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeMarkdown(tt.in); got != tt.want {
+				t.Errorf("sanitizeMarkdown(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	memoryEnabled  bool          // Whether durable facts are remembered across conversations
+	memoryModel    string        // Model used to extract facts (defaults to the chat model)
+	memoryTopK     int           // Memories added to each prompt
+	memoryMaxFacts int           // Facts kept per room and user; the oldest are dropped
+	memoryTimeout  time.Duration // Upper bound for one extraction
+)
+
+// Memory is a durable fact about a user or a room
+type Memory struct {
+	ID              int       `json:"id"`
+	RoomID          int       `json:"room_id"`
+	User            string    `json:"user,omitempty"` // Empty for facts about the room as a whole
+	Fact            string    `json:"fact"`
+	SourceMessageID *int      `json:"source_message_id,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// initMemory reads memory settings and creates the memories table
+func initMemory() {
+	memoryEnabled = getEnvBool("MEMORY_ENABLED", false)
+	memoryModel = getEnv("MEMORY_MODEL", "")
+	memoryTopK = max(1, getEnvInt("MEMORY_TOP_K", 5))
+	memoryMaxFacts = max(1, getEnvInt("MEMORY_MAX_FACTS", 200))
+	memoryTimeout = getEnvDuration("MEMORY_TIMEOUT", 30*time.Second)
+
+	if !memoryEnabled {
+		return
+	}
+	createMemoriesTable()
+	log.Printf("🧠 Long-term memory enabled (top k: %d)", memoryTopK)
+}
+
+// Create `memories` table if it doesn't exist
+func createMemoriesTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS memories (
+			id SERIAL PRIMARY KEY,
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			user_id TEXT NOT NULL DEFAULT '',
+			fact TEXT NOT NULL,
+			embedding REAL[],
+			source_message_id INTEGER REFERENCES chat_history(id) ON DELETE SET NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			UNIQUE (room_id, user_id, fact)
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create memories table:", err)
+	}
+	log.Println("✅ Table memories is ready")
+}
+
+// loadMemories returns the facts about a room and (if known) its user, newest first
+func loadMemories(roomID int, user string) ([]Memory, [][]float32, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT id, room_id, user_id, fact, source_message_id, created_at, embedding
+		FROM memories WHERE room_id = $1 AND (user_id = '' OR user_id = $2)
+		ORDER BY created_at DESC`, roomID, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var memories []Memory
+	var embeddings [][]float32
+	for rows.Next() {
+		var m Memory
+		var embedding []float32
+		if err := rows.Scan(&m.ID, &m.RoomID, &m.User, &m.Fact, &m.SourceMessageID, &m.CreatedAt, &embedding); err != nil {
+			return nil, nil, err
+		}
+		memories = append(memories, m)
+		embeddings = append(embeddings, embedding)
+	}
+	return memories, embeddings, rows.Err()
+}
+
+// recallMemories adds the memories most relevant to the prompt to the generation. Only
+// verified users get their personal facts; anyone could claim an unverified name.
+// Without embeddings it falls back to the most recent facts.
+func recallMemories(gen *generation) {
+	memories, embeddings, err := loadMemories(gen.roomID, gen.identity)
+	if err != nil {
+		log.Println("Error loading memories:", err)
+		return
+	}
+	if len(memories) <= memoryTopK {
+		gen.memories = memories
+		return
+	}
+
+	if query, err := embedTexts([]string{gen.prompt}); err == nil {
+		scores := make([]float64, len(memories))
+		for i := range memories {
+			scores[i] = cosineSimilarity(query[0], embeddings[i])
+		}
+		order := make([]int, len(memories))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+		for _, i := range order[:memoryTopK] {
+			gen.memories = append(gen.memories, memories[i])
+		}
+		return
+	}
+	gen.memories = memories[:memoryTopK]
+}
+
+// memoryPrompt formats recalled memories for the model
+func memoryPrompt(memories []Memory) string {
+	var b strings.Builder
+	b.WriteString("Things you remember about the user and this conversation:\n")
+	for _, m := range memories {
+		fmt.Fprintf(&b, "- %s\n", m.Fact)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// rememberFacts asks the model for durable facts in an exchange and stores new ones. user
+// is the sender's verified name, "" if they haven't proven it.
+func rememberFacts(roomID int, user string, messageID int, question, answer string) {
+	existing, _, err := loadMemories(roomID, user)
+	if err != nil {
+		log.Println("Error loading memories:", err)
+		return
+	}
+	var known strings.Builder
+	for _, m := range existing {
+		fmt.Fprintf(&known, "- %s\n", m.Fact)
+	}
+
+	model := memoryModel
+	if model == "" {
+		model = ollamaModel
+	}
+	prompt := fmt.Sprintf(`Extract durable facts worth remembering for future conversations, such as preferences,
+names of projects or people, and stable circumstances. Ignore small talk, one-off questions and anything already known.
+Use "user" scope for facts about the person and "room" scope for facts about the shared conversation or project.
+Reply only with JSON in the form {"facts": [{"fact": "...", "scope": "user"}]}, or {"facts": []} if there is nothing new.
+
+Already known:
+%s
+User: %s
+
+Assistant: %s`, known.String(), question, answer)
+
+	raw, err := generateOnce(model, prompt, "json", memoryTimeout)
+	if err != nil {
+		log.Println("Error extracting memories:", err)
+		return
+	}
+
+	var parsed struct {
+		Facts []struct {
+			Fact  string `json:"fact"`
+			Scope string `json:"scope"`
+		} `json:"facts"`
+	}
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		log.Println("Error parsing memories:", err)
+		return
+	}
+
+	var source *int
+	if messageID != 0 {
+		source = &messageID
+	}
+	for _, f := range parsed.Facts {
+		fact := strings.TrimSpace(f.Fact)
+		if fact == "" || len(fact) > 500 {
+			continue
+		}
+		// Personal facts need a verified user: an anonymous one's would be stored room-wide and
+		// shown to everyone, and an unverified name could belong to someone else
+		owner := ""
+		if f.Scope == "user" {
+			if user == "" {
+				continue
+			}
+			owner = user
+		}
+
+		// Embeddings are optional; without them recall falls back to recency
+		var embedding []float32
+		if vectors, err := embedTexts([]string{fact}); err == nil {
+			embedding = vectors[0]
+		}
+
+		tag, err := db.Exec(context.Background(), `
+			INSERT INTO memories (room_id, user_id, fact, embedding, source_message_id) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (room_id, user_id, fact) DO NOTHING`, roomID, owner, fact, embedding, source)
+		if err != nil {
+			log.Println("Error saving memory:", err)
+			continue
+		}
+		if tag.RowsAffected() > 0 {
+			log.Printf("🧠 Remembered: %s", fact)
+		}
+	}
+
+	// Keep the store bounded
+	_, err = db.Exec(context.Background(), `
+		DELETE FROM memories WHERE room_id = $1 AND (user_id = '' OR user_id = $2) AND id NOT IN (
+			SELECT id FROM memories WHERE room_id = $1 AND (user_id = '' OR user_id = $2)
+			ORDER BY created_at DESC LIMIT $3)`, roomID, user, memoryMaxFacts)
+	if err != nil {
+		log.Println("Error pruning memories:", err)
+	}
+}
+
+// Handler to list memories for a room (and optionally a user), for moderators
+func listMemories(w http.ResponseWriter, r *http.Request) {
+	if !memoryEnabled {
+		http.Error(w, "Memory is disabled", http.StatusServiceUnavailable)
+		return
+	}
+	roomID, err := requestRoom(r)
+	if err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	memories, _, err := loadMemories(roomID, r.URL.Query().Get("user"))
+	if err != nil {
+		http.Error(w, "Failed to fetch memories", http.StatusInternalServerError)
+		log.Println("Error fetching memories:", err)
+		return
+	}
+	if memories == nil {
+		memories = []Memory{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(memories)
+}
+
+// Handler to delete a memory, for moderators
+func deleteMemory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !memoryEnabled {
+		http.Error(w, "Memory is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid memory id", http.StatusBadRequest)
+		return
+	}
+
+	var deleted int
+	err = db.QueryRow(context.Background(), "DELETE FROM memories WHERE id = $1 RETURNING id", id).Scan(&deleted)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Memory not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete memory", http.StatusInternalServerError)
+		log.Println("Error deleting memory:", err)
+		return
+	}
+	recordAudit("moderator", clientIP(r), "memory.delete", strconv.Itoa(id), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
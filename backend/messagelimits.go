@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// Message length limits by sender role: guests get the shortest, members more, and
+// moderators by default only the prompt limit. Users on the override list are held only
+// to the prompt limit too. Roles come from what a client can prove, never from a bare
+// ?user= name: members and overrides need a verified identity (a user token, or an
+// OpenAI API key) and moderators the moderator or admin token. No limit is ever above
+// PROMPT_MAX_CHARS, since a longer message could never be answered.
+var (
+	messageLimitGuest     int      // Longest message from a client without a verified identity; 0 for only the prompt limit
+	messageLimitMember    int      // Longest message from a verified user
+	messageLimitModerator int      // Longest message from a moderator or admin
+	messageLimitOverrides []string // Verified users held only to the prompt limit
+)
+
+// initMessageLimits reads the per-role message length limits
+func initMessageLimits() {
+	messageLimitGuest = getEnvInt("MAX_MESSAGE_CHARS_GUEST", 2000)
+	messageLimitMember = getEnvInt("MAX_MESSAGE_CHARS_MEMBER", 8000)
+	messageLimitModerator = getEnvInt("MAX_MESSAGE_CHARS_MODERATOR", 0)
+	messageLimitOverrides = splitList(getEnv("MESSAGE_LIMIT_OVERRIDE_USERS", ""))
+
+	if messageLimitMember > 0 && (messageLimitGuest <= 0 || messageLimitGuest > messageLimitMember) {
+		log.Printf("⚠️ MAX_MESSAGE_CHARS_GUEST (%d) allows more than MAX_MESSAGE_CHARS_MEMBER (%d)", messageLimitGuest, messageLimitMember)
+	}
+	if len(messageLimitOverrides) > 0 {
+		log.Printf("📏 Message length limits lifted for %s", strings.Join(messageLimitOverrides, ", "))
+	}
+}
+
+// messageLimit is the longest message a sender may send and the role it's set by.
+// identity is the sender's verified user name, "" if they have none; moderator says
+// whether they presented the moderator or admin token.
+func messageLimit(identity string, moderator bool) (limit int, role string) {
+	switch {
+	case identity != "" && containsString(messageLimitOverrides, identity):
+		role = "override"
+	case moderator:
+		limit, role = messageLimitModerator, "moderator"
+	case identity == "":
+		limit, role = messageLimitGuest, "guest"
+	default:
+		limit, role = messageLimitMember, "member"
+	}
+	if limit <= 0 || limit > promptMaxChars {
+		limit = promptMaxChars
+	}
+	return limit, role
+}
+
+// messageTooLong checks a message against the sender's limit, returning the error to
+// show them or "" when it fits
+func messageTooLong(text, identity string, moderator bool) (limit int, message string) {
+	limit, role := messageLimit(identity, moderator)
+	length := utf8.RuneCountInString(text)
+	if length <= limit {
+		return limit, ""
+	}
+	addCounter("cubbychat_messages_too_long_total", "Messages refused for exceeding the sender's length limit, by role", 1, "role", role)
+	return limit, fmt.Sprintf("Messages are limited to %d characters; this one has %d", limit, length)
+}
+
+// checkMessageLength tells a session its message is over its limit, returning false if it is
+func (s *Session) checkMessageLength(text string) bool {
+	limit, message := messageTooLong(text, s.identity, s.moderator)
+	if message == "" {
+		return true
+	}
+	if err := s.sendEvent("error", ErrorEvent{Code: "message_too_large", Message: message, Limit: limit}); err != nil {
+		log.Println("Error sending error event:", err)
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// withMessageLimits sets the limits for one test and puts the old ones back after it
+func withMessageLimits(t *testing.T, guest, member, moderator, prompt int, overrides ...string) {
+	t.Helper()
+	oldGuest, oldMember, oldModerator, oldPrompt, oldOverrides :=
+		messageLimitGuest, messageLimitMember, messageLimitModerator, promptMaxChars, messageLimitOverrides
+	t.Cleanup(func() {
+		messageLimitGuest, messageLimitMember, messageLimitModerator, promptMaxChars, messageLimitOverrides =
+			oldGuest, oldMember, oldModerator, oldPrompt, oldOverrides
+	})
+	messageLimitGuest, messageLimitMember, messageLimitModerator, promptMaxChars = guest, member, moderator, prompt
+	messageLimitOverrides = overrides
+}
+
+func TestMessageLimit(t *testing.T) {
+	withMessageLimits(t, 100, 1000, 0, 5000, "vip")
+
+	tests := []struct {
+		name      string
+		identity  string
+		moderator bool
+		wantLimit int
+		wantRole  string
+	}{
+		{"guest", "", false, 100, "guest"},
+		{"verified member", "alice", false, 1000, "member"},
+		{"moderator token without a limit of its own", "", true, 5000, "moderator"},
+		{"moderator token outranks membership", "alice", true, 5000, "moderator"},
+		{"verified override user", "vip", false, 5000, "override"},
+		{"override names don't match partially", "vip2", false, 1000, "member"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, role := messageLimit(tt.identity, tt.moderator)
+			if limit != tt.wantLimit || role != tt.wantRole {
+				t.Errorf("messageLimit(%q, %v) = %d, %q; want %d, %q", tt.identity, tt.moderator, limit, role, tt.wantLimit, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestMessageLimitNeverExceedsPromptLimit(t *testing.T) {
+	withMessageLimits(t, 100000, 200000, 300000, 5000)
+
+	for _, identity := range []string{"", "alice"} {
+		for _, moderator := range []bool{false, true} {
+			if limit, _ := messageLimit(identity, moderator); limit != 5000 {
+				t.Errorf("messageLimit(%q, %v) = %d, want the prompt limit 5000", identity, moderator, limit)
+			}
+		}
+	}
+}
+
+func TestMessageTooLong(t *testing.T) {
+	withMessageLimits(t, 10, 20, 0, 5000)
+
+	tests := []struct {
+		name     string
+		text     string
+		identity string
+		tooLong  bool
+	}{
+		{"guest at the limit", strings.Repeat("a", 10), "", false},
+		{"guest over the limit", strings.Repeat("a", 11), "", true},
+		{"member over the guest limit", strings.Repeat("a", 11), "alice", false},
+		{"characters, not bytes, are counted", strings.Repeat("é", 10), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, message := messageTooLong(tt.text, tt.identity, false)
+			if (message != "") != tt.tooLong {
+				t.Errorf("messageTooLong(%d runes, %q) = %q, want too long %v", len([]rune(tt.text)), tt.identity, message, tt.tooLong)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricFamily is one Prometheus metric and its values by label set
+type metricFamily struct {
+	kind   string // "counter" or "gauge"
+	help   string
+	values map[string]float64 // Keyed by the rendered label set, e.g. {model="x"}
+}
+
+var (
+	metricsMu sync.Mutex
+	metrics   = make(map[string]*metricFamily)
+)
+
+// addCounter increases a counter; labels are name/value pairs
+func addCounter(name, help string, value float64, labels ...string) {
+	updateMetric("counter", name, help, labels, func(old float64) float64 { return old + value })
+}
+
+// setGauge sets a gauge; labels are name/value pairs
+func setGauge(name, help string, value float64, labels ...string) {
+	updateMetric("gauge", name, help, labels, func(float64) float64 { return value })
+}
+
+func updateMetric(kind, name, help string, labels []string, update func(float64) float64) {
+	key := renderLabels(labels)
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	family, ok := metrics[name]
+	if !ok {
+		family = &metricFamily{kind: kind, help: help, values: make(map[string]float64)}
+		metrics[name] = family
+	}
+	family.values[key] = update(family.values[key])
+}
+
+// renderLabels formats name/value pairs as a Prometheus label set
+func renderLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], escape.Replace(labels[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Handler for /metrics in the Prometheus text format
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, name := range names {
+		family := metrics[name]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, family.kind)
+		keys := make([]string, 0, len(family.values))
+		for key := range family.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s%s %g\n", name, key, family.values[key])
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// modelPreferences lists the models to use, most preferred first
+var modelPreferences []string
+
+// initModelPreferences reads OLLAMA_MODEL and MODEL_PREFERENCES (comma-separated);
+// OLLAMA_MODEL goes first when both are set
+func initModelPreferences() {
+	modelPreferences = splitList(getEnv("OLLAMA_MODEL", ""))
+	for _, model := range splitList(getEnv("MODEL_PREFERENCES", "")) {
+		if !containsString(modelPreferences, model) {
+			modelPreferences = append(modelPreferences, model)
+		}
+	}
+	if len(modelPreferences) > 0 {
+		log.Printf("📋 Model preferences: %s", strings.Join(modelPreferences, ", "))
+	}
+}
+
+// containsString reports whether a list contains a value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// preferredModel picks the installed model that best matches the preferences.
+// A preference without a tag ("llama3") matches its :latest tag first, then any tag.
+// Without preferences, or when none is installed, the first installed model is used.
+func preferredModel(installed []OllamaModel) string {
+	for _, preference := range modelPreferences {
+		candidates := []func(name string) bool{
+			func(name string) bool { return name == preference },
+		}
+		if !strings.Contains(preference, ":") {
+			candidates = append(candidates,
+				func(name string) bool { return name == preference+":latest" },
+				func(name string) bool { return strings.HasPrefix(name, preference+":") })
+		}
+		for _, matches := range candidates {
+			for _, m := range installed {
+				if matches(strings.ToLower(m.Name)) {
+					return m.Name
+				}
+			}
+		}
+	}
+
+	if len(modelPreferences) > 0 {
+		log.Printf("⚠️ None of the preferred models are installed, using %s", installed[0].Name)
+	}
+	return installed[0].Name
+}
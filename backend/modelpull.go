@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	ollamaDefaultModel string         // Model pulled when Ollama has none installed
+	pullProgress       *ModelProgress // Latest model download, kept after it finishes
+)
+
+// OllamaPullRequest is the body of Ollama's /api/pull
+type OllamaPullRequest struct {
+	Model  string `json:"model"`
+	Stream bool   `json:"stream"`
+}
+
+// OllamaPullResponse is one line of Ollama's streamed pull progress
+type OllamaPullResponse struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// initModelPull reads the model to download when Ollama has none
+func initModelPull() {
+	ollamaDefaultModel = getEnv("OLLAMA_DEFAULT_MODEL", "")
+	if ollamaEnabled && ollamaDefaultModel != "" {
+		log.Printf("📥 Will pull %s if Ollama has no models", ollamaDefaultModel)
+	}
+}
+
+// pullModel downloads a model through Ollama, reporting progress as the
+// "pulling_model" status until it completes
+func pullModel(model string) error {
+	log.Printf("📥 Pulling model %s...", model)
+	progress := &ModelProgress{Model: model}
+	publishPullProgress(progress, true)
+
+	resp, err := newOllamaClient().R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(OllamaPullRequest{Model: model, Stream: true}).
+		SetDoNotParseResponse(true).
+		Post(fmt.Sprintf("%s/api/pull", ollamaURL))
+	if err != nil {
+		return fmt.Errorf("failed to connect to ollama: %v", err)
+	}
+	defer resp.RawBody().Close()
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("ollama returned status %d", resp.StatusCode())
+	}
+
+	// Layers download one after another; progress covers all layers seen so far
+	layers := make(map[string][2]int64)
+	started := time.Now()
+	lastPublished := time.Time{}
+
+	scanner := bufio.NewScanner(resp.RawBody())
+	for scanner.Scan() {
+		var line OllamaPullResponse
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			log.Println("Error parsing pull progress:", err)
+			continue
+		}
+		if line.Error != "" {
+			return fmt.Errorf("pull failed: %s", line.Error)
+		}
+		if line.Digest != "" && line.Total > 0 {
+			layers[line.Digest] = [2]int64{line.Completed, line.Total}
+		}
+
+		var completed, total int64
+		for _, layer := range layers {
+			completed += layer[0]
+			total += layer[1]
+		}
+		next := &ModelProgress{Model: model, Step: line.Status, CompletedBytes: completed, TotalBytes: total}
+		if total > 0 {
+			next.Percent = float64(completed) * 100 / float64(total)
+			if elapsed := time.Since(started).Seconds(); completed > 0 && elapsed > 0 {
+				rate := float64(completed) / elapsed
+				next.ETASeconds = int(float64(total-completed) / rate)
+			}
+		}
+
+		// Push at most once a second, but always report a new step
+		if line.Status != progress.Step || time.Since(lastPublished) >= time.Second {
+			publishPullProgress(next, true)
+			lastPublished = time.Now()
+		} else {
+			publishPullProgress(next, false)
+		}
+		progress = next
+
+		if line.Status == "success" {
+			log.Printf("✅ Pulled model %s", model)
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading pull progress: %v", err)
+	}
+	return fmt.Errorf("pull of %s ended without success", model)
+}
+
+// publishPullProgress records download progress, pushing it to clients when asked
+func publishPullProgress(progress *ModelProgress, push bool) {
+	modelStatusMu.Lock()
+	pullProgress = progress
+	modelStatusMu.Unlock()
+	setModelProgress(progress)
+	if push {
+		setModelStatus("pulling_model")
+	}
+}
+
+// Handler for /api/model-status/progress: the latest model download, if any
+func getModelPullProgress(w http.ResponseWriter, r *http.Request) {
+	modelStatusMu.Lock()
+	progress := pullProgress
+	modelStatusMu.Unlock()
+	if progress == nil {
+		http.Error(w, "No model download has run", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(progress)
+}
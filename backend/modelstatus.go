@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// modelStatusMu guards modelStatus and modelProgress
+var (
+	modelStatusMu sync.Mutex
+	modelProgress *ModelProgress // Progress of the current loading step, if any
+)
+
+// ModelProgress reports how far loading the model has got
+type ModelProgress struct {
+	Attempt        int     `json:"attempt,omitempty"`         // Readiness check attempt
+	MaxAttempts    int     `json:"max_attempts,omitempty"`    // Attempts before giving up
+	Model          string  `json:"model,omitempty"`           // Model being downloaded
+	Step           string  `json:"step,omitempty"`            // Ollama's description of the download step
+	CompletedBytes int64   `json:"completed_bytes,omitempty"` // Bytes downloaded so far
+	TotalBytes     int64   `json:"total_bytes,omitempty"`     // Bytes to download in the layers seen so far
+	Percent        float64 `json:"percent,omitempty"`
+	ETASeconds     int     `json:"eta_seconds,omitempty"` // Estimated time left at the average rate
+}
+
+// modelPhase groups detailed statuses into the transitions clients show:
+// loading, testing, ready, degraded or unavailable
+func modelPhase(status string) string {
+	switch status {
+	case "ready":
+		return "ready"
+	case "degraded":
+		return "degraded"
+	case "testing_generation":
+		return "testing"
+	case "disabled", "failed":
+		return "unavailable"
+	default:
+		return "loading"
+	}
+}
+
+// currentModelStatus returns a snapshot of the model's readiness
+func currentModelStatus() ModelStatusResponse {
+	modelStatusMu.Lock()
+	defer modelStatusMu.Unlock()
+	return ModelStatusResponse{
+		Ready:    modelReady.Load(),
+		Status:   modelStatus,
+		Phase:    modelPhase(modelStatus),
+		Model:    ollamaModel,
+		Progress: modelProgress,
+	}
+}
+
+// setModelStatus records a status change and pushes it to every connected client
+func setModelStatus(status string) {
+	modelStatusMu.Lock()
+	modelStatus = status
+	modelStatusMu.Unlock()
+	broadcastModelStatus()
+}
+
+// setModelProgress records progress, which is sent with the next status change
+func setModelProgress(progress *ModelProgress) {
+	modelStatusMu.Lock()
+	defer modelStatusMu.Unlock()
+	modelProgress = progress
+}
+
+// broadcastModelStatus sends the current status as a "model_status" event to all sessions
+func broadcastModelStatus() {
+	status := currentModelStatus()
+	for _, s := range connectedSessions(func(*Session) bool { return true }) {
+		if err := s.sendEvent("model_status", status); err != nil {
+			log.Println("Error sending model_status event:", err)
+		}
+	}
+}
+
+// sendModelStatus tells a newly connected client the current status
+func sendModelStatus(s *Session) {
+	if err := s.sendEvent("model_status", currentModelStatus()); err != nil {
+		log.Println("Error sending model_status event:", err)
+	}
+}
+
+// reportGeneration marks a ready model degraded when generations fail, and ready again once one succeeds
+func reportGeneration(err error) {
+	if !modelReady.Load() {
+		return
+	}
+	phase := currentModelStatus().Phase
+	if err != nil && phase == "ready" {
+		log.Println("⚠️ Model degraded:", err)
+		setModelStatus("degraded")
+	} else if err == nil && phase == "degraded" {
+		log.Println("✅ Model recovered")
+		setModelStatus("ready")
+	}
+}
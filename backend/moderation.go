@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// The moderation queue collects stored messages flagged by the word filters, by users'
+// reports or by the model classifier. Moderators approve, redact or delete them; the
+// author is told and every decision goes to the audit log.
+var (
+	moderationFilters           []*regexp.Regexp // Messages matching any of these are flagged
+	moderationClassifierEnabled bool             // Whether the model screens user messages
+	moderationClassifierModel   string           // Model used to screen messages (defaults to the chat model)
+	moderationClassifierTimeout time.Duration
+)
+
+// redactedText replaces the content of a redacted message
+const redactedText = "[This message was redacted by a moderator]"
+
+// errFlagReviewed refuses a second decision on a flag
+var errFlagReviewed = errors.New("flag already reviewed")
+
+// moderationDecisions maps the decisions moderators can make to the status they leave
+var moderationDecisions = map[string]string{"approve": "approved", "redact": "redacted", "delete": "deleted"}
+
+// ModerationFlag is a reason to look at a message
+type ModerationFlag struct {
+	ID         int        `json:"id"`
+	MessageID  int        `json:"message_id"`
+	RoomID     int        `json:"room_id"`
+	Author     string     `json:"author"`
+	Source     string     `json:"source"` // filter, report or classifier
+	Reason     string     `json:"reason"`
+	Reporter   string     `json:"reporter,omitempty"` // Who reported it, for reports
+	Excerpt    string     `json:"excerpt"`            // The message when it was flagged
+	Message    *string    `json:"message,omitempty"`  // The message now, unless it was deleted
+	Status     string     `json:"status"`             // pending, approved, redacted or deleted
+	Note       string     `json:"note,omitempty"`     // The moderator's explanation
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+}
+
+// ModerationEvent tells a room a message was redacted or deleted, and its author why
+type ModerationEvent struct {
+	MessageID int    `json:"message_id"`
+	RoomID    int    `json:"room_id"`
+	Decision  string `json:"decision"`
+	Message   string `json:"message,omitempty"` // The replacement text of a redacted message
+	Note      string `json:"note,omitempty"`
+}
+
+// initModeration reads the filters and classifier settings and creates the queue table
+func initModeration() {
+	for _, pattern := range splitList(getEnv("MODERATION_FILTER_PATTERNS", "")) {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			log.Fatalf("❌ Invalid MODERATION_FILTER_PATTERNS entry %q: %v", pattern, err)
+		}
+		moderationFilters = append(moderationFilters, re)
+	}
+	moderationClassifierEnabled = getEnvBool("MODERATION_CLASSIFIER_ENABLED", false)
+	moderationClassifierModel = getEnv("MODERATION_CLASSIFIER_MODEL", "")
+	moderationClassifierTimeout = getEnvDuration("MODERATION_CLASSIFIER_TIMEOUT", 20*time.Second)
+	createModerationFlagsTable()
+
+	if moderationClassifierEnabled {
+		log.Printf("🚩 Screening messages with the model classifier (model: %s)", getEnv("MODERATION_CLASSIFIER_MODEL", "chat model"))
+	}
+}
+
+// Create `moderation_flags` table if it doesn't exist. Flags outlive the messages they're
+// about, so message_id isn't a foreign key.
+func createModerationFlagsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS moderation_flags (
+			id SERIAL PRIMARY KEY,
+			message_id INTEGER NOT NULL,
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			author TEXT NOT NULL DEFAULT '',
+			source TEXT NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			reporter TEXT NOT NULL DEFAULT '',
+			excerpt TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'pending',
+			note TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			reviewed_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS moderation_flags_status_idx ON moderation_flags (status, id);
+		CREATE INDEX IF NOT EXISTS moderation_flags_message_idx ON moderation_flags (message_id);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create moderation_flags table:", err)
+	}
+	log.Println("✅ Table moderation_flags is ready")
+}
+
+// flagMessage puts a message in the moderation queue and writes the flag to the audit log
+func flagMessage(messageID, roomID int, author, source, reason, reporter, text string) (int, error) {
+	excerpt := text
+	if len(excerpt) > 500 {
+		excerpt = strings.ToValidUTF8(excerpt[:500], "")
+	}
+	var id int
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO moderation_flags (message_id, room_id, author, source, reason, reporter, excerpt)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		messageID, roomID, author, source, reason, reporter, excerpt).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	actor := "system"
+	if reporter != "" {
+		actor = reporter
+	}
+	recordAudit(actor, "", "moderation.flag", "message:"+strconv.Itoa(messageID),
+		map[string]interface{}{"flag_id": id, "room_id": roomID, "source": source, "reason": reason})
+	addCounter("cubbychat_moderation_flags_total", "Messages flagged for moderation, by source", 1, "source", source)
+	return id, nil
+}
+
+// screenMessage runs a stored user message past the filters, then the classifier in the background
+func screenMessage(s *Session, messageID int, text string) {
+	for _, re := range moderationFilters {
+		if re.MatchString(text) {
+			if _, err := flagMessage(messageID, s.room, s.user, "filter", "matched "+re.String()[len("(?i)"):], "", text); err != nil {
+				log.Println("Error flagging message:", err)
+			}
+			break
+		}
+	}
+	if moderationClassifierEnabled {
+		go classifyMessage(messageID, s.room, s.user, text)
+	}
+}
+
+// classifyMessage asks the model whether a message breaks the rules and flags it if so
+func classifyMessage(messageID, roomID int, author, text string) {
+	model := moderationClassifierModel
+	if model == "" {
+		model = ollamaModel
+	}
+	prompt := fmt.Sprintf(`You review chat messages for a moderation team. Decide whether this message contains
+harassment, hate, threats, sexual content involving minors, self-harm encouragement, spam or personal data
+posted without consent. Reply only with JSON in the form {"flag": true, "category": "...", "reason": "..."}
+or {"flag": false}.
+
+Message: %s`, text)
+
+	raw, err := generateOnce(model, prompt, "json", moderationClassifierTimeout)
+	if err != nil {
+		log.Println("Error classifying message:", err)
+		return
+	}
+	var verdict struct {
+		Flag     bool   `json:"flag"`
+		Category string `json:"category"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(raw), &verdict); err != nil {
+		log.Println("Error parsing moderation verdict:", err)
+		return
+	}
+	if !verdict.Flag {
+		return
+	}
+	reason := strings.TrimSpace(strings.Trim(verdict.Category+": "+verdict.Reason, ": "))
+	if len(reason) > 300 {
+		reason = strings.ToValidUTF8(reason[:300], "")
+	}
+	if _, err := flagMessage(messageID, roomID, author, "classifier", reason, "", text); err != nil {
+		log.Println("Error flagging message:", err)
+	}
+}
+
+// Handler for /api/moderation/queue: flags by status (pending, approved, redacted, deleted
+// or all; pending by default), optionally for one room, oldest pending first
+func listModerationQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status == "" {
+		status = "pending"
+	}
+	if status != "pending" && status != "approved" && status != "redacted" && status != "deleted" && status != "all" {
+		http.Error(w, "status must be pending, approved, redacted, deleted or all", http.StatusBadRequest)
+		return
+	}
+	roomID := 0
+	if v := query.Get("room"); v != "" {
+		var err error
+		if roomID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid room", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT f.id, f.message_id, f.room_id, f.author, f.source, f.reason, f.reporter, f.excerpt,
+			`+messageText("m")+`, f.status, f.note, f.created_at, f.reviewed_at
+		FROM moderation_flags f LEFT JOIN chat_history m ON m.id = f.message_id
+		WHERE ($1 = 'all' OR f.status = $1) AND ($2 = 0 OR f.room_id = $2)
+		ORDER BY CASE WHEN f.status = 'pending' THEN f.id ELSE -f.id END LIMIT 200`, status, roomID)
+	if err != nil {
+		http.Error(w, "Failed to fetch moderation queue", http.StatusInternalServerError)
+		log.Println("Error fetching moderation queue:", err)
+		return
+	}
+	defer rows.Close()
+
+	flags := []ModerationFlag{}
+	for rows.Next() {
+		var f ModerationFlag
+		if err := rows.Scan(&f.ID, &f.MessageID, &f.RoomID, &f.Author, &f.Source, &f.Reason, &f.Reporter, &f.Excerpt,
+			&f.Message, &f.Status, &f.Note, &f.CreatedAt, &f.ReviewedAt); err != nil {
+			http.Error(w, "Error processing moderation queue", http.StatusInternalServerError)
+			log.Println("Error scanning moderation queue:", err)
+			return
+		}
+		flags = append(flags, f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flags)
+}
+
+// Handler for /api/moderation/queue/{id}: decide a flag ({"decision": "approve", "redact" or
+// "delete", "note": "..."}). The decision settles every pending flag on the same message.
+func reviewModerationFlag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Decision string `json:"decision"`
+		Note     string `json:"note"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&req); err != nil || moderationDecisions[req.Decision] == "" {
+		http.Error(w, `decision must be "approve", "redact" or "delete"`, http.StatusBadRequest)
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if len([]rune(req.Note)) > 1000 {
+		http.Error(w, "Notes are limited to 1000 characters", http.StatusBadRequest)
+		return
+	}
+	status := moderationDecisions[req.Decision]
+
+	var flag ModerationFlag
+	ctx := context.Background()
+	err = pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, "SELECT message_id, room_id, author, status FROM moderation_flags WHERE id = $1 FOR UPDATE", id).
+			Scan(&flag.MessageID, &flag.RoomID, &flag.Author, &flag.Status)
+		if err != nil {
+			return err
+		}
+		if flag.Status != "pending" {
+			return errFlagReviewed
+		}
+
+		switch req.Decision {
+		case "redact":
+			// The compressed original and the search embedding would still hold the content
+			_, err := tx.Exec(ctx, "UPDATE chat_history SET message = $2, metadata = NULL, content_hash = NULL WHERE id = $1", flag.MessageID, redactedText)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, "UPDATE chat_history_archive SET message = $2, metadata = NULL WHERE id = $1", flag.MessageID, redactedText); err != nil {
+				return err
+			}
+			if messageEmbeddingsReady {
+				if _, err := tx.Exec(ctx, "DELETE FROM message_embeddings WHERE message_id = $1", flag.MessageID); err != nil {
+					return err
+				}
+			}
+		case "delete":
+			for _, table := range []string{"chat_history_archive", "chat_history"} {
+				if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE id = $1", flag.MessageID); err != nil {
+					return err
+				}
+			}
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE moderation_flags SET status = $2, note = $3, reviewed_at = NOW()
+			WHERE message_id = $1 AND status = 'pending'`, flag.MessageID, status, req.Note)
+		return err
+	})
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Flag not found", http.StatusNotFound)
+		return
+	}
+	if err == errFlagReviewed {
+		http.Error(w, "Flag was already reviewed ("+flag.Status+")", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to review flag", http.StatusInternalServerError)
+		log.Println("Error reviewing moderation flag:", err)
+		return
+	}
+
+	event := ModerationEvent{MessageID: flag.MessageID, RoomID: flag.RoomID, Decision: req.Decision, Note: req.Note}
+	switch req.Decision {
+	case "redact":
+		// Redaction keeps the message count, so cached history has to be told it changed
+		historyChanged(flag.RoomID)
+		event.Message = redactedText
+		publishRoomEvent(flag.RoomID, nil, "message_redacted", event)
+	case "delete":
+		publishRoomEvent(flag.RoomID, nil, "message_deleted", event)
+	}
+	// Authors hear about every decision on their message, approvals included
+	for _, s := range userSessions(flag.Author) {
+		if err := s.sendEvent("moderation", event); err != nil {
+			log.Println("Error sending moderation event:", err)
+		}
+	}
+
+	recordAudit("moderator", clientIP(r), "moderation."+req.Decision, "message:"+strconv.Itoa(flag.MessageID),
+		map[string]interface{}{"flag_id": id, "room_id": flag.RoomID, "author": flag.Author, "note": req.Note})
+	addCounter("cubbychat_moderation_decisions_total", "Moderation decisions, by decision", 1, "decision", req.Decision)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(event)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Notification preferences say when a user wants to be told about activity they aren't
+// connected for: rooms they muted, quiet hours, and whether only mentions count. Whatever
+// delivers push or email notifications must ask shouldNotify before sending anything.
+// Like settings they belong to the self-reported ?user= name.
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+var clockTimePattern = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d$`)
+
+// NotificationPrefs are a user's notification preferences
+type NotificationPrefs struct {
+	MutedRooms  []int        `json:"muted_rooms,omitempty"`  // Rooms never notified about
+	MentionOnly bool         `json:"mention_only,omitempty"` // Only messages that @mention the user are notified
+	DND         *DNDSchedule `json:"dnd,omitempty"`          // Recurring quiet hours
+	DNDUntil    *time.Time   `json:"dnd_until,omitempty"`    // Nothing is notified before this, whatever the schedule
+	UpdatedAt   *time.Time   `json:"updated_at,omitempty"`
+}
+
+// DNDSchedule is a daily do-not-disturb window. A window whose end is before its start
+// runs past midnight and belongs to the day it starts on.
+type DNDSchedule struct {
+	Start    string   `json:"start"`              // "22:00"
+	End      string   `json:"end"`                // "07:30"
+	Timezone string   `json:"timezone,omitempty"` // IANA zone such as "Europe/Zurich"; UTC when empty
+	Days     []string `json:"days,omitempty"`     // "mon".."sun" the window starts on; every day when empty
+}
+
+// initNotificationPrefs adds the notification preferences table
+func initNotificationPrefs() {
+	createNotificationPrefsTable()
+}
+
+// Create `notification_preferences` table if it doesn't exist
+func createNotificationPrefsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS notification_preferences (
+			user_id TEXT PRIMARY KEY,
+			preferences JSONB NOT NULL DEFAULT '{}',
+			updated_at TIMESTAMPTZ DEFAULT NOW()
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create notification_preferences table:", err)
+	}
+	log.Println("✅ Table notification_preferences is ready")
+}
+
+// getNotificationPrefs returns a user's preferences, empty if they haven't saved any
+func getNotificationPrefs(user string) (*NotificationPrefs, error) {
+	prefs := &NotificationPrefs{}
+	var updatedAt time.Time
+	err := db.QueryRow(context.Background(),
+		"SELECT preferences, updated_at FROM notification_preferences WHERE user_id = $1", user).Scan(prefs, &updatedAt)
+	if err == pgx.ErrNoRows {
+		return prefs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	prefs.UpdatedAt = &updatedAt
+	return prefs, nil
+}
+
+// validate checks and tidies preferences before they're saved
+func (p *NotificationPrefs) validate() error {
+	slices.Sort(p.MutedRooms)
+	p.MutedRooms = slices.Compact(p.MutedRooms)
+	if len(p.MutedRooms) > 1000 {
+		return fmt.Errorf("muted_rooms is limited to 1000 rooms")
+	}
+	if p.DND == nil {
+		return nil
+	}
+	return p.DND.validate("dnd")
+}
+
+// validate checks a schedule and lowercases its days; name prefixes the errors
+func (d *DNDSchedule) validate(name string) error {
+	if !clockTimePattern.MatchString(d.Start) || !clockTimePattern.MatchString(d.End) {
+		return fmt.Errorf(`%s start and end must be times such as "22:00"`, name)
+	}
+	if d.Start == d.End {
+		return fmt.Errorf("%s start and end must differ", name)
+	}
+	if _, err := time.LoadLocation(d.Timezone); err != nil {
+		return fmt.Errorf("%s timezone %q is not a known time zone", name, d.Timezone)
+	}
+	for i, day := range d.Days {
+		d.Days[i] = strings.ToLower(day)
+		if !containsString(weekdayNames, d.Days[i]) {
+			return fmt.Errorf("%s days must be among %s", name, strings.Join(weekdayNames, ", "))
+		}
+	}
+	return nil
+}
+
+// quiet reports whether the schedule's window covers a moment
+func (d *DNDSchedule) quiet(now time.Time) bool {
+	location, err := time.LoadLocation(d.Timezone)
+	if err != nil {
+		return false
+	}
+	local := now.In(location)
+	minutes := local.Hour()*60 + local.Minute()
+	start, end := clockMinutes(d.Start), clockMinutes(d.End)
+
+	// Past midnight, the window that covers the early hours started the day before
+	day := local.Weekday()
+	if start > end && minutes < end {
+		day = (day + 6) % 7
+	}
+	if len(d.Days) > 0 && !containsString(d.Days, weekdayNames[day]) {
+		return false
+	}
+	if start < end {
+		return minutes >= start && minutes < end
+	}
+	return minutes >= start || minutes < end
+}
+
+// clockMinutes turns a validated "HH:MM" into minutes past midnight
+func clockMinutes(hhmm string) int {
+	var hours, minutes int
+	fmt.Sscanf(hhmm, "%d:%d", &hours, &minutes)
+	return hours*60 + minutes
+}
+
+// mentions reports whether a message @mentions a user by name or display name
+func mentions(text, user, displayName string) bool {
+	lower := strings.ToLower(text)
+	for _, name := range []string{user, displayName} {
+		if name != "" && strings.Contains(lower, "@"+strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldNotify decides whether a user is told about a message in a room, and if not, why.
+// Preferences that can't be read don't hold a notification back.
+func shouldNotify(user string, roomID int, text string, now time.Time) (bool, string) {
+	prefs, err := getNotificationPrefs(user)
+	if err != nil {
+		log.Println("Error fetching notification preferences:", err)
+		return true, ""
+	}
+
+	reason := ""
+	switch {
+	case slices.Contains(prefs.MutedRooms, roomID):
+		reason = "muted"
+	case prefs.DNDUntil != nil && now.Before(*prefs.DNDUntil):
+		reason = "dnd"
+	case prefs.DND != nil && prefs.DND.quiet(now):
+		reason = "dnd"
+	case prefs.MentionOnly:
+		displayName := ""
+		if settings, err := getUserSettings(user); err == nil {
+			displayName = settings.DisplayName
+		}
+		if !mentions(text, user, displayName) {
+			reason = "not_mentioned"
+		}
+	}
+	if reason != "" {
+		addCounter("cubbychat_notifications_suppressed_total", "Notifications held back by user preferences, by reason", 1, "reason", reason)
+		return false, reason
+	}
+	return true, ""
+}
+
+// Handler for /api/me/notifications: the preferences of the user whose token the request
+// presents; fetch with GET, replace with PUT
+func handleNotificationPrefs(w http.ResponseWriter, r *http.Request) {
+	user := verifiedUser(r)
+	if user == "" {
+		http.Error(w, "Present your user token (X-User-Token)", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		prefs, err := getNotificationPrefs(user)
+		if err != nil {
+			http.Error(w, "Failed to fetch notification preferences", http.StatusInternalServerError)
+			log.Println("Error fetching notification preferences:", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(prefs)
+
+	case http.MethodPut:
+		var prefs NotificationPrefs
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&prefs); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := prefs.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.UpdatedAt = nil
+		var updatedAt time.Time
+		err := db.QueryRow(context.Background(), `
+			INSERT INTO notification_preferences (user_id, preferences) VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET preferences = EXCLUDED.preferences, updated_at = NOW()
+			RETURNING updated_at`, user, &prefs).Scan(&updatedAt)
+		if err != nil {
+			http.Error(w, "Failed to save notification preferences", http.StatusInternalServerError)
+			log.Println("Error saving notification preferences:", err)
+			return
+		}
+		prefs.UpdatedAt = &updatedAt
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(prefs)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	offlineQueueSize int           // Events kept per disconnected member; 0 disables the queue
+	offlineQueueTTL  time.Duration // How long events (and disconnected members) are kept
+)
+
+// roomMember identifies a named user in a room
+type roomMember struct {
+	room int
+	user string
+}
+
+// queuedEvent is an event held for a disconnected member
+type queuedEvent struct {
+	event WSEvent
+	at    time.Time
+}
+
+// offlineQueue holds the events a member missed while disconnected
+type offlineQueue struct {
+	since  time.Time
+	events []queuedEvent
+}
+
+// Queues of members who recently disconnected from a room
+var (
+	offlineMu     sync.Mutex
+	offlineQueues = make(map[roomMember]*offlineQueue)
+)
+
+// initOfflineQueue reads the offline queue bounds
+func initOfflineQueue() {
+	offlineQueueSize = max(0, getEnvInt("OFFLINE_QUEUE_SIZE", 100))
+	offlineQueueTTL = getEnvDuration("OFFLINE_QUEUE_TTL", 2*time.Minute)
+}
+
+// startOfflineQueue begins collecting room events for a user whose last session in the room closed
+func startOfflineQueue(s *Session) {
+	if offlineQueueSize == 0 || s.user == "" {
+		return
+	}
+	stillConnected := connectedSessions(func(other *Session) bool { return other.room == s.room && other.user == s.user })
+	if len(stillConnected) > 0 {
+		return
+	}
+
+	offlineMu.Lock()
+	defer offlineMu.Unlock()
+	member := roomMember{s.room, s.user}
+	if _, ok := offlineQueues[member]; !ok {
+		offlineQueues[member] = &offlineQueue{since: time.Now()}
+	}
+}
+
+// deliverOfflineQueue sends a reconnecting user the events they missed, oldest first
+func deliverOfflineQueue(s *Session) {
+	if s.user == "" {
+		return
+	}
+	offlineMu.Lock()
+	member := roomMember{s.room, s.user}
+	queue := offlineQueues[member]
+	delete(offlineQueues, member)
+	offlineMu.Unlock()
+	if queue == nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-offlineQueueTTL)
+	delivered := 0
+	for _, queued := range queue.events {
+		if queued.at.Before(cutoff) {
+			continue
+		}
+		if err := s.sendEvent(queued.event.Type, queued.event.Data); err != nil {
+			log.Println("Error delivering queued event:", err)
+			return
+		}
+		delivered++
+	}
+	if delivered > 0 {
+		log.Printf("📬 Delivered %d queued events to %s in room %d", delivered, s.user, s.room)
+	}
+}
+
+// publishRoomEvent sends an event to every session in a room except the sender
+// (nil for server-originated events) and queues it for members who disconnected recently
+func publishRoomEvent(roomID int, from *Session, eventType string, data interface{}) {
+	fromUser := ""
+	if from != nil {
+		fromUser = from.user
+	}
+	for _, s := range connectedSessions(func(s *Session) bool { return s.room == roomID && s != from }) {
+		if err := s.sendEvent(eventType, data); err != nil {
+			log.Printf("Error sending %s event: %v", eventType, err)
+		}
+	}
+
+	if offlineQueueSize == 0 {
+		return
+	}
+	offlineMu.Lock()
+	defer offlineMu.Unlock()
+	now := time.Now()
+	for member, queue := range offlineQueues {
+		// Members gone longer than the TTL are treated as having left
+		if now.Sub(queue.since) > offlineQueueTTL {
+			delete(offlineQueues, member)
+			continue
+		}
+		if member.room != roomID || (fromUser != "" && member.user == fromUser) {
+			continue
+		}
+		queue.events = append(queue.events, queuedEvent{event: WSEvent{Type: eventType, Data: data}, at: now})
+		if len(queue.events) > offlineQueueSize {
+			queue.events = queue.events[len(queue.events)-offlineQueueSize:]
+		}
+	}
+}
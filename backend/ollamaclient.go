@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+var (
+	ollamaAPIKey    string            // Bearer token for Ollama behind an authenticating proxy
+	ollamaUsername  string            // Basic auth user, used when no API key is set
+	ollamaPassword  string            // Basic auth password
+	ollamaHeaders   map[string]string // Extra headers sent with every Ollama request
+	ollamaTLSConfig *tls.Config       // Custom CA or verification settings; nil for the defaults
+)
+
+// initOllamaClient reads authentication, header and TLS settings for Ollama requests
+func initOllamaClient() {
+	ollamaAPIKey = getEnv("OLLAMA_API_KEY", "")
+	ollamaUsername = getEnv("OLLAMA_USERNAME", "")
+	ollamaPassword = getEnv("OLLAMA_PASSWORD", "")
+	ollamaHeaders = parseHeaderList(getEnv("OLLAMA_HEADERS", ""))
+
+	caFile := getEnv("OLLAMA_CA_FILE", "")
+	skipVerify := getEnvBool("OLLAMA_TLS_SKIP_VERIFY", false)
+	if caFile != "" || skipVerify {
+		ollamaTLSConfig = &tls.Config{InsecureSkipVerify: skipVerify}
+		if skipVerify {
+			log.Println("⚠️ TLS verification is disabled for Ollama requests")
+		}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			log.Fatal("❌ Failed to read OLLAMA_CA_FILE:", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatal("❌ OLLAMA_CA_FILE contains no certificates")
+		}
+		ollamaTLSConfig.RootCAs = pool
+	}
+
+	if ollamaAPIKey != "" || ollamaUsername != "" || len(ollamaHeaders) > 0 {
+		log.Printf("🔐 Ollama requests are authenticated (%d extra headers)", len(ollamaHeaders))
+	}
+}
+
+// parseHeaderList reads "Name=value,Other=value" into a header map
+func parseHeaderList(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		name, val, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			continue
+		}
+		headers[name] = strings.TrimSpace(val)
+	}
+	return headers
+}
+
+// newOllamaClient returns a resty client configured to reach Ollama
+func newOllamaClient() *resty.Client {
+	client := useProxy(resty.New(), ollamaProxy).SetHeaders(ollamaHeaders)
+	if ollamaAPIKey != "" {
+		client.SetAuthToken(ollamaAPIKey)
+	} else if ollamaUsername != "" {
+		client.SetBasicAuth(ollamaUsername, ollamaPassword)
+	}
+	if ollamaTLSConfig != nil {
+		client.SetTLSClientConfig(ollamaTLSConfig)
+	}
+	return client
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ollama residency stats, polled from /api/ps and /api/show
+var (
+	ollamaStatsInterval time.Duration // How often Ollama is polled; 0 disables polling
+	ollamaStatsMu       sync.RWMutex
+	ollamaStats         = OllamaStats{Models: []LoadedModel{}}
+	ollamaModelInfo     = map[string]ollamaShowInfo{} // /api/show results by model digest, which don't change
+)
+
+// OllamaStats is what Ollama last reported about the models it has in memory
+type OllamaStats struct {
+	Up        bool          `json:"up"`
+	CheckedAt time.Time     `json:"checked_at"`
+	Error     string        `json:"error,omitempty"`
+	VRAMBytes int64         `json:"vram_bytes"` // Total across loaded models
+	Models    []LoadedModel `json:"models"`
+}
+
+// LoadedModel is a model resident in Ollama's memory
+type LoadedModel struct {
+	Name              string    `json:"name"`
+	Digest            string    `json:"digest"`
+	SizeBytes         int64     `json:"size_bytes"`         // Total memory the model occupies
+	VRAMBytes         int64     `json:"vram_bytes"`         // The part of it on the GPU
+	ContextLength     int       `json:"context_length"`     // Context the model was loaded with, if Ollama reports it
+	MaxContextLength  int       `json:"max_context_length"` // Context the model was trained for
+	ParameterSize     string    `json:"parameter_size,omitempty"`
+	QuantizationLevel string    `json:"quantization_level,omitempty"`
+	ExpiresAt         time.Time `json:"expires_at"` // When Ollama unloads it if it stays idle
+}
+
+// ollamaShowInfo is the part of /api/show that's kept
+type ollamaShowInfo struct {
+	maxContext int
+	numCtx     int // num_ctx from the modelfile, 0 if not set
+}
+
+// initOllamaStats starts polling Ollama for loaded models
+func initOllamaStats() {
+	ollamaStatsInterval = getEnvDuration("OLLAMA_STATS_INTERVAL", 30*time.Second)
+	if !ollamaEnabled || ollamaStatsInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := clock.NewTicker(ollamaStatsInterval)
+		defer ticker.Stop()
+		for ; ; <-ticker.C() {
+			pollOllamaStats()
+		}
+	}()
+}
+
+// pollOllamaStats refreshes the stats and the cubbychat_ollama_* gauges
+func pollOllamaStats() {
+	var ps struct {
+		Models []struct {
+			Name          string    `json:"name"`
+			Digest        string    `json:"digest"`
+			Size          int64     `json:"size"`
+			SizeVRAM      int64     `json:"size_vram"`
+			ContextLength int       `json:"context_length"`
+			ExpiresAt     time.Time `json:"expires_at"`
+			Details       struct {
+				ParameterSize     string `json:"parameter_size"`
+				QuantizationLevel string `json:"quantization_level"`
+			} `json:"details"`
+		} `json:"models"`
+	}
+	stats := OllamaStats{CheckedAt: time.Now(), Models: []LoadedModel{}}
+	resp, err := newOllamaClient().SetTimeout(10 * time.Second).R().SetResult(&ps).Get(ollamaURL + "/api/ps")
+	if err == nil && resp.StatusCode() != http.StatusOK {
+		err = fmt.Errorf("ollama returned status %d", resp.StatusCode())
+	}
+
+	ollamaStatsMu.RLock()
+	previous := ollamaStats.Models
+	ollamaStatsMu.RUnlock()
+
+	if err != nil {
+		stats.Error = err.Error()
+	} else {
+		stats.Up = true
+		for _, m := range ps.Models {
+			info := showOllamaModel(m.Name, m.Digest)
+			model := LoadedModel{
+				Name: m.Name, Digest: m.Digest, SizeBytes: m.Size, VRAMBytes: m.SizeVRAM,
+				ContextLength: m.ContextLength, MaxContextLength: info.maxContext,
+				ParameterSize: m.Details.ParameterSize, QuantizationLevel: m.Details.QuantizationLevel, ExpiresAt: m.ExpiresAt,
+			}
+			if model.ContextLength == 0 {
+				model.ContextLength = info.numCtx
+			}
+			stats.VRAMBytes += m.SizeVRAM
+			stats.Models = append(stats.Models, model)
+		}
+	}
+
+	up := 0.0
+	if stats.Up {
+		up = 1
+	}
+	setGauge("cubbychat_ollama_up", "Whether Ollama answered the last stats poll", up)
+	setGauge("cubbychat_ollama_vram_bytes", "GPU memory used by all loaded Ollama models", float64(stats.VRAMBytes))
+	loaded := map[string]bool{}
+	for _, m := range stats.Models {
+		loaded[m.Name] = true
+		setGauge("cubbychat_ollama_model_loaded", "Whether a model is resident in Ollama's memory", 1, "model", m.Name)
+		setGauge("cubbychat_ollama_model_size_bytes", "Memory a loaded model occupies", float64(m.SizeBytes), "model", m.Name)
+		setGauge("cubbychat_ollama_model_vram_bytes", "GPU memory a loaded model occupies", float64(m.VRAMBytes), "model", m.Name)
+		setGauge("cubbychat_ollama_model_context_length", "Context length a model was loaded with", float64(m.ContextLength), "model", m.Name)
+		setGauge("cubbychat_ollama_model_expires_seconds", "Seconds until an idle model is unloaded", time.Until(m.ExpiresAt).Seconds(), "model", m.Name)
+	}
+	// Models that were unloaded since the last poll drop to zero
+	for _, m := range previous {
+		if !loaded[m.Name] {
+			setGauge("cubbychat_ollama_model_loaded", "Whether a model is resident in Ollama's memory", 0, "model", m.Name)
+			setGauge("cubbychat_ollama_model_size_bytes", "Memory a loaded model occupies", 0, "model", m.Name)
+			setGauge("cubbychat_ollama_model_vram_bytes", "GPU memory a loaded model occupies", 0, "model", m.Name)
+			setGauge("cubbychat_ollama_model_expires_seconds", "Seconds until an idle model is unloaded", 0, "model", m.Name)
+		}
+	}
+
+	ollamaStatsMu.Lock()
+	ollamaStats = stats
+	ollamaStatsMu.Unlock()
+}
+
+// showOllamaModel fetches a model's context settings, once per digest
+func showOllamaModel(name, digest string) ollamaShowInfo {
+	ollamaStatsMu.RLock()
+	info, ok := ollamaModelInfo[digest]
+	ollamaStatsMu.RUnlock()
+	if ok {
+		return info
+	}
+
+	var show struct {
+		Parameters string                 `json:"parameters"`
+		ModelInfo  map[string]interface{} `json:"model_info"`
+	}
+	resp, err := newOllamaClient().SetTimeout(10 * time.Second).R().
+		SetBody(map[string]string{"model": name}).SetResult(&show).Post(ollamaURL + "/api/show")
+	if err != nil || resp.StatusCode() != http.StatusOK {
+		log.Printf("Error fetching Ollama model info for %s: %v", name, err)
+		return info
+	}
+	for key, value := range show.ModelInfo {
+		if n, ok := value.(float64); ok && strings.HasSuffix(key, ".context_length") {
+			info.maxContext = int(n)
+		}
+	}
+	for _, line := range strings.Split(show.Parameters, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "num_ctx" {
+			info.numCtx, _ = strconv.Atoi(fields[1])
+		}
+	}
+
+	ollamaStatsMu.Lock()
+	ollamaModelInfo[digest] = info
+	ollamaStatsMu.Unlock()
+	return info
+}
+
+// Handler for /api/admin/ollama: the models Ollama has loaded, as of the last poll
+func getOllamaStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ollamaEnabled || ollamaStatsInterval <= 0 {
+		http.Error(w, "Ollama stats are disabled", http.StatusServiceUnavailable)
+		return
+	}
+	ollamaStatsMu.RLock()
+	stats := ollamaStats
+	ollamaStatsMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
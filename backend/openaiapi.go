@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// OpenAI-compatible API: /v1/chat/completions and /v1/models, so OpenAI SDKs and
+// tools can use cubbychat as their base URL
+var (
+	openAIAPIKeys map[string]string // API key → user the conversation is stored under
+	openAIAPIRoom int               // Room used when a request doesn't name one
+)
+
+// OpenAICompletionRequest is the body of POST /v1/chat/completions
+type OpenAICompletionRequest struct {
+	Model         string                 `json:"model"`
+	Messages      []OpenAIRequestMessage `json:"messages"`
+	Stream        bool                   `json:"stream"`
+	StreamOptions *OpenAIStreamOptions   `json:"stream_options,omitempty"`
+	User          string                 `json:"user,omitempty"`
+	Provider      string                 `json:"provider,omitempty"` // Not in OpenAI's API; see requestOverride
+	Temperature   *float64               `json:"temperature,omitempty"`
+	TopP          *float64               `json:"top_p,omitempty"`
+	MaxTokens     *int                   `json:"max_tokens,omitempty"`
+	Seed          *int                   `json:"seed,omitempty"`
+}
+
+// OpenAIRequestMessage is one message of a chat completion request. Content is a
+// string or a list of parts, of which only the text parts are used.
+type OpenAIRequestMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// OpenAICompletion is a chat completion, whole or as one streamed chunk
+type OpenAICompletion struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"` // "chat.completion" or "chat.completion.chunk"
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []OpenAIChoice `json:"choices"`
+	Usage   *OpenAIUsage   `json:"usage,omitempty"`
+}
+
+// OpenAIChoice is the single answer of a completion; Message is set on whole
+// completions and Delta on streamed chunks
+type OpenAIChoice struct {
+	Index        int                 `json:"index"`
+	Message      *OpenAIReplyMessage `json:"message,omitempty"`
+	Delta        *OpenAIReplyMessage `json:"delta,omitempty"`
+	FinishReason *string             `json:"finish_reason"`
+}
+
+// OpenAIReplyMessage is the assistant's message, or the part of it in a chunk
+type OpenAIReplyMessage struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+// OpenAIModel is one entry of GET /v1/models
+type OpenAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// initOpenAIAPI reads the API keys. OPENAI_API_KEYS is a list of "user=key" pairs (a bare
+// key stores messages as user "api"); the API is disabled while it's empty.
+func initOpenAIAPI() {
+	openAIAPIKeys = map[string]string{}
+	for _, entry := range splitList(getEnv("OPENAI_API_KEYS", "")) {
+		user, key, ok := strings.Cut(entry, "=")
+		if !ok {
+			user, key = "api", entry
+		}
+		user, key = strings.TrimSpace(user), strings.TrimSpace(key)
+		if user == "" || key == "" {
+			log.Fatalf("❌ Invalid OPENAI_API_KEYS entry (want user=key)")
+		}
+		openAIAPIKeys[key] = user
+	}
+	openAIAPIRoom = getEnvInt("OPENAI_API_ROOM", defaultRoomID)
+	if len(openAIAPIKeys) > 0 {
+		log.Printf("🔑 OpenAI-compatible API enabled for %d keys", len(openAIAPIKeys))
+	}
+}
+
+// writeOpenAIError answers in OpenAI's error format, which SDKs turn into typed exceptions
+func writeOpenAIError(w http.ResponseWriter, status int, errType, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"message": message, "type": errType, "code": code},
+	})
+}
+
+// openAIErrorType is the OpenAI error type matching a generation error code
+func openAIErrorType(code string) string {
+	switch code {
+	case errCodeContextOverflow, errCodeModelNotFound:
+		return "invalid_request_error"
+	case errCodeRateLimited:
+		return "rate_limit_error"
+	}
+	return "server_error"
+}
+
+// openAIOnly wraps an endpoint of the OpenAI-compatible API: it checks the bearer key
+// and passes on the user the key belongs to
+func openAIOnly(next func(w http.ResponseWriter, r *http.Request, user string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(openAIAPIKeys) == 0 {
+			writeOpenAIError(w, http.StatusServiceUnavailable, "server_error", "api_disabled", "The OpenAI-compatible API is disabled")
+			return
+		}
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		for key, user := range openAIAPIKeys {
+			if tokenMatches(token, key) {
+				next(w, r, user)
+				return
+			}
+		}
+		writeOpenAIError(w, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "Incorrect API key provided")
+	}
+}
+
+// text joins the text of a message's content, whether a string or a list of parts
+func (m OpenAIRequestMessage) text() string {
+	var s string
+	if json.Unmarshal(m.Content, &s) == nil {
+		return s
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	json.Unmarshal(m.Content, &parts)
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" && part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// openAIPrompt turns a request's messages into a prompt. The last message must come
+// from the user and is the one stored; system messages and earlier turns are put in
+// front of it as a transcript, since clients resend the whole conversation each time.
+func openAIPrompt(messages []OpenAIRequestMessage) (prompt, question string, err error) {
+	if len(messages) == 0 || messages[len(messages)-1].Role != "user" {
+		return "", "", errors.New("the last message must have the user role")
+	}
+	question = messages[len(messages)-1].text()
+	if strings.TrimSpace(question) == "" {
+		return "", "", errors.New("the last message has no text")
+	}
+	if len(messages) == 1 {
+		return question, question, nil
+	}
+
+	var b strings.Builder
+	for _, m := range messages[:len(messages)-1] {
+		switch m.Role {
+		case "system", "developer":
+			b.WriteString("Instructions: ")
+		case "assistant":
+			b.WriteString("Assistant: ")
+		default:
+			b.WriteString("User: ")
+		}
+		b.WriteString(m.text())
+		b.WriteString("\n\n")
+	}
+	b.WriteString("User: ")
+	b.WriteString(question)
+	return b.String(), question, nil
+}
+
+// openAIRoom picks the room from the X-Cubbychat-Room header or ?room=, defaulting to OPENAI_API_ROOM
+func openAIRoom(r *http.Request) (*Room, error) {
+	value := r.Header.Get("X-Cubbychat-Room")
+	if value == "" {
+		value = r.URL.Query().Get("room")
+	}
+	if value == "" {
+		return getRoom(openAIAPIRoom)
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, errRoomNotFound
+	}
+	return getRoom(id)
+}
+
+// Handler for GET /v1/models: the models answers can come from
+func listOpenAIModels(w http.ResponseWriter, r *http.Request, user string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	models := []OpenAIModel{}
+	seen := map[string]bool{}
+	for _, p := range providers {
+		if model := p.model(); model != "" && !seen[model] {
+			seen[model] = true
+			models = append(models, OpenAIModel{ID: model, Object: "model", OwnedBy: p.Name})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": models})
+}
+
+// Handler for POST /v1/chat/completions. The question and answer are stored in the
+// room like any other exchange, so they show up in its history and live clients.
+func createChatCompletion(w http.ResponseWriter, r *http.Request, user string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req OpenAICompletionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<20)).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "invalid_body", "Invalid request body")
+		return
+	}
+	prompt, question, err := openAIPrompt(req.Messages)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "invalid_messages", err.Error())
+		return
+	}
+	// API users are who their key says they are
+	if _, message := messageTooLong(question, user, false); message != "" {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", errCodeContextOverflow, message)
+		return
+	}
+	override, err := requestOverride(r, user, GenerationOverride{
+		Provider: req.Provider,
+		Model:    req.Model,
+		Params:   GenerationParams{Temperature: req.Temperature, TopP: req.TopP, MaxTokens: req.MaxTokens, Seed: req.Seed},
+	})
+	if errors.Is(err, errOverrideForbidden) {
+		writeOpenAIError(w, http.StatusForbidden, "permission_error", "override_forbidden", err.Error())
+		return
+	}
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "invalid_override", err.Error())
+		return
+	}
+	room, err := openAIRoom(r)
+	if err != nil {
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "room_not_found", "Room not found")
+		return
+	}
+	roomID := room.ID
+
+	// The session is headless: tokens go to the sink, which streams them as chunks when asked to.
+	// Managed rooms only answer keys whose user is a member.
+	s := &Session{room: roomID, user: user, identity: user, ip: clientIP(r)}
+	if !sessionHasRoomRole(s, roleMember, true) {
+		writeOpenAIError(w, http.StatusForbidden, "permission_error", "not_a_member", "The API key's user isn't a member of this room")
+		return
+	}
+	if code, message := roomStateRefuses(room, false); code != "" {
+		writeOpenAIError(w, http.StatusForbidden, "invalid_request_error", code, message)
+		return
+	}
+	if !roomAIAnswers(room, false) {
+		writeOpenAIError(w, http.StatusForbidden, "invalid_request_error", "ai_unavailable", "The AI doesn't answer in this room")
+		return
+	}
+	gen, err := prepareGeneration(roomID, user, user, prompt)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", errCodeContextOverflow,
+			fmt.Sprintf("The conversation is too long for the model (limit %d characters)", promptMaxChars))
+		return
+	}
+	gen.override = override
+
+	ack, _ := saveUserMessage(roomID, user, question, "", nil)
+	publishRoomEvent(roomID, nil, "message", ChatMessage{ID: ack.MessageID, Sender: "User", Message: question, Timestamp: ack.Timestamp, Incognito: ack.Incognito})
+
+	completion := OpenAICompletion{
+		ID:      fmt.Sprintf("chatcmpl-%d-%d", roomID, ack.MessageID),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+	}
+	if req.Stream {
+		streamChatCompletion(w, r, s, gen, completion, req.StreamOptions != nil && req.StreamOptions.IncludeUsage)
+		return
+	}
+
+	s.sink = func(frame outboundFrame) error { return nil }
+	defer s.close()
+	if err := generateWithFailover(s, gen); err != nil && !gen.partial() {
+		log.Println("Error generating API response:", err)
+		recordUsage(gen, 0, err)
+		recordFailedGeneration(gen, err)
+		code := classifyGenerationError(err)
+		writeOpenAIError(w, generationErrorStatuses[code], openAIErrorType(code), code, generationErrorMessage(code))
+		return
+	}
+	answer, usage := storeAPIResponse(gen)
+
+	stop := finishReason(gen)
+	completion.Model = gen.model
+	completion.Choices = []OpenAIChoice{{Message: &OpenAIReplyMessage{Role: "assistant", Content: answer}, FinishReason: &stop}}
+	completion.Usage = usage
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(completion)
+}
+
+// streamChatCompletion answers as server-sent "chat.completion.chunk" events ending in [DONE]
+func streamChatCompletion(w http.ResponseWriter, r *http.Request, s *Session, gen *generation, chunk OpenAICompletion, includeUsage bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "streaming_unsupported", "Streaming unsupported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	chunk.Object = "chat.completion.chunk"
+	writeChunk := func(data interface{}) error {
+		encoded, _ := json.Marshal(data)
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", encoded); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	delta := func(content string, role string, finish *string) OpenAICompletion {
+		c := chunk
+		c.Model = gen.model
+		c.Choices = []OpenAIChoice{{Delta: &OpenAIReplyMessage{Role: role, Content: content}, FinishReason: finish}}
+		return c
+	}
+
+	started := false
+	s.sink = func(frame outboundFrame) error {
+		if !frame.token || frame.messageType != websocket.TextMessage {
+			return nil // Events meant for the chat UI have no place in the completion
+		}
+		if !started {
+			started = true
+			if err := writeChunk(delta("", "assistant", nil)); err != nil {
+				return err
+			}
+		}
+		return writeChunk(delta(string(frame.data), "", nil))
+	}
+	defer s.close()
+
+	if err := generateWithFailover(s, gen); err != nil && !gen.partial() {
+		log.Println("Error generating API response:", err)
+		recordUsage(gen, 0, err)
+		recordFailedGeneration(gen, err)
+		code := classifyGenerationError(err)
+		writeChunk(map[string]interface{}{"error": map[string]string{
+			"message": generationErrorMessage(code), "type": openAIErrorType(code), "code": code}})
+		return
+	}
+	_, usage := storeAPIResponse(gen)
+
+	stop := finishReason(gen)
+	if !started {
+		writeChunk(delta("", "assistant", nil))
+	}
+	writeChunk(delta("", "", &stop))
+	if includeUsage {
+		final := chunk
+		final.Model = gen.model
+		final.Choices = []OpenAIChoice{}
+		final.Usage = usage
+		writeChunk(final)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// finishReason says why the answer ended: "stop", or "timeout" when a deadline cut it short
+func finishReason(gen *generation) string {
+	if gen.timeout != nil {
+		return "timeout"
+	}
+	return "stop"
+}
+
+// storeAPIResponse stores the answer to an API request and shows it to the room's live clients
+func storeAPIResponse(gen *generation) (string, *OpenAIUsage) {
+	answer, metadata, messageID := storeAIResponse(gen)
+	publishRoomEvent(gen.roomID, nil, "message", ChatMessage{ID: messageID, Sender: "AI", Message: answer, Timestamp: time.Now(), Metadata: metadata})
+	return answer, &OpenAIUsage{
+		PromptTokens:     gen.promptTokens,
+		CompletionTokens: gen.completionTokens,
+		TotalTokens:      gen.promptTokens + gen.completionTokens,
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	sendQueueSize      int           // Frames buffered per connection before the overflow policy applies
+	sendOverflowPolicy string        // What to do when a client falls behind: coalesce, drop or disconnect
+	sendWriteTimeout   time.Duration // Upper bound for one write to a client
+	pingInterval       time.Duration // How often idle clients are pinged; 0 disables keepalive
+)
+
+var (
+	errSessionClosed = errors.New("connection closed")
+	errSlowClient    = errors.New("client is not keeping up")
+)
+
+// outboundFrame is a frame waiting for the session's writer
+type outboundFrame struct {
+	messageType int
+	data        []byte
+	token       bool   // Streamed token text, which may be merged or dropped under backpressure
+	replaces    string // Supersedes a queued frame with the same key instead of queueing behind it
+	envelope    string // Event type raw text is wrapped in for v2 clients ("token" or "text"); see wsprotocol.go
+}
+
+// initOutbound reads the per-connection send queue settings
+func initOutbound() {
+	sendQueueSize = max(1, getEnvInt("SEND_QUEUE_SIZE", 256))
+	sendWriteTimeout = getEnvDuration("SEND_WRITE_TIMEOUT", 10*time.Second)
+	pingInterval = getEnvDuration("WS_PING_INTERVAL", 30*time.Second)
+
+	sendOverflowPolicy = getEnv("SEND_OVERFLOW_POLICY", "coalesce")
+	switch sendOverflowPolicy {
+	case "coalesce", "drop", "disconnect":
+	default:
+		log.Printf("⚠️ Unknown SEND_OVERFLOW_POLICY %q, using coalesce", sendOverflowPolicy)
+		sendOverflowPolicy = "coalesce"
+	}
+}
+
+// enqueue hands a frame to the writer without blocking the caller. When the
+// queue is full the overflow policy decides whether to merge, drop or disconnect.
+func (s *Session) enqueue(frame outboundFrame) error {
+	if s == nil {
+		return nil
+	}
+
+	s.queueMu.Lock()
+	if s.closed {
+		s.queueMu.Unlock()
+		return errSessionClosed
+	}
+	if s.sink != nil {
+		defer s.queueMu.Unlock()
+		return s.sink(frame)
+	}
+	if frame.replaces != "" {
+		for i := range s.queue {
+			if s.queue[i].replaces == frame.replaces {
+				s.queue[i].data = frame.data
+				s.queueMu.Unlock()
+				return nil
+			}
+		}
+	}
+	last := len(s.queue) - 1
+	switch {
+	case frame.token && sendOverflowPolicy == "coalesce" && last >= 0 && s.queue[last].token:
+		// The writer is behind anyway, so the client gets the tokens in one frame
+		s.queue[last].data = append(s.queue[last].data, frame.data...)
+	case len(s.queue) < sendQueueSize:
+		s.queue = append(s.queue, frame)
+	case frame.token && sendOverflowPolicy == "drop":
+		s.queueMu.Unlock()
+		addCounter("cubbychat_ws_frames_dropped_total", "Outbound WebSocket frames dropped for slow clients", 1)
+		return nil
+	case s.makeRoom():
+		s.queue = append(s.queue, frame)
+	default:
+		queued := len(s.queue)
+		s.queueMu.Unlock()
+		log.Printf("🐢 Disconnecting slow client in room %d (%d frames queued)", s.room, queued)
+		addCounter("cubbychat_ws_slow_disconnects_total", "WebSocket clients disconnected for falling behind", 1)
+		s.close()
+		return errSlowClient
+	}
+	s.queueMu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// makeRoom frees a queue slot according to the overflow policy; queueMu must be held
+func (s *Session) makeRoom() bool {
+	switch sendOverflowPolicy {
+	case "coalesce":
+		// Merge runs of token frames queued between events
+		compacted := s.queue[:0]
+		for _, f := range s.queue {
+			if n := len(compacted); f.token && n > 0 && compacted[n-1].token {
+				compacted[n-1].data = append(compacted[n-1].data, f.data...)
+				continue
+			}
+			compacted = append(compacted, f)
+		}
+		s.queue = compacted
+	case "drop":
+		// Events matter more than intermediate tokens; give up the oldest token frame
+		for i, f := range s.queue {
+			if f.token {
+				s.queue = append(s.queue[:i], s.queue[i+1:]...)
+				addCounter("cubbychat_ws_frames_dropped_total", "Outbound WebSocket frames dropped for slow clients", 1)
+				break
+			}
+		}
+	}
+	return len(s.queue) < sendQueueSize
+}
+
+// keepAlive expects a pong within two ping intervals, so dead peers end the read loop
+func (s *Session) keepAlive() {
+	if pingInterval <= 0 {
+		return
+	}
+	s.conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	})
+}
+
+// writeLoop is the only goroutine that writes frames to the session's connection.
+// Data frames, pings and the closing handshake all go through it, so they can't interleave.
+func (s *Session) writeLoop() {
+	defer s.conn.Close()
+
+	var ping <-chan time.Time
+	if pingInterval > 0 {
+		ticker := clock.NewTicker(pingInterval)
+		defer ticker.Stop()
+		ping = ticker.C()
+	}
+
+	for {
+		select {
+		case <-s.wake:
+		case <-ping:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(sendWriteTimeout)); err != nil {
+				log.Println("WebSocket ping error:", err)
+				s.close()
+				return
+			}
+			continue
+		case <-s.done:
+			s.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			return
+		}
+
+		for {
+			s.queueMu.Lock()
+			if len(s.queue) == 0 {
+				s.queueMu.Unlock()
+				break
+			}
+			frame := s.queue[0]
+			s.queue = s.queue[1:]
+			s.queueMu.Unlock()
+
+			s.conn.SetWriteDeadline(time.Now().Add(sendWriteTimeout))
+			if err := s.conn.WriteMessage(s.encodeFrame(frame)); err != nil {
+				log.Println("WebSocket write error:", err)
+				s.close()
+				return
+			}
+		}
+	}
+}
+
+// close stops the writer, which says goodbye and closes the connection; that also ends the read loop
+func (s *Session) close() {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.queue = nil
+	if s.done != nil {
+		close(s.done)
+	}
+}
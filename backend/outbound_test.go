@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// withSendQueue sets the send queue settings for one test and puts the old ones back after it
+func withSendQueue(t *testing.T, size int, policy string) {
+	t.Helper()
+	oldSize, oldPolicy := sendQueueSize, sendOverflowPolicy
+	t.Cleanup(func() { sendQueueSize, sendOverflowPolicy = oldSize, oldPolicy })
+	sendQueueSize, sendOverflowPolicy = size, policy
+}
+
+func tokenFrame(text string) outboundFrame {
+	return outboundFrame{data: []byte(text), token: true}
+}
+
+func eventFrame(text string) outboundFrame {
+	return outboundFrame{data: []byte(text)}
+}
+
+func TestEnqueueOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		frames     []outboundFrame
+		want       []string
+		wantClosed bool
+	}{
+		{"room in the queue", "disconnect", []outboundFrame{eventFrame("a"), tokenFrame("b")}, []string{"a", "b"}, false},
+		{"coalesce merges trailing tokens", "coalesce",
+			[]outboundFrame{eventFrame("e"), tokenFrame("a"), tokenFrame("b"), tokenFrame("c")}, []string{"e", "abc"}, false},
+		{"coalesce disconnects when nothing merges", "coalesce",
+			[]outboundFrame{tokenFrame("a"), eventFrame("e"), tokenFrame("b"), eventFrame("f")}, []string{"a", "e", "b"}, true},
+		{"drop discards new tokens when full", "drop",
+			[]outboundFrame{eventFrame("e"), tokenFrame("a"), tokenFrame("b"), tokenFrame("c")}, []string{"e", "a", "b"}, false},
+		{"drop gives up the oldest token for an event", "drop",
+			[]outboundFrame{eventFrame("e"), tokenFrame("a"), tokenFrame("b"), eventFrame("f")}, []string{"e", "b", "f"}, false},
+		{"drop disconnects when only events are queued", "drop",
+			[]outboundFrame{eventFrame("e"), eventFrame("f"), eventFrame("g"), eventFrame("h")}, nil, true},
+		{"disconnect when full", "disconnect",
+			[]outboundFrame{tokenFrame("a"), tokenFrame("b"), tokenFrame("c"), tokenFrame("d")}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withSendQueue(t, 3, tt.policy)
+			s := &Session{wake: make(chan struct{}, 1)}
+			var err error
+			for _, f := range tt.frames {
+				if err = s.enqueue(f); err != nil {
+					break
+				}
+			}
+			if closed := errors.Is(err, errSlowClient); closed != tt.wantClosed {
+				t.Fatalf("enqueue error = %v, want disconnect %v", err, tt.wantClosed)
+			}
+			if tt.wantClosed {
+				if !s.closed {
+					t.Error("slow client's session wasn't closed")
+				}
+				return
+			}
+			var got []string
+			for _, f := range s.queue {
+				got = append(got, string(f.data))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("queue = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("queue = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEnqueueReplacesQueuedFrame(t *testing.T) {
+	withSendQueue(t, 3, "disconnect")
+	s := &Session{wake: make(chan struct{}, 1)}
+	for _, f := range []outboundFrame{
+		{data: []byte("typing 1"), replaces: "typing"},
+		eventFrame("e"),
+		{data: []byte("typing 2"), replaces: "typing"},
+	} {
+		if err := s.enqueue(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.queue) != 2 || string(s.queue[0].data) != "typing 2" {
+		t.Errorf("queue has %d frames, first %q; want 2 with the newest typing frame first", len(s.queue), s.queue[0].data)
+	}
+}
+
+func TestEnqueueAfterClose(t *testing.T) {
+	s := &Session{wake: make(chan struct{}, 1)}
+	s.close()
+	if err := s.enqueue(eventFrame("e")); !errors.Is(err, errSessionClosed) {
+		t.Errorf("enqueue after close = %v, want %v", err, errSessionClosed)
+	}
+}
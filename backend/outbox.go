@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// The outbox records domain events in the same transaction as the change they describe,
+// and a relay delivers them in order to a webhook or NATS. Consumers can also page
+// through /api/admin/outbox. Kafka is reachable through its REST proxy as a webhook.
+var (
+	outboxEnabled       bool
+	outboxSink          string        // webhook, nats, or empty to only store events for polling
+	outboxWebhookURL    string        // Receives batches as {"events": [...]}
+	outboxWebhookSecret string        // Signs webhook bodies (X-Cubbychat-Signature: sha256=<hex>)
+	outboxNATSURL       string        // nats://[user:pass@]host:port
+	outboxNATSSubject   string        // Events are published to <subject>.<event type>
+	outboxBatchSize     int           // Events delivered per batch
+	outboxPollInterval  time.Duration // How often the relay looks for new events
+	outboxRetention     time.Duration // How long delivered events are kept
+)
+
+// OutboxEvent is one domain event
+type OutboxEvent struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"` // message.created, generation.completed, feedback.given, sla.breached
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// MessageCreatedEvent is the payload of message.created
+type MessageCreatedEvent struct {
+	RoomID int    `json:"room_id"`
+	User   string `json:"user,omitempty"`
+	ChatMessage
+}
+
+// GenerationCompletedEvent is the payload of generation.completed
+type GenerationCompletedEvent struct {
+	MessageID        int     `json:"message_id,omitempty"`
+	RoomID           int     `json:"room_id"`
+	User             string  `json:"user,omitempty"`
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	Status           string  `json:"status"`
+	ErrorCode        string  `json:"error_code,omitempty"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	LatencyMs        int64   `json:"latency_ms"`
+}
+
+// initOutbox reads the outbox settings, creates its table and starts the relay
+func initOutbox() {
+	outboxEnabled = getEnvBool("OUTBOX_ENABLED", false)
+	if !outboxEnabled {
+		return
+	}
+	outboxSink = getEnv("OUTBOX_SINK", "")
+	outboxWebhookURL = getEnv("OUTBOX_WEBHOOK_URL", "")
+	outboxWebhookSecret = getEnv("OUTBOX_WEBHOOK_SECRET", "")
+	outboxNATSURL = getEnv("OUTBOX_NATS_URL", "nats://nats:4222")
+	outboxNATSSubject = getEnv("OUTBOX_NATS_SUBJECT", "cubbychat.events")
+	outboxBatchSize = getEnvInt("OUTBOX_BATCH_SIZE", 100)
+	outboxPollInterval = getEnvDuration("OUTBOX_POLL_INTERVAL", 2*time.Second)
+	outboxRetention = getEnvDuration("OUTBOX_RETENTION", 7*24*time.Hour)
+
+	switch outboxSink {
+	case "webhook":
+		if outboxWebhookURL == "" {
+			log.Fatal("❌ OUTBOX_WEBHOOK_URL is required when OUTBOX_SINK=webhook")
+		}
+	case "nats", "":
+	default:
+		log.Fatalf("❌ Unknown OUTBOX_SINK %q (want webhook or nats)", outboxSink)
+	}
+
+	createOutboxTable()
+	if outboxSink == "" {
+		log.Println("📤 Outbox enabled; events are kept for polling")
+		return
+	}
+	go runOutboxRelay()
+	log.Printf("📤 Outbox enabled, relaying to %s", outboxSink)
+}
+
+// Create `outbox_events` table if it doesn't exist
+func createOutboxTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS outbox_events (
+			id BIGSERIAL PRIMARY KEY,
+			type TEXT NOT NULL,
+			payload JSONB NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			delivered_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS outbox_events_pending_idx ON outbox_events (id) WHERE delivered_at IS NULL;
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create outbox_events table:", err)
+	}
+	log.Println("✅ Table outbox_events is ready")
+}
+
+// recordEvent adds an event to the outbox within the caller's transaction, so it's
+// stored exactly when the change is. It does nothing while the outbox is disabled.
+func recordEvent(ctx context.Context, tx pgx.Tx, eventType string, payload interface{}) error {
+	if !outboxEnabled {
+		return nil
+	}
+	_, err := tx.Exec(ctx, "INSERT INTO outbox_events (type, payload) VALUES ($1, $2)", eventType, payload)
+	return err
+}
+
+// runOutboxRelay delivers pending events in batches, retrying a failed batch until it goes through
+func runOutboxRelay() {
+	var nats *natsPublisher
+	if outboxSink == "nats" {
+		nats = &natsPublisher{}
+	}
+	lastCleanup := time.Time{}
+
+	for {
+		delivered, err := relayOutboxBatch(nats)
+		if err != nil {
+			log.Println("Error delivering outbox events:", err)
+		}
+		if time.Since(lastCleanup) > time.Hour {
+			lastCleanup = time.Now()
+			if _, err := db.Exec(context.Background(),
+				"DELETE FROM outbox_events WHERE delivered_at < $1", time.Now().Add(-outboxRetention)); err != nil {
+				log.Println("Error pruning outbox:", err)
+			}
+		}
+		// Keep going while there's a backlog
+		if err != nil || delivered < outboxBatchSize {
+			time.Sleep(outboxPollInterval)
+		}
+	}
+}
+
+// relayOutboxBatch delivers the oldest pending events. The rows stay locked until they're
+// marked delivered, so several replicas can relay without sending an event twice.
+func relayOutboxBatch(nats *natsPublisher) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	delivered := 0
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT id, type, payload, created_at FROM outbox_events
+			WHERE delivered_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`, outboxBatchSize)
+		if err != nil {
+			return err
+		}
+		events, err := pgx.CollectRows(rows, pgx.RowToStructByPos[OutboxEvent])
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		if outboxSink == "nats" {
+			err = nats.publish(events)
+		} else {
+			err = postOutboxWebhook(ctx, events)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "UPDATE outbox_events SET delivered_at = NOW() WHERE id = ANY($1)", eventIDs(events))
+		delivered = len(events)
+		return err
+	})
+	return delivered, err
+}
+
+// eventIDs lists the ids of a batch
+func eventIDs(events []OutboxEvent) []int64 {
+	ids := make([]int64, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+// postOutboxWebhook sends a batch to the webhook, which must answer 2xx
+func postOutboxWebhook(ctx context.Context, events []OutboxEvent) error {
+	body, err := json.Marshal(map[string]interface{}{"events": events})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, outboxWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if outboxWebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(outboxWebhookSecret))
+		mac.Write(body)
+		req.Header.Set("X-Cubbychat-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// natsPublisher speaks just enough of the NATS text protocol to publish, reconnecting as needed
+type natsPublisher struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// connect dials the server and sends CONNECT with any credentials from the URL
+func (n *natsPublisher) connect() error {
+	u, err := url.Parse(outboxNATSURL)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS greeting %q: %v", strings.TrimSpace(line), err)
+	}
+
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "name": "cubbychat-outbox", "lang": "go", "version": Version}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			options["user"], options["pass"] = u.User.Username(), pass
+		} else {
+			options["auth_token"] = u.User.Username()
+		}
+	}
+	connect, _ := json.Marshal(options)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", connect); err != nil {
+		conn.Close()
+		return err
+	}
+	n.conn, n.reader = conn, reader
+	return nil
+}
+
+// publish sends each event to <subject>.<type> and waits for the server to confirm with a PONG
+func (n *natsPublisher) publish(events []OutboxEvent) error {
+	if n.conn == nil {
+		if err := n.connect(); err != nil {
+			return err
+		}
+	}
+	err := n.publishBatch(events)
+	if err != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+	return err
+}
+
+// publishBatch writes the batch on the open connection
+func (n *natsPublisher) publishBatch(events []OutboxEvent) error {
+	n.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	var buf bytes.Buffer
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "PUB %s.%s %d\r\n%s\r\n", outboxNATSSubject, e.Type, len(data), data)
+	}
+	buf.WriteString("PING\r\n")
+	if _, err := n.conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	for {
+		line, err := n.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			fmt.Fprint(n.conn, "PONG\r\n")
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("NATS: " + line)
+		}
+	}
+}
+
+// Handler for /api/admin/outbox: events after ?after= (an event id), oldest first, for consumers that poll
+func listOutboxEvents(w http.ResponseWriter, r *http.Request) {
+	if !outboxEnabled {
+		http.Error(w, "Outbox is disabled", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, type, payload, created_at FROM outbox_events WHERE id > $1 ORDER BY id LIMIT $2`, after, limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch outbox events", http.StatusInternalServerError)
+		log.Println("Error fetching outbox events:", err)
+		return
+	}
+	events, err := pgx.CollectRows(rows, pgx.RowToStructByPos[OutboxEvent])
+	if err != nil {
+		http.Error(w, "Error processing outbox events", http.StatusInternalServerError)
+		log.Println("Error scanning outbox events:", err)
+		return
+	}
+	if events == nil {
+		events = []OutboxEvent{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
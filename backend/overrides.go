@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Per-request overrides of the provider, model and sampling parameters, for
+// privileged API clients experimenting without changing room defaults
+var (
+	overrideUsers      []string // OpenAI API users allowed to override
+	overrideAllowlist  []string // "provider", "provider/model" or "provider/*" entries that may be picked
+	overrideParameters []string // Sampling parameters that may be set
+)
+
+var errOverrideForbidden = errors.New("overrides are not allowed for this client")
+
+// GenerationOverride changes how one request is answered
+type GenerationOverride struct {
+	Provider string           `json:"provider,omitempty"`
+	Model    string           `json:"model,omitempty"`
+	Params   GenerationParams `json:"params"`
+}
+
+// GenerationParams are sampling parameters; nil leaves the provider's default
+type GenerationParams struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+}
+
+// initOverrides reads who may override what. Without OVERRIDE_ALLOWLIST only the
+// parameters can be changed; "*" allows any configured provider and model.
+func initOverrides() {
+	overrideUsers = splitList(getEnv("OVERRIDE_USERS", ""))
+	overrideAllowlist = splitList(getEnv("OVERRIDE_ALLOWLIST", ""))
+	overrideParameters = splitList(getEnv("OVERRIDE_PARAMETERS", "temperature,top_p,max_tokens,seed"))
+	if len(overrideUsers) > 0 {
+		log.Printf("🧪 Per-request overrides enabled for %s", strings.Join(overrideUsers, ", "))
+	}
+}
+
+// findProvider looks up a provider in the failover chain by name
+func findProvider(name string) *Provider {
+	for _, p := range providers {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// overrideAllowed reports whether the allowlist lets a request pick this provider and model
+func overrideAllowed(provider, model string) bool {
+	for _, entry := range overrideAllowlist {
+		name, pattern, hasModel := strings.Cut(entry, "/")
+		if entry == "*" || (name == provider && (!hasModel || pattern == "*" || pattern == model)) {
+			return true
+		}
+	}
+	return false
+}
+
+// set lists the parameters that were given
+func (p GenerationParams) set() []string {
+	var names []string
+	if p.Temperature != nil {
+		names = append(names, "temperature")
+	}
+	if p.TopP != nil {
+		names = append(names, "top_p")
+	}
+	if p.MaxTokens != nil {
+		names = append(names, "max_tokens")
+	}
+	if p.Seed != nil {
+		names = append(names, "seed")
+	}
+	return names
+}
+
+// ollamaOptions maps the parameters onto Ollama's options
+func (p GenerationParams) ollamaOptions() map[string]interface{} {
+	options := map[string]interface{}{}
+	if p.Temperature != nil {
+		options["temperature"] = *p.Temperature
+	}
+	if p.TopP != nil {
+		options["top_p"] = *p.TopP
+	}
+	if p.MaxTokens != nil {
+		options["num_predict"] = *p.MaxTokens
+	}
+	if p.Seed != nil {
+		options["seed"] = *p.Seed
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	return options
+}
+
+// requestOverride reads an API request's overrides from the X-Cubbychat-Provider,
+// X-Cubbychat-Model and X-Cubbychat-Params headers, then the body's own fields.
+// Clients that aren't privileged get errOverrideForbidden if they send the headers;
+// the body fields are ones every OpenAI SDK sends, so for them they're ignored.
+func requestOverride(r *http.Request, user string, body GenerationOverride) (*GenerationOverride, error) {
+	headers := GenerationOverride{
+		Provider: r.Header.Get("X-Cubbychat-Provider"),
+		Model:    r.Header.Get("X-Cubbychat-Model"),
+	}
+	if params := r.Header.Get("X-Cubbychat-Params"); params != "" {
+		if err := json.Unmarshal([]byte(params), &headers.Params); err != nil {
+			return nil, fmt.Errorf("X-Cubbychat-Params is not valid JSON: %v", err)
+		}
+	}
+	fromHeaders := headers.Provider != "" || headers.Model != "" || len(headers.Params.set()) > 0
+	if !containsString(overrideUsers, user) {
+		if fromHeaders {
+			return nil, errOverrideForbidden
+		}
+		return nil, nil
+	}
+
+	// Headers win over the body
+	override := body
+	if headers.Provider != "" {
+		override.Provider = headers.Provider
+	}
+	if headers.Model != "" {
+		override.Model = headers.Model
+	}
+	for _, name := range headers.Params.set() {
+		switch name {
+		case "temperature":
+			override.Params.Temperature = headers.Params.Temperature
+		case "top_p":
+			override.Params.TopP = headers.Params.TopP
+		case "max_tokens":
+			override.Params.MaxTokens = headers.Params.MaxTokens
+		case "seed":
+			override.Params.Seed = headers.Params.Seed
+		}
+	}
+	for _, name := range override.Params.set() {
+		if !containsString(overrideParameters, name) {
+			return nil, fmt.Errorf("parameter %s may not be overridden", name)
+		}
+	}
+
+	// A model without a provider goes to the provider that serves it, or Ollama
+	if override.Provider == "" && override.Model != "" {
+		for _, p := range providers {
+			if p.model() == override.Model {
+				override.Provider = p.Name
+				break
+			}
+		}
+		if override.Provider == "" {
+			override.Provider = "ollama"
+		}
+	}
+	if override.Provider != "" {
+		p := findProvider(override.Provider)
+		if p == nil {
+			return nil, fmt.Errorf("unknown provider %q", override.Provider)
+		}
+		// Asking for the model the provider uses anyway isn't an override
+		if override.Model == p.model() {
+			override.Model = ""
+		}
+		if override.Model != "" || len(providers) > 1 {
+			if !overrideAllowed(p.Name, override.Model) {
+				return nil, fmt.Errorf("provider %s with model %q is not on the override allowlist", p.Name, override.Model)
+			}
+		}
+	}
+
+	if override.Provider == "" && len(override.Params.set()) == 0 {
+		return nil, nil
+	}
+	log.Printf("🧪 User %s overrides provider %q, model %q, parameters %v", user, override.Provider, override.Model, override.Params.set())
+	return &override, nil
+}
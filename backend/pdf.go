@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A small PDF writer for exports. It only uses the standard fonts every PDF reader has
+// (so nothing is embedded) and lays text out top to bottom on A4 pages, which is all a
+// transcript needs. Text is encoded as WinAnsi; characters outside it, emoji mostly,
+// are dropped.
+
+const (
+	pdfPageWidth  = 595.0 // A4, in points
+	pdfPageHeight = 842.0
+	pdfMargin     = 50.0
+)
+
+// pdfFont is one of the standard fonts the writer declares
+type pdfFont string
+
+const (
+	pdfRegular pdfFont = "F1" // Helvetica
+	pdfBold    pdfFont = "F2" // Helvetica-Bold
+	pdfItalic  pdfFont = "F3" // Helvetica-Oblique
+	pdfMono    pdfFont = "F4" // Courier
+)
+
+var pdfFontNames = []struct {
+	font pdfFont
+	name string
+}{{pdfRegular, "Helvetica"}, {pdfBold, "Helvetica-Bold"}, {pdfItalic, "Helvetica-Oblique"}, {pdfMono, "Courier"}}
+
+// Glyph widths of printable ASCII (32-126) in thousandths of the font size, from the fonts' AFM files
+var (
+	helveticaWidths = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	helveticaBoldWidths = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
+
+// winAnsiSpecials maps the characters WinAnsi puts in 0x80-0x9F
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B,
+	'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// pdfEncode converts text to WinAnsi, dropping emoji and other symbols it can't show
+// and replacing any other character it lacks with "?"
+func pdfEncode(text string) []byte {
+	out := make([]byte, 0, len(text))
+	for _, r := range text {
+		switch {
+		case r == '\t':
+			out = append(out, "    "...)
+		case r < 0x20 || r == 0x7F:
+			// Control characters have no glyph
+		case r < 0x80 || (r >= 0xA0 && r <= 0xFF):
+			out = append(out, byte(r))
+		case winAnsiSpecials[r] != 0:
+			out = append(out, winAnsiSpecials[r])
+		case unicode.Is(unicode.So, r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r) || (r >= 0xFE00 && r <= 0xFE0F):
+			// Emoji, variation selectors and joiners
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
+// pdfTextWidth measures WinAnsi text in points
+func pdfTextWidth(text []byte, font pdfFont, size float64) float64 {
+	if font == pdfMono {
+		return float64(len(text)) * 0.6 * size
+	}
+	widths := &helveticaWidths
+	if font == pdfBold {
+		widths = &helveticaBoldWidths
+	}
+	total := 0
+	for _, c := range text {
+		switch {
+		case c >= 32 && c <= 126:
+			total += widths[c-32]
+		case c == 0x95:
+			total += 350
+		case c == 0x97:
+			total += 1000
+		default:
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// pdfWrap breaks text into lines no wider than width, splitting words that don't fit on a line of their own
+func pdfWrap(text []byte, font pdfFont, size, width float64) [][]byte {
+	var lines [][]byte
+	var line []byte
+	for _, word := range bytes.Split(text, []byte(" ")) {
+		candidate := word
+		if len(line) > 0 {
+			candidate = append(append(append([]byte{}, line...), ' '), word...)
+		}
+		if pdfTextWidth(candidate, font, size) <= width {
+			line = candidate
+			continue
+		}
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+		for len(word) > 1 && pdfTextWidth(word, font, size) > width {
+			n := len(word) - 1
+			for n > 1 && pdfTextWidth(word[:n], font, size) > width {
+				n--
+			}
+			lines = append(lines, word[:n])
+			word = word[n:]
+		}
+		line = word
+	}
+	return append(lines, line)
+}
+
+// pdfColor is an RGB colour with components from 0 to 1
+type pdfColor struct{ r, g, b float64 }
+
+var (
+	pdfBlack    = pdfColor{0.13, 0.13, 0.13}
+	pdfGray     = pdfColor{0.53, 0.53, 0.53}
+	pdfCodeFill = pdfColor{0.965, 0.973, 0.98}
+)
+
+// pdfHexColor parses "#rgb" or "#rrggbb", falling back when the value isn't one
+func pdfHexColor(hex string, fallback pdfColor) pdfColor {
+	if !colorPattern.MatchString(hex) {
+		return fallback
+	}
+	hex = hex[1:]
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, _ := strconv.ParseUint(hex, 16, 32)
+	return pdfColor{float64(v>>16&0xFF) / 255, float64(v>>8&0xFF) / 255, float64(v&0xFF) / 255}
+}
+
+// pdfDocument lays out pages; y is the baseline of the next line, from the bottom
+type pdfDocument struct {
+	pages   []*bytes.Buffer
+	y       float64
+	newPage func(d *pdfDocument) // Draws each page's header, if set
+}
+
+// addPage starts a new page
+func (d *pdfDocument) addPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pdfPageHeight - pdfMargin
+	if d.newPage != nil {
+		d.newPage(d)
+	}
+}
+
+// ensureSpace starts a new page unless height points fit above the bottom margin
+func (d *pdfDocument) ensureSpace(height float64) {
+	if len(d.pages) == 0 || d.y-height < pdfMargin {
+		d.addPage()
+	}
+}
+
+// page is the content stream being written
+func (d *pdfDocument) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// text draws one line of WinAnsi text with its baseline at (x, y)
+func (d *pdfDocument) text(x, y float64, font pdfFont, size float64, color pdfColor, text []byte) {
+	fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.3f %.3f %.3f rg %.2f %.2f Td (%s) Tj ET\n",
+		font, size, color.r, color.g, color.b, x, y, pdfEscape(text))
+}
+
+// rect fills a rectangle whose lower left corner is (x, y)
+func (d *pdfDocument) rect(x, y, width, height float64, color pdfColor) {
+	fmt.Fprintf(d.page(), "%.3f %.3f %.3f rg %.2f %.2f %.2f %.2f re f\n", color.r, color.g, color.b, x, y, width, height)
+}
+
+// paragraph draws wrapped text at the left margin plus indent, moving down as it goes
+func (d *pdfDocument) paragraph(text string, font pdfFont, size, indent float64, color pdfColor) {
+	leading := size * 1.35
+	for _, line := range pdfWrap(pdfEncode(text), font, size, pdfPageWidth-2*pdfMargin-indent) {
+		d.ensureSpace(leading)
+		d.y -= leading
+		d.text(pdfMargin+indent, d.y, font, size, color, line)
+	}
+}
+
+// centered draws wrapped text centred between the margins
+func (d *pdfDocument) centered(text string, font pdfFont, size float64, color pdfColor) {
+	leading := size * 1.35
+	for _, line := range pdfWrap(pdfEncode(text), font, size, pdfPageWidth-2*pdfMargin) {
+		d.ensureSpace(leading)
+		d.y -= leading
+		d.text((pdfPageWidth-pdfTextWidth(line, font, size))/2, d.y, font, size, color, line)
+	}
+}
+
+// codeBlock draws preformatted lines in a monospaced font on a shaded background,
+// breaking lines too long for the page
+func (d *pdfDocument) codeBlock(code string, size float64) {
+	leading := size * 1.3
+	width := pdfPageWidth - 2*pdfMargin
+	columns := int((width - 12) / (0.6 * size))
+	var lines [][]byte
+	for _, line := range strings.Split(code, "\n") {
+		encoded := pdfEncode(line)
+		for len(encoded) > columns {
+			lines = append(lines, encoded[:columns])
+			encoded = encoded[columns:]
+		}
+		lines = append(lines, encoded)
+	}
+
+	d.y -= 4
+	for len(lines) > 0 {
+		d.ensureSpace(leading + 8)
+		// As many lines as fit on this page share one background
+		fit := min(len(lines), int((d.y-pdfMargin-8)/leading))
+		height := float64(fit)*leading + 8
+		d.rect(pdfMargin, d.y-height, width, height, pdfCodeFill)
+		for _, line := range lines[:fit] {
+			d.y -= leading
+			d.text(pdfMargin+6, d.y+2, pdfMono, size, pdfBlack, line)
+		}
+		d.y -= 8
+		lines = lines[fit:]
+	}
+	d.y -= 4
+}
+
+// pdfEscape makes WinAnsi text safe inside a PDF string literal
+func pdfEscape(text []byte) string {
+	var b strings.Builder
+	for _, c := range text {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7F:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// writeTo writes the document as a PDF file. footer, if set, gives each page's footer line.
+func (d *pdfDocument) writeTo(w io.Writer, title string, footer func(page, pages int) string) error {
+	if len(d.pages) == 0 {
+		d.addPage()
+	}
+	for i := range d.pages {
+		if footer != nil {
+			line := pdfEncode(footer(i+1, len(d.pages)))
+			fmt.Fprintf(d.pages[i], "BT /%s 8.0 Tf %.3f %.3f %.3f rg %.2f %.2f Td (%s) Tj ET\n", pdfRegular,
+				pdfGray.r, pdfGray.g, pdfGray.b, (pdfPageWidth-pdfTextWidth(line, pdfRegular, 8))/2, pdfMargin/2, pdfEscape(line))
+		}
+	}
+
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	out.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+
+	// 1 catalog, 2 page tree, 3 info, then the fonts, then a page and its content per page
+	fontsStart := 4
+	pagesStart := fontsStart + len(pdfFontNames)
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pagesStart+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object(fmt.Sprintf("<< /Title (%s) /Producer (Cubby Chat) >>", pdfEscape(pdfEncode(title))))
+	var fonts []string
+	for i, f := range pdfFontNames {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", f.name))
+		fonts = append(fonts, fmt.Sprintf("/%s %d 0 R", f.font, fontsStart+i))
+	}
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, strings.Join(fonts, " "), pagesStart+2*i+1))
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(content.Bytes())
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	_, err := out.WriteTo(w)
+	return err
+}
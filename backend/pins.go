@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A room's moderators can pin messages so they stay at hand, like house rules or an
+// answer that keeps coming up. Pins go when their message does.
+const maxRoomPins = 50
+
+// PinnedMessage is a pinned message and who pinned it
+type PinnedMessage struct {
+	MessageID int       `json:"message_id"`
+	Sender    string    `json:"sender"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+	PinnedBy  string    `json:"pinned_by"`
+	PinnedAt  time.Time `json:"pinned_at"`
+}
+
+// PinEvent tells a room's clients a message was pinned or unpinned
+type PinEvent struct {
+	RoomID    int  `json:"room_id"`
+	MessageID int  `json:"message_id"`
+	Pinned    bool `json:"pinned"`
+}
+
+// initPins creates the pinned messages table
+func initPins() {
+	createPinnedMessagesTable()
+}
+
+// Create `pinned_messages` table if it doesn't exist
+func createPinnedMessagesTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS pinned_messages (
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			message_id INTEGER NOT NULL REFERENCES chat_history(id) ON DELETE CASCADE,
+			pinned_by TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			PRIMARY KEY (room_id, message_id)
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create pinned_messages table:", err)
+	}
+	log.Println("✅ Table pinned_messages is ready")
+}
+
+// Handler for /api/rooms/{id}/pins: list the room's pinned messages with GET (members only
+// in a managed room), pin one with POST ({"message_id": 42}; the room's moderator role)
+func handleRoomPins(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if !requireRoomRole(w, r, room, roleMember, true) {
+			return
+		}
+		rows, err := db.Query(context.Background(), `
+			SELECT p.message_id, h.sender, `+messageText("h")+`, h.timestamp, p.pinned_by, p.created_at
+			FROM pinned_messages p JOIN chat_history h ON h.id = p.message_id
+			WHERE p.room_id = $1 ORDER BY p.created_at DESC`, room.ID)
+		if err != nil {
+			http.Error(w, "Failed to fetch pinned messages", http.StatusInternalServerError)
+			log.Println("Error fetching pinned messages:", err)
+			return
+		}
+		defer rows.Close()
+
+		pins := []PinnedMessage{}
+		for rows.Next() {
+			var p PinnedMessage
+			if err := rows.Scan(&p.MessageID, &p.Sender, &p.Message, &p.Timestamp, &p.PinnedBy, &p.PinnedAt); err != nil {
+				http.Error(w, "Error processing pinned messages", http.StatusInternalServerError)
+				log.Println("Error scanning pinned messages:", err)
+				return
+			}
+			pins = append(pins, p)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pins)
+
+	case http.MethodPost:
+		if !requireRoomRole(w, r, room, roleModerator, false) {
+			return
+		}
+		var req struct {
+			MessageID int `json:"message_id"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		var p PinnedMessage
+		err := db.QueryRow(context.Background(), `
+			INSERT INTO pinned_messages (room_id, message_id, pinned_by)
+			SELECT $1, h.id, $3 FROM chat_history h
+			WHERE h.id = $2 AND h.room_id = $1 AND (SELECT COUNT(*) FROM pinned_messages WHERE room_id = $1) < $4
+			ON CONFLICT (room_id, message_id) DO UPDATE SET pinned_by = pinned_messages.pinned_by
+			RETURNING message_id, pinned_by, created_at`, room.ID, req.MessageID, roomActor(r), maxRoomPins).
+			Scan(&p.MessageID, &p.PinnedBy, &p.PinnedAt)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Message not found in this room, or the room already has "+strconv.Itoa(maxRoomPins)+" pins", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to pin message", http.StatusInternalServerError)
+			log.Println("Error pinning message:", err)
+			return
+		}
+		recordAudit(roomActor(r), clientIP(r), "room.pin", strconv.Itoa(room.ID), map[string]int{"message_id": p.MessageID})
+		publishRoomEvent(room.ID, nil, "pin", PinEvent{RoomID: room.ID, MessageID: p.MessageID, Pinned: true})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(p)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Handler for /api/rooms/{id}/pins/{message}: unpin a message with DELETE (the room's
+// moderator role)
+func deleteRoomPin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+	messageID, err := strconv.Atoi(r.PathValue("message"))
+	if err != nil {
+		http.Error(w, "Invalid message id", http.StatusBadRequest)
+		return
+	}
+	if !requireRoomRole(w, r, room, roleModerator, false) {
+		return
+	}
+
+	tag, err := db.Exec(context.Background(), "DELETE FROM pinned_messages WHERE room_id = $1 AND message_id = $2", room.ID, messageID)
+	if err != nil {
+		http.Error(w, "Failed to unpin message", http.StatusInternalServerError)
+		log.Println("Error unpinning message:", err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Pinned message not found", http.StatusNotFound)
+		return
+	}
+	recordAudit(roomActor(r), clientIP(r), "room.unpin", strconv.Itoa(room.ID), map[string]int{"message_id": messageID})
+	publishRoomEvent(room.ID, nil, "pin", PinEvent{RoomID: room.ID, MessageID: messageID, Pinned: false})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Plugins are operator-provided WebAssembly modules in PLUGINS_DIR, run inside the server
+// by wazero. Every call starts a fresh instance of the module as a WASI command that reads
+// a JSON PluginRequest on stdin and answers with a JSON PluginResponse on stdout, so a
+// plugin can be written in any language that compiles to wasm32-wasi. The module sees no
+// files, sockets or server environment; its memory is capped and each call has a
+// deadline. Its only way out is the cubbychat.http_request host function, which reaches
+// just the hosts its manifest allows.
+var (
+	pluginsDir      string
+	pluginsMu       sync.RWMutex
+	plugins         []*Plugin
+	pluginTransport http.RoundTripper // Carries plugins' HTTP calls, through the upstream proxy
+)
+
+// Plugin hook points
+const (
+	pluginHookPrompt   = "prompt"   // Rewrite the prompt the model is sent
+	pluginHookResponse = "response" // Transform the finished answer
+	pluginHookMessage  = "message"  // Inspect a user message before it's stored; may block it
+	pluginHookCommand  = "command"  // Run one of the plugin's slash commands
+)
+
+// PluginManifest is a plugin's plugin.json
+type PluginManifest struct {
+	Name           string            `json:"name"`
+	Description    string            `json:"description,omitempty"`
+	Module         string            `json:"module,omitempty"`   // WASI module, relative to the plugin's directory; default plugin.wasm
+	Hooks          []string          `json:"hooks,omitempty"`    // prompt, response and/or message
+	Commands       []string          `json:"commands,omitempty"` // Slash commands it implements
+	Env            map[string]string `json:"env,omitempty"`      // The only environment the module sees
+	Timeout        string            `json:"timeout,omitempty"`  // Per call, HTTP calls included; default 5s
+	MaxOutputBytes int               `json:"max_output_bytes,omitempty"`
+	MaxConcurrency int               `json:"max_concurrency,omitempty"`
+	MaxMemoryMB    int               `json:"max_memory_mb,omitempty"` // Linear memory per call; default 64, at most 4096
+	AllowedHosts   []string          `json:"allowed_hosts,omitempty"` // Hosts, and their subdomains, http_request may reach
+	Enabled        *bool             `json:"enabled,omitempty"`       // Default for the plugin.<name> feature flag; true when unset
+}
+
+// Plugin is a loaded plugin with its limits and call statistics
+type Plugin struct {
+	PluginManifest
+	dir       string
+	loadedAt  time.Time
+	timeout   time.Duration
+	maxOutput int
+	maxMemory int                   // MB
+	slots     chan struct{}         // One per call allowed at once
+	runtime   wazero.Runtime        // Holds the memory limit and the host functions
+	module    wazero.CompiledModule // Instantiated once per call
+
+	mu        sync.Mutex
+	calls     int64
+	failures  int64
+	lastError string
+}
+
+// PluginStatus is a plugin as the admin API shows it
+type PluginStatus struct {
+	PluginManifest
+	Dir       string    `json:"dir"`
+	Flag      string    `json:"flag"`    // Feature flag that turns it on or off per room or user
+	Enabled   bool      `json:"enabled"` // The flag's value outside any room override
+	Calls     int64     `json:"calls"`
+	Failures  int64     `json:"failures"`
+	LastError string    `json:"last_error,omitempty"`
+	LoadedAt  time.Time `json:"loaded_at"`
+}
+
+// PluginHTTPRequest is what a plugin passes to cubbychat.http_request
+type PluginHTTPRequest struct {
+	Method  string            `json:"method,omitempty"` // Default GET
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// PluginHTTPResponse is what cubbychat.http_request hands back. Error is set instead of
+// the rest when the call wasn't allowed or failed.
+type PluginHTTPResponse struct {
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// PluginRequest is what a plugin reads on stdin
+type PluginRequest struct {
+	Hook    string `json:"hook"`
+	RoomID  int    `json:"room_id"`
+	User    string `json:"user"`
+	Text    string `json:"text"`              // The prompt, answer or message
+	Command string `json:"command,omitempty"` // For command calls
+	Args    string `json:"args,omitempty"`
+}
+
+// PluginResponse is what a plugin writes on stdout. Every field is optional.
+type PluginResponse struct {
+	Text   *string `json:"text,omitempty"`   // Replacement prompt or answer
+	Reply  string  `json:"reply,omitempty"`  // Shown to the user
+	Prompt string  `json:"prompt,omitempty"` // For commands: have the AI answer this
+	Block  bool    `json:"block,omitempty"`  // For message hooks: refuse the message
+	Reason string  `json:"reason,omitempty"` // Why it was blocked, shown to the user
+}
+
+var errPluginBusy = errors.New("plugin is at its concurrency limit")
+
+// initPlugins loads the plugins in PLUGINS_DIR. It runs after initFeatureFlags,
+// since each plugin adds a plugin.<name> flag default.
+func initPlugins() {
+	pluginsDir = getEnv("PLUGINS_DIR", "")
+	if pluginsDir == "" {
+		return
+	}
+	pluginTransport = &http.Transport{Proxy: upstreamProxy}
+	if err := loadPlugins(); err != nil {
+		log.Fatal("❌ Failed to load plugins:", err)
+	}
+}
+
+// loadPlugins reads every <PLUGINS_DIR>/<name>/plugin.json and replaces the loaded plugins
+func loadPlugins() error {
+	manifests, err := filepath.Glob(filepath.Join(pluginsDir, "*", "plugin.json"))
+	if err != nil {
+		return err
+	}
+
+	var loaded []*Plugin
+	names := map[string]bool{}
+	for _, path := range manifests {
+		p, err := loadPlugin(path)
+		if err == nil && names[p.Name] {
+			p.runtime.Close(context.Background())
+			err = fmt.Errorf("duplicate plugin name %q", p.Name)
+		}
+		if err != nil {
+			for _, p := range loaded {
+				p.runtime.Close(context.Background())
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		names[p.Name] = true
+		loaded = append(loaded, p)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].Name < loaded[j].Name })
+
+	for _, p := range loaded {
+		setFlagDefault("plugin."+p.Name, p.Enabled == nil || *p.Enabled)
+	}
+	pluginsMu.Lock()
+	previous := plugins
+	plugins = loaded
+	pluginsMu.Unlock()
+	for _, p := range previous {
+		go p.release()
+	}
+
+	for _, p := range loaded {
+		log.Printf("🧩 Loaded plugin %s (hooks: %s; commands: %s)", p.Name, strings.Join(p.Hooks, ", "), strings.Join(p.Commands, ", "))
+	}
+	return nil
+}
+
+// loadPlugin reads and checks one manifest
+func loadPlugin(path string) (*Plugin, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m PluginManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if !flagNamePattern.MatchString(m.Name) {
+		return nil, fmt.Errorf("invalid plugin name %q", m.Name)
+	}
+	if m.Module == "" {
+		m.Module = "plugin.wasm"
+	}
+	for _, hook := range m.Hooks {
+		if hook != pluginHookPrompt && hook != pluginHookResponse && hook != pluginHookMessage {
+			return nil, fmt.Errorf("unknown hook %q", hook)
+		}
+	}
+	for i, command := range m.Commands {
+		m.Commands[i] = strings.ToLower(strings.TrimPrefix(command, "/"))
+		if _, builtIn := chatCommands[m.Commands[i]]; builtIn {
+			return nil, fmt.Errorf("command /%s is already built in", m.Commands[i])
+		}
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	for i, host := range m.AllowedHosts {
+		m.AllowedHosts[i] = strings.ToLower(strings.TrimSpace(host))
+	}
+	p := &Plugin{PluginManifest: m, dir: dir, loadedAt: time.Now(), timeout: 5 * time.Second, maxOutput: 64 << 10, maxMemory: 64}
+	if m.Timeout != "" {
+		if p.timeout, err = time.ParseDuration(m.Timeout); err != nil || p.timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", m.Timeout)
+		}
+	}
+	if m.MaxOutputBytes > 0 {
+		p.maxOutput = m.MaxOutputBytes
+	}
+	if m.MaxMemoryMB > 4096 {
+		return nil, fmt.Errorf("max_memory_mb is at most 4096, the most a 32-bit module can address")
+	}
+	if m.MaxMemoryMB > 0 {
+		p.maxMemory = m.MaxMemoryMB
+	}
+	p.slots = make(chan struct{}, max(m.MaxConcurrency, 1))
+
+	wasm, err := os.ReadFile(filepath.Join(dir, m.Module))
+	if err != nil {
+		return nil, err
+	}
+	if err := p.compile(wasm); err != nil {
+		return nil, fmt.Errorf("%s: %w", m.Module, err)
+	}
+	return p, nil
+}
+
+// compile prepares the plugin's runtime, with its memory limit, WASI and the cubbychat host
+// module, and compiles its module into it
+func (p *Plugin) compile(wasm []byte) error {
+	ctx := context.Background()
+	config := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(p.maxMemory) * 16). // Pages are 64 KiB
+		WithCloseOnContextDone(true)                    // So a call can't outlive its deadline, even in a busy loop
+	p.runtime = wazero.NewRuntimeWithConfig(ctx, config)
+
+	_, err := wasi_snapshot_preview1.Instantiate(ctx, p.runtime)
+	if err == nil {
+		_, err = p.runtime.NewHostModuleBuilder("cubbychat").
+			NewFunctionBuilder().WithFunc(p.httpRequest).Export("http_request").
+			Instantiate(ctx)
+	}
+	if err == nil {
+		p.module, err = p.runtime.CompileModule(ctx, wasm)
+	}
+	if err != nil {
+		p.runtime.Close(ctx)
+		return err
+	}
+	return nil
+}
+
+// release frees a replaced plugin's runtime once the calls it's still running have finished
+func (p *Plugin) release() {
+	for range cap(p.slots) {
+		p.slots <- struct{}{}
+	}
+	p.runtime.Close(context.Background())
+}
+
+// activePlugins returns the loaded plugins using a hook or command that are enabled for a room and user
+func activePlugins(hook, command string, roomID int, user string) []*Plugin {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	var active []*Plugin
+	for _, p := range plugins {
+		if hook != "" && !containsString(p.Hooks, hook) || command != "" && !containsString(p.Commands, command) {
+			continue
+		}
+		if flagEnabled("plugin."+p.Name, roomID, user) {
+			active = append(active, p)
+		}
+	}
+	return active
+}
+
+// call runs the plugin once, within its time, output, concurrency and memory limits
+func (p *Plugin) call(ctx context.Context, req PluginRequest) (PluginResponse, error) {
+	select {
+	case p.slots <- struct{}{}:
+		defer func() { <-p.slots }()
+	default:
+		return PluginResponse{}, p.record(req.Hook, errPluginBusy)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	input, err := json.Marshal(req)
+	if err != nil {
+		return PluginResponse{}, err
+	}
+	stdout := &limitedBuffer{limit: p.maxOutput}
+	stderr := &limitedBuffer{limit: 4 << 10}
+	config := wazero.NewModuleConfig().
+		WithName(""). // Calls run side by side, so their instances go unnamed
+		WithArgs(p.Name).
+		WithEnv("CUBBYCHAT_PLUGIN", p.Name).
+		WithStdin(bytes.NewReader(input)).
+		WithStdout(stdout).
+		WithStderr(stderr).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader)
+	for key, value := range p.Env {
+		config = config.WithEnv(key, value)
+	}
+
+	// Instantiating runs the command's _start; one that returns or exits with 0 succeeded
+	module, err := p.runtime.InstantiateModule(ctx, p.module, config)
+	if module != nil {
+		module.Close(context.Background())
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		err = fmt.Errorf("timed out after %v", p.timeout)
+	case stdout.overflow:
+		err = fmt.Errorf("wrote more than %d bytes", p.maxOutput)
+	case err != nil && stderr.Len() > 0:
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return PluginResponse{}, p.record(req.Hook, err)
+	}
+
+	var resp PluginResponse
+	if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 {
+		if err := json.Unmarshal(out, &resp); err != nil {
+			return PluginResponse{}, p.record(req.Hook, fmt.Errorf("invalid response: %w", err))
+		}
+	}
+	return resp, p.record(req.Hook, nil)
+}
+
+// record counts a call and its outcome
+func (p *Plugin) record(hook string, err error) error {
+	p.mu.Lock()
+	p.calls++
+	result := "ok"
+	if err != nil {
+		p.failures++
+		p.lastError = err.Error()
+		result = "error"
+	}
+	p.mu.Unlock()
+
+	addCounter("cubbychat_plugin_calls_total", "Plugin calls by plugin, hook and result", 1, "plugin", p.Name, "hook", hook, "result", result)
+	if err != nil {
+		log.Printf("Error running plugin %s (%s): %v", p.Name, hook, err)
+	}
+	return err
+}
+
+// httpRequest is the cubbychat.http_request host function. It reads a JSON
+// PluginHTTPRequest of reqLen bytes at reqPtr in the plugin's memory, makes the call and
+// writes as much of the JSON PluginHTTPResponse as fits in respCap bytes at respPtr. It
+// returns the response's full length, so a plugin can tell when its buffer was too small,
+// or -1 when the pointers are out of bounds.
+func (p *Plugin) httpRequest(ctx context.Context, m api.Module, reqPtr, reqLen, respPtr, respCap uint32) int32 {
+	data, ok := m.Memory().Read(reqPtr, reqLen)
+	if !ok {
+		return -1
+	}
+	out, err := json.Marshal(p.fetch(ctx, data))
+	if err != nil {
+		return -1
+	}
+	if !m.Memory().Write(respPtr, out[:min(len(out), int(respCap))]) {
+		return -1
+	}
+	return int32(len(out))
+}
+
+// fetch makes an HTTP call for the plugin, refusing hosts its manifest doesn't allow.
+// Response bodies are text and held to the plugin's output limit.
+func (p *Plugin) fetch(ctx context.Context, data []byte) PluginHTTPResponse {
+	var req PluginHTTPRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return PluginHTTPResponse{Error: "invalid request: " + err.Error()}
+	}
+	target, err := url.Parse(req.URL)
+	if err != nil {
+		return PluginHTTPResponse{Error: "invalid url: " + err.Error()}
+	}
+	if err := p.checkHost(target); err != nil {
+		return PluginHTTPResponse{Error: err.Error()}
+	}
+	method := strings.ToUpper(req.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, target.String(), strings.NewReader(req.Body))
+	if err != nil {
+		return PluginHTTPResponse{Error: err.Error()}
+	}
+	for key, value := range req.Headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	client := &http.Client{
+		Transport: pluginTransport,
+		CheckRedirect: func(next *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("stopped after 5 redirects")
+			}
+			return p.checkHost(next.URL)
+		},
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return PluginHTTPResponse{Error: err.Error()}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(p.maxOutput)+1))
+	if err != nil {
+		return PluginHTTPResponse{Error: err.Error()}
+	}
+	if len(body) > p.maxOutput {
+		return PluginHTTPResponse{Error: fmt.Sprintf("response is larger than %d bytes", p.maxOutput)}
+	}
+	headers := make(map[string]string, len(resp.Header))
+	for key := range resp.Header {
+		headers[key] = resp.Header.Get(key)
+	}
+	return PluginHTTPResponse{Status: resp.StatusCode, Headers: headers, Body: string(body)}
+}
+
+// checkHost allows http and https URLs on the plugin's allowed hosts
+func (p *Plugin) checkHost(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" || !domainMatches(u.Hostname(), p.AllowedHosts) {
+		return fmt.Errorf("host %q isn't in the plugin's allowed_hosts", u.Hostname())
+	}
+	return nil
+}
+
+// limitedBuffer collects output up to a limit and notes whether more was written
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(data []byte) (int, error) {
+	if room := b.limit - b.Len(); len(data) > room {
+		b.overflow = true
+		b.Buffer.Write(data[:max(room, 0)])
+		return len(data), nil
+	}
+	return b.Buffer.Write(data)
+}
+
+// applyPluginText runs text through every enabled plugin on a prompt or response hook.
+// A failing plugin leaves the text as it was.
+func applyPluginText(gen *generation, hook, text string) string {
+	for _, p := range activePlugins(hook, "", gen.roomID, gen.user) {
+		resp, err := p.call(gen.context(), PluginRequest{Hook: hook, RoomID: gen.roomID, User: gen.user, Text: text})
+		if err == nil && resp.Text != nil {
+			text = *resp.Text
+		}
+	}
+	return text
+}
+
+// checkMessagePlugins asks the enabled message plugins about a user message and
+// reports whether one blocked it, with the reason to show the user
+func checkMessagePlugins(s *Session, text string) (blocked bool, reason string) {
+	for _, p := range activePlugins(pluginHookMessage, "", s.room, s.user) {
+		resp, err := p.call(context.Background(), PluginRequest{Hook: pluginHookMessage, RoomID: s.room, User: s.user, Text: text})
+		if err != nil {
+			continue // Plugins fail open so a broken one doesn't silence the room
+		}
+		if resp.Reply != "" {
+			s.sendText(resp.Reply)
+		}
+		if resp.Block {
+			if resp.Reason == "" {
+				resp.Reason = "Your message was blocked"
+			}
+			return true, resp.Reason
+		}
+	}
+	return false, ""
+}
+
+// runPluginCommand runs a slash command implemented by an enabled plugin; false when none has it
+func runPluginCommand(s *Session, name, args string) bool {
+	active := activePlugins("", name, s.room, s.user)
+	if len(active) == 0 {
+		return false
+	}
+
+	p := active[0]
+	log.Printf("⚡ Running plugin command /%s (%s)", name, p.Name)
+	resp, err := p.call(context.Background(), PluginRequest{Hook: pluginHookCommand, RoomID: s.room, User: s.user, Command: name, Args: args})
+	if err != nil {
+		s.sendText(fmt.Sprintf("⚠️ /%s failed", name))
+		return true
+	}
+	if resp.Reply != "" {
+		s.sendText(resp.Reply)
+	}
+	if resp.Prompt != "" {
+		answerPrompt(s, resp.Prompt)
+	}
+	return true
+}
+
+// handlePlugins lists the loaded plugins (GET) or reloads them from PLUGINS_DIR (POST)
+func handlePlugins(w http.ResponseWriter, r *http.Request) {
+	if pluginsDir == "" {
+		http.Error(w, "Plugins are disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := loadPlugins(); err != nil {
+			http.Error(w, "Failed to reload plugins: "+err.Error(), http.StatusBadRequest)
+			log.Println("Error reloading plugins:", err)
+			return
+		}
+		recordAudit("admin", clientIP(r), "plugins.reload", pluginsDir, nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	list := make([]PluginStatus, 0, len(plugins))
+	for _, p := range plugins {
+		p.mu.Lock()
+		list = append(list, PluginStatus{
+			PluginManifest: p.PluginManifest,
+			Dir:            p.dir,
+			Flag:           "plugin." + p.Name,
+			Enabled:        flagEnabled("plugin."+p.Name, 0, ""),
+			Calls:          p.calls,
+			Failures:       p.failures,
+			LastError:      p.lastError,
+			LoadedAt:       p.loadedAt,
+		})
+		p.mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
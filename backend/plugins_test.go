@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Instructions for the _start of test modules
+var (
+	wasmWriteData = []byte{0x41, 1, 0x41, 0, 0x41, 1, 0x41, 8, 0x10, 0, 0x1a} // fd_write(stdout, the data's iovec) and drop the result
+	wasmSpin      = []byte{0x03, 0x40, 0x0c, 0, 0x0b}                         // Loop forever
+)
+
+// wasmModule assembles a WASI command whose _start runs body. Function 0 is fd_write, and
+// memory holds an iovec for data at offset 0 and the data itself at offset 16.
+func wasmModule(memoryPages int, data string, body ...byte) []byte {
+	uleb := func(n int) []byte { return binary.AppendUvarint(nil, uint64(n)) }
+	name := func(s string) []byte { return append(uleb(len(s)), s...) }
+	section := func(id byte, parts ...[]byte) []byte {
+		var content []byte
+		for _, part := range parts {
+			content = append(content, part...)
+		}
+		return append(append([]byte{id}, uleb(len(content))...), content...)
+	}
+
+	code := append(append([]byte{0}, body...), 0x0b) // No locals
+	segment := binary.LittleEndian.AppendUint32(nil, 16)
+	segment = binary.LittleEndian.AppendUint32(segment, uint32(len(data)))
+	segment = append(append(segment, make([]byte, 8)...), data...)
+
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	module = append(module, section(1, []byte{2, 0x60, 4, 0x7f, 0x7f, 0x7f, 0x7f, 1, 0x7f, 0x60, 0, 0})...)
+	module = append(module, section(2, []byte{1}, name("wasi_snapshot_preview1"), name("fd_write"), []byte{0x00, 0})...)
+	module = append(module, section(3, []byte{1, 1})...)
+	module = append(module, section(5, []byte{1, 0}, uleb(memoryPages))...)
+	module = append(module, section(7, []byte{2}, name("_start"), []byte{0x00, 1}, name("memory"), []byte{0x02, 0})...)
+	module = append(module, section(10, []byte{1}, uleb(len(code)), code)...)
+	module = append(module, section(11, []byte{1, 0, 0x41, 0, 0x0b}, uleb(len(segment)), segment)...)
+	return module
+}
+
+// writePlugin puts a manifest and module in a new plugin directory, returning the manifest's path
+func writePlugin(t *testing.T, manifest string, wasm []byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "plugin.wasm"), wasm, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "plugin.json")
+	if err := os.WriteFile(path, []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPluginCall(t *testing.T) {
+	tests := []struct {
+		name      string
+		manifest  string
+		wasm      []byte
+		wantReply string
+		wantErr   string
+	}{
+		{"replies on stdout", `{"name": "hello"}`, wasmModule(1, `{"reply": "hi"}`, wasmWriteData...), "hi", ""},
+		{"output is limited", `{"name": "hello", "max_output_bytes": 4}`, wasmModule(1, `{"reply": "hi"}`, wasmWriteData...), "", "wrote more than 4 bytes"},
+		{"busy loops are stopped", `{"name": "spin", "timeout": "100ms"}`, wasmModule(1, "", wasmSpin...), "", "timed out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := loadPlugin(writePlugin(t, tt.manifest, tt.wasm))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.runtime.Close(context.Background())
+
+			start := time.Now()
+			resp, err := p.call(context.Background(), PluginRequest{Hook: pluginHookMessage, Text: "hello"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				if time.Since(start) > 5*time.Second {
+					t.Errorf("call took %v", time.Since(start))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Reply != tt.wantReply {
+				t.Errorf("got reply %q, want %q", resp.Reply, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestLoadPluginMemoryLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		pages    int
+		wantErr  bool
+	}{
+		{"within the default limit", `{"name": "small"}`, 16, false},
+		{"over the default limit", `{"name": "large"}`, 2048, true},
+		{"within a raised limit", `{"name": "large", "max_memory_mb": 256}`, 2048, false},
+		{"limit beyond 32-bit memory", `{"name": "huge", "max_memory_mb": 8192}`, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := loadPlugin(writePlugin(t, tt.manifest, wasmModule(tt.pages, "")))
+			if err == nil {
+				p.runtime.Close(context.Background())
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPluginFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "http://elsewhere.invalid/", http.StatusFound)
+		case "/large":
+			w.Write([]byte(strings.Repeat("x", 100)))
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	p := &Plugin{PluginManifest: PluginManifest{AllowedHosts: []string{serverURL.Hostname()}}, maxOutput: 64}
+	tests := []struct {
+		name      string
+		request   PluginHTTPRequest
+		wantBody  string
+		wantError string
+	}{
+		{"allowed host", PluginHTTPRequest{URL: server.URL + "/"}, "ok", ""},
+		{"other host", PluginHTTPRequest{URL: "http://example.com/"}, "", "allowed_hosts"},
+		{"other scheme", PluginHTTPRequest{URL: "file:///etc/passwd"}, "", "unsupported scheme"},
+		{"redirect to another host", PluginHTTPRequest{URL: server.URL + "/redirect"}, "", "allowed_hosts"},
+		{"body over the output limit", PluginHTTPRequest{URL: server.URL + "/large"}, "", "larger than 64 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := json.Marshal(tt.request)
+			resp := p.fetch(context.Background(), data)
+			if tt.wantError != "" {
+				if !strings.Contains(resp.Error, tt.wantError) {
+					t.Errorf("got error %q, want one containing %q", resp.Error, tt.wantError)
+				}
+				return
+			}
+			if resp.Error != "" || resp.Status != http.StatusOK || resp.Body != tt.wantBody {
+				t.Errorf("got %+v, want status 200 and body %q", resp, tt.wantBody)
+			}
+		})
+	}
+}
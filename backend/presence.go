@@ -0,0 +1,206 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Presence and typing indicators. Clients' typing frames only update per-room state;
+// a single loop sends each changed room one aggregated "presence" event per interval,
+// so the fan-out stays bounded however fast people type or reconnect.
+var (
+	presenceEnabled   bool
+	presenceInterval  time.Duration // Longest a change waits before it's sent, and the least time between a room's events
+	presenceMaxNames  int           // Rooms with more people than this get counts without names
+	typingTimeout     time.Duration // How long a typing indicator lasts without a refresh
+	typingMinInterval time.Duration // Typing frames closer together than this from one connection are ignored
+
+	presenceMu    sync.Mutex
+	roomPresences = make(map[int]*roomPresence)
+)
+
+// PresenceEvent tells a room's clients who is connected and who is typing
+type PresenceEvent struct {
+	RoomID      int      `json:"room_id"`
+	Online      []string `json:"online,omitempty"` // Named users connected; omitted in busy rooms
+	OnlineCount int      `json:"online_count"`     // Users and guests connected
+	Typing      []string `json:"typing,omitempty"` // Named users typing; omitted in busy rooms
+	TypingCount int      `json:"typing_count"`
+
+	Avatars map[string]string `json:"avatars,omitempty"` // Avatar URL of each user in Online
+}
+
+// roomPresence is a room's connected clients and typing indicators, by client key
+type roomPresence struct {
+	sessions map[string]int       // Connections per client
+	names    map[string]string    // The client's user name, "" for guests
+	avatars  map[string]string    // The client's avatar URL, "" for guests
+	typing   map[string]time.Time // When each typing indicator expires
+	dirty    bool                 // Changed since the last event
+}
+
+// initPresence reads the presence settings and starts the loop that sends presence events
+func initPresence() {
+	presenceEnabled = getEnvBool("PRESENCE_ENABLED", true)
+	if !presenceEnabled {
+		return
+	}
+	presenceInterval = max(getEnvDuration("PRESENCE_INTERVAL", time.Second), 100*time.Millisecond)
+	presenceMaxNames = getEnvInt("PRESENCE_MAX_NAMES", 20)
+	typingTimeout = getEnvDuration("TYPING_TIMEOUT", 6*time.Second)
+	typingMinInterval = getEnvDuration("TYPING_MIN_INTERVAL", time.Second)
+	go presenceLoop()
+}
+
+// presenceFor returns a room's presence, creating it; presenceMu must be held
+func presenceFor(roomID int) *roomPresence {
+	p, ok := roomPresences[roomID]
+	if !ok {
+		p = &roomPresence{sessions: make(map[string]int), names: make(map[string]string), avatars: make(map[string]string), typing: make(map[string]time.Time)}
+		roomPresences[roomID] = p
+	}
+	return p
+}
+
+// presenceJoined counts a new connection in its room
+func presenceJoined(s *Session) {
+	if !presenceEnabled {
+		return
+	}
+	presenceMu.Lock()
+	defer presenceMu.Unlock()
+	p := presenceFor(s.room)
+	key := s.clientKey()
+	p.sessions[key]++
+	p.names[key] = s.displayName()
+	p.avatars[key] = s.avatar()
+	p.dirty = true
+}
+
+// presenceLeft stops counting a closed connection; the client's typing indicator goes with its last one
+func presenceLeft(s *Session) {
+	if !presenceEnabled {
+		return
+	}
+	presenceMu.Lock()
+	defer presenceMu.Unlock()
+	p := presenceFor(s.room)
+	key := s.clientKey()
+	if p.sessions[key]--; p.sessions[key] <= 0 {
+		delete(p.sessions, key)
+		delete(p.names, key)
+		delete(p.avatars, key)
+		delete(p.typing, key)
+	}
+	p.dirty = true
+}
+
+// handleTyping records that a client started or stopped typing. Refreshes that arrive
+// faster than typingMinInterval are dropped before they touch shared state.
+func handleTyping(s *Session, typing bool) {
+	if !presenceEnabled || shadowBanned(s) {
+		return
+	}
+	now := clock.Now()
+	if typing && now.Sub(s.lastTyping) < typingMinInterval {
+		addCounter("cubbychat_typing_frames_throttled_total", "Typing frames ignored for arriving too often", 1)
+		return
+	}
+	if typing {
+		s.lastTyping = now
+	} else {
+		s.lastTyping = time.Time{}
+	}
+
+	presenceMu.Lock()
+	defer presenceMu.Unlock()
+	p := presenceFor(s.room)
+	key := s.clientKey()
+	_, wasTyping := p.typing[key]
+	if typing {
+		p.typing[key] = now.Add(typingTimeout)
+	} else {
+		delete(p.typing, key)
+	}
+	// Refreshing an indicator that's already shown changes nothing anyone sees
+	if typing != wasTyping {
+		p.dirty = true
+	}
+}
+
+// presenceLoop sends the rooms that changed their presence once per interval
+func presenceLoop() {
+	ticker := clock.NewTicker(presenceInterval)
+	defer ticker.Stop()
+	for now := range ticker.C() {
+		for _, event := range collectPresence(now) {
+			roomSessions := connectedSessions(func(s *Session) bool { return s.room == event.RoomID && s.sink == nil })
+			for _, s := range roomSessions {
+				if err := s.sendLatestEvent("presence", event); err != nil && err != errSessionClosed {
+					log.Println("Error sending presence event:", err)
+				}
+			}
+			addCounter("cubbychat_presence_events_total", "Presence events sent, one per connection", float64(len(roomSessions)))
+		}
+	}
+}
+
+// collectPresence expires stale typing indicators and builds an event for each room that changed
+func collectPresence(now time.Time) []PresenceEvent {
+	presenceMu.Lock()
+	defer presenceMu.Unlock()
+	var events []PresenceEvent
+	for roomID, p := range roomPresences {
+		for key, expires := range p.typing {
+			if now.After(expires) {
+				delete(p.typing, key)
+				p.dirty = true
+			}
+		}
+		if !p.dirty {
+			continue
+		}
+		p.dirty = false
+		if len(p.sessions) == 0 {
+			delete(roomPresences, roomID)
+			continue // Nobody is left to tell
+		}
+
+		event := PresenceEvent{RoomID: roomID, OnlineCount: len(p.sessions), TypingCount: len(p.typing)}
+		if len(p.sessions) <= presenceMaxNames {
+			event.Online = presenceNames(p, p.sessions)
+			event.Typing = presenceNames(p, p.typing)
+			event.Avatars = presenceAvatars(p)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// presenceNames lists the named users among a set of client keys, sorted
+func presenceNames[V any](p *roomPresence, keys map[string]V) []string {
+	var names []string
+	for key := range keys {
+		if name := p.names[key]; name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// presenceAvatars maps each named user connected to the room to their avatar
+func presenceAvatars(p *roomPresence) map[string]string {
+	avatars := map[string]string{}
+	for key := range p.sessions {
+		if name, avatar := p.names[key], p.avatars[key]; name != "" && avatar != "" {
+			avatars[name] = avatar
+		}
+	}
+	if len(avatars) == 0 {
+		return nil
+	}
+	return avatars
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often a client waiting for the first token hears from the server; 0 disables it
+var progressInterval time.Duration
+
+// ProgressEvent reassures a client that a slow generation is still under way
+type ProgressEvent struct {
+	ElapsedMs   int64  `json:"elapsed_ms"`
+	Stage       string `json:"stage"`              // queued (waiting for a free slot) or waiting (for the first token)
+	Provider    string `json:"provider,omitempty"` // Provider being tried
+	ModelPhase  string `json:"model_phase"`        // The model's readiness, as in model_status events
+	QueueLength int    `json:"queue_length,omitempty"`
+}
+
+// generationStage is where a generation is, read by the progress goroutine
+type generationStage struct {
+	stage    string
+	provider string
+}
+
+// generationProgress sends progress events until the first token or the end of the generation
+type generationProgress struct {
+	stage atomic.Value // generationStage
+	done  chan struct{}
+	once  sync.Once
+}
+
+// initProgress reads the progress event interval
+func initProgress() {
+	progressInterval = getEnvDuration("PROGRESS_INTERVAL", 2*time.Second)
+}
+
+// startProgress begins sending "progress" events to the session every progressInterval
+func (g *generation) startProgress(s *Session) {
+	g.progress = &generationProgress{done: make(chan struct{})}
+	g.progress.stage.Store(generationStage{stage: "waiting"})
+	if progressInterval <= 0 || s == nil {
+		return
+	}
+
+	go func(p *generationProgress, started time.Time) {
+		ticker := clock.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C():
+			}
+			stage := p.stage.Load().(generationStage)
+			event := ProgressEvent{
+				ElapsedMs:   time.Since(started).Milliseconds(),
+				Stage:       stage.stage,
+				Provider:    stage.provider,
+				ModelPhase:  currentModelStatus().Phase,
+				QueueLength: ollamaLimiter.queueLength(),
+			}
+			if err := s.sendEvent("progress", event); err != nil {
+				log.Println("Error sending progress event:", err)
+				return
+			}
+		}
+	}(g.progress, g.started)
+}
+
+// setStage records what the generation is waiting on
+func (g *generation) setStage(stage, provider string) {
+	if g.progress != nil {
+		g.progress.stage.Store(generationStage{stage: stage, provider: provider})
+	}
+}
+
+// stopProgress ends the progress events; safe to call more than once
+func (g *generation) stopProgress() {
+	if g.progress != nil {
+		g.progress.once.Do(func() { close(g.progress.done) })
+	}
+}
+
+// queueLength is how many generations are waiting for a slot
+func (l *generationLimiter) queueLength() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.waiting)
+}
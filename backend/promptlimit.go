@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	promptMaxChars       int           // Longest prompt sent to the model, context included
+	promptTruncation     string        // What to do with an oversized prompt: reject, truncate or summarize
+	promptSummaryTimeout time.Duration // Upper bound for condensing context
+)
+
+var errPromptTooLarge = errors.New("prompt too large")
+
+// initPromptLimit reads the prompt size limit and truncation strategy
+func initPromptLimit() {
+	promptMaxChars = max(1, getEnvInt("PROMPT_MAX_CHARS", 16000))
+	promptTruncation = strings.ToLower(getEnv("PROMPT_TRUNCATION", "truncate"))
+	promptSummaryTimeout = getEnvDuration("PROMPT_SUMMARY_TIMEOUT", 60*time.Second)
+
+	switch promptTruncation {
+	case "reject", "truncate", "summarize":
+	default:
+		log.Printf("⚠️ Unknown PROMPT_TRUNCATION %q, using truncate", promptTruncation)
+		promptTruncation = "truncate"
+	}
+}
+
+// messageTooLarge reports whether a user message can never fit in a prompt
+func messageTooLarge(text string) bool {
+	return utf8.RuneCountInString(text) > promptMaxChars
+}
+
+// fitPrompt makes the model prompt fit the limit using the configured strategy.
+// It returns errPromptTooLarge when the prompt can't be made small enough.
+func fitPrompt(gen *generation) error {
+	size := utf8.RuneCountInString(gen.modelPrompt())
+	if size <= promptMaxChars {
+		return nil
+	}
+	log.Printf("✂️ Prompt is %d characters (limit %d), applying %s", size, promptMaxChars, promptTruncation)
+
+	switch promptTruncation {
+	case "reject":
+		return errPromptTooLarge
+	case "summarize":
+		if err := summarizeContext(gen); err != nil {
+			log.Println("Error summarizing context, truncating instead:", err)
+		}
+	}
+	truncateContext(gen)
+
+	if utf8.RuneCountInString(gen.modelPrompt()) > promptMaxChars {
+		return errPromptTooLarge
+	}
+	return nil
+}
+
+// truncateContext drops the least relevant memories, then the lowest-ranked
+// excerpts, until the prompt fits
+func truncateContext(gen *generation) {
+	for utf8.RuneCountInString(gen.modelPrompt()) > promptMaxChars {
+		switch {
+		case gen.contextSummary != "":
+			gen.contextSummary = ""
+		case len(gen.memories) > 0:
+			gen.memories = gen.memories[:len(gen.memories)-1]
+		case len(gen.retrieved) > 0:
+			gen.retrieved = gen.retrieved[:len(gen.retrieved)-1]
+		default:
+			return
+		}
+	}
+}
+
+// summarizeContext has the model condense memories and excerpts into a summary
+// that replaces them in the prompt. Citations still refer to the excerpts.
+func summarizeContext(gen *generation) error {
+	var context strings.Builder
+	if len(gen.memories) > 0 {
+		context.WriteString(memoryPrompt(gen.memories))
+	}
+	if len(gen.retrieved) > 0 {
+		context.WriteString(knowledgePrompt(gen.retrieved))
+	}
+	if context.Len() == 0 {
+		return nil
+	}
+
+	budget := promptMaxChars - utf8.RuneCountInString(gen.prompt) - 200
+	if budget <= 0 {
+		return errPromptTooLarge
+	}
+	prompt := fmt.Sprintf(`Condense the following background into at most %d characters. Keep every fact that could help
+answer the question, and keep excerpt numbers like [1] next to what they support. Reply with the summary only.
+
+Question: %s
+
+Background:
+%s`, budget, gen.prompt, context.String())
+
+	summary, err := generateOnce(ollamaModel, prompt, "", promptSummaryTimeout)
+	if err != nil {
+		return err
+	}
+	gen.contextSummary = strings.TrimSpace(summary)
+	return nil
+}
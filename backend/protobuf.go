@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// Just enough of the protobuf wire format for the Frame message in cubbychat.proto, so
+// token streams to native apps and bots skip JSON entirely
+const (
+	protoFieldType      = 1
+	protoFieldText      = 2
+	protoFieldData      = 3
+	protoFieldAudio     = 4
+	protoFieldClientID  = 5
+	protoFieldMessageID = 6
+	protoFieldSuggestID = 7
+	protoFieldScore     = 8
+
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+	protoWireFixed32 = 5
+)
+
+var errProtoMalformed = errors.New("malformed protobuf frame")
+
+// ProtoFrame is a decoded Frame message
+type ProtoFrame struct {
+	Type         string
+	Text         string
+	Data         []byte
+	Audio        []byte
+	ClientID     string
+	MessageID    int
+	SuggestionID int
+	Score        int
+}
+
+// appendProtoBytes appends a length-delimited field, leaving it out when empty as proto3 does
+func appendProtoBytes(b []byte, field int, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(field<<3|protoWireBytes))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// marshal encodes the frame in the protobuf wire format
+func (f *ProtoFrame) marshal() []byte {
+	b := make([]byte, 0, len(f.Type)+len(f.Text)+len(f.Data)+len(f.Audio)+len(f.ClientID)+16)
+	b = appendProtoBytes(b, protoFieldType, []byte(f.Type))
+	b = appendProtoBytes(b, protoFieldText, []byte(f.Text))
+	b = appendProtoBytes(b, protoFieldData, f.Data)
+	b = appendProtoBytes(b, protoFieldAudio, f.Audio)
+	b = appendProtoBytes(b, protoFieldClientID, []byte(f.ClientID))
+	if f.MessageID != 0 {
+		b = binary.AppendUvarint(b, uint64(protoFieldMessageID<<3|protoWireVarint))
+		b = binary.AppendUvarint(b, uint64(f.MessageID))
+	}
+	return b
+}
+
+// unmarshalProtoFrame decodes a Frame message, skipping fields it doesn't know
+func unmarshalProtoFrame(b []byte) (*ProtoFrame, error) {
+	f := &ProtoFrame{}
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errProtoMalformed
+		}
+		b = b[n:]
+		field, wire := int(key>>3), key&7
+
+		switch wire {
+		case protoWireVarint:
+			value, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errProtoMalformed
+			}
+			b = b[n:]
+			switch field {
+			case protoFieldMessageID:
+				f.MessageID = int(value)
+			case protoFieldSuggestID:
+				f.SuggestionID = int(value)
+			case protoFieldScore:
+				f.Score = int(value)
+			}
+		case protoWireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || length > uint64(len(b)-n) {
+				return nil, errProtoMalformed
+			}
+			value := b[n : n+int(length)]
+			b = b[n+int(length):]
+			switch field {
+			case protoFieldType:
+				f.Type = string(value)
+			case protoFieldText:
+				f.Text = string(value)
+			case protoFieldData:
+				f.Data = value
+			case protoFieldAudio:
+				f.Audio = value
+			case protoFieldClientID:
+				f.ClientID = string(value)
+			}
+		case protoWireFixed64:
+			if len(b) < 8 {
+				return nil, errProtoMalformed
+			}
+			b = b[8:]
+		case protoWireFixed32:
+			if len(b) < 4 {
+				return nil, errProtoMalformed
+			}
+			b = b[4:]
+		default:
+			return nil, fmt.Errorf("%w: unsupported wire type %d", errProtoMalformed, wire)
+		}
+	}
+	return f, nil
+}
+
+// protoFrameData encodes an outbound frame as a Frame message. Events are queued as JSON
+// envelopes; their data is passed through as JSON since events are rare next to tokens.
+func protoFrameData(frame outboundFrame) []byte {
+	switch {
+	case frame.messageType == websocket.BinaryMessage:
+		return (&ProtoFrame{Type: "audio", Audio: frame.data}).marshal()
+	case frame.envelope != "":
+		return (&ProtoFrame{Type: frame.envelope, Text: string(frame.data)}).marshal()
+	}
+	var event struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(frame.data, &event); err != nil {
+		return (&ProtoFrame{Type: "text", Text: string(frame.data)}).marshal()
+	}
+	return (&ProtoFrame{Type: event.Type, Data: event.Data}).marshal()
+}
+
+// handleProtoFrame handles a frame from a cubbychat.v2+proto client
+func handleProtoFrame(s *Session, messageType int, data []byte) {
+	if messageType != websocket.BinaryMessage {
+		s.sendError("invalid_frame", wsProtocolProto+" frames must be binary Frame messages; see cubbychat.proto")
+		return
+	}
+	frame, err := unmarshalProtoFrame(data)
+	if err != nil || frame.Type == "" {
+		s.sendError("invalid_frame", "Could not decode the Frame message")
+		return
+	}
+	if frame.Type == "audio" {
+		handleVoiceMessage(s, frame.Audio)
+		return
+	}
+	if len(frame.ClientID) > clientIDMaxLength {
+		frame.ClientID = frame.ClientID[:clientIDMaxLength]
+	}
+	handleClientFrame(s, &ClientFrame{Type: frame.Type, ClientID: frame.ClientID, Text: frame.Text, MessageID: frame.MessageID, SuggestionID: frame.SuggestionID, Score: frame.Score})
+}
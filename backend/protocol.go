@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// clientIDMaxLength bounds client-generated message ids
+const clientIDMaxLength = 64
+
+// ClientFrame is a structured frame sent by a WebSocket client. Plain text frames
+// are still accepted as messages from older clients.
+type ClientFrame struct {
+	Type         string `json:"type"`                    // "message", "ack", "typing", "typing_stop", "rate_conversation", or an operator's "takeover", "release", "suggest_reply" or "approve_reply"
+	ClientID     string `json:"client_id,omitempty"`     // Client-generated id used to deduplicate retries
+	Text         string `json:"text,omitempty"`          // Message text
+	MessageID    int    `json:"message_id,omitempty"`    // AI message being acknowledged, or survey being answered; see csat.go
+	SuggestionID int    `json:"suggestion_id,omitempty"` // Suggested reply being approved; see handoff.go
+	Score        int    `json:"score,omitempty"`         // Rating for a "rate_conversation" frame, from 1 to 5
+}
+
+// MessageAckEvent confirms a user message was stored with its id and timestamp, echoing any client id
+type MessageAckEvent struct {
+	ClientID  string    `json:"client_id,omitempty"`
+	MessageID int       `json:"message_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Duplicate bool      `json:"duplicate,omitempty"` // Already received earlier; not processed again
+	Incognito bool      `json:"incognito,omitempty"` // Not stored, so there is no message id; see incognito.go
+}
+
+// initProtocol adds the client id column used to deduplicate retried sends and
+// records which user sent each message. Client ids are unique per sender, so two
+// users who happen to pick the same id don't swallow each other's messages.
+func initProtocol() {
+	query := `
+		ALTER TABLE chat_history ADD COLUMN IF NOT EXISTS client_id TEXT;
+		ALTER TABLE chat_history ADD COLUMN IF NOT EXISTS user_id TEXT NOT NULL DEFAULT '';
+		DROP INDEX IF EXISTS chat_history_client_id_idx;
+		CREATE UNIQUE INDEX IF NOT EXISTS chat_history_user_client_id_idx ON chat_history (room_id, user_id, client_id) WHERE client_id IS NOT NULL;
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to add client ids to chat_history:", err)
+	}
+}
+
+// parseClientFrame decodes a structured client frame; ok is false for plain text messages
+func parseClientFrame(data []byte) (*ClientFrame, bool) {
+	if !strings.HasPrefix(string(data), `{"type":`) {
+		return nil, false
+	}
+	var frame ClientFrame
+	if err := json.Unmarshal(data, &frame); err != nil || frame.Type == "" {
+		return nil, false
+	}
+	if len(frame.ClientID) > clientIDMaxLength {
+		frame.ClientID = frame.ClientID[:clientIDMaxLength]
+	}
+	return &frame, true
+}
+
+// handleClientFrame dispatches a structured client frame
+func handleClientFrame(s *Session, frame *ClientFrame) {
+	switch frame.Type {
+	case "message":
+		log.Printf("Received message: %s\n", frame.Text)
+		handleUserMessage(s, frame.Text, frame.ClientID)
+	case "ack":
+		handleClientAck(s, frame.MessageID)
+	case "typing":
+		handleTyping(s, true)
+	case "typing_stop":
+		handleTyping(s, false)
+	case "takeover", "release":
+		handleTakeover(s, frame.Type == "takeover")
+	case "suggest_reply":
+		handleSuggestReply(s)
+	case "approve_reply":
+		handleApproveReply(s, frame)
+	case "rate_conversation":
+		handleRateConversation(s, frame)
+	default:
+		s.sendError("unknown_frame", "Unknown frame type "+frame.Type)
+	}
+}
+
+// saveUserMessage stores a user message with optional metadata. When the client supplied an id that was
+// already stored in this room for the same user, the existing record is returned with duplicate set. Nothing is stored in
+// an incognito room; the ack comes back flagged incognito and without an id.
+func saveUserMessage(roomID int, user, text, clientID string, metadata *MessageMetadata) (ack MessageAckEvent, duplicate bool) {
+	ack.ClientID = clientID
+	if incognitoRoom(roomID) {
+		ack.Timestamp, ack.Incognito = time.Now(), true
+		return ack, false
+	}
+	var client *string
+	if clientID != "" {
+		client = &clientID
+	}
+
+	log.Printf("saving message to database: %s", text)
+	ctx := context.Background()
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+			INSERT INTO chat_history (room_id, sender, message, user_id, client_id, metadata) VALUES ($1, 'User', $2, $3, $4, $5)
+			ON CONFLICT (room_id, user_id, client_id) WHERE client_id IS NOT NULL DO NOTHING
+			RETURNING id, timestamp`, roomID, text, user, client, metadata).Scan(&ack.MessageID, &ack.Timestamp)
+		if err != nil {
+			return err
+		}
+		return recordEvent(ctx, tx, "message.created", MessageCreatedEvent{RoomID: roomID, User: user,
+			ChatMessage: ChatMessage{ID: ack.MessageID, Sender: "User", Message: text, Timestamp: ack.Timestamp, Metadata: metadata, ClientID: clientID}})
+	})
+	if err == nil {
+		return ack, false
+	}
+	if client == nil {
+		log.Println("Error saving message:", err)
+		return ack, false
+	}
+
+	err = db.QueryRow(context.Background(),
+		"SELECT id, timestamp FROM chat_history WHERE room_id = $1 AND user_id = $2 AND client_id = $3", roomID, user, clientID).
+		Scan(&ack.MessageID, &ack.Timestamp)
+	if err != nil {
+		log.Println("Error saving message:", err)
+		return ack, false
+	}
+	ack.Duplicate = true
+	return ack, true
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	providers       []*Provider   // Providers tried in order for each answer
+	breakerFailures int           // Consecutive failures that open a provider's breaker
+	breakerCooldown time.Duration // How long an open breaker skips its provider
+)
+
+var errNoProvider = errors.New("no provider available")
+
+// Provider is an LLM backend that can answer prompts
+type Provider struct {
+	Name      string
+	Kind      string // "ollama", "openai" (any OpenAI-compatible chat completions API), "anthropic" or "gemini"
+	URL       string
+	Model     string
+	APIKey    string
+	MaxTokens int // Answer length limit, which the Anthropic API requires
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// OpenAIChatRequest is the body of an OpenAI-compatible /chat/completions call
+type OpenAIChatRequest struct {
+	Model         string               `json:"model"`
+	Messages      []OllamaChatMessage  `json:"messages"`
+	Stream        bool                 `json:"stream"`
+	StreamOptions *OpenAIStreamOptions `json:"stream_options,omitempty"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	MaxTokens     *int                 `json:"max_tokens,omitempty"`
+	Seed          *int                 `json:"seed,omitempty"`
+}
+
+// OpenAIStreamOptions asks for token usage at the end of a stream
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// OpenAIUsage is the token usage of a completion
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens,omitempty"`
+}
+
+// OpenAIChatChunk is one server-sent event of a streamed chat completion
+type OpenAIChatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *OpenAIUsage `json:"usage,omitempty"` // Only on the final chunk
+}
+
+// providerDefaultURLs are the API base URLs used when a provider doesn't set one
+var providerDefaultURLs = map[string]string{
+	"anthropic": "https://api.anthropic.com/v1",
+	"gemini":    "https://generativelanguage.googleapis.com/v1beta",
+}
+
+// initProviders reads the failover chain. PROVIDERS lists provider names in order;
+// "ollama" is the built-in Ollama, others are configured with PROVIDER_<NAME>_URL,
+// _MODEL, _API_KEY, _MAX_TOKENS and _KIND (openai, anthropic or gemini).
+func initProviders() {
+	breakerFailures = max(1, getEnvInt("BREAKER_FAILURES", 3))
+	breakerCooldown = getEnvDuration("BREAKER_COOLDOWN", 30*time.Second)
+
+	providers = nil
+	for _, name := range splitList(getEnv("PROVIDERS", "ollama")) {
+		if name == "ollama" {
+			providers = append(providers, &Provider{Name: name, Kind: "ollama"})
+			continue
+		}
+
+		prefix := "PROVIDER_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		kind := strings.ToLower(getEnv(prefix+"KIND", "openai"))
+		p := &Provider{
+			Name:      name,
+			Kind:      kind,
+			URL:       strings.TrimSuffix(getEnv(prefix+"URL", providerDefaultURLs[kind]), "/"),
+			Model:     getEnv(prefix+"MODEL", ""),
+			APIKey:    getEnv(prefix+"API_KEY", ""),
+			MaxTokens: getEnvInt(prefix+"MAX_TOKENS", 4096),
+		}
+		if (p.Kind != "openai" && p.Kind != "anthropic" && p.Kind != "gemini") || p.URL == "" || p.Model == "" {
+			log.Printf("⚠️ Skipping provider %s: it needs %sURL and %sMODEL and an openai, anthropic or gemini kind", name, prefix, prefix)
+			continue
+		}
+		providers = append(providers, p)
+	}
+
+	if len(providers) > 1 {
+		names := make([]string, len(providers))
+		for i, p := range providers {
+			names[i] = p.Name
+		}
+		log.Printf("🔀 Provider failover chain: %s", strings.Join(names, " → "))
+	}
+}
+
+// hasFallbackProvider reports whether answers can come from somewhere other than Ollama
+func hasFallbackProvider() bool {
+	for _, p := range providers {
+		if p.Kind != "ollama" {
+			return true
+		}
+	}
+	return false
+}
+
+// model returns the model the provider answers with
+func (p *Provider) model() string {
+	if p.Kind == "ollama" {
+		return ollamaModel
+	}
+	return p.Model
+}
+
+// available reports whether the provider should be tried: its breaker is closed
+// (or the cooldown has passed) and, for Ollama, the model is loaded
+func (p *Provider) available() bool {
+	if p.Kind == "ollama" && !modelReady.Load() {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Now().After(p.openUntil)
+}
+
+// recordResult updates the breaker after an attempt
+func (p *Provider) recordResult(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		p.failures = 0
+		return
+	}
+	p.failures++
+	if p.failures >= breakerFailures {
+		p.openUntil = time.Now().Add(breakerCooldown)
+		log.Printf("🔌 Breaker open for provider %s for %v", p.Name, breakerCooldown)
+	}
+}
+
+// providerOrder is the failover chain, starting with the room's provider if it has one
+func (g *generation) providerOrder() []*Provider {
+	if g.roomProvider == "" || findProvider(g.roomProvider) == nil {
+		return providers
+	}
+	order := []*Provider{findProvider(g.roomProvider)}
+	for _, p := range providers {
+		if p.Name != g.roomProvider {
+			order = append(order, p)
+		}
+	}
+	return order
+}
+
+// generateWithFailover answers with the first available provider. A provider that
+// fails before streaming anything is skipped in favor of the next one; once tokens
+// have reached the client the error is returned instead.
+func generateWithFailover(s *Session, gen *generation) error {
+	stop := gen.startDeadlines()
+	defer stop()
+	gen.startProgress(s)
+	defer gen.stopProgress()
+
+	err := errNoProvider
+	for _, p := range gen.providerOrder() {
+		// An override pins the provider, so there's nothing to fail over to
+		if gen.override != nil && gen.override.Provider != "" && gen.override.Provider != p.Name {
+			continue
+		}
+		if !p.available() {
+			continue
+		}
+		gen.provider, gen.model = p.Name, p.model()
+		if p.Name == gen.roomProvider && gen.roomModel != "" {
+			gen.model = gen.roomModel
+		}
+		if gen.override != nil && gen.override.Model != "" {
+			gen.model = gen.override.Model
+		}
+		gen.setStage("waiting", p.Name)
+
+		err = withRetries(s, gen, p, func() error {
+			switch p.Kind {
+			case "ollama":
+				gen.setStage("queued", p.Name)
+				release := ollamaLimiter.acquire(s)
+				defer release()
+				gen.setStage("waiting", p.Name)
+				return streamOllama(s, gen)
+			case "anthropic":
+				return streamAnthropic(s, gen, p)
+			case "gemini":
+				return streamGemini(s, gen, p)
+			}
+			return streamOpenAI(s, gen, p)
+		})
+		if p.Kind == "ollama" {
+			reportGeneration(err)
+		}
+		p.recordResult(err)
+
+		if err == nil || !gen.firstToken.IsZero() || gen.context().Err() != nil {
+			break
+		}
+		log.Printf("⚠️ Provider %s failed, trying the next one: %v", p.Name, err)
+		gen.failedProviders = append(gen.failedProviders, providerLabel(gen.provider, gen.model))
+		gen.failureCodes = append(gen.failureCodes, classifyGenerationError(err))
+		gen.resetOutput()
+	}
+	if cause := gen.checkDeadlines(); cause != nil {
+		return cause
+	}
+	return err
+}
+
+// streamOpenAI streams a chat completion from an OpenAI-compatible API into gen.response
+func streamOpenAI(s *Session, gen *generation, p *Provider) error {
+	params := gen.params()
+	req := newUpstreamClient().R().
+		SetContext(gen.context()).
+		SetHeader("Content-Type", "application/json").
+		SetBody(OpenAIChatRequest{
+			Model:         gen.model,
+			Messages:      []OllamaChatMessage{{Role: "user", Content: gen.modelPrompt()}},
+			Stream:        true,
+			StreamOptions: &OpenAIStreamOptions{IncludeUsage: true},
+			Temperature:   params.Temperature,
+			TopP:          params.TopP,
+			MaxTokens:     params.MaxTokens,
+			Seed:          params.Seed,
+		}).
+		SetDoNotParseResponse(true)
+	if p.APIKey != "" {
+		req.SetAuthToken(p.APIKey)
+	}
+
+	resp, err := req.Post(p.URL + "/chat/completions")
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", p.Name, err)
+	}
+	defer resp.RawBody().Close()
+	if resp.StatusCode() != 200 {
+		body, _ := io.ReadAll(io.LimitReader(resp.RawBody(), 4096))
+		return &upstreamStatusError{provider: p.Name, status: resp.StatusCode(), body: string(body)}
+	}
+
+	scanner := bufio.NewScanner(resp.RawBody())
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk OpenAIChatChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			log.Printf("Error parsing %s response: %v", p.Name, err)
+			continue
+		}
+		if chunk.Usage != nil {
+			gen.promptTokens += chunk.Usage.PromptTokens
+			gen.completionTokens += chunk.Usage.CompletionTokens
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			if err := gen.sendToken(s, choice.Delta.Content); err != nil {
+				return nil
+			}
+			gen.response += choice.Delta.Content
+		}
+	}
+	return scanner.Err()
+}
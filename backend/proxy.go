@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+	"golang.org/x/net/http/httpproxy"
+)
+
+var (
+	upstreamProxy func(*http.Request) (*url.URL, error) // Proxy for provider calls
+	ollamaProxy   func(*http.Request) (*url.URL, error) // Proxy for Ollama calls
+)
+
+// initProxy reads proxy settings for upstream calls. HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY are honored by default; UPSTREAM_PROXY sets one proxy for every upstream
+// and OLLAMA_PROXY overrides it for Ollama. Either can be "direct" to bypass proxies.
+func initProxy() {
+	upstreamProxy = proxyFunc("UPSTREAM_PROXY", http.ProxyFromEnvironment)
+	ollamaProxy = proxyFunc("OLLAMA_PROXY", upstreamProxy)
+}
+
+// proxyFunc builds the proxy function configured by an environment variable
+func proxyFunc(key string, fallback func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	value := strings.TrimSpace(getEnv(key, ""))
+	switch strings.ToLower(value) {
+	case "":
+		return fallback
+	case "direct", "none":
+		log.Printf("🌐 %s: connecting directly", key)
+		return nil
+	}
+
+	proxyURL, err := url.Parse(value)
+	if err != nil || proxyURL.Host == "" {
+		log.Fatalf("❌ Invalid %s %q", key, value)
+	}
+	log.Printf("🌐 %s: using proxy %s", key, proxyURL.Redacted())
+
+	// Hosts in NO_PROXY are still reached directly
+	config := httpproxy.Config{HTTPProxy: value, HTTPSProxy: value, NoProxy: getEnv("NO_PROXY", os.Getenv("no_proxy"))}
+	proxy := config.ProxyFunc()
+	return func(r *http.Request) (*url.URL, error) { return proxy(r.URL) }
+}
+
+// useProxy applies a proxy function to a resty client's transport
+func useProxy(client *resty.Client, proxy func(*http.Request) (*url.URL, error)) *resty.Client {
+	transport, err := client.Transport()
+	if err != nil {
+		log.Println("Error configuring proxy:", err)
+		return client
+	}
+	transport.Proxy = proxy
+	return client
+}
+
+// newUpstreamClient returns a resty client for calls to providers other than Ollama
+func newUpstreamClient() *resty.Client {
+	return useProxy(resty.New(), upstreamProxy)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+)
+
+var (
+	ragEnabled    bool    // Whether answers are grounded in uploaded documents
+	ragEmbedModel string  // Ollama embedding model
+	ragEmbedBatch int     // Chunks embedded per request
+	ragTopK       int     // Chunks added to the prompt
+	ragMinScore   float64 // Chunks less similar than this are ignored
+)
+
+// RetrievedChunk is a knowledge base excerpt selected for a prompt
+type RetrievedChunk struct {
+	ChunkID     int     `json:"chunk_id"`
+	DocumentID  int     `json:"document_id"`
+	Document    string  `json:"document"`
+	Page        int     `json:"page,omitempty"`
+	Start       int     `json:"start"`
+	End         int     `json:"end"`
+	Content     string  `json:"content"`
+	Score       float64 `json:"score"`
+	RerankScore float64 `json:"rerank_score,omitempty"`
+}
+
+// Citation tells the client which knowledge base excerpt an answer drew on
+type Citation struct {
+	Index       int     `json:"index"`
+	DocumentID  int     `json:"document_id"`
+	Document    string  `json:"document"`
+	Page        int     `json:"page,omitempty"`
+	Start       int     `json:"start"`
+	End         int     `json:"end"`
+	Score       float64 `json:"score"`
+	RerankScore float64 `json:"rerank_score,omitempty"`
+	Excerpt     string  `json:"excerpt"`
+}
+
+// CitationsEvent is delivered on the "citations" WebSocket event
+type CitationsEvent struct {
+	MessageID int        `json:"message_id"`
+	Citations []Citation `json:"citations"`
+}
+
+// initRAG reads retrieval settings and sets up document ingestion
+func initRAG() {
+	ragEnabled = getEnvBool("RAG_ENABLED", false)
+	ragEmbedModel = getEnv("RAG_EMBED_MODEL", "nomic-embed-text")
+	ragEmbedBatch = max(1, getEnvInt("RAG_EMBED_BATCH", 16))
+	ragTopK = max(1, getEnvInt("RAG_TOP_K", 4))
+	ragMinScore = getEnvFloat("RAG_MIN_SCORE", 0.3)
+
+	if !ragEnabled {
+		return
+	}
+	initDocuments()
+	initRerank()
+	initGrounding()
+	log.Printf("🔎 Retrieval enabled (embedding model: %s, top k: %d)", ragEmbedModel, ragTopK)
+}
+
+// embedTexts returns one embedding vector per input text using the retrieval model
+func embedTexts(texts []string) ([][]float32, error) {
+	vectors, _, err := embedWithModel(ragEmbedModel, texts)
+	return vectors, err
+}
+
+// cosineSimilarity compares two embedding vectors
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// retrieveChunks returns the k ingested chunks most similar to the query. Rooms with
+// knowledge bases bound only search those; other rooms search the shared pool.
+// Similarity is computed in Go over all candidate chunks, which is fine for modest knowledge bases.
+func retrieveChunks(query string, k, roomID int) ([]RetrievedChunk, error) {
+	embeddings, err := embedTexts([]string{query})
+	if err != nil {
+		return nil, err
+	}
+	queryEmbedding := embeddings[0]
+
+	rows, err := db.Query(context.Background(), `
+		SELECT c.id, c.document_id, d.filename, c.page, c.start_offset, c.end_offset, c.content, c.embedding
+		FROM document_chunks c JOIN documents d ON d.id = c.document_id
+		WHERE d.status = $1 AND (
+			d.knowledge_base_id IN (SELECT knowledge_base_id FROM room_knowledge_bases WHERE room_id = $2)
+			OR (d.knowledge_base_id IS NULL AND NOT EXISTS (SELECT 1 FROM room_knowledge_bases WHERE room_id = $2)))`,
+		documentReady, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []RetrievedChunk
+	for rows.Next() {
+		var c RetrievedChunk
+		var embedding []float32
+		if err := rows.Scan(&c.ChunkID, &c.DocumentID, &c.Document, &c.Page, &c.Start, &c.End, &c.Content, &embedding); err != nil {
+			return nil, err
+		}
+		c.Score = cosineSimilarity(queryEmbedding, embedding)
+		if c.Score >= ragMinScore {
+			chunks = append(chunks, c)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Score > chunks[j].Score })
+	if len(chunks) > k {
+		chunks = chunks[:k]
+	}
+	return chunks, nil
+}
+
+// retrieveKnowledge looks up context for the prompt; failures leave the prompt unchanged
+func retrieveKnowledge(gen *generation) {
+	k := ragTopK
+	if rerankEnabled {
+		k = rerankTopKIn
+	}
+	chunks, err := retrieveChunks(gen.prompt, k, gen.roomID)
+	if err != nil {
+		log.Println("Error retrieving knowledge:", err)
+		return
+	}
+
+	// Let the reranker pick the best candidates; fall back to vector order if it fails
+	if rerankEnabled {
+		reranked, err := rerankChunks(gen.prompt, chunks)
+		if err != nil {
+			log.Println("Error reranking knowledge:", err)
+			reranked = chunks[:min(len(chunks), rerankTopKOut)]
+		}
+		chunks = reranked
+	}
+	gen.retrieved = chunks
+}
+
+// knowledgePrompt formats retrieved excerpts for the model, numbered for citation
+func knowledgePrompt(chunks []RetrievedChunk) string {
+	var b strings.Builder
+	b.WriteString("Use the following excerpts from the knowledge base to answer, citing them by number like [1]. If they don't contain the answer, say so.\n\n")
+	for i, c := range chunks {
+		fmt.Fprintf(&b, "[%d] %s", i+1, c.Document)
+		if c.Page > 0 {
+			fmt.Fprintf(&b, " (page %d)", c.Page)
+		}
+		fmt.Fprintf(&b, ":\n%s\n\n", c.Content)
+	}
+	return b.String()
+}
+
+// citationsFor lists the excerpts given to the model, numbered as in the prompt
+func citationsFor(chunks []RetrievedChunk) []Citation {
+	citations := make([]Citation, len(chunks))
+	for i, c := range chunks {
+		excerpt := c.Content
+		if len(excerpt) > 300 {
+			excerpt = strings.ToValidUTF8(excerpt[:300], "") + "…"
+		}
+		citations[i] = Citation{
+			Index:       i + 1,
+			DocumentID:  c.DocumentID,
+			Document:    c.Document,
+			Page:        c.Page,
+			Start:       c.Start,
+			End:         c.End,
+			Score:       math.Round(c.Score*1000) / 1000,
+			RerankScore: math.Round(c.RerankScore*1000) / 1000,
+			Excerpt:     excerpt,
+		}
+	}
+	return citations
+}
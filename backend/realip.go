@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var (
+	trustedProxies []netip.Prefix // Reverse proxies whose forwarding headers are believed
+	realIPHeader   string         // X-Forwarded-For, X-Real-IP or Forwarded
+)
+
+// initRealIP reads which proxies are trusted to report the client address
+func initRealIP() {
+	for _, value := range splitList(getEnv("TRUSTED_PROXIES", "")) {
+		prefix, err := parsePrefix(value)
+		if err != nil {
+			log.Fatalf("❌ Invalid TRUSTED_PROXIES entry %q: %v", value, err)
+		}
+		trustedProxies = append(trustedProxies, prefix)
+	}
+
+	realIPHeader = http.CanonicalHeaderKey(getEnv("REAL_IP_HEADER", "X-Forwarded-For"))
+	switch realIPHeader {
+	case "X-Forwarded-For", "X-Real-Ip", "Forwarded":
+	default:
+		log.Fatalf("❌ Unknown REAL_IP_HEADER %q (use X-Forwarded-For, X-Real-IP or Forwarded)", realIPHeader)
+	}
+	if len(trustedProxies) > 0 {
+		log.Printf("🔁 Trusting %s from %d proxy ranges", realIPHeader, len(trustedProxies))
+	}
+}
+
+// trustedProxy reports whether an address belongs to a trusted proxy
+func trustedProxy(addr netip.Addr) bool {
+	return inPrefixes(addr.Unmap(), trustedProxies)
+}
+
+// parseHop reads an address from a forwarding header, which may carry a port, brackets or quotes
+func parseHop(value string) (netip.Addr, bool) {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.Trim(value, "[]"))
+	return addr.Unmap(), err == nil
+}
+
+// forwardedHops lists the addresses in the configured forwarding header, nearest client first
+func forwardedHops(r *http.Request) []string {
+	var hops []string
+	for _, line := range r.Header.Values(realIPHeader) {
+		for _, part := range strings.Split(line, ",") {
+			if realIPHeader == "Forwarded" {
+				// RFC 7239: for=<node> among ;-separated pairs
+				for _, pair := range strings.Split(part, ";") {
+					if name, value, ok := strings.Cut(strings.TrimSpace(pair), "="); ok && strings.EqualFold(name, "for") {
+						hops = append(hops, value)
+					}
+				}
+				continue
+			}
+			hops = append(hops, part)
+		}
+	}
+	return hops
+}
+
+// clientIP returns the address a request really came from. Requests from trusted
+// proxies are traced back through the forwarding header, skipping further trusted
+// hops from the right, so a client can't spoof its address by adding entries.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !trustedProxy(peer) {
+		return host
+	}
+
+	hops := forwardedHops(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			// Whatever is left of a malformed entry can't be trusted
+			break
+		}
+		if !trustedProxy(addr) || i == 0 {
+			return addr.String()
+		}
+	}
+	return peer.Unmap().String()
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Reply drafts are the AI's suggested replies to a customer in a room an operator took
+// over. Only staff see them. Each draft is kept with what became of it, sent as written,
+// sent after editing or discarded, so the suggestions' quality can be measured.
+const replyDraftColumns = "id, room_id, message_id, operator, draft, status, edited, similarity, sent_message_id, created_at, resolved_at"
+
+// ReplyDraft is a suggested reply and its outcome
+type ReplyDraft struct {
+	ID            int        `json:"id"`
+	RoomID        int        `json:"room_id"`
+	MessageID     *int       `json:"message_id"` // The customer message being answered
+	Operator      string     `json:"operator"`
+	Draft         string     `json:"draft"`
+	Status        string     `json:"status"`               // pending, sent or discarded
+	Edited        bool       `json:"edited"`               // Sent after the operator changed it
+	Similarity    *float64   `json:"similarity,omitempty"` // Share of the draft's words kept in what was sent
+	SentMessageID *int       `json:"sent_message_id,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ResolvedAt    *time.Time `json:"resolved_at,omitempty"`
+}
+
+// ReplyDraftStats summarizes how useful drafts have been
+type ReplyDraftStats struct {
+	Since          time.Time `json:"since"`
+	Drafted        int       `json:"drafted"`
+	Sent           int       `json:"sent"`
+	SentUnedited   int       `json:"sent_unedited"`
+	Discarded      int       `json:"discarded"`
+	Pending        int       `json:"pending"`
+	AcceptanceRate float64   `json:"acceptance_rate"` // Sent out of those resolved
+	MeanSimilarity *float64  `json:"mean_similarity"` // Over sent drafts
+}
+
+// initReplyDrafts creates the reply drafts table
+func initReplyDrafts() {
+	createReplyDraftsTable()
+}
+
+// Create `reply_drafts` table if it doesn't exist
+func createReplyDraftsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS reply_drafts (
+			id SERIAL PRIMARY KEY,
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			message_id INTEGER REFERENCES chat_history(id) ON DELETE SET NULL,
+			operator TEXT NOT NULL DEFAULT '',
+			draft TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			edited BOOLEAN NOT NULL DEFAULT FALSE,
+			similarity REAL,
+			sent_message_id INTEGER REFERENCES chat_history(id) ON DELETE SET NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			resolved_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS reply_drafts_room_idx ON reply_drafts (room_id, id);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create reply_drafts table:", err)
+	}
+	log.Println("✅ Table reply_drafts is ready")
+}
+
+// scanReplyDraft reads a row selected with replyDraftColumns
+func scanReplyDraft(row pgx.Row) (*ReplyDraft, error) {
+	var d ReplyDraft
+	var similarity *float32
+	err := row.Scan(&d.ID, &d.RoomID, &d.MessageID, &d.Operator, &d.Draft, &d.Status, &d.Edited, &similarity,
+		&d.SentMessageID, &d.CreatedAt, &d.ResolvedAt)
+	if similarity != nil {
+		value := float64(*similarity)
+		d.Similarity = &value
+	}
+	return &d, err
+}
+
+// createReplyDraft has the model draft the operator's reply to the room's latest customer message
+func createReplyDraft(roomID int, operator string) (*ReplyDraft, error) {
+	text, messageID, err := draftReply(roomID)
+	if err != nil {
+		return nil, err
+	}
+	var customerMessage *int
+	if messageID != 0 {
+		customerMessage = &messageID
+	}
+	draft, err := scanReplyDraft(db.QueryRow(context.Background(), `
+		INSERT INTO reply_drafts (room_id, message_id, operator, draft) VALUES ($1, $2, $3, $4)
+		RETURNING `+replyDraftColumns, roomID, customerMessage, operator, text))
+	if err != nil {
+		return nil, err
+	}
+	addCounter("cubbychat_reply_drafts_total", "Replies drafted for operators", 1)
+	return draft, nil
+}
+
+// pendingReplyDraft returns a room's draft while it can still be sent or discarded
+func pendingReplyDraft(roomID, id int) (*ReplyDraft, error) {
+	draft, err := scanReplyDraft(db.QueryRow(context.Background(),
+		"SELECT "+replyDraftColumns+" FROM reply_drafts WHERE id = $1 AND room_id = $2 AND status = 'pending'", id, roomID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return draft, nil
+}
+
+// claimReplyDraft atomically moves a pending draft to status ("sent" or "discarded"),
+// reporting false if another request resolved it first, so a draft is only ever sent once
+func claimReplyDraft(draft *ReplyDraft, status string) (bool, error) {
+	err := db.QueryRow(context.Background(), `
+		UPDATE reply_drafts SET status = $2, resolved_at = NOW()
+		WHERE id = $1 AND status = 'pending' RETURNING status`, draft.ID, status).Scan(&draft.Status)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// releaseReplyDraft makes a claimed draft pending again after sending it failed
+func releaseReplyDraft(draft *ReplyDraft) {
+	if _, err := db.Exec(context.Background(), "UPDATE reply_drafts SET status = 'pending', resolved_at = NULL WHERE id = $1", draft.ID); err != nil {
+		log.Println("Error releasing reply draft:", err)
+	}
+}
+
+// draftSimilarity is the share of the draft's distinctive words that are in the sent text
+func draftSimilarity(draft, sent string) float64 {
+	draftWords := groundingWords(draft)
+	if len(draftWords) == 0 {
+		return 1
+	}
+	sentWords := groundingWords(sent)
+	kept := 0
+	for word := range draftWords {
+		if sentWords[word] {
+			kept++
+		}
+	}
+	return float64(kept) / float64(len(draftWords))
+}
+
+// resolveReplyDraft records what became of a claimed draft: sent as a message, or
+// discarded when messageID is 0
+func resolveReplyDraft(draft *ReplyDraft, sent string, messageID int) error {
+	status := "discarded"
+	var edited bool
+	var similarity *float64
+	var sentMessage *int
+	if messageID != 0 {
+		status = "sent"
+		edited = strings.TrimSpace(sent) != strings.TrimSpace(draft.Draft)
+		value := draftSimilarity(draft.Draft, sent)
+		similarity, sentMessage = &value, &messageID
+	}
+	_, err := db.Exec(context.Background(), `
+		UPDATE reply_drafts SET status = $2, edited = $3, similarity = $4, sent_message_id = $5, resolved_at = NOW()
+		WHERE id = $1`, draft.ID, status, edited, similarity, sentMessage)
+	if err != nil {
+		return err
+	}
+	outcome := status
+	if edited {
+		outcome = "edited"
+	}
+	addCounter("cubbychat_reply_drafts_resolved_total", "Reply drafts by outcome: sent as drafted, edited or discarded", 1, "outcome", outcome)
+	return nil
+}
+
+// postStaffReply stores an operator's reply and shows it to the room's clients other than
+// the session that sent it, if any
+func postStaffReply(roomID int, from *Session, operator, text, clientID string) (MessageAckEvent, bool) {
+	metadata := &MessageMetadata{Staff: true}
+	if settings, err := getUserSettings(operator); err == nil {
+		metadata.Avatar = avatarURL(operator, settings)
+	}
+	ack, duplicate := saveUserMessage(roomID, operator, text, clientID, metadata)
+	if (ack.MessageID != 0 || ack.Incognito) && !duplicate {
+		publishRoomEvent(roomID, from, "message", ChatMessage{ID: ack.MessageID, Sender: "User", Message: text, Timestamp: ack.Timestamp, Metadata: metadata, ClientID: clientID, Incognito: ack.Incognito})
+		stopSLAFirstResponse(roomID)
+	}
+	return ack, duplicate
+}
+
+// Handler for /api/rooms/{id}/reply-drafts?user=operator: draft a reply to the latest
+// customer message with POST, list the room's drafts with GET (optionally ?status=)
+func handleReplyDrafts(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		if room.Metadata.Handoff == nil {
+			http.Error(w, "Take the room over before drafting replies", http.StatusConflict)
+			return
+		}
+		operator := requestUser(r)
+		if operator == "" {
+			operator = room.Metadata.Handoff.Operator
+		}
+		draft, err := createReplyDraft(room.ID, operator)
+		if err != nil {
+			http.Error(w, "Failed to draft a reply", http.StatusBadGateway)
+			log.Println("Error drafting reply:", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(draft)
+
+	case http.MethodGet:
+		status := r.URL.Query().Get("status")
+		if status != "" && status != "pending" && status != "sent" && status != "discarded" {
+			http.Error(w, "status must be pending, sent or discarded", http.StatusBadRequest)
+			return
+		}
+		rows, err := db.Query(context.Background(), `
+			SELECT `+replyDraftColumns+` FROM reply_drafts
+			WHERE room_id = $1 AND ($2 = '' OR status = $2) ORDER BY id DESC LIMIT 100`, room.ID, status)
+		if err != nil {
+			http.Error(w, "Failed to fetch reply drafts", http.StatusInternalServerError)
+			log.Println("Error fetching reply drafts:", err)
+			return
+		}
+		defer rows.Close()
+
+		drafts := []*ReplyDraft{}
+		for rows.Next() {
+			draft, err := scanReplyDraft(rows)
+			if err != nil {
+				http.Error(w, "Error processing reply drafts", http.StatusInternalServerError)
+				log.Println("Error scanning reply drafts:", err)
+				return
+			}
+			drafts = append(drafts, draft)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(drafts)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Handler for /api/rooms/{id}/reply-drafts/{draft}?user=operator: send the draft as the
+// operator's reply with POST ({"text": "..."} sends an edited version; {"client_id": "..."}
+// deduplicates retries), or discard it with DELETE
+func handleReplyDraft(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("draft"))
+	if err != nil {
+		http.Error(w, "Invalid draft id", http.StatusBadRequest)
+		return
+	}
+	draft, err := pendingReplyDraft(room.ID, id)
+	if err != nil {
+		http.Error(w, "Failed to fetch reply draft", http.StatusInternalServerError)
+		log.Println("Error fetching reply draft:", err)
+		return
+	}
+	if draft == nil {
+		http.Error(w, "No pending draft with that id in this room", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Text     string `json:"text"`
+			ClientID string `json:"client_id"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+		text := strings.TrimSpace(req.Text)
+		if text == "" {
+			text = draft.Draft
+		}
+		if messageTooLarge(text) {
+			http.Error(w, "The reply is too long", http.StatusBadRequest)
+			return
+		}
+		if room.State == roomArchived {
+			http.Error(w, "This room is archived; its history is read-only", http.StatusConflict)
+			return
+		}
+		if len(req.ClientID) > clientIDMaxLength {
+			req.ClientID = req.ClientID[:clientIDMaxLength]
+		}
+		operator := requestUser(r)
+		if operator == "" {
+			operator = draft.Operator
+		}
+		if !claimReplyDraftOrFail(w, draft, "sent") {
+			return
+		}
+
+		ack, duplicate := postStaffReply(room.ID, nil, operator, text, req.ClientID)
+		if ack.MessageID == 0 && !ack.Incognito {
+			releaseReplyDraft(draft)
+			http.Error(w, "Failed to send reply", http.StatusInternalServerError)
+			return
+		}
+		if !duplicate {
+			if err := resolveReplyDraft(draft, text, ack.MessageID); err != nil {
+				log.Println("Error recording reply draft outcome:", err)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ack)
+
+	case http.MethodDelete:
+		if !claimReplyDraftOrFail(w, draft, "discarded") {
+			return
+		}
+		if err := resolveReplyDraft(draft, "", 0); err != nil {
+			http.Error(w, "Failed to discard reply draft", http.StatusInternalServerError)
+			log.Println("Error discarding reply draft:", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// claimReplyDraftOrFail claims a draft for a request, writing the error response if it
+// can't be claimed
+func claimReplyDraftOrFail(w http.ResponseWriter, draft *ReplyDraft, status string) bool {
+	claimed, err := claimReplyDraft(draft, status)
+	if err != nil {
+		http.Error(w, "Failed to update reply draft", http.StatusInternalServerError)
+		log.Println("Error claiming reply draft:", err)
+		return false
+	}
+	if !claimed {
+		http.Error(w, "This draft was already sent or discarded", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// Handler for /api/admin/reply-drafts/stats: how often drafts were sent, edited or
+// discarded (period 24h, 7d, 30d or 90d; 30d by default)
+func getReplyDraftStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "30d"
+	}
+	length, ok := analyticsPeriods[period]
+	if !ok {
+		http.Error(w, "period must be 24h, 7d, 30d or 90d", http.StatusBadRequest)
+		return
+	}
+
+	stats := ReplyDraftStats{Since: time.Now().UTC().Add(-length)}
+	err := db.QueryRow(context.Background(), `
+		SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE status = 'sent'),
+			COUNT(*) FILTER (WHERE status = 'sent' AND NOT edited),
+			COUNT(*) FILTER (WHERE status = 'discarded'),
+			COUNT(*) FILTER (WHERE status = 'pending'),
+			AVG(similarity) FILTER (WHERE status = 'sent')
+		FROM reply_drafts WHERE created_at >= $1`, stats.Since).
+		Scan(&stats.Drafted, &stats.Sent, &stats.SentUnedited, &stats.Discarded, &stats.Pending, &stats.MeanSimilarity)
+	if err != nil {
+		http.Error(w, "Failed to compute reply draft stats", http.StatusInternalServerError)
+		log.Println("Error computing reply draft stats:", err)
+		return
+	}
+	if resolved := stats.Sent + stats.Discarded; resolved > 0 {
+		stats.AcceptanceRate = float64(stats.Sent) / float64(resolved)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Users report messages they think break the rules; each report becomes a flag in the
+// moderation queue. Reporters are limited to a few reports per window so the queue
+// can't be flooded.
+var (
+	reportsEnabled   bool
+	reportRateLimit  int           // Reports allowed per reporter per window
+	reportRateWindow time.Duration // Window the rate limit looks at
+)
+
+// Recent report times by reporter ("user:<verified name>" or "ip:<address>")
+var (
+	reportMu    sync.Mutex
+	reportTimes = make(map[string][]time.Time)
+)
+
+// initReports reads the reporting settings
+func initReports() {
+	reportsEnabled = getEnvBool("REPORTS_ENABLED", true)
+	reportRateLimit = max(1, getEnvInt("REPORT_RATE_LIMIT", 5))
+	reportRateWindow = getEnvDuration("REPORT_RATE_WINDOW", 10*time.Minute)
+}
+
+// allowReport records a report attempt and reports whether the reporter is within the
+// limit, and if not, how long until they are
+func allowReport(reporter string, now time.Time) (bool, time.Duration) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	since := now.Add(-reportRateWindow)
+	if len(reportTimes) > 10000 {
+		for key, times := range reportTimes {
+			if len(recentTimes(times, since)) == 0 {
+				delete(reportTimes, key)
+			}
+		}
+	}
+	times := recentTimes(reportTimes[reporter], since)
+	if len(times) >= reportRateLimit {
+		reportTimes[reporter] = times
+		return false, times[0].Sub(since)
+	}
+	reportTimes[reporter] = append(times, now)
+	return true, 0
+}
+
+// Handler for /api/messages/{id}/report: report a message ({"reason": "..."}). Reporters
+// are told apart by their user token, or by address when they have none; messages in a
+// managed room can only be reported by its members.
+func reportMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !reportsEnabled {
+		http.Error(w, "Reporting is disabled", http.StatusServiceUnavailable)
+		return
+	}
+	messageID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid message id", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.ToValidUTF8(strings.TrimSpace(req.Reason), "")
+	if req.Reason == "" {
+		http.Error(w, "A reason is required", http.StatusBadRequest)
+		return
+	}
+	if len([]rune(req.Reason)) > 500 {
+		http.Error(w, "Reasons are limited to 500 characters", http.StatusBadRequest)
+		return
+	}
+
+	reporter := "ip:" + clientIP(r)
+	if user := verifiedUser(r); user != "" {
+		reporter = "user:" + user
+	}
+	if ok, wait := allowReport(reporter, time.Now()); !ok {
+		addCounter("cubbychat_reports_throttled_total", "Message reports refused for arriving too often", 1)
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Too many reports; please try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	var roomID int
+	var author, sender, text string
+	var reported bool
+	err = db.QueryRow(context.Background(), `
+		SELECT room_id, user_id, sender, `+messageText("chat_history")+`,
+			EXISTS (SELECT 1 FROM moderation_flags WHERE message_id = chat_history.id AND source = 'report' AND reporter = $2)
+		FROM chat_history WHERE id = $1`, messageID, reporter).Scan(&roomID, &author, &sender, &text, &reported)
+	if err == pgx.ErrNoRows || (err == nil && sender != "User" && sender != "AI") {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to report message", http.StatusInternalServerError)
+		log.Println("Error fetching reported message:", err)
+		return
+	}
+	room, err := getRoom(roomID)
+	if err != nil {
+		http.Error(w, "Failed to report message", http.StatusInternalServerError)
+		log.Println("Error fetching reported message's room:", err)
+		return
+	}
+	if !requireRoomRole(w, r, room, roleMember, true) {
+		return
+	}
+	if reported {
+		http.Error(w, "You already reported this message", http.StatusConflict)
+		return
+	}
+
+	flagID, err := flagMessage(messageID, roomID, author, "report", req.Reason, reporter, text)
+	if err != nil {
+		http.Error(w, "Failed to report message", http.StatusInternalServerError)
+		log.Println("Error flagging message:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": flagID, "message_id": messageID, "status": "pending"})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	rerankEnabled bool          // Whether retrieved chunks are reordered before prompting
+	rerankBackend string        // "cross-encoder" (a /v1/rerank server) or "llm"
+	rerankURL     string        // Base URL of the rerank server
+	rerankModel   string        // Reranker model (defaults to the chat model for "llm")
+	rerankAPIKey  string        // Optional bearer token for the rerank server
+	rerankTopKIn  int           // Candidates fetched from vector search
+	rerankTopKOut int           // Candidates kept after reranking
+	rerankTimeout time.Duration // Upper bound for one rerank call
+)
+
+// rerankResponse is the Cohere/Jina style response of /v1/rerank
+type rerankResponse struct {
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float64 `json:"relevance_score"`
+	} `json:"results"`
+}
+
+// initRerank reads reranking settings
+func initRerank() {
+	rerankEnabled = getEnvBool("RERANK_ENABLED", false)
+	rerankBackend = strings.ToLower(getEnv("RERANK_BACKEND", "cross-encoder"))
+	rerankURL = getEnv("RERANK_URL", "http://reranker:8080")
+	rerankModel = getEnv("RERANK_MODEL", "")
+	rerankAPIKey = getEnv("RERANK_API_KEY", "")
+	rerankTopKIn = max(1, getEnvInt("RERANK_TOP_K_IN", 20))
+	rerankTopKOut = max(1, getEnvInt("RERANK_TOP_K_OUT", ragTopK))
+	rerankTimeout = getEnvDuration("RERANK_TIMEOUT", 30*time.Second)
+
+	if !rerankEnabled {
+		return
+	}
+	if rerankBackend != "cross-encoder" && rerankBackend != "llm" {
+		log.Printf("⚠️ Unknown RERANK_BACKEND %q, reranking disabled", rerankBackend)
+		rerankEnabled = false
+		return
+	}
+	if rerankTopKIn < rerankTopKOut {
+		rerankTopKIn = rerankTopKOut
+	}
+	log.Printf("🏅 Reranking enabled (backend: %s, top k: %d → %d)", rerankBackend, rerankTopKIn, rerankTopKOut)
+}
+
+// rerankChunks scores each chunk against the query and keeps the best rerankTopKOut
+func rerankChunks(query string, chunks []RetrievedChunk) ([]RetrievedChunk, error) {
+	if len(chunks) == 0 {
+		return chunks, nil
+	}
+
+	var scores []float64
+	var err error
+	if rerankBackend == "llm" {
+		scores, err = rerankWithLLM(query, chunks)
+	} else {
+		scores, err = rerankWithCrossEncoder(query, chunks)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reranked := make([]RetrievedChunk, len(chunks))
+	copy(reranked, chunks)
+	for i := range reranked {
+		reranked[i].RerankScore = scores[i]
+	}
+	sort.SliceStable(reranked, func(i, j int) bool { return reranked[i].RerankScore > reranked[j].RerankScore })
+	if len(reranked) > rerankTopKOut {
+		reranked = reranked[:rerankTopKOut]
+	}
+	return reranked, nil
+}
+
+// rerankWithCrossEncoder calls a Cohere/Jina compatible /v1/rerank endpoint
+func rerankWithCrossEncoder(query string, chunks []RetrievedChunk) ([]float64, error) {
+	documents := make([]string, len(chunks))
+	for i, c := range chunks {
+		documents[i] = c.Content
+	}
+
+	var result rerankResponse
+	req := newUpstreamClient().SetTimeout(rerankTimeout).R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(map[string]interface{}{
+			"model":     rerankModel,
+			"query":     query,
+			"documents": documents,
+		}).
+		SetResult(&result)
+	if rerankAPIKey != "" {
+		req.SetAuthToken(rerankAPIKey)
+	}
+
+	resp, err := req.Post(rerankURL + "/v1/rerank")
+	if err != nil {
+		return nil, fmt.Errorf("reranker unavailable: %v", err)
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("reranker returned status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	scores := make([]float64, len(chunks))
+	for _, r := range result.Results {
+		if r.Index >= 0 && r.Index < len(scores) {
+			scores[r.Index] = r.RelevanceScore
+		}
+	}
+	return scores, nil
+}
+
+// rerankWithLLM asks the chat model to grade each passage's relevance from 0 to 10
+func rerankWithLLM(query string, chunks []RetrievedChunk) ([]float64, error) {
+	model := rerankModel
+	if model == "" {
+		model = ollamaModel
+	}
+
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, `Rate how useful each passage is for answering the question, from 0 (irrelevant) to 10 (answers it directly).
+Reply only with JSON in the form {"scores": [n, n, ...]} with one score per passage, in order.
+
+Question: %s
+`, query)
+	for i, c := range chunks {
+		fmt.Fprintf(&prompt, "\nPassage %d:\n%s\n", i+1, c.Content)
+	}
+
+	raw, err := generateOnce(model, prompt.String(), "json", rerankTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed struct {
+		Scores []float64 `json:"scores"`
+	}
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse rerank scores: %v", err)
+	}
+	if len(parsed.Scores) != len(chunks) {
+		return nil, fmt.Errorf("expected %d rerank scores, got %d", len(chunks), len(parsed.Scores))
+	}
+	for i := range parsed.Scores {
+		parsed.Scores[i] /= 10
+	}
+	return parsed.Scores, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Message retention: a job deletes messages older than the room's retention period, or
+// the server's default for rooms without their own. Ephemeral rooms set a short one.
+var (
+	defaultRetention  time.Duration // How long messages are kept; 0 keeps them forever
+	retentionInterval time.Duration // How often the job runs
+	retentionBatch    int           // Rows deleted per statement, so deletes don't hold long locks
+)
+
+// retentionForever is the room override that keeps messages despite the server's default
+const retentionForever = "forever"
+
+// RoomRetentionEvent tells a room's clients how long its messages are kept ("" for the server's default)
+type RoomRetentionEvent struct {
+	RoomID    int    `json:"room_id"`
+	Retention string `json:"retention"`
+}
+
+// initRetention reads the retention settings and starts the job
+func initRetention() {
+	defaultRetention = getEnvDuration("MESSAGE_RETENTION", 0)
+	retentionInterval = getEnvDuration("RETENTION_INTERVAL", time.Hour)
+	retentionBatch = getEnvInt("RETENTION_BATCH", 5000)
+	go runRetentionJob()
+	if defaultRetention > 0 {
+		log.Printf("🧹 Messages are deleted after %v unless their room says otherwise", defaultRetention)
+	}
+}
+
+// parseRetention checks a room's retention override: a duration of at least a minute, or "forever"
+func parseRetention(value string) (time.Duration, error) {
+	if value == retentionForever {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < time.Minute {
+		return 0, fmt.Errorf(`retention must be a duration of at least 1m, such as "24h", or "forever"`)
+	}
+	return d, nil
+}
+
+// runRetentionJob deletes expired messages every RETENTION_INTERVAL
+func runRetentionJob() {
+	ticker := clock.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		deleted, err := deleteExpiredMessages()
+		if err != nil {
+			log.Println("Error deleting expired messages:", err)
+		}
+		if deleted > 0 {
+			log.Printf("🧹 Deleted %d expired messages", deleted)
+			addCounter("cubbychat_retention_deleted_total", "Messages deleted for being older than their room's retention", float64(deleted))
+		}
+	}
+}
+
+// deleteExpiredMessages applies each room's override, then the default to the other rooms
+func deleteExpiredMessages() (int64, error) {
+	rows, err := db.Query(context.Background(), "SELECT id, metadata->>'retention' FROM rooms WHERE metadata ? 'retention'")
+	if err != nil {
+		return 0, err
+	}
+	overrides := map[int]string{}
+	for rows.Next() {
+		var id int
+		var retention string
+		if err := rows.Scan(&id, &retention); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		overrides[id] = retention
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	now := clock.Now()
+	for roomID, retention := range overrides {
+		d, err := parseRetention(retention)
+		if err != nil || d == 0 {
+			continue // Kept forever, or an override that was valid once and no longer is
+		}
+		deleted, err := deleteMessagesBefore(now.Add(-d), "room_id = $2", roomID)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+	}
+	if defaultRetention > 0 {
+		deleted, err := deleteMessagesBefore(now.Add(-defaultRetention),
+			"room_id IN (SELECT id FROM rooms WHERE NOT metadata ? 'retention')")
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// deleteMessagesBefore deletes messages, and the compressed originals kept for them, from
+// before cutoff in the rooms the condition picks ($1 is the cutoff), a batch at a time
+func deleteMessagesBefore(cutoff time.Time, rooms string, args ...interface{}) (int64, error) {
+	var total int64
+	for _, table := range []string{"chat_history_archive", "chat_history"} {
+		query := fmt.Sprintf(`
+			DELETE FROM %[1]s WHERE id IN (
+				SELECT id FROM %[1]s WHERE timestamp < $1 AND %[2]s LIMIT %[3]d
+			)`, table, rooms, retentionBatch)
+		for {
+			tag, err := db.Exec(context.Background(), query, append([]interface{}{cutoff}, args...)...)
+			if err != nil {
+				return total, err
+			}
+			if table == "chat_history" {
+				total += tag.RowsAffected()
+			}
+			if tag.RowsAffected() < int64(retentionBatch) {
+				break
+			}
+		}
+	}
+	return total, nil
+}
+
+// Handler for /api/rooms/{id}/retention: set how long the room keeps messages with PUT
+// ({"retention": "24h"} or "forever"), go back to the server's default with DELETE
+func handleRoomRetention(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	retention := ""
+	switch r.Method {
+	case http.MethodPut:
+		var req struct {
+			Retention string `json:"retention"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if _, err := parseRetention(req.Retention); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		retention = req.Retention
+		_, err = db.Exec(context.Background(),
+			"UPDATE rooms SET metadata = metadata || jsonb_build_object('retention', $2::text) WHERE id = $1", room.ID, retention)
+	case http.MethodDelete:
+		_, err = db.Exec(context.Background(), "UPDATE rooms SET metadata = metadata - 'retention' WHERE id = $1", room.ID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update room retention", http.StatusInternalServerError)
+		log.Println("Error updating room retention:", err)
+		return
+	}
+	recordAudit("admin", clientIP(r), "room.retention", strconv.Itoa(room.ID), map[string]string{"from": room.Metadata.Retention, "to": retention})
+	publishRoomEvent(room.ID, nil, "room_retention", RoomRetentionEvent{RoomID: room.ID, Retention: retention})
+
+	room.Metadata.Retention = retention
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
+
+// HistoryPurgedEvent tells a room's clients its messages from before a moment are gone
+type HistoryPurgedEvent struct {
+	RoomID  int       `json:"room_id"`
+	Before  time.Time `json:"before"`
+	Deleted int64     `json:"deleted"`
+}
+
+// Handler for /api/rooms/{id}/messages: purge the room's history with DELETE, all of it
+// or what came before ?before= (RFC 3339). It takes the room's owner role.
+func purgeRoomHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+	before := time.Now()
+	if v := r.URL.Query().Get("before"); v != "" {
+		var err error
+		if before, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "before must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	if !requireRoomRole(w, r, room, roleOwner, false) {
+		return
+	}
+
+	deleted, err := deleteMessagesBefore(before, "room_id = $2", room.ID)
+	if err != nil {
+		http.Error(w, "Failed to purge room history", http.StatusInternalServerError)
+		log.Println("Error purging room history:", err)
+		return
+	}
+	recordAudit(roomActor(r), clientIP(r), "room.purge", strconv.Itoa(room.ID), map[string]interface{}{"before": before, "deleted": deleted})
+	event := HistoryPurgedEvent{RoomID: room.ID, Before: before, Deleted: deleted}
+	publishRoomEvent(room.ID, nil, "history_purged", event)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(event)
+}
+
+// effectiveRetention is how long a room keeps messages: its override, else the server's
+// default; 0 means forever
+func effectiveRetention(room *Room) time.Duration {
+	if room.Metadata.Retention == "" {
+		return defaultRetention
+	}
+	d, _ := parseRetention(room.Metadata.Retention)
+	return d
+}
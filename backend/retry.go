@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+var (
+	generationRetries      int           // Extra attempts per provider after a transient failure
+	generationRetryBackoff time.Duration // Base delay, doubled per attempt and jittered
+)
+
+// RetryEvent tells the client a generation is being retried after a transient failure
+type RetryEvent struct {
+	Provider    string `json:"provider"`
+	Attempt     int    `json:"attempt"` // The attempt about to start, counting from 2
+	MaxAttempts int    `json:"max_attempts"`
+	DelayMs     int64  `json:"delay_ms"`
+	Reason      string `json:"reason"`
+}
+
+// upstreamStatusError is an unexpected HTTP status from a provider
+type upstreamStatusError struct {
+	provider string
+	status   int
+	body     string
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("%s returned status %d: %s", e.provider, e.status, e.body)
+}
+
+// initRetries reads generation retry settings
+func initRetries() {
+	generationRetries = max(0, getEnvInt("GENERATION_RETRIES", 2))
+	generationRetryBackoff = getEnvDuration("GENERATION_RETRY_BACKOFF", 500*time.Millisecond)
+}
+
+// isRetryable reports whether a generation error is likely transient:
+// dropped or refused connections, timeouts, and 429/502/503/504 responses
+func isRetryable(err error) bool {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.status {
+		case 429, 502, 503, 504:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryDelay picks a jittered exponential backoff for the given retry (1 for the first)
+func retryDelay(retry int) time.Duration {
+	ceiling := generationRetryBackoff << (retry - 1)
+	return ceiling/2 + time.Duration(rng.Int63n(int64(ceiling/2)+1))
+}
+
+// withRetries runs one provider's generation, retrying transient failures that
+// happen before any token reached the client
+func withRetries(s *Session, gen *generation, p *Provider, attempt func() error) error {
+	for retry := 0; ; retry++ {
+		err := attempt()
+		if err == nil || retry >= generationRetries || !gen.firstToken.IsZero() || !isRetryable(err) {
+			return err
+		}
+
+		delay := retryDelay(retry + 1)
+		log.Printf("🔁 Provider %s failed (%v), retrying in %v", p.Name, err, delay)
+		if sendErr := s.sendEvent("retrying", RetryEvent{
+			Provider:    p.Name,
+			Attempt:     retry + 2,
+			MaxAttempts: generationRetries + 1,
+			DelayMs:     delay.Milliseconds(),
+			Reason:      err.Error(),
+		}); sendErr != nil {
+			log.Println("Error sending retrying event:", sendErr)
+		}
+		clock.Sleep(delay)
+		gen.resetOutput()
+	}
+}
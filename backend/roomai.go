@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Who the AI answers in a room. Rooms without a mode answer everyone.
+const (
+	roomAIOn         = "on"         // The AI answers everyone
+	roomAIOff        = "off"        // Human-only room; the AI never answers
+	roomAIModerators = "moderators" // The AI only answers moderators' prompts
+)
+
+// RoomAIEvent tells a room's clients who the AI answers now
+type RoomAIEvent struct {
+	RoomID int    `json:"room_id"`
+	AI     string `json:"ai"`
+}
+
+// roomAIMode is the room's AI mode, roomAIOn when it has none
+func roomAIMode(room *Room) string {
+	if room.Metadata.AI == "" {
+		return roomAIOn
+	}
+	return room.Metadata.AI
+}
+
+// aiAnswers reports whether the AI may answer a session's prompt in its room. Messages
+// in rooms where it may not are still stored and shown; they just go unanswered.
+func aiAnswers(s *Session) bool {
+	room, err := getRoom(s.room)
+	if err != nil {
+		log.Println("Error fetching room AI mode:", err)
+		return true
+	}
+	return roomAIAnswers(room, s.moderator)
+}
+
+// roomAIAnswers reports whether the AI may answer a prompt in a room, from a moderator or
+// not. It never does while an operator has taken the room over, nor answers customers
+// after hours in rooms that queue their messages.
+func roomAIAnswers(room *Room, moderator bool) bool {
+	if room.Metadata.Handoff != nil {
+		return false
+	}
+	if h := roomAfterHours(room); h != nil && h.Mode == afterHoursQueue && !moderator {
+		return false
+	}
+	switch roomAIMode(room) {
+	case roomAIOff:
+		return false
+	case roomAIModerators:
+		return moderator
+	}
+	return true
+}
+
+// Handler for /api/rooms/{id}/ai: set who the AI answers with PUT ({"ai": "on", "off" or
+// "moderators"}), go back to answering everyone with DELETE
+func handleRoomAI(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	mode := ""
+	switch r.Method {
+	case http.MethodPut:
+		var req struct {
+			AI string `json:"ai"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.AI != roomAIOn && req.AI != roomAIOff && req.AI != roomAIModerators {
+			http.Error(w, `ai must be "on", "off" or "moderators"`, http.StatusBadRequest)
+			return
+		}
+		mode = req.AI
+		_, err = db.Exec(context.Background(),
+			"UPDATE rooms SET metadata = metadata || jsonb_build_object('ai', $2::text) WHERE id = $1", room.ID, mode)
+	case http.MethodDelete:
+		_, err = db.Exec(context.Background(), "UPDATE rooms SET metadata = metadata - 'ai' WHERE id = $1", room.ID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update room AI mode", http.StatusInternalServerError)
+		log.Println("Error updating room AI mode:", err)
+		return
+	}
+	previous := roomAIMode(room)
+	room.Metadata.AI = mode
+	recordAudit("moderator", clientIP(r), "room.ai", strconv.Itoa(room.ID), map[string]string{"from": previous, "to": roomAIMode(room)})
+	publishRoomEvent(room.ID, nil, "room_ai", RoomAIEvent{RoomID: room.ID, AI: roomAIMode(room)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
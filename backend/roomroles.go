@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Room roles give named users a say over one room. Owners can do anything a moderator
+// can and also change the room's persona, purge its history and decide who its
+// moderators and owners are; moderators can change its settings, pin messages, manage
+// share links and invite members. Roles only count for users who prove their name with
+// a user token (see usertokens.go), never for a bare ?user= name. A room is managed
+// once it has an owner, which the verified user who creates it becomes. Unmanaged rooms
+// keep working as before: anyone may change their settings, and the moderator token
+// acts as their owner. The admin token owns every room.
+const (
+	roleOwner     = "owner"
+	roleModerator = "moderator"
+	roleMember    = "member"
+)
+
+// roomRoleRanks orders the roles; a role can do whatever lower ones can
+var roomRoleRanks = map[string]int{roleMember: 1, roleModerator: 2, roleOwner: 3}
+
+var errLastRoomOwner = fmt.Errorf("a managed room needs at least one owner")
+
+// RoomMember is a user's role in a room
+type RoomMember struct {
+	User      string    `json:"user"`
+	Role      string    `json:"role"`
+	AddedBy   string    `json:"added_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// initRoomRoles creates the room members table
+func initRoomRoles() {
+	createRoomMembersTable()
+}
+
+// Create `room_members` table if it doesn't exist
+func createRoomMembersTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS room_members (
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			user_id TEXT NOT NULL,
+			role TEXT NOT NULL CHECK (role IN ('owner', 'moderator', 'member')),
+			added_by TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			PRIMARY KEY (room_id, user_id)
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create room_members table:", err)
+	}
+	log.Println("✅ Table room_members is ready")
+}
+
+// roomRoleOf is the role someone acts with in a room, "" for none, and whether the room
+// is managed. admin and moderator say which token they presented; user must be verified.
+func roomRoleOf(roomID int, admin, moderator bool, user string) (role string, managed bool, err error) {
+	err = db.QueryRow(context.Background(), `
+		SELECT EXISTS (SELECT 1 FROM room_members WHERE room_id = $1 AND role = 'owner'),
+			COALESCE((SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2 AND $2 <> ''), '')`,
+		roomID, user).Scan(&managed, &role)
+	if err != nil {
+		return "", false, err
+	}
+	return effectiveRoomRole(role, managed, admin, moderator), managed, nil
+}
+
+// effectiveRoomRole combines a user's membership role with the token they presented: the
+// admin token owns every room, the moderator token owns unmanaged rooms and moderates
+// managed ones
+func effectiveRoomRole(member string, managed, admin, moderator bool) string {
+	switch {
+	case admin, moderator && !managed:
+		return roleOwner
+	case moderator && roomRoleRanks[member] < roomRoleRanks[roleModerator]:
+		return roleModerator
+	}
+	return member
+}
+
+// roomRoleAllows reports whether a held role is enough for one that's required. With
+// managedOnly, unmanaged rooms let anyone through.
+func roomRoleAllows(held, required string, managed, managedOnly bool) bool {
+	return (managedOnly && !managed) || (held != "" && roomRoleRanks[held] >= roomRoleRanks[required])
+}
+
+// requireRoomRole checks a request acts with at least role in the room, writing an error
+// response if not
+func requireRoomRole(w http.ResponseWriter, r *http.Request, room *Room, role string, managedOnly bool) bool {
+	held, managed, err := roomRoleOf(room.ID, isAdmin(r), isModerator(r), verifiedUser(r))
+	if err != nil {
+		http.Error(w, "Failed to check room role", http.StatusInternalServerError)
+		log.Println("Error checking room role:", err)
+		return false
+	}
+	if roomRoleAllows(held, role, managed, managedOnly) {
+		return true
+	}
+	http.Error(w, fmt.Sprintf("This needs the room's %s role", role), http.StatusForbidden)
+	return false
+}
+
+// requireSocketMember checks, before a WebSocket is upgraded, that the client may join the
+// room: managed rooms only let their members listen in and post
+func requireSocketMember(w http.ResponseWriter, r *http.Request, roomID int) bool {
+	room, err := getRoom(roomID)
+	if err != nil {
+		http.Error(w, "Failed to fetch room", http.StatusInternalServerError)
+		log.Println("Error fetching room:", err)
+		return false
+	}
+	return requireRoomRole(w, r, room, roleMember, true)
+}
+
+// sessionHasRoomRole is requireRoomRole for a WebSocket session
+func sessionHasRoomRole(s *Session, role string, managedOnly bool) bool {
+	held, managed, err := roomRoleOf(s.room, s.admin, s.moderator, s.identity)
+	if err != nil {
+		log.Println("Error checking room role:", err)
+		return false
+	}
+	return roomRoleAllows(held, role, managed, managedOnly)
+}
+
+// roomActor names who made a change for the audit log
+func roomActor(r *http.Request) string {
+	switch {
+	case isAdmin(r):
+		return "admin"
+	case isModerator(r):
+		return "moderator"
+	}
+	return "user:" + verifiedUser(r)
+}
+
+// addRoomOwner makes a room's creator its owner
+func addRoomOwner(roomID int, user string) error {
+	_, err := db.Exec(context.Background(),
+		"INSERT INTO room_members (room_id, user_id, role, added_by) VALUES ($1, $2, 'owner', $2) ON CONFLICT DO NOTHING", roomID, user)
+	return err
+}
+
+// lockRoomOwners locks and returns a room's owners, so two changes can't both leave it
+// without one
+func lockRoomOwners(ctx context.Context, tx pgx.Tx, roomID int) ([]string, error) {
+	rows, err := tx.Query(ctx, "SELECT user_id FROM room_members WHERE room_id = $1 AND role = 'owner' FOR UPDATE", roomID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// setRoomMember adds a member or changes their role, refusing to demote the last owner
+func setRoomMember(roomID int, member *RoomMember) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		owners, err := lockRoomOwners(ctx, tx, roomID)
+		if err != nil {
+			return err
+		}
+		if member.Role != roleOwner && len(owners) == 1 && owners[0] == member.User {
+			return errLastRoomOwner
+		}
+		return tx.QueryRow(ctx, `
+			INSERT INTO room_members (room_id, user_id, role, added_by) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (room_id, user_id) DO UPDATE SET role = EXCLUDED.role
+			RETURNING added_by, created_at`, roomID, member.User, member.Role, member.AddedBy).Scan(&member.AddedBy, &member.CreatedAt)
+	})
+}
+
+// removeRoomMember removes a member, refusing to remove the last owner. It returns the
+// role they had, or pgx.ErrNoRows if they weren't a member.
+func removeRoomMember(roomID int, user string) (string, error) {
+	ctx := context.Background()
+	var role string
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		owners, err := lockRoomOwners(ctx, tx, roomID)
+		if err != nil {
+			return err
+		}
+		if len(owners) == 1 && owners[0] == user {
+			return errLastRoomOwner
+		}
+		return tx.QueryRow(ctx, "DELETE FROM room_members WHERE room_id = $1 AND user_id = $2 RETURNING role", roomID, user).Scan(&role)
+	})
+	return role, err
+}
+
+// Handler for /api/rooms/{id}/members: list the room's members with GET (as a
+// member), add one or change their role with POST ({"user": "...", "role": "member"}).
+// Moderators invite members; only owners hand out the moderator and owner roles.
+func handleRoomMembers(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if !requireRoomRole(w, r, room, roleMember, false) {
+			return
+		}
+		rows, err := db.Query(context.Background(), `
+			SELECT user_id, role, added_by, created_at FROM room_members WHERE room_id = $1
+			ORDER BY CASE role WHEN 'owner' THEN 0 WHEN 'moderator' THEN 1 ELSE 2 END, user_id`, room.ID)
+		if err != nil {
+			http.Error(w, "Failed to fetch room members", http.StatusInternalServerError)
+			log.Println("Error fetching room members:", err)
+			return
+		}
+		defer rows.Close()
+
+		members := []RoomMember{}
+		for rows.Next() {
+			var m RoomMember
+			if err := rows.Scan(&m.User, &m.Role, &m.AddedBy, &m.CreatedAt); err != nil {
+				http.Error(w, "Error processing room members", http.StatusInternalServerError)
+				log.Println("Error scanning room members:", err)
+				return
+			}
+			members = append(members, m)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(members)
+
+	case http.MethodPost:
+		var member RoomMember
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&member); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		member.User = strings.TrimSpace(member.User)
+		if member.User == "" || len(member.User) > 64 {
+			http.Error(w, "A user of up to 64 characters is required", http.StatusBadRequest)
+			return
+		}
+		if member.Role == "" {
+			member.Role = roleMember
+		}
+		if _, ok := roomRoleRanks[member.Role]; !ok {
+			http.Error(w, `role must be "owner", "moderator" or "member"`, http.StatusBadRequest)
+			return
+		}
+		// Roles go to claimed names only, so nobody can claim a name after it was given one
+		claimed, err := userNameClaimed(member.User)
+		if err != nil {
+			http.Error(w, "Failed to check user", http.StatusInternalServerError)
+			log.Println("Error checking user token:", err)
+			return
+		}
+		if !claimed {
+			http.Error(w, member.User+" hasn't claimed their name with a user token yet", http.StatusConflict)
+			return
+		}
+
+		// Changing someone's role takes the higher of their old and new role's say
+		required := roleModerator
+		current, _, err := roomRoleOf(room.ID, false, false, member.User)
+		if err != nil {
+			http.Error(w, "Failed to check room role", http.StatusInternalServerError)
+			log.Println("Error checking room role:", err)
+			return
+		}
+		if member.Role != roleMember || roomRoleRanks[current] > roomRoleRanks[roleMember] {
+			required = roleOwner
+		}
+		if !requireRoomRole(w, r, room, required, false) {
+			return
+		}
+
+		member.AddedBy = roomActor(r)
+		err = setRoomMember(room.ID, &member)
+		if err == errLastRoomOwner {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to save room member", http.StatusInternalServerError)
+			log.Println("Error saving room member:", err)
+			return
+		}
+		recordAudit(roomActor(r), clientIP(r), "room.member", strconv.Itoa(room.ID), map[string]string{"user": member.User, "from": current, "to": member.Role})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(member)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Handler for /api/rooms/{id}/members/{user}: remove a member. Members may leave;
+// moderators remove members and owners remove anyone, except the last owner.
+func deleteRoomMember(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+	user := r.PathValue("user")
+
+	current, _, err := roomRoleOf(room.ID, false, false, user)
+	if err != nil {
+		http.Error(w, "Failed to check room role", http.StatusInternalServerError)
+		log.Println("Error checking room role:", err)
+		return
+	}
+	if current == "" {
+		http.Error(w, "Room member not found", http.StatusNotFound)
+		return
+	}
+	required := roleModerator
+	switch {
+	case user == verifiedUser(r):
+		required = roleMember
+	case current != roleMember:
+		required = roleOwner
+	}
+	if !requireRoomRole(w, r, room, required, false) {
+		return
+	}
+
+	role, err := removeRoomMember(room.ID, user)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Room member not found", http.StatusNotFound)
+		return
+	}
+	if err == errLastRoomOwner {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to remove room member", http.StatusInternalServerError)
+		log.Println("Error removing room member:", err)
+		return
+	}
+	recordAudit(roomActor(r), clientIP(r), "room.member", strconv.Itoa(room.ID), map[string]string{"user": user, "from": role, "to": ""})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for /api/rooms/{id}/persona: the room's owners set the instructions
+// that come before every prompt with PUT ({"persona": "..."}) and remove them with DELETE
+func handleRoomPersona(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireRoomRole(w, r, room, roleOwner, false) {
+		return
+	}
+
+	var err error
+	persona := ""
+	if r.Method == http.MethodPut {
+		var req struct {
+			Persona string `json:"persona"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		persona = strings.TrimSpace(req.Persona)
+		if persona == "" || utf8.RuneCountInString(persona) > maxPersonaChars {
+			http.Error(w, fmt.Sprintf("A persona of up to %d characters is required", maxPersonaChars), http.StatusBadRequest)
+			return
+		}
+		_, err = db.Exec(context.Background(),
+			"UPDATE rooms SET metadata = metadata || jsonb_build_object('persona', $2::text) WHERE id = $1", room.ID, persona)
+	} else {
+		_, err = db.Exec(context.Background(), "UPDATE rooms SET metadata = metadata - 'persona' WHERE id = $1", room.ID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to update room persona", http.StatusInternalServerError)
+		log.Println("Error updating room persona:", err)
+		return
+	}
+	recordAudit(roomActor(r), clientIP(r), "room.persona", strconv.Itoa(room.ID), map[string]string{"from": room.Metadata.Persona, "to": persona})
+
+	room.Metadata.Persona = persona
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEffectiveRoomRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		member     string
+		managed    bool
+		admin, mod bool
+		want       string
+	}{
+		{"nobody in an unmanaged room", "", false, false, false, ""},
+		{"nobody in a managed room", "", true, false, false, ""},
+		{"member keeps their role", roleMember, true, false, false, roleMember},
+		{"owner keeps their role", roleOwner, true, false, false, roleOwner},
+		{"admin token owns unmanaged rooms", "", false, true, true, roleOwner},
+		{"admin token owns managed rooms", "", true, true, true, roleOwner},
+		{"moderator token owns unmanaged rooms", "", false, false, true, roleOwner},
+		{"moderator token moderates managed rooms", "", true, false, true, roleModerator},
+		{"moderator token lifts a member", roleMember, true, false, true, roleModerator},
+		{"moderator token doesn't lower an owner", roleOwner, true, false, true, roleOwner},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := effectiveRoomRole(tt.member, tt.managed, tt.admin, tt.mod); got != tt.want {
+				t.Errorf("effectiveRoomRole(%q, managed=%v, admin=%v, moderator=%v) = %q, want %q",
+					tt.member, tt.managed, tt.admin, tt.mod, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoomRoleAllows(t *testing.T) {
+	tests := []struct {
+		name                 string
+		held, required       string
+		managed, managedOnly bool
+		want                 bool
+	}{
+		{"no role in a managed room", "", roleMember, true, false, false},
+		{"no role, unmanaged room, managed-only gate", "", roleModerator, false, true, true},
+		{"no role, unmanaged room, strict gate", "", roleModerator, false, false, false},
+		{"no role, managed room, managed-only gate", "", roleModerator, true, true, false},
+		{"member below moderator", roleMember, roleModerator, true, true, false},
+		{"moderator meets moderator", roleModerator, roleModerator, true, false, true},
+		{"moderator below owner", roleModerator, roleOwner, true, false, false},
+		{"owner above member", roleOwner, roleMember, true, false, true},
+		{"unknown role counts for nothing", "guest", roleMember, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := roomRoleAllows(tt.held, tt.required, tt.managed, tt.managedOnly); got != tt.want {
+				t.Errorf("roomRoleAllows(%q, %q, managed=%v, managedOnly=%v) = %v, want %v",
+					tt.held, tt.required, tt.managed, tt.managedOnly, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifiedUserIgnoresSelfReportedName(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/rooms/1/members?user=owner", nil)
+	if got := verifiedUser(r); got != "" {
+		t.Errorf("verifiedUser without a token = %q, want \"\"", got)
+	}
+}
+
+func TestNewUserToken(t *testing.T) {
+	token, hash, err := newUserToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != hashUserToken(token) {
+		t.Error("stored hash doesn't match the token")
+	}
+	if hash == token {
+		t.Error("token is stored in the clear")
+	}
+	other, _, err := newUserToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == token {
+		t.Error("two tokens are the same")
+	}
+}
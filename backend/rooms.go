@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// defaultRoomID is the room used by clients that don't ask for one
+const defaultRoomID = 1
+
+var errRoomNotFound = errors.New("room not found")
+
+// Room is a separate conversation with its own history and settings
+type Room struct {
+	ID        int          `json:"id"`
+	Name      string       `json:"name"`
+	State     string       `json:"state"` // active, archived or locked
+	Metadata  RoomMetadata `json:"metadata"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// RoomMetadata holds flags and settings stored with a room
+type RoomMetadata struct {
+	KnowledgeBase    bool              `json:"knowledge_base"`               // Whether retrieval is scoped to bound knowledge bases
+	KnowledgeBaseIDs []int             `json:"knowledge_base_ids,omitempty"` // Knowledge bases bound to the room
+	Welcome          *RoomWelcome      `json:"welcome,omitempty"`            // Overrides the server's welcome message
+	Settings         map[string]string `json:"settings,omitempty"`           // Answer settings and template variables; see roomsettings.go
+	Persona          string            `json:"persona,omitempty"`            // Instructions that come before every prompt
+	Provider         string            `json:"provider,omitempty"`           // Provider tried first when answering
+	Model            string            `json:"model,omitempty"`              // Model to use with Provider
+	Template         string            `json:"template,omitempty"`           // The room template the room was created from
+	Retention        string            `json:"retention,omitempty"`          // How long messages are kept, overriding the server's default; see retention.go
+	Incognito        bool              `json:"incognito,omitempty"`          // Messages are answered but never stored; see incognito.go
+	AI               string            `json:"ai,omitempty"`                 // Who the AI answers: on (the default), off or moderators; see roomai.go
+	Handoff          *Handoff          `json:"handoff,omitempty"`            // The operator who took over, pausing the AI; see handoff.go
+	Status           string            `json:"status,omitempty"`             // Conversation status: open (the default), pending or resolved; see conversations.go
+	Labels           []string          `json:"labels,omitempty"`             // Conversation labels, lowercase and sorted
+	Assignee         string            `json:"assignee,omitempty"`           // Who the conversation is assigned to
+	SLA              *RoomSLA          `json:"sla,omitempty"`                // Overrides the server's SLA targets; see sla.go
+	BusinessHours    *BusinessHours    `json:"business_hours,omitempty"`     // Overrides the deployment's business hours; see businesshours.go
+}
+
+// initRooms creates the rooms table and scopes chat history by room
+func initRooms() {
+	createRoomsTable()
+	migrateRoomStates()
+}
+
+// Create `rooms` table if it doesn't exist and add the room column to chat_history
+func createRoomsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS rooms (
+			id SERIAL PRIMARY KEY,
+			name TEXT NOT NULL,
+			metadata JSONB NOT NULL DEFAULT '{}',
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		);
+		INSERT INTO rooms (id, name) VALUES (1, 'General') ON CONFLICT (id) DO NOTHING;
+		SELECT setval(pg_get_serial_sequence('rooms', 'id'), GREATEST((SELECT MAX(id) FROM rooms), 1));
+		ALTER TABLE chat_history ADD COLUMN IF NOT EXISTS room_id INTEGER NOT NULL DEFAULT 1 REFERENCES rooms(id) ON DELETE CASCADE;
+		CREATE INDEX IF NOT EXISTS chat_history_room_id_idx ON chat_history (room_id, timestamp);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create rooms table:", err)
+	}
+	log.Println("✅ Table rooms is ready")
+}
+
+// getRoom loads a room by id
+func getRoom(id int) (*Room, error) {
+	var room Room
+	err := db.QueryRow(context.Background(),
+		"SELECT id, name, state, metadata, created_at FROM rooms WHERE id = $1", id).
+		Scan(&room.ID, &room.Name, &room.State, &room.Metadata, &room.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, errRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// requestRoom returns the room named by the "room" query parameter (the default room if absent)
+func requestRoom(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("room")
+	if value == "" {
+		return defaultRoomID, nil
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errRoomNotFound
+	}
+	if _, err := getRoom(id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// pathRoom returns the room named by the {id} path segment, writing an error response if invalid
+func pathRoom(w http.ResponseWriter, r *http.Request) (*Room, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid room id", http.StatusBadRequest)
+		return nil, false
+	}
+	room, err := getRoom(id)
+	if err == errRoomNotFound {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch room", http.StatusInternalServerError)
+		log.Println("Error fetching room:", err)
+		return nil, false
+	}
+	return room, true
+}
+
+// Handler for /api/rooms: create with POST (from a room template with ?template=name), list with
+// GET, optionally filtered by conversation fields with ?status=, ?label= and ?assignee= (where
+// ?assignee=none lists unassigned rooms). Managed rooms are only listed for their members and
+// moderators.
+func handleRooms(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		createRoom(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status != "" && !validConversationStatus(status) {
+		http.Error(w, `status must be "open", "pending" or "resolved"`, http.StatusBadRequest)
+		return
+	}
+	label := strings.ToLower(strings.TrimSpace(query.Get("label")))
+	assignee := strings.TrimSpace(query.Get("assignee"))
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, name, state, metadata, created_at FROM rooms
+		WHERE ($1 = '' OR COALESCE(metadata->>'status', 'open') = $1)
+			AND ($2 = '' OR COALESCE(metadata->'labels', '[]'::jsonb) @> jsonb_build_array($2::text))
+			AND ($3 = '' OR ($3 = 'none' AND metadata->>'assignee' IS NULL) OR metadata->>'assignee' = $3)
+			AND ($4 OR NOT EXISTS (SELECT 1 FROM room_members m WHERE m.room_id = rooms.id AND m.role = 'owner')
+				OR EXISTS (SELECT 1 FROM room_members m WHERE m.room_id = rooms.id AND m.user_id = $5 AND $5 <> ''))
+		ORDER BY id`, status, label, assignee, isModerator(r), verifiedUser(r))
+	if err != nil {
+		http.Error(w, "Failed to fetch rooms", http.StatusInternalServerError)
+		log.Println("Error fetching rooms:", err)
+		return
+	}
+	defer rows.Close()
+
+	rooms := []Room{}
+	for rows.Next() {
+		var room Room
+		if err := rows.Scan(&room.ID, &room.Name, &room.State, &room.Metadata, &room.CreatedAt); err != nil {
+			http.Error(w, "Error processing rooms", http.StatusInternalServerError)
+			log.Println("Error scanning rooms:", err)
+			return
+		}
+		rooms = append(rooms, room)
+	}
+
+	writeJSONWithETag(w, r, rooms)
+}
+
+// Handler to create a room
+func createRoom(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string `json:"name"`
+		Incognito bool   `json:"incognito"`
+	}
+	if name := r.URL.Query().Get("template"); name != "" {
+		createRoomFromTemplate(w, r, name)
+		return
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "A room name is required", http.StatusBadRequest)
+		return
+	}
+
+	var room Room
+	err := db.QueryRow(context.Background(),
+		"INSERT INTO rooms (name, metadata) VALUES ($1, $2) RETURNING id, name, state, metadata, created_at",
+		strings.TrimSpace(req.Name), RoomMetadata{Incognito: req.Incognito}).
+		Scan(&room.ID, &room.Name, &room.State, &room.Metadata, &room.CreatedAt)
+	if err != nil {
+		http.Error(w, "Failed to create room", http.StatusInternalServerError)
+		log.Println("Error creating room:", err)
+		return
+	}
+	// A verified user who creates a room owns it
+	if user := verifiedUser(r); user != "" {
+		if err := addRoomOwner(room.ID, user); err != nil {
+			log.Println("Error adding room owner:", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(room)
+}
+
+// Handler to fetch one room
+func getRoomHandler(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok || !requireRoomRole(w, r, room, roleMember, true) {
+		return
+	}
+	writeJSONWithETag(w, r, room)
+}
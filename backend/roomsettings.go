@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Room settings are key/value pairs kept in the room's metadata. The built-in ones
+// change how the AI answers; any other key is a variable that /template fills in.
+const (
+	maxRoomSettings          = 32
+	maxRoomSettingValueChars = 200
+)
+
+var roomSettingKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+var errTooManyRoomSettings = fmt.Errorf("rooms can have at most %d settings", maxRoomSettings)
+
+// verbosityInstructions are added to prompts for each verbosity setting
+var verbosityInstructions = map[string]string{
+	"brief":    "Answer briefly, in a few sentences at most.",
+	"normal":   "",
+	"detailed": "Answer in detail, with explanations and examples where they help.",
+}
+
+// roomSettingValidators check the built-in settings' values
+var roomSettingValidators = map[string]func(value string) error{
+	"temperature": func(value string) error { return checkFloatSetting(value, 0, 2) },
+	"top_p":       func(value string) error { return checkFloatSetting(value, 0, 1) },
+	"max_tokens": func(value string) error {
+		if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 32768 {
+			return fmt.Errorf("must be a whole number from 1 to 32768")
+		}
+		return nil
+	},
+	"language": func(value string) error {
+		if len(value) > 32 {
+			return fmt.Errorf("must be a language name such as French")
+		}
+		return nil
+	},
+	"verbosity": func(value string) error {
+		if _, ok := verbosityInstructions[value]; !ok {
+			return fmt.Errorf("must be brief, normal or detailed")
+		}
+		return nil
+	},
+}
+
+// RoomSettingsEvent tells a room's clients its settings changed
+type RoomSettingsEvent struct {
+	RoomID   int               `json:"room_id"`
+	Settings map[string]string `json:"settings"`
+}
+
+// initRoomSettings registers the /set and /unset commands
+func initRoomSettings() {
+	registerCommand("set", setCommand)
+	registerCommand("unset", unsetCommand)
+}
+
+// checkFloatSetting checks a number is within a range
+func checkFloatSetting(value string, lowest, highest float64) error {
+	if f, err := strconv.ParseFloat(value, 64); err != nil || f < lowest || f > highest {
+		return fmt.Errorf("must be a number from %g to %g", lowest, highest)
+	}
+	return nil
+}
+
+// validateRoomSetting checks a key and value, normalizing the value
+func validateRoomSetting(key, value string) (string, error) {
+	if !roomSettingKeyPattern.MatchString(key) {
+		return "", fmt.Errorf("setting names are lowercase letters, digits and underscores")
+	}
+	value = strings.TrimSpace(value)
+	if length := utf8.RuneCountInString(value); length == 0 || length > maxRoomSettingValueChars {
+		return "", fmt.Errorf("%s needs a value of up to %d characters", key, maxRoomSettingValueChars)
+	}
+	if key == "verbosity" {
+		value = strings.ToLower(value)
+	}
+	if validate, ok := roomSettingValidators[key]; ok {
+		if err := validate(value); err != nil {
+			return "", fmt.Errorf("%s %v", key, err)
+		}
+	}
+	return value, nil
+}
+
+// roomSettings loads a room's settings
+func roomSettings(roomID int) (map[string]string, error) {
+	room, err := getRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room.Metadata.Settings == nil {
+		return map[string]string{}, nil
+	}
+	return room.Metadata.Settings, nil
+}
+
+// updateRoomSettings applies changes to a room's settings (nil values remove a setting),
+// stores them and tells the room's clients
+func updateRoomSettings(roomID int, changes map[string]*string) (map[string]string, error) {
+	settings, err := roomSettings(roomID)
+	if err != nil {
+		return nil, err
+	}
+	before := make(map[string]string, len(settings))
+	for key, value := range settings {
+		before[key] = value
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(settings, key)
+			continue
+		}
+		normalized, err := validateRoomSetting(key, *value)
+		if err != nil {
+			return nil, err
+		}
+		settings[key] = normalized
+	}
+	if len(settings) > maxRoomSettings {
+		return nil, errTooManyRoomSettings
+	}
+
+	_, err = db.Exec(context.Background(),
+		"UPDATE rooms SET metadata = metadata || jsonb_build_object('settings', $2::jsonb) WHERE id = $1", roomID, settings)
+	if err != nil {
+		return nil, err
+	}
+	publishRoomEvent(roomID, nil, "room_settings", RoomSettingsEvent{RoomID: roomID, Settings: settings})
+	annotateSettingsChanges(roomID, before, settings)
+	return settings, nil
+}
+
+// settingsParams are the sampling parameters the room's settings ask for
+func settingsParams(settings map[string]string) GenerationParams {
+	var p GenerationParams
+	if value, err := strconv.ParseFloat(settings["temperature"], 64); err == nil {
+		p.Temperature = &value
+	}
+	if value, err := strconv.ParseFloat(settings["top_p"], 64); err == nil {
+		p.TopP = &value
+	}
+	if value, err := strconv.Atoi(settings["max_tokens"]); err == nil {
+		p.MaxTokens = &value
+	}
+	return p
+}
+
+// with returns the parameters with those set in other taking precedence
+func (p GenerationParams) with(other GenerationParams) GenerationParams {
+	if other.Temperature != nil {
+		p.Temperature = other.Temperature
+	}
+	if other.TopP != nil {
+		p.TopP = other.TopP
+	}
+	if other.MaxTokens != nil {
+		p.MaxTokens = other.MaxTokens
+	}
+	if other.Seed != nil {
+		p.Seed = other.Seed
+	}
+	return p
+}
+
+// settingsInstructions tell the model the room's language and verbosity, or "" for neither
+func settingsInstructions(settings map[string]string) string {
+	var lines []string
+	if language := settings["language"]; language != "" {
+		lines = append(lines, "Answer in "+language+".")
+	}
+	if instruction := verbosityInstructions[settings["verbosity"]]; instruction != "" {
+		lines = append(lines, instruction)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, " ") + "\n\n"
+}
+
+// formatRoomSettings lists settings one per line, sorted by name
+func formatRoomSettings(settings map[string]string) string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = fmt.Sprintf("%s = %s", key, settings[key])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// setCommand shows the room's settings ("/set"), one setting ("/set language") or changes one ("/set language French")
+func setCommand(s *Session, args string) {
+	key, value, _ := strings.Cut(strings.TrimSpace(args), " ")
+	key = strings.ToLower(key)
+	settings, err := roomSettings(s.room)
+	if err != nil {
+		log.Println("Error fetching room settings:", err)
+		s.sendText("⚙️ Could not load the room's settings, please try again later.")
+		return
+	}
+
+	switch {
+	case key == "":
+		if len(settings) == 0 {
+			s.sendText("⚙️ This room has no settings. Usage: /set <name> <value> (temperature, top_p, max_tokens, language, verbosity or your own variables)")
+			return
+		}
+		s.sendText("⚙️ Room settings:\n" + formatRoomSettings(settings))
+	case strings.TrimSpace(value) == "":
+		if current, ok := settings[key]; ok {
+			s.sendText(fmt.Sprintf("⚙️ %s = %s", key, current))
+		} else {
+			s.sendText(fmt.Sprintf("⚙️ %s is not set", key))
+		}
+	case !sessionHasRoomRole(s, roleModerator, true):
+		s.sendText("⚙️ Only the room's moderators and owners can change its settings.")
+	default:
+		if value, err = validateRoomSetting(key, value); err != nil {
+			s.sendText("⚙️ " + err.Error())
+			return
+		}
+		if _, err := updateRoomSettings(s.room, map[string]*string{key: &value}); err == errTooManyRoomSettings {
+			s.sendText("⚙️ " + err.Error())
+			return
+		} else if err != nil {
+			log.Println("Error updating room settings:", err)
+			s.sendText("⚙️ Could not update the room's settings, please try again later.")
+			return
+		}
+		s.sendText(fmt.Sprintf("⚙️ Set %s = %s", key, value))
+	}
+}
+
+// unsetCommand removes a room setting: "/unset language"
+func unsetCommand(s *Session, args string) {
+	key := strings.ToLower(strings.TrimSpace(args))
+	if key == "" {
+		s.sendText("⚙️ Usage: /unset <name>")
+		return
+	}
+	if !sessionHasRoomRole(s, roleModerator, true) {
+		s.sendText("⚙️ Only the room's moderators and owners can change its settings.")
+		return
+	}
+	if _, err := updateRoomSettings(s.room, map[string]*string{key: nil}); err != nil {
+		log.Println("Error updating room settings:", err)
+		s.sendText("⚙️ Could not update the room's settings, please try again later.")
+		return
+	}
+	s.sendText(fmt.Sprintf("⚙️ Removed %s", key))
+}
+
+// Handler for /api/rooms/{id}/settings: list with GET, change with PUT or PATCH
+// ({"language": "French", "verbosity": null} sets one and removes the other). Changes to
+// a room with an owner take its moderator role.
+func handleRoomSettings(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+
+	settings := room.Metadata.Settings
+	switch r.Method {
+	case http.MethodGet:
+		if settings == nil {
+			settings = map[string]string{}
+		}
+	case http.MethodPut, http.MethodPatch:
+		if !requireRoomRole(w, r, room, roleModerator, true) {
+			return
+		}
+		var changes map[string]*string
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		for key, value := range changes {
+			if value != nil {
+				if _, err := validateRoomSetting(key, *value); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		var err error
+		settings, err = updateRoomSettings(room.ID, changes)
+		if err == errTooManyRoomSettings {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to update room settings", http.StatusInternalServerError)
+			log.Println("Error updating room settings:", err)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Room lifecycle states
+const (
+	roomActive   = "active"   // Anyone can post
+	roomArchived = "archived" // Readable, but nobody can post
+	roomLocked   = "locked"   // Only moderators can post
+)
+
+// RoomStateEvent tells a room's clients its state changed
+type RoomStateEvent struct {
+	RoomID int    `json:"room_id"`
+	State  string `json:"state"`
+}
+
+// Add the rooms.state column if it doesn't exist
+func migrateRoomStates() {
+	query := `
+		ALTER TABLE rooms ADD COLUMN IF NOT EXISTS state TEXT NOT NULL DEFAULT 'active';
+		ALTER TABLE rooms ADD COLUMN IF NOT EXISTS state_changed_at TIMESTAMPTZ;
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to add room states:", err)
+	}
+}
+
+// roomRefusesMessage explains why the session can't post in its room, or returns "" if it can
+func roomRefusesMessage(s *Session) (code, message string) {
+	room, err := getRoom(s.room)
+	if err != nil {
+		log.Println("Error fetching room state:", err)
+		return "", ""
+	}
+	return roomStateRefuses(room, s.moderator)
+}
+
+// roomStateRefuses explains why a room's state keeps someone from posting in it, or
+// returns "" if it doesn't; moderator says whether they presented the moderator token
+func roomStateRefuses(room *Room, moderator bool) (code, message string) {
+	switch {
+	case room.State == roomArchived:
+		return "room_archived", "This room is archived; its history is read-only"
+	case room.State == roomLocked && !moderator:
+		return "room_locked", "This room is locked; only moderators can post"
+	}
+	return "", ""
+}
+
+// changeRoomState moves a room to another state, telling its clients and the audit log
+func changeRoomState(room *Room, state, actor, ip string) error {
+	previous := room.State
+	if previous == state {
+		return nil
+	}
+	if _, err := db.Exec(context.Background(), "UPDATE rooms SET state = $2, state_changed_at = NOW() WHERE id = $1", room.ID, state); err != nil {
+		return err
+	}
+	room.State = state
+	recordAudit(actor, ip, "room.state", strconv.Itoa(room.ID), map[string]string{"from": previous, "to": state})
+	publishRoomEvent(room.ID, nil, "room_state", RoomStateEvent{RoomID: room.ID, State: state})
+	log.Printf("🚪 Room %d is now %s", room.ID, state)
+	return nil
+}
+
+// Handler for /api/rooms/{id}/state: move a room to another state ({"state": "archived"})
+func setRoomState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		State string `json:"state"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.State != roomActive && req.State != roomArchived && req.State != roomLocked {
+		http.Error(w, `state must be "active", "archived" or "locked"`, http.StatusBadRequest)
+		return
+	}
+
+	if err := changeRoomState(room, req.State, "moderator", clientIP(r)); err != nil {
+		http.Error(w, "Failed to update room state", http.StatusInternalServerError)
+		log.Println("Error updating room state:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
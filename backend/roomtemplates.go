@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maxPersonaChars limits a room template's persona instructions
+const maxPersonaChars = 4000
+
+// RoomTemplate presets a purpose-built assistant room: its persona, model, knowledge
+// bases, welcome message and settings. Rooms created from one copy it, so later
+// changes to the template don't affect existing rooms.
+type RoomTemplate struct {
+	Name             string            `json:"name"`
+	Description      string            `json:"description"`
+	Persona          string            `json:"persona,omitempty"`
+	Provider         string            `json:"provider,omitempty"`
+	Model            string            `json:"model,omitempty"`
+	KnowledgeBaseIDs []int             `json:"knowledge_base_ids,omitempty"`
+	Welcome          *RoomWelcome      `json:"welcome,omitempty"`
+	Settings         map[string]string `json:"settings,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+}
+
+// initRoomTemplates creates the room templates table
+func initRoomTemplates() {
+	createRoomTemplatesTable()
+}
+
+// Create `room_templates` table if it doesn't exist
+func createRoomTemplatesTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS room_templates (
+			name TEXT PRIMARY KEY,
+			description TEXT NOT NULL DEFAULT '',
+			persona TEXT NOT NULL DEFAULT '',
+			provider TEXT NOT NULL DEFAULT '',
+			model TEXT NOT NULL DEFAULT '',
+			knowledge_base_ids INTEGER[] NOT NULL DEFAULT '{}',
+			welcome JSONB,
+			settings JSONB NOT NULL DEFAULT '{}',
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			updated_at TIMESTAMPTZ DEFAULT NOW()
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create room_templates table:", err)
+	}
+	log.Println("✅ Table room_templates is ready")
+}
+
+const roomTemplateColumns = "name, description, persona, provider, model, knowledge_base_ids, welcome, settings, created_at, updated_at"
+
+// scanRoomTemplate reads a row of roomTemplateColumns
+func scanRoomTemplate(row pgx.Row) (*RoomTemplate, error) {
+	var t RoomTemplate
+	err := row.Scan(&t.Name, &t.Description, &t.Persona, &t.Provider, &t.Model, &t.KnowledgeBaseIDs, &t.Welcome, &t.Settings, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// getRoomTemplate loads a room template by name
+func getRoomTemplate(name string) (*RoomTemplate, error) {
+	return scanRoomTemplate(db.QueryRow(context.Background(),
+		"SELECT "+roomTemplateColumns+" FROM room_templates WHERE name = $1", name))
+}
+
+// validate checks a template and normalizes its settings
+func (t *RoomTemplate) validate() error {
+	if !templateNamePattern.MatchString(t.Name) {
+		return fmt.Errorf("room template names use lowercase letters, digits, - and _")
+	}
+	t.Persona = strings.TrimSpace(t.Persona)
+	if utf8.RuneCountInString(t.Persona) > maxPersonaChars {
+		return fmt.Errorf("the persona can be at most %d characters", maxPersonaChars)
+	}
+	if t.Model != "" && t.Provider == "" {
+		return fmt.Errorf("a model needs a provider")
+	}
+	if t.Provider != "" && findProvider(t.Provider) == nil {
+		return fmt.Errorf("unknown provider %q", t.Provider)
+	}
+	if len(t.KnowledgeBaseIDs) > 0 && !ragEnabled {
+		return fmt.Errorf("the knowledge base is disabled")
+	}
+	for _, id := range t.KnowledgeBaseIDs {
+		if !knowledgeBaseExists(id) {
+			return fmt.Errorf("knowledge base %d does not exist", id)
+		}
+	}
+	if len(t.Settings) > maxRoomSettings {
+		return errTooManyRoomSettings
+	}
+	for key, value := range t.Settings {
+		normalized, err := validateRoomSetting(key, value)
+		if err != nil {
+			return err
+		}
+		t.Settings[key] = normalized
+	}
+	return nil
+}
+
+// roomMetadata is the metadata of a room created from the template
+func (t *RoomTemplate) roomMetadata() RoomMetadata {
+	return RoomMetadata{
+		KnowledgeBase:    len(t.KnowledgeBaseIDs) > 0,
+		KnowledgeBaseIDs: t.KnowledgeBaseIDs,
+		Welcome:          t.Welcome,
+		Settings:         t.Settings,
+		Persona:          t.Persona,
+		Provider:         t.Provider,
+		Model:            t.Model,
+		Template:         t.Name,
+	}
+}
+
+// personaInstructions put a room's persona before the prompt, or "" without one
+func personaInstructions(persona string) string {
+	if persona == "" {
+		return ""
+	}
+	return persona + "\n\n"
+}
+
+// Handler to create a room from a room template: POST /api/rooms?template=support-bot
+// with an optional {"name": "..."}, which defaults to the template's name. Templates
+// pick models and knowledge bases, so this takes the admin token.
+func createRoomFromTemplate(w http.ResponseWriter, r *http.Request, name string) {
+	if adminToken == "" {
+		http.Error(w, "Admin API is disabled", http.StatusServiceUnavailable)
+		return
+	}
+	if !isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	t, err := getRoomTemplate(strings.ToLower(name))
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Room template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch room template", http.StatusInternalServerError)
+		log.Println("Error fetching room template:", err)
+		return
+	}
+	// Providers and knowledge bases may have gone since the template was saved
+	if err := t.validate(); err != nil {
+		http.Error(w, "Room template "+t.Name+" is no longer valid: "+err.Error(), http.StatusConflict)
+		return
+	}
+	roomName := strings.TrimSpace(req.Name)
+	if roomName == "" {
+		roomName = t.Name
+	}
+
+	room, err := insertTemplatedRoom(roomName, t)
+	if err != nil {
+		http.Error(w, "Failed to create room", http.StatusInternalServerError)
+		log.Println("Error creating room from template:", err)
+		return
+	}
+	if user := verifiedUser(r); user != "" {
+		if err := addRoomOwner(room.ID, user); err != nil {
+			log.Println("Error adding room owner:", err)
+		}
+	}
+	recordAudit("admin", clientIP(r), "room.create_from_template", strconv.Itoa(room.ID), map[string]string{"template": t.Name, "name": room.Name})
+	addCounter("cubbychat_templated_rooms_created_total", "Rooms created from room templates", 1, "template", t.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(room)
+}
+
+// insertTemplatedRoom creates the room and binds its knowledge bases in one transaction
+func insertTemplatedRoom(name string, t *RoomTemplate) (*Room, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var room Room
+	err = tx.QueryRow(ctx,
+		"INSERT INTO rooms (name, metadata) VALUES ($1, $2) RETURNING id, name, state, metadata, created_at", name, t.roomMetadata()).
+		Scan(&room.ID, &room.Name, &room.State, &room.Metadata, &room.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range t.KnowledgeBaseIDs {
+		if _, err := tx.Exec(ctx,
+			"INSERT INTO room_knowledge_bases (room_id, knowledge_base_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", room.ID, id); err != nil {
+			return nil, err
+		}
+	}
+	return &room, tx.Commit(ctx)
+}
+
+// Handler for /api/admin/room-templates: create or replace with POST, list with GET
+func handleRoomTemplates(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		saveRoomTemplate(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rows, err := db.Query(context.Background(), "SELECT "+roomTemplateColumns+" FROM room_templates ORDER BY name")
+	if err != nil {
+		http.Error(w, "Failed to fetch room templates", http.StatusInternalServerError)
+		log.Println("Error fetching room templates:", err)
+		return
+	}
+	defer rows.Close()
+
+	templates := []*RoomTemplate{}
+	for rows.Next() {
+		t, err := scanRoomTemplate(rows)
+		if err != nil {
+			http.Error(w, "Error processing room templates", http.StatusInternalServerError)
+			log.Println("Error scanning room templates:", err)
+			return
+		}
+		templates = append(templates, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+// Handler to create or update a room template
+func saveRoomTemplate(w http.ResponseWriter, r *http.Request) {
+	var t RoomTemplate
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	t.Name = strings.ToLower(strings.TrimSpace(t.Name))
+	if err := t.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if t.KnowledgeBaseIDs == nil {
+		t.KnowledgeBaseIDs = []int{}
+	}
+	if t.Settings == nil {
+		t.Settings = map[string]string{}
+	}
+
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO room_templates (name, description, persona, provider, model, knowledge_base_ids, welcome, settings)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description, persona = EXCLUDED.persona,
+			provider = EXCLUDED.provider, model = EXCLUDED.model, knowledge_base_ids = EXCLUDED.knowledge_base_ids,
+			welcome = EXCLUDED.welcome, settings = EXCLUDED.settings, updated_at = NOW()
+		RETURNING created_at, updated_at`,
+		t.Name, t.Description, t.Persona, t.Provider, t.Model, t.KnowledgeBaseIDs, t.Welcome, t.Settings).Scan(&t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		http.Error(w, "Failed to save room template", http.StatusInternalServerError)
+		log.Println("Error saving room template:", err)
+		return
+	}
+	recordAudit("admin", clientIP(r), "room_template.save", t.Name, t)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// Handler for /api/admin/room-templates/{name}: fetch with GET, remove with DELETE
+func handleRoomTemplate(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(r.PathValue("name"))
+
+	if r.Method == http.MethodDelete {
+		tag, err := db.Exec(context.Background(), "DELETE FROM room_templates WHERE name = $1", name)
+		if err != nil {
+			http.Error(w, "Failed to delete room template", http.StatusInternalServerError)
+			log.Println("Error deleting room template:", err)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			http.Error(w, "Room template not found", http.StatusNotFound)
+			return
+		}
+		recordAudit("admin", clientIP(r), "room_template.delete", name, nil)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	t, err := getRoomTemplate(name)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Room template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch room template", http.StatusInternalServerError)
+		log.Println("Error fetching room template:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	sandboxEnabled   bool          // Whether the run_code tool is offered to the model (off by default)
+	sandboxBackend   string        // "piston" (HTTP sandbox service) or "docker"
+	sandboxURL       string        // Piston API base URL
+	sandboxTimeout   time.Duration // Wall-clock limit per execution
+	sandboxMemoryMB  int           // Memory limit per execution
+	sandboxCPUs      string        // CPU quota for the docker backend (e.g. "0.5")
+	sandboxMaxOutput int           // Output bytes returned to the model
+	sandboxImages    = map[string]string{}
+)
+
+// Languages the sandbox accepts, with their Piston names and interpreter commands
+var sandboxLanguages = map[string]struct {
+	piston  string
+	command []string
+}{
+	"python":     {piston: "python", command: []string{"python3", "-c"}},
+	"javascript": {piston: "javascript", command: []string{"node", "-e"}},
+}
+
+// pistonExecuteRequest is the body of Piston's POST /api/v2/execute
+type pistonExecuteRequest struct {
+	Language       string       `json:"language"`
+	Version        string       `json:"version"`
+	Files          []pistonFile `json:"files"`
+	RunTimeout     int64        `json:"run_timeout"`
+	RunCPUTime     int64        `json:"run_cpu_time"`
+	RunMemoryLimit int64        `json:"run_memory_limit"`
+}
+
+type pistonFile struct {
+	Content string `json:"content"`
+}
+
+type pistonExecuteResponse struct {
+	Message string `json:"message"`
+	Run     struct {
+		Stdout string  `json:"stdout"`
+		Stderr string  `json:"stderr"`
+		Code   *int    `json:"code"`
+		Signal *string `json:"signal"`
+	} `json:"run"`
+}
+
+// initCodeSandbox reads the sandbox settings and registers the run_code tool when enabled
+func initCodeSandbox() {
+	sandboxEnabled = getEnvBool("CODE_SANDBOX_ENABLED", false)
+	sandboxBackend = getEnv("CODE_SANDBOX_BACKEND", "piston")
+	sandboxURL = getEnv("CODE_SANDBOX_URL", "http://piston:2000")
+	sandboxTimeout = getEnvDuration("CODE_SANDBOX_TIMEOUT", 10*time.Second)
+	sandboxMemoryMB = getEnvInt("CODE_SANDBOX_MEMORY_MB", 128)
+	sandboxCPUs = getEnv("CODE_SANDBOX_CPUS", "0.5")
+	sandboxMaxOutput = getEnvInt("CODE_SANDBOX_MAX_OUTPUT", 8*1024)
+	sandboxImages["python"] = getEnv("CODE_SANDBOX_PYTHON_IMAGE", "python:3.12-alpine")
+	sandboxImages["javascript"] = getEnv("CODE_SANDBOX_NODE_IMAGE", "node:22-alpine")
+
+	if !sandboxEnabled {
+		return
+	}
+	if sandboxBackend != "piston" && sandboxBackend != "docker" {
+		log.Printf("⚠️ Unknown CODE_SANDBOX_BACKEND %q, code execution disabled", sandboxBackend)
+		sandboxEnabled = false
+		return
+	}
+
+	registerTool(&Tool{
+		Name:        "run_code",
+		Description: "Run a short Python or JavaScript snippet in an isolated sandbox and return its output. Use it for calculations and quick experiments. No network or file access.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"language": map[string]interface{}{
+					"type": "string",
+					"enum": []string{"python", "javascript"},
+				},
+				"code": map[string]interface{}{
+					"type":        "string",
+					"description": "The source code to run",
+				},
+			},
+			"required": []string{"language", "code"},
+		},
+		Run:     runCodeTool,
+		Timeout: sandboxTimeout + 5*time.Second,
+	})
+	log.Printf("🧪 Code sandbox enabled (backend: %s, timeout: %v, memory: %dMB)", sandboxBackend, sandboxTimeout, sandboxMemoryMB)
+}
+
+// runCodeTool validates the model's arguments and executes the snippet
+func runCodeTool(ctx context.Context, args map[string]interface{}) (*ToolResult, error) {
+	language, _ := args["language"].(string)
+	code, _ := args["code"].(string)
+	language = strings.ToLower(strings.TrimSpace(language))
+	if _, ok := sandboxLanguages[language]; !ok {
+		return nil, fmt.Errorf("unsupported language %q", language)
+	}
+	if strings.TrimSpace(code) == "" {
+		return nil, errors.New("no code provided")
+	}
+
+	var output string
+	var err error
+	if sandboxBackend == "docker" {
+		output, err = runInDocker(ctx, language, code)
+	} else {
+		output, err = runInPiston(ctx, language, code)
+	}
+	return &ToolResult{Output: truncateOutput(output)}, err
+}
+
+// runInPiston executes code through a Piston sandbox service, which enforces the limits
+func runInPiston(ctx context.Context, language, code string) (string, error) {
+	client := newUpstreamClient()
+	request := pistonExecuteRequest{
+		Language:       sandboxLanguages[language].piston,
+		Version:        "*",
+		Files:          []pistonFile{{Content: code}},
+		RunTimeout:     sandboxTimeout.Milliseconds(),
+		RunCPUTime:     sandboxTimeout.Milliseconds(),
+		RunMemoryLimit: int64(sandboxMemoryMB) * 1024 * 1024,
+	}
+
+	var result pistonExecuteResponse
+	resp, err := client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(request).
+		SetResult(&result).
+		SetError(&result).
+		Post(sandboxURL + "/api/v2/execute")
+	if err != nil {
+		return "", fmt.Errorf("sandbox unavailable: %v", err)
+	}
+	if resp.StatusCode() != 200 {
+		return "", fmt.Errorf("sandbox returned status %d: %s", resp.StatusCode(), result.Message)
+	}
+
+	output := result.Run.Stdout
+	if result.Run.Stderr != "" {
+		output += "\n[stderr]\n" + result.Run.Stderr
+	}
+	if result.Run.Signal != nil {
+		return output, fmt.Errorf("execution killed by %s (time or memory limit exceeded)", *result.Run.Signal)
+	}
+	if result.Run.Code != nil && *result.Run.Code != 0 {
+		return output, fmt.Errorf("exited with code %d", *result.Run.Code)
+	}
+	return output, nil
+}
+
+// runInDocker executes code in a throwaway container with no network and hard resource limits
+func runInDocker(ctx context.Context, language, code string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, sandboxTimeout)
+	defer cancel()
+
+	name := fmt.Sprintf("cubby-sandbox-%d", time.Now().UnixNano())
+	args := []string{
+		"run", "--rm", "-i",
+		"--name", name,
+		"--network", "none",
+		"--read-only",
+		"--tmpfs", "/tmp:rw,size=16m",
+		"--memory", fmt.Sprintf("%dm", sandboxMemoryMB),
+		"--memory-swap", fmt.Sprintf("%dm", sandboxMemoryMB),
+		"--cpus", sandboxCPUs,
+		"--pids-limit", "64",
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+		"--user", "65534:65534",
+		sandboxImages[language],
+	}
+	args = append(args, sandboxLanguages[language].command...)
+	args = append(args, code)
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	// One byte over the limit is enough for truncateOutput to notice
+	stdout := &limitedBuffer{limit: sandboxMaxOutput + 1}
+	stderr := &limitedBuffer{limit: sandboxMaxOutput + 1}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	output := stdout.String()
+	if stderr.Len() > 0 {
+		output += "\n[stderr]\n" + stderr.String()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		// Killing the docker CLI doesn't stop the container itself
+		if err := exec.Command("docker", "rm", "-f", name).Run(); err != nil {
+			log.Printf("⚠️ Failed to remove sandbox container %s: %v", name, err)
+		}
+		return output, fmt.Errorf("execution timed out after %v", sandboxTimeout)
+	}
+	if err != nil {
+		return output, fmt.Errorf("execution failed: %v", err)
+	}
+	return output, nil
+}
+
+// truncateOutput keeps sandbox output within the configured size, cutting on a character boundary
+func truncateOutput(output string) string {
+	if len(output) <= sandboxMaxOutput {
+		return output
+	}
+	return cutText(output, sandboxMaxOutput) + "\n... (output truncated)"
+}
+
+// cutText keeps at most n bytes of text, cutting on a character boundary
+func cutText(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
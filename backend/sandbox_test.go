@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateOutput(t *testing.T) {
+	oldMax := sandboxMaxOutput
+	t.Cleanup(func() { sandboxMaxOutput = oldMax })
+	sandboxMaxOutput = 5
+
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"short", "hi", "hi"},
+		{"at the limit", "hello", "hello"},
+		{"over the limit", "hello world", "hello"},
+		{"cut inside a character", "abcdé", "abcd"},
+		{"multibyte at the limit", "aéé", "aéé"},
+		{"wide character that fits", "ab日本", "ab日"},
+		{"wide character that doesn't fit", "abc日", "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateOutput(tt.output)
+			if !utf8.ValidString(got) {
+				t.Errorf("truncateOutput(%q) = %q, which isn't valid UTF-8", tt.output, got)
+			}
+			if kept := strings.TrimSuffix(got, "\n... (output truncated)"); kept != tt.want {
+				t.Errorf("truncateOutput(%q) kept %q, want %q", tt.output, kept, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	webSearchEnabled    bool   // Whether the web_search tool is offered to the model
+	webSearchProvider   string // "searxng", "brave" or "bing"
+	webSearchURL        string // Base URL of the search API
+	webSearchAPIKey     string // API key for Brave or Bing
+	webSearchMaxResults int    // Results handed to the model per search
+)
+
+// Default API endpoints per search provider
+var defaultSearchURLs = map[string]string{
+	"searxng": "http://searxng:8080",
+	"brave":   "https://api.search.brave.com",
+	"bing":    "https://api.bing.microsoft.com",
+}
+
+// Source is a citable search result
+type Source struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// initWebSearch reads the search settings and registers the web_search tool when enabled
+func initWebSearch() {
+	webSearchEnabled = getEnvBool("WEB_SEARCH_ENABLED", false)
+	webSearchProvider = strings.ToLower(getEnv("WEB_SEARCH_PROVIDER", "searxng"))
+	webSearchURL = getEnv("WEB_SEARCH_URL", defaultSearchURLs[webSearchProvider])
+	webSearchAPIKey = getEnv("WEB_SEARCH_API_KEY", "")
+	webSearchMaxResults = getEnvInt("WEB_SEARCH_MAX_RESULTS", 5)
+
+	if !webSearchEnabled {
+		return
+	}
+	switch webSearchProvider {
+	case "searxng":
+	case "brave", "bing":
+		if webSearchAPIKey == "" {
+			log.Printf("⚠️ WEB_SEARCH_API_KEY is required for %s, web search disabled", webSearchProvider)
+			webSearchEnabled = false
+			return
+		}
+	default:
+		log.Printf("⚠️ Unknown WEB_SEARCH_PROVIDER %q, web search disabled", webSearchProvider)
+		webSearchEnabled = false
+		return
+	}
+
+	registerTool(&Tool{
+		Name:        "web_search",
+		Description: "Search the web for current information. Results are numbered; cite the ones you use in your answer as [1], [2], etc.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "The search query",
+				},
+			},
+			"required": []string{"query"},
+		},
+		Run:     webSearchTool,
+		Timeout: 15 * time.Second,
+	})
+	log.Printf("🔎 Web search enabled (provider: %s)", webSearchProvider)
+}
+
+// webSearchTool runs a search with the configured provider
+func webSearchTool(ctx context.Context, args map[string]interface{}) (*ToolResult, error) {
+	query, _ := args["query"].(string)
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, errors.New("no query provided")
+	}
+
+	var sources []Source
+	var err error
+	switch webSearchProvider {
+	case "brave":
+		sources, err = searchBrave(ctx, query)
+	case "bing":
+		sources, err = searchBing(ctx, query)
+	default:
+		sources, err = searchSearxNG(ctx, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) > webSearchMaxResults {
+		sources = sources[:webSearchMaxResults]
+	}
+	if len(sources) == 0 {
+		return &ToolResult{Output: "No results found."}, nil
+	}
+	return &ToolResult{Sources: sources}, nil
+}
+
+// searchSearxNG queries a SearxNG instance's JSON API
+func searchSearxNG(ctx context.Context, query string) ([]Source, error) {
+	var result struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+
+	resp, err := newUpstreamClient().R().
+		SetContext(ctx).
+		SetQueryParams(map[string]string{"q": query, "format": "json"}).
+		SetResult(&result).
+		Get(webSearchURL + "/search")
+	if err != nil {
+		return nil, fmt.Errorf("search unavailable: %v", err)
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("search returned status %d", resp.StatusCode())
+	}
+
+	var sources []Source
+	for _, r := range result.Results {
+		sources = append(sources, Source{Title: r.Title, URL: r.URL, Snippet: r.Content})
+	}
+	return sources, nil
+}
+
+// searchBrave queries the Brave Search API
+func searchBrave(ctx context.Context, query string) ([]Source, error) {
+	var result struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+
+	resp, err := newUpstreamClient().R().
+		SetContext(ctx).
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Subscription-Token", webSearchAPIKey).
+		SetQueryParams(map[string]string{"q": query, "count": fmt.Sprint(webSearchMaxResults)}).
+		SetResult(&result).
+		Get(webSearchURL + "/res/v1/web/search")
+	if err != nil {
+		return nil, fmt.Errorf("search unavailable: %v", err)
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("search returned status %d", resp.StatusCode())
+	}
+
+	var sources []Source
+	for _, r := range result.Web.Results {
+		sources = append(sources, Source{Title: r.Title, URL: r.URL, Snippet: r.Description})
+	}
+	return sources, nil
+}
+
+// searchBing queries the Bing Web Search API
+func searchBing(ctx context.Context, query string) ([]Source, error) {
+	var result struct {
+		WebPages struct {
+			Value []struct {
+				Name    string `json:"name"`
+				URL     string `json:"url"`
+				Snippet string `json:"snippet"`
+			} `json:"value"`
+		} `json:"webPages"`
+	}
+
+	resp, err := newUpstreamClient().R().
+		SetContext(ctx).
+		SetHeader("Ocp-Apim-Subscription-Key", webSearchAPIKey).
+		SetQueryParams(map[string]string{"q": query, "count": fmt.Sprint(webSearchMaxResults)}).
+		SetResult(&result).
+		Get(webSearchURL + "/v7.0/search")
+	if err != nil {
+		return nil, fmt.Errorf("search unavailable: %v", err)
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("search returned status %d", resp.StatusCode())
+	}
+
+	var sources []Source
+	for _, r := range result.WebPages.Value {
+		sources = append(sources, Source{Title: r.Name, URL: r.URL, Snippet: r.Snippet})
+	}
+	return sources, nil
+}
+
+// formatToolSources renders numbered sources for the model, continuing after offset
+func formatToolSources(sources []Source, offset int) string {
+	var b strings.Builder
+	for i, src := range sources {
+		fmt.Fprintf(&b, "[%d] %s\n%s\n%s\n\n", offset+i+1, src.Title, src.URL, src.Snippet)
+	}
+	return b.String()
+}
+
+// formatSources renders the numbered source list appended to a cited answer
+func formatSources(sources []Source) string {
+	var b strings.Builder
+	b.WriteString("\n\n**Sources**\n")
+	for i, src := range sources {
+		title := src.Title
+		if title == "" {
+			title = src.URL
+		}
+		fmt.Fprintf(&b, "%d. [%s](%s)\n", i+1, title, src.URL)
+	}
+	return b.String()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// kubernetesTokenPath is where pods find their service account token
+const kubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// loadSecrets fills in environment variables from secret sources so credentials
+// don't have to be passed as plain variables. It runs before any other settings
+// are read; a variable that is already set always wins, then NAME_FILE, then
+// files in SECRETS_DIR (a mounted Kubernetes secret), then Vault.
+func loadSecrets() {
+	for _, entry := range os.Environ() {
+		key, path, _ := strings.Cut(entry, "=")
+		name, ok := strings.CutSuffix(key, "_FILE")
+		if !ok || name == "" || path == "" || os.Getenv(name) != "" {
+			continue
+		}
+		value, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("❌ Failed to read %s: %v", key, err)
+		}
+		os.Setenv(name, strings.TrimRight(string(value), "\r\n"))
+	}
+
+	if dir := os.Getenv("SECRETS_DIR"); dir != "" {
+		loadSecretsDir(dir)
+	}
+	if os.Getenv("VAULT_ADDR") != "" {
+		if err := loadVaultSecrets(); err != nil {
+			log.Fatal("❌ Failed to load secrets from Vault:", err)
+		}
+	}
+}
+
+// setSecret sets a variable unless it's already set, reporting whether it did
+func setSecret(name, value string) bool {
+	if os.Getenv(name) != "" {
+		return false
+	}
+	os.Setenv(name, value)
+	return true
+}
+
+// loadSecretsDir reads one variable per file, named after the file (Kubernetes secret volumes look like this)
+func loadSecretsDir(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Fatalf("❌ Failed to read SECRETS_DIR %s: %v", dir, err)
+	}
+	loaded := 0
+	for _, entry := range entries {
+		// Kubernetes keeps the real files in hidden ..data directories
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		value, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			log.Printf("⚠️ Skipping secret %s: %v", entry.Name(), err)
+			continue
+		}
+		if setSecret(entry.Name(), strings.TrimRight(string(value), "\r\n")) {
+			loaded++
+		}
+	}
+	log.Printf("🔐 Loaded %d secrets from %s", loaded, dir)
+}
+
+// loadVaultSecrets reads a KV v2 secret (VAULT_SECRET_PATH, e.g. "secret/data/cubbychat") and
+// sets each of its keys. It authenticates with VAULT_TOKEN, or with the pod's service
+// account when VAULT_K8S_ROLE is set.
+func loadVaultSecrets() error {
+	addr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	path := strings.Trim(os.Getenv("VAULT_SECRET_PATH"), "/")
+	if path == "" {
+		return fmt.Errorf("VAULT_SECRET_PATH is required with VAULT_ADDR")
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	token := os.Getenv("VAULT_TOKEN")
+	if role := os.Getenv("VAULT_K8S_ROLE"); token == "" && role != "" {
+		jwt, err := os.ReadFile(getEnv("VAULT_K8S_TOKEN_PATH", kubernetesTokenPath))
+		if err != nil {
+			return fmt.Errorf("reading service account token: %v", err)
+		}
+		body, _ := json.Marshal(map[string]string{"role": role, "jwt": strings.TrimSpace(string(jwt))})
+		mount := getEnv("VAULT_K8S_MOUNT", "kubernetes")
+
+		var login struct {
+			Auth struct {
+				ClientToken string `json:"client_token"`
+			} `json:"auth"`
+		}
+		if err := vaultRequest(client, http.MethodPost, addr+"/v1/auth/"+mount+"/login", "", body, &login); err != nil {
+			return fmt.Errorf("kubernetes login: %v", err)
+		}
+		token = login.Auth.ClientToken
+	}
+	if token == "" {
+		return fmt.Errorf("set VAULT_TOKEN or VAULT_K8S_ROLE")
+	}
+
+	var secret struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := vaultRequest(client, http.MethodGet, addr+"/v1/"+path, token, nil, &secret); err != nil {
+		return err
+	}
+	loaded := 0
+	for name, value := range secret.Data.Data {
+		if setSecret(name, fmt.Sprint(value)) {
+			loaded++
+		}
+	}
+	log.Printf("🔐 Loaded %d secrets from Vault (%s)", loaded, path)
+	return nil
+}
+
+// vaultRequest makes one Vault API call and decodes the JSON response
+func vaultRequest(client *http.Client, method, url, token string, body []byte, result interface{}) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
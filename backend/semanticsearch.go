@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Semantic search over past conversations: messages are embedded with the retrieval model
+// and stored in a pgvector column, and the database finds the nearest ones to the query
+// through an HNSW index, over the whole history. Callers only search rooms they may read.
+var (
+	semanticSearchEnabled    bool
+	semanticSearchLimit      int     // Exchanges returned when the request doesn't say
+	semanticSearchMinScore   float64 // Messages less similar than this are ignored
+	semanticSearchCandidates int     // Index candidates considered per query (hnsw.ef_search)
+	messageEmbeddingDims     int     // Dimensions of the embedding model's vectors
+
+	messageEmbeddingsReady bool // Whether the message embeddings table exists
+)
+
+// SemanticSearchResult is a past exchange relevant to the query: the matching message
+// with the question or answer that goes with it
+type SemanticSearchResult struct {
+	RoomID    int           `json:"room_id"`
+	Room      string        `json:"room"`
+	Score     float64       `json:"score"`
+	MessageID int           `json:"message_id"` // The message that matched
+	Messages  []ChatMessage `json:"messages"`
+}
+
+// SemanticSearchResponse is returned by GET /api/history/semantic-search
+type SemanticSearchResponse struct {
+	Query   string                 `json:"query"`
+	Results []SemanticSearchResult `json:"results"`
+}
+
+// semanticMatch is an embedded message scored against a query
+type semanticMatch struct {
+	messageID int
+	roomID    int
+	score     float64
+}
+
+// initSemanticSearch reads the search settings and adds the message embeddings table,
+// which needs the pgvector extension
+func initSemanticSearch() {
+	semanticSearchEnabled = getEnvBool("SEMANTIC_SEARCH_ENABLED", false)
+	semanticSearchLimit = max(1, getEnvInt("SEMANTIC_SEARCH_LIMIT", 10))
+	semanticSearchMinScore = getEnvFloat("SEMANTIC_SEARCH_MIN_SCORE", 0.4)
+	semanticSearchCandidates = min(1000, max(1, getEnvInt("SEMANTIC_SEARCH_CANDIDATES", 200)))
+	messageEmbeddingDims = max(1, getEnvInt("SEMANTIC_SEARCH_DIMENSIONS", 768))
+	if semanticSearchEnabled {
+		createMessageEmbeddingsTable()
+		log.Printf("🔎 Semantic history search enabled (embedding model: %s, %d dimensions)", ragEmbedModel, messageEmbeddingDims)
+	}
+}
+
+// Create `message_embeddings` table if it doesn't exist. Tables from before pgvector kept
+// vectors as REAL[]; those of the configured size are converted, the rest dropped.
+func createMessageEmbeddingsTable() {
+	query := fmt.Sprintf(`
+		CREATE EXTENSION IF NOT EXISTS vector;
+		CREATE TABLE IF NOT EXISTS message_embeddings (
+			message_id INTEGER PRIMARY KEY REFERENCES chat_history(id) ON DELETE CASCADE,
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			model TEXT NOT NULL,
+			embedding vector(%[1]d) NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		);
+		DO $$ BEGIN
+			IF EXISTS (SELECT 1 FROM information_schema.columns
+				WHERE table_name = 'message_embeddings' AND column_name = 'embedding' AND data_type = 'ARRAY') THEN
+				DELETE FROM message_embeddings WHERE cardinality(embedding) <> %[1]d;
+				ALTER TABLE message_embeddings ALTER COLUMN embedding TYPE vector(%[1]d) USING embedding::vector(%[1]d);
+			END IF;
+		END $$;
+		CREATE INDEX IF NOT EXISTS message_embeddings_room_idx ON message_embeddings (room_id, message_id);
+		CREATE INDEX IF NOT EXISTS message_embeddings_embedding_idx ON message_embeddings USING hnsw (embedding vector_cosine_ops);
+	`, messageEmbeddingDims)
+
+	// Building the index over an existing history can take a while
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create message_embeddings table (is the pgvector extension installed?):", err)
+	}
+	messageEmbeddingsReady = true
+	log.Println("✅ Table message_embeddings is ready")
+}
+
+// vectorLiteral writes an embedding in pgvector's text form, "[0.1,0.2,...]"
+func vectorLiteral(v []float32) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, x := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(x), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// searchMessageEmbeddings returns the embedded messages nearest the query, best first,
+// in the given rooms (nil searches every room)
+func searchMessageEmbeddings(query string, rooms []int, limit int) ([]semanticMatch, error) {
+	embeddings, err := embedTexts([]string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings[0]) != messageEmbeddingDims {
+		return nil, fmt.Errorf("%s returns %d dimensions, but SEMANTIC_SEARCH_DIMENSIONS is %d", ragEmbedModel, len(embeddings[0]), messageEmbeddingDims)
+	}
+
+	ctx := context.Background()
+	var matches []semanticMatch
+	err = pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		// The index returns ef_search candidates before the model and room filters apply
+		if _, err := tx.Exec(ctx, "SELECT set_config('hnsw.ef_search', $1, true)", strconv.Itoa(semanticSearchCandidates)); err != nil {
+			return err
+		}
+		// Vectors from another model aren't comparable, so only the current model's are searched
+		rows, err := tx.Query(ctx, `
+			SELECT message_id, room_id, 1 - (embedding <=> $1::vector) FROM message_embeddings
+			WHERE model = $2 AND ($3::int[] IS NULL OR room_id = ANY($3))
+			ORDER BY embedding <=> $1::vector LIMIT $4`, vectorLiteral(embeddings[0]), ragEmbedModel, rooms, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var m semanticMatch
+			if err := rows.Scan(&m.messageID, &m.roomID, &m.score); err != nil {
+				return err
+			}
+			if m.score < semanticSearchMinScore {
+				break // Nearest first, so the rest are further still
+			}
+			matches = append(matches, m)
+		}
+		return rows.Err()
+	})
+	return matches, err
+}
+
+// searchableRooms is the rooms a request may search, nil for all of them. A named room
+// needs its member role when it has an owner, like its other member-only views; without
+// one, moderators search everything and verified users the rooms they're members of.
+// ok is false when an error response was written.
+func searchableRooms(w http.ResponseWriter, r *http.Request) (rooms []int, ok bool) {
+	if r.URL.Query().Get("room") != "" {
+		id, err := requestRoom(r)
+		if err != nil {
+			http.Error(w, "Room not found", http.StatusNotFound)
+			return nil, false
+		}
+		room, err := getRoom(id)
+		if err != nil {
+			http.Error(w, "Room not found", http.StatusNotFound)
+			return nil, false
+		}
+		if !requireRoomRole(w, r, room, roleMember, true) {
+			return nil, false
+		}
+		return []int{id}, true
+	}
+	if isModerator(r) {
+		return nil, true
+	}
+	user := verifiedUser(r)
+	if user == "" {
+		http.Error(w, "Name a room, or present your user token to search the rooms you're a member of", http.StatusUnauthorized)
+		return nil, false
+	}
+	rows, err := db.Query(context.Background(), "SELECT room_id FROM room_members WHERE user_id = $1", user)
+	if err == nil {
+		rooms, err = pgx.CollectRows(rows, pgx.RowTo[int])
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch your rooms", http.StatusInternalServerError)
+		log.Println("Error fetching member rooms:", err)
+		return nil, false
+	}
+	if rooms == nil {
+		rooms = []int{} // Not nil, which would search every room
+	}
+	return rooms, true
+}
+
+// loadExchange returns the room name and the exchange a message belongs to: a user
+// message with the answer after it, or an answer with the question before it
+func loadExchange(m semanticMatch) (string, []ChatMessage, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT h.id, h.sender, `+messageText("h")+`, h.timestamp, h.metadata, r.name FROM chat_history h JOIN rooms r ON r.id = h.room_id
+		WHERE h.id IN (
+			(SELECT id FROM chat_history WHERE room_id = $1 AND id < $2 ORDER BY id DESC LIMIT 1),
+			$2,
+			(SELECT id FROM chat_history WHERE room_id = $1 AND id > $2 ORDER BY id LIMIT 1)
+		) ORDER BY h.id`, m.roomID, m.messageID)
+	if err != nil {
+		return "", nil, err
+	}
+	defer rows.Close()
+
+	var room string
+	var nearby []ChatMessage
+	for rows.Next() {
+		var msg ChatMessage
+		if err := rows.Scan(&msg.ID, &msg.Sender, &msg.Message, &msg.Timestamp, &msg.Metadata, &room); err != nil {
+			return "", nil, err
+		}
+		nearby = append(nearby, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, err
+	}
+
+	var exchange []ChatMessage
+	for i, msg := range nearby {
+		if msg.ID != m.messageID {
+			continue
+		}
+		if msg.Sender == "AI" && i > 0 && nearby[i-1].Sender == "User" {
+			exchange = append(exchange, nearby[i-1])
+		}
+		exchange = append(exchange, msg)
+		if msg.Sender == "User" && i+1 < len(nearby) && nearby[i+1].Sender == "AI" {
+			exchange = append(exchange, nearby[i+1])
+		}
+	}
+	return room, exchange, nil
+}
+
+// Handler for /api/history/semantic-search?q=: past exchanges most relevant to the query,
+// in the one room named by ?room= or across the rooms the caller may read (see
+// searchableRooms); ?limit= sets how many (up to 50)
+func semanticSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !semanticSearchEnabled {
+		http.Error(w, "Semantic search is disabled", http.StatusServiceUnavailable)
+		return
+	}
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "A query (q) is required", http.StatusBadRequest)
+		return
+	}
+	rooms, ok := searchableRooms(w, r)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = semanticSearchLimit
+	}
+
+	// Both sides of an exchange may match; fetch extra so there are enough after merging them
+	matches, err := searchMessageEmbeddings(query, rooms, 2*limit)
+	if err != nil {
+		http.Error(w, "Failed to search history", http.StatusBadGateway)
+		log.Println("Error searching message embeddings:", err)
+		return
+	}
+
+	results := []SemanticSearchResult{}
+	seen := map[int]bool{}
+	for _, m := range matches {
+		if len(results) == limit {
+			break
+		}
+		if seen[m.messageID] {
+			continue
+		}
+		room, exchange, err := loadExchange(m)
+		if err != nil {
+			http.Error(w, "Failed to fetch matching messages", http.StatusInternalServerError)
+			log.Println("Error fetching matching exchange:", err)
+			return
+		}
+		if len(exchange) == 0 {
+			continue // Deleted since it was embedded
+		}
+		for _, msg := range exchange {
+			seen[msg.ID] = true
+		}
+		results = append(results, SemanticSearchResult{
+			RoomID:    m.roomID,
+			Room:      room,
+			Score:     math.Round(m.score*1000) / 1000,
+			MessageID: m.messageID,
+			Messages:  exchange,
+		})
+	}
+	addCounter("cubbychat_semantic_searches_total", "Semantic history searches", 1)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SemanticSearchResponse{Query: query, Results: results})
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestVectorLiteral(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []float32
+		want string
+	}{
+		{"empty", nil, "[]"},
+		{"one", []float32{1}, "[1]"},
+		{"several", []float32{0.5, -0.25, 3}, "[0.5,-0.25,3]"},
+		{"shortest float32 form", []float32{0.1}, "[0.1]"},
+		{"tiny values use exponents", []float32{1e-8}, "[1e-08]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := vectorLiteral(tt.in); got != tt.want {
+				t.Errorf("vectorLiteral(%v) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Session is the server-side state of one WebSocket connection. All writes to
+// the client are queued for a single writer goroutine, so concurrent senders
+// can't interleave frames or block on a slow client. A nil session discards
+// its output, for generations with no client attached.
+type Session struct {
+	conn      *websocket.Conn
+	room      int    // Room this connection chats in
+	user      string // Self-reported user name ("user" query parameter), empty if anonymous
+	identity  string // The user name when proven with its user token, else empty; see usertokens.go
+	ip        string // Address the client connected from
+	moderator bool   // Whether the client presented the moderator or admin token
+	admin     bool   // Whether the client presented the admin token, which owns every room
+	tts       bool   // Whether completed AI responses are also spoken; guarded by prefsMu
+	voice     bool   // Whether this is a real-time voice session (audio streamed back)
+	acks      bool   // Whether the client acknowledges AI messages (unacknowledged ones are resent)
+	incognito bool   // Whether messages are answered without being stored; see incognito.go
+	protocol  string // Negotiated WebSocket subprotocol; see wsprotocol.go
+
+	settings *UserSettings // The user's saved profile settings, nil for guests; see usersettings.go
+	prefsMu  sync.Mutex    // Guards tts and settings, which settings saved elsewhere replace
+
+	pending    pendingAcks // AI messages awaiting the client's acknowledgement
+	lastTyping time.Time   // When the client's last typing frame was accepted; see handleTyping
+
+	queueMu sync.Mutex      // Guards queue and closed
+	queue   []outboundFrame // Frames waiting for writeLoop
+	closed  bool
+	wake    chan struct{} // Signals writeLoop that frames are queued
+	done    chan struct{} // Closed when the session shuts down
+
+	sink func(frame outboundFrame) error // Receives frames directly when there is no WebSocket (widget streams)
+}
+
+// newSession wraps an upgraded connection, applying preferences from the query string
+func newSession(conn *websocket.Conn, r *http.Request, room int) *Session {
+	s := &Session{conn: conn, room: room, user: requestUser(r), ip: clientIP(r), moderator: isModerator(r), admin: isAdmin(r)}
+	if identity := verifiedUser(r); identity != "" && (s.user == "" || s.user == identity) {
+		s.user, s.identity = identity, identity
+	}
+	s.protocol = negotiatedProtocol(conn)
+	s.tts = queryFlag(r, "tts", ttsDefault)
+	s.acks = queryFlag(r, "acks", false)
+	s.incognito = queryFlag(r, "incognito", false) || incognitoRoom(room)
+	s.loadSettings(r)
+	s.wake = make(chan struct{}, 1)
+	s.done = make(chan struct{})
+	s.keepAlive()
+	go s.writeLoop()
+	return s
+}
+
+// queryFlag reads an on/off query parameter such as ?tts=1
+func queryFlag(r *http.Request, name string, fallback bool) bool {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback
+	}
+	return value == "1" || value == "true" || value == "on"
+}
+
+// requestUser returns the user name a client identifies itself with. Names are
+// self-reported and only used to personalise the conversation, not for access control.
+func requestUser(r *http.Request) string {
+	user := strings.TrimSpace(r.URL.Query().Get("user"))
+	if len(user) > 64 {
+		user = user[:64]
+	}
+	return strings.ToValidUTF8(user, "")
+}
+
+// sendText writes a plain text frame (a token or a complete short message)
+func (s *Session) sendText(text string) error {
+	return s.enqueue(outboundFrame{messageType: websocket.TextMessage, data: []byte(text), envelope: "text"})
+}
+
+// sendToken queues a streamed token, which backpressure may merge with its neighbours
+func (s *Session) sendToken(token string) error {
+	return s.enqueue(outboundFrame{messageType: websocket.TextMessage, data: []byte(token), token: true, envelope: "token"})
+}
+
+// sendBinary writes a binary frame (streamed audio)
+func (s *Session) sendBinary(data []byte) error {
+	return s.enqueue(outboundFrame{messageType: websocket.BinaryMessage, data: data})
+}
+
+// sendEvent writes a JSON event frame to the WebSocket client
+func (s *Session) sendEvent(eventType string, data interface{}) error {
+	if s == nil {
+		return nil
+	}
+	payload, err := json.Marshal(WSEvent{Type: eventType, Data: data})
+	if err != nil {
+		return err
+	}
+	return s.enqueue(outboundFrame{messageType: websocket.TextMessage, data: payload})
+}
+
+// sendLatestEvent writes an event that describes current state, replacing an event of the
+// same type that is still queued so a slow client only gets the newest
+func (s *Session) sendLatestEvent(eventType string, data interface{}) error {
+	payload, err := json.Marshal(WSEvent{Type: eventType, Data: data})
+	if err != nil {
+		return err
+	}
+	return s.enqueue(outboundFrame{messageType: websocket.TextMessage, data: payload, replaces: eventType})
+}
+
+// sendError writes an "error" event to the WebSocket client
+func (s *Session) sendError(code, message string) {
+	if err := s.sendEvent("error", ErrorEvent{Code: code, Message: message}); err != nil {
+		log.Println("Error sending error event:", err)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Shadow bans let moderators silence a user without telling them: their messages are
+// acknowledged and shown on their own devices, but nobody else sees them and the AI
+// doesn't answer. A ban covers one room, or every room when its room is 0. Since anyone
+// can pick a ?user= name, bans by name only take verified users (see usertokens.go);
+// guests are banned by their address instead. Bans are only listed through the
+// moderation API.
+var (
+	shadowBansMu sync.RWMutex
+	shadowBans   map[shadowBanKey]bool
+)
+
+// shadowBanKey is a banned verified user or guest address in a room, 0 for everywhere
+type shadowBanKey struct {
+	user string
+	ip   string
+	room int
+}
+
+// ShadowBan silences a verified user, or the guests at an address, in a room or everywhere
+type ShadowBan struct {
+	User      string    `json:"user,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	RoomID    int       `json:"room_id"` // 0 for every room
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// initShadowBans creates the shadow ban table and loads the bans
+func initShadowBans() {
+	createShadowBansTable()
+	if err := loadShadowBans(); err != nil {
+		log.Fatal("❌ Failed to load shadow bans:", err)
+	}
+}
+
+// Create `shadow_bans` table if it doesn't exist. Global bans use room 0, so room_id
+// isn't a foreign key. Each ban names either a user or an address.
+func createShadowBansTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS shadow_bans (
+			user_id TEXT NOT NULL DEFAULT '',
+			room_id INTEGER NOT NULL DEFAULT 0,
+			reason TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		);
+		ALTER TABLE shadow_bans ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+		ALTER TABLE shadow_bans DROP CONSTRAINT IF EXISTS shadow_bans_pkey;
+		CREATE UNIQUE INDEX IF NOT EXISTS shadow_bans_target ON shadow_bans (user_id, ip, room_id);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create shadow_bans table:", err)
+	}
+	log.Println("✅ Table shadow_bans is ready")
+}
+
+// loadShadowBans reloads the bans from the database
+func loadShadowBans() error {
+	rows, err := db.Query(context.Background(), "SELECT user_id, ip, room_id FROM shadow_bans")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	bans := map[shadowBanKey]bool{}
+	for rows.Next() {
+		var key shadowBanKey
+		if err := rows.Scan(&key.user, &key.ip, &key.room); err != nil {
+			return err
+		}
+		bans[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	shadowBansMu.Lock()
+	defer shadowBansMu.Unlock()
+	shadowBans = bans
+	return nil
+}
+
+// shadowBanned reports whether a session is silenced in its room: a verified user by
+// their name, anyone else by their address
+func shadowBanned(s *Session) bool {
+	key := shadowBanKey{user: s.identity}
+	if key.user == "" {
+		key.ip = s.ip
+		if addr, err := netip.ParseAddr(s.ip); err == nil {
+			key.ip = addr.Unmap().String() // As bans store it
+		}
+	}
+	shadowBansMu.RLock()
+	defer shadowBansMu.RUnlock()
+	for _, room := range []int{s.room, 0} {
+		key.room = room
+		if shadowBans[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// echoShadowBanned acknowledges a silenced user's message and shows it on their own
+// connections to the room, as though it had been sent
+func echoShadowBanned(s *Session, text, clientID string) {
+	addCounter("cubbychat_shadow_banned_messages_total", "Messages from shadow-banned users kept from their room", 1)
+	ack := MessageAckEvent{ClientID: clientID, Timestamp: time.Now()}
+	if err := s.sendEvent("message_ack", ack); err != nil {
+		log.Println("Error sending message_ack event:", err)
+	}
+	message := ChatMessage{Sender: "User", Message: text, Timestamp: ack.Timestamp, ClientID: clientID}
+	for _, other := range userSessions(s.identity) {
+		if other != s && other.room == s.room {
+			if err := other.sendEvent("message", message); err != nil {
+				log.Println("Error sending message event:", err)
+			}
+		}
+	}
+}
+
+// Handler for /api/moderation/shadow-bans: list bans with GET (optionally ?room=id), ban
+// with POST ({"user": "...", "room_id": 0, "reason": "..."}, or "ip" instead of "user" for
+// guests). Only names claimed with a user token can be banned.
+func handleShadowBans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		roomID := -1
+		if v := r.URL.Query().Get("room"); v != "" {
+			var err error
+			if roomID, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "Invalid room", http.StatusBadRequest)
+				return
+			}
+		}
+		rows, err := db.Query(context.Background(), `
+			SELECT user_id, ip, room_id, reason, created_at FROM shadow_bans
+			WHERE $1 < 0 OR room_id = $1 ORDER BY created_at DESC`, roomID)
+		if err != nil {
+			http.Error(w, "Failed to fetch shadow bans", http.StatusInternalServerError)
+			log.Println("Error fetching shadow bans:", err)
+			return
+		}
+		defer rows.Close()
+
+		bans := []ShadowBan{}
+		for rows.Next() {
+			var ban ShadowBan
+			if err := rows.Scan(&ban.User, &ban.IP, &ban.RoomID, &ban.Reason, &ban.CreatedAt); err != nil {
+				http.Error(w, "Error processing shadow bans", http.StatusInternalServerError)
+				log.Println("Error scanning shadow bans:", err)
+				return
+			}
+			bans = append(bans, ban)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bans)
+
+	case http.MethodPost:
+		var ban ShadowBan
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&ban); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		ban.User = strings.TrimSpace(ban.User)
+		ban.IP = strings.TrimSpace(ban.IP)
+		ban.Reason = strings.TrimSpace(ban.Reason)
+		if (ban.User == "") == (ban.IP == "") {
+			http.Error(w, "Either a user or an ip is required", http.StatusBadRequest)
+			return
+		}
+		if ban.IP != "" {
+			addr, err := netip.ParseAddr(ban.IP)
+			if err != nil {
+				http.Error(w, "Invalid ip", http.StatusBadRequest)
+				return
+			}
+			ban.IP = addr.Unmap().String()
+		} else {
+			claimed, err := userNameClaimed(ban.User)
+			if err != nil {
+				http.Error(w, "Failed to check user", http.StatusInternalServerError)
+				log.Println("Error checking user name:", err)
+				return
+			}
+			if !claimed {
+				http.Error(w, "Only verified users can be banned by name, since anyone can use an unclaimed one; ban their ip instead", http.StatusBadRequest)
+				return
+			}
+		}
+		if ban.RoomID < 0 {
+			http.Error(w, "room_id must be a room id, or 0 for every room", http.StatusBadRequest)
+			return
+		}
+		if ban.RoomID > 0 {
+			if _, err := getRoom(ban.RoomID); err != nil {
+				http.Error(w, "Room not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		err := db.QueryRow(context.Background(), `
+			INSERT INTO shadow_bans (user_id, ip, room_id, reason) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, ip, room_id) DO UPDATE SET reason = EXCLUDED.reason
+			RETURNING created_at`, ban.User, ban.IP, ban.RoomID, ban.Reason).Scan(&ban.CreatedAt)
+		if err == nil {
+			err = loadShadowBans()
+		}
+		if err != nil {
+			http.Error(w, "Failed to save shadow ban", http.StatusInternalServerError)
+			log.Println("Error saving shadow ban:", err)
+			return
+		}
+		recordAudit("moderator", clientIP(r), "shadow_ban.create", ban.target(), map[string]interface{}{"room_id": ban.RoomID, "reason": ban.Reason})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ban)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Handler for /api/moderation/shadow-bans/{user}?room=id and
+// /api/moderation/shadow-bans/ip/{ip}?room=id: lift a ban (room 0, the default, is the
+// global one)
+func deleteShadowBan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ban := ShadowBan{User: r.PathValue("user"), IP: r.PathValue("ip")}
+	if addr, err := netip.ParseAddr(ban.IP); err == nil {
+		ban.IP = addr.Unmap().String()
+	}
+	roomID := 0
+	if v := r.URL.Query().Get("room"); v != "" {
+		var err error
+		if roomID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid room", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tag, err := db.Exec(context.Background(),
+		"DELETE FROM shadow_bans WHERE user_id = $1 AND ip = $2 AND room_id = $3", ban.User, ban.IP, roomID)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = pgx.ErrNoRows
+	}
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Shadow ban not found", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = loadShadowBans()
+	}
+	if err != nil {
+		http.Error(w, "Failed to lift shadow ban", http.StatusInternalServerError)
+		log.Println("Error lifting shadow ban:", err)
+		return
+	}
+	recordAudit("moderator", clientIP(r), "shadow_ban.delete", ban.target(), map[string]interface{}{"room_id": roomID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// target names who a ban silences for the audit log
+func (b ShadowBan) target() string {
+	if b.IP != "" {
+		return "ip:" + b.IP
+	}
+	return b.User
+}
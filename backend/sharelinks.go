@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	shareLinkSecret []byte        // Signs share tokens
+	shareLinkMaxTTL time.Duration // Longest expiry a link may ask for; 0 allows links that never expire
+)
+
+var errShareLinkInvalid = errors.New("share link is invalid, expired or revoked")
+
+// ShareLink grants read-only access to a room's conversation as it was when the link was made
+type ShareLink struct {
+	ID            int        `json:"id"`
+	RoomID        int        `json:"room_id"`
+	Token         string     `json:"token,omitempty"` // Only returned when the link is created
+	URL           string     `json:"url,omitempty"`   // Server-rendered transcript page for the token
+	LastMessageID int        `json:"last_message_id"` // Messages after this one aren't shared
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+}
+
+// SharedTranscript is what a share link shows
+type SharedTranscript struct {
+	Room      string        `json:"room"`
+	SharedAt  time.Time     `json:"shared_at"`
+	ExpiresAt *time.Time    `json:"expires_at,omitempty"`
+	Messages  []ChatMessage `json:"messages"`
+}
+
+// initShareLinks reads the signing secret and creates the share links table
+func initShareLinks() {
+	shareLinkMaxTTL = getEnvDuration("SHARE_LINK_MAX_TTL", 0)
+	if secret := getEnv("SHARE_LINK_SECRET", ""); secret != "" {
+		shareLinkSecret = []byte(secret)
+	} else {
+		shareLinkSecret = make([]byte, 32)
+		if _, err := rand.Read(shareLinkSecret); err != nil {
+			log.Fatal("❌ Failed to generate share link secret:", err)
+		}
+		log.Println("⚠️ SHARE_LINK_SECRET is not set; share links will stop working when the server restarts")
+	}
+	createShareLinksTable()
+}
+
+// Create `share_links` table if it doesn't exist
+func createShareLinksTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS share_links (
+			id SERIAL PRIMARY KEY,
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			last_message_id INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			expires_at TIMESTAMPTZ,
+			revoked_at TIMESTAMPTZ
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create share_links table:", err)
+	}
+	log.Println("✅ Table share_links is ready")
+}
+
+// signShareLink makes the token for a link: its id and a signature over it
+func signShareLink(id int) string {
+	mac := hmac.New(sha256.New, shareLinkSecret)
+	fmt.Fprintf(mac, "share:%d", id)
+	return fmt.Sprintf("%d.%s", id, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// resolveShareLink checks a token's signature and that its link is still live
+func resolveShareLink(token string) (*ShareLink, error) {
+	idPart, _, _ := strings.Cut(token, ".")
+	id, err := strconv.Atoi(idPart)
+	if err != nil || !hmac.Equal([]byte(token), []byte(signShareLink(id))) {
+		return nil, errShareLinkInvalid
+	}
+
+	var link ShareLink
+	err = db.QueryRow(context.Background(), `
+		SELECT id, room_id, last_message_id, created_at, expires_at, revoked_at FROM share_links
+		WHERE id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())`, id).
+		Scan(&link.ID, &link.RoomID, &link.LastMessageID, &link.CreatedAt, &link.ExpiresAt, &link.RevokedAt)
+	if err == pgx.ErrNoRows {
+		return nil, errShareLinkInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// loadSharedTranscript returns the messages a link shares
+func loadSharedTranscript(link *ShareLink) (*SharedTranscript, error) {
+	room, err := getRoom(link.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := loadRoomMessages(link.RoomID, link.LastMessageID, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	return &SharedTranscript{Room: room.Name, SharedAt: link.CreatedAt, ExpiresAt: link.ExpiresAt, Messages: messages}, nil
+}
+
+// Handler for /api/rooms/{id}/share-links: create with POST ({"expires_in": "24h"}; the
+// moderator role in rooms with an owner), list with GET (the moderator role)
+func handleShareLinks(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+
+	// Anyone may share an unmanaged room, but only its moderators see and revoke links
+	if !requireRoomRole(w, r, room, roleModerator, r.Method == http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		createShareLink(w, r, room)
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, room_id, last_message_id, created_at, expires_at, revoked_at FROM share_links
+		WHERE room_id = $1 ORDER BY id DESC`, room.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch share links", http.StatusInternalServerError)
+		log.Println("Error fetching share links:", err)
+		return
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		var link ShareLink
+		if err := rows.Scan(&link.ID, &link.RoomID, &link.LastMessageID, &link.CreatedAt, &link.ExpiresAt, &link.RevokedAt); err != nil {
+			http.Error(w, "Error processing share links", http.StatusInternalServerError)
+			log.Println("Error scanning share links:", err)
+			return
+		}
+		links = append(links, link)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+// createShareLink shares the room's conversation up to its latest message
+func createShareLink(w http.ResponseWriter, r *http.Request, room *Room) {
+	var req struct {
+		ExpiresIn string `json:"expires_in"` // Go duration such as "24h"; empty for no expiry
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresIn != "" || shareLinkMaxTTL > 0 {
+		ttl := shareLinkMaxTTL
+		if req.ExpiresIn != "" {
+			parsed, err := time.ParseDuration(req.ExpiresIn)
+			if err != nil || parsed <= 0 {
+				http.Error(w, "expires_in must be a positive duration such as 24h", http.StatusBadRequest)
+				return
+			}
+			if shareLinkMaxTTL == 0 || parsed < shareLinkMaxTTL {
+				ttl = parsed
+			}
+		}
+		expires := time.Now().Add(ttl)
+		expiresAt = &expires
+	}
+
+	link := ShareLink{RoomID: room.ID, ExpiresAt: expiresAt}
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO share_links (room_id, last_message_id, expires_at)
+		VALUES ($1, (SELECT COALESCE(MAX(id), 0) FROM chat_history WHERE room_id = $1), $2)
+		RETURNING id, last_message_id, created_at`, room.ID, expiresAt).Scan(&link.ID, &link.LastMessageID, &link.CreatedAt)
+	if err != nil {
+		http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+		log.Println("Error creating share link:", err)
+		return
+	}
+	link.Token = signShareLink(link.ID)
+	link.URL = "/t/" + link.Token
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+// Handler for /api/share-links/{id}: fetch with GET, revoke with DELETE; both take the
+// moderator role in the link's room
+func handleShareLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid share link id", http.StatusBadRequest)
+		return
+	}
+
+	var link ShareLink
+	err = db.QueryRow(context.Background(), `
+		SELECT id, room_id, last_message_id, created_at, expires_at, revoked_at FROM share_links WHERE id = $1`, id).
+		Scan(&link.ID, &link.RoomID, &link.LastMessageID, &link.CreatedAt, &link.ExpiresAt, &link.RevokedAt)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch share link", http.StatusInternalServerError)
+		log.Println("Error fetching share link:", err)
+		return
+	}
+	room, err := getRoom(link.RoomID)
+	if err != nil {
+		http.Error(w, "Failed to fetch room", http.StatusInternalServerError)
+		log.Println("Error fetching room:", err)
+		return
+	}
+	if !requireRoomRole(w, r, room, roleModerator, false) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(link)
+		return
+	}
+
+	tag, err := db.Exec(context.Background(),
+		"UPDATE share_links SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		http.Error(w, "Failed to revoke share link", http.StatusInternalServerError)
+		log.Println("Error revoking share link:", err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Share link already revoked", http.StatusNotFound)
+		return
+	}
+	recordAudit(roomActor(r), clientIP(r), "share_link.revoke", strconv.Itoa(id), map[string]int{"room_id": room.ID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for the public /api/shared/{token} transcript
+func getSharedTranscript(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	link, err := resolveShareLink(r.PathValue("token"))
+	if err == errShareLinkInvalid {
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch share link", http.StatusInternalServerError)
+		log.Println("Error fetching share link:", err)
+		return
+	}
+
+	transcript, err := loadSharedTranscript(link)
+	if err != nil {
+		http.Error(w, "Failed to fetch transcript", http.StatusInternalServerError)
+		log.Println("Error fetching shared transcript:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transcript)
+}
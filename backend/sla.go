@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SLAs time support conversations, meaning rooms whose status was set to open or pending
+// (see conversations.go); rooms that never had one aren't timed. A customer's message starts the clock; the first staff reply meets
+// the first-response target and resolving the conversation meets the resolution target.
+// In rooms with business hours the clocks only run while the team is around (see
+// businesshours.go). A scheduler looks for missed targets and escalates each breach
+// once: to the room's assignee and the SLA_NOTIFY_USERS, to SLA_WEBHOOK_URL and to the
+// outbox.
+var (
+	slaFirstResponse time.Duration // Default first-response target; 0 for none
+	slaResolution    time.Duration // Default resolution target; 0 for none
+	slaInterval      time.Duration // How often the scheduler looks for breaches
+	slaNotifyUsers   []string      // Users told about every breach besides the assignee
+	slaWebhookURL    string        // Receives each breach as JSON
+	slaWebhookSecret string        // Signs webhook bodies (X-Cubbychat-Signature: sha256=<hex>)
+)
+
+// SLA kinds
+const (
+	slaKindFirstResponse = "first_response"
+	slaKindResolution    = "resolution"
+)
+
+// slaOff turns off a target a room would otherwise get from the server's default
+const slaOff = "off"
+
+// RoomSLA overrides the server's SLA targets for a room: durations such as "30m", or "off"
+type RoomSLA struct {
+	FirstResponse string `json:"first_response,omitempty"`
+	Resolution    string `json:"resolution,omitempty"`
+}
+
+// SLATimer is where a room's conversation stands against its targets
+type SLATimer struct {
+	RoomID                  int        `json:"room_id"`
+	StartedAt               time.Time  `json:"started_at"`
+	FirstResponseAt         *time.Time `json:"first_response_at,omitempty"`
+	ResolvedAt              *time.Time `json:"resolved_at,omitempty"`
+	FirstResponseBreachedAt *time.Time `json:"first_response_breached_at,omitempty"`
+	ResolutionBreachedAt    *time.Time `json:"resolution_breached_at,omitempty"`
+}
+
+// SLABreachEvent is sent to the people escalated to, the SLA webhook and the outbox
+// (as sla.breached)
+type SLABreachEvent struct {
+	RoomID     int       `json:"room_id"`
+	RoomName   string    `json:"room_name"`
+	Kind       string    `json:"kind"`   // first_response or resolution
+	Target     string    `json:"target"` // The target that was missed, such as "30m0s"
+	StartedAt  time.Time `json:"started_at"`
+	DueAt      time.Time `json:"due_at"`
+	BreachedAt time.Time `json:"breached_at"`
+	Assignee   string    `json:"assignee,omitempty"`
+}
+
+// initSLA reads the SLA settings, creates the timer table and starts the scheduler
+func initSLA() {
+	slaFirstResponse = getEnvDuration("SLA_FIRST_RESPONSE", 0)
+	slaResolution = getEnvDuration("SLA_RESOLUTION", 0)
+	slaInterval = getEnvDuration("SLA_CHECK_INTERVAL", time.Minute)
+	slaNotifyUsers = splitList(getEnv("SLA_NOTIFY_USERS", ""))
+	slaWebhookURL = getEnv("SLA_WEBHOOK_URL", "")
+	slaWebhookSecret = getEnv("SLA_WEBHOOK_SECRET", "")
+	createSLATimersTable()
+	go runSLAScheduler()
+	if slaFirstResponse > 0 || slaResolution > 0 {
+		log.Printf("⏱️ Conversations are due a first response within %v and resolution within %v (0 for none)", slaFirstResponse, slaResolution)
+	}
+}
+
+// Create `sla_timers` table if it doesn't exist
+func createSLATimersTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS sla_timers (
+			room_id INTEGER PRIMARY KEY REFERENCES rooms(id) ON DELETE CASCADE,
+			started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			first_response_at TIMESTAMPTZ,
+			resolved_at TIMESTAMPTZ,
+			first_response_breached_at TIMESTAMPTZ,
+			resolution_breached_at TIMESTAMPTZ
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create sla_timers table:", err)
+	}
+	log.Println("✅ Table sla_timers is ready")
+}
+
+// parseSLATarget checks a room's SLA override: a duration of at least a minute, or "off"
+func parseSLATarget(value string) (time.Duration, error) {
+	if value == slaOff {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < time.Minute {
+		return 0, fmt.Errorf(`SLA targets must be a duration of at least 1m, such as "4h", or "off"`)
+	}
+	return d, nil
+}
+
+// effectiveSLA is a room's first-response and resolution targets: its overrides, else the
+// server's defaults; 0 means none
+func effectiveSLA(room *Room) (firstResponse, resolution time.Duration) {
+	firstResponse, resolution = slaFirstResponse, slaResolution
+	if sla := room.Metadata.SLA; sla != nil {
+		if sla.FirstResponse != "" {
+			firstResponse, _ = parseSLATarget(sla.FirstResponse)
+		}
+		if sla.Resolution != "" {
+			resolution, _ = parseSLATarget(sla.Resolution)
+		}
+	}
+	return firstResponse, resolution
+}
+
+// slaDue is when a target is due for a clock started at a moment, counting only the
+// room's business hours if it has any
+func slaDue(room *Room, started time.Time, target time.Duration) time.Time {
+	if h := roomBusinessHours(room); h != nil {
+		return h.addOpen(started, target)
+	}
+	return started.Add(target)
+}
+
+// startSLATimer starts a room's clock on a customer's message, unless it's already
+// running. Only open and pending conversations are timed; a message after the last one
+// was resolved starts a new clock.
+func startSLATimer(roomID int) {
+	_, err := db.Exec(context.Background(), `
+		INSERT INTO sla_timers (room_id) SELECT id FROM rooms WHERE id = $1 AND metadata->>'status' IN ('open', 'pending')
+		ON CONFLICT (room_id) DO UPDATE SET started_at = NOW(), first_response_at = NULL, resolved_at = NULL,
+			first_response_breached_at = NULL, resolution_breached_at = NULL
+		WHERE sla_timers.resolved_at IS NOT NULL`, roomID)
+	if err != nil {
+		log.Println("Error starting SLA timer:", err)
+	}
+}
+
+// stopSLAFirstResponse records a room's first staff reply
+func stopSLAFirstResponse(roomID int) {
+	_, err := db.Exec(context.Background(),
+		"UPDATE sla_timers SET first_response_at = NOW() WHERE room_id = $1 AND first_response_at IS NULL AND resolved_at IS NULL", roomID)
+	if err != nil {
+		log.Println("Error recording SLA first response:", err)
+	}
+}
+
+// resolveSLATimer stops a room's clock when its conversation is resolved
+func resolveSLATimer(roomID int) {
+	_, err := db.Exec(context.Background(), "UPDATE sla_timers SET resolved_at = NOW() WHERE room_id = $1 AND resolved_at IS NULL", roomID)
+	if err != nil {
+		log.Println("Error resolving SLA timer:", err)
+	}
+}
+
+// getSLATimer loads a room's timer, or nil if its clock never started
+func getSLATimer(roomID int) (*SLATimer, error) {
+	var t SLATimer
+	err := db.QueryRow(context.Background(), `
+		SELECT room_id, started_at, first_response_at, resolved_at, first_response_breached_at, resolution_breached_at
+		FROM sla_timers WHERE room_id = $1`, roomID).
+		Scan(&t.RoomID, &t.StartedAt, &t.FirstResponseAt, &t.ResolvedAt, &t.FirstResponseBreachedAt, &t.ResolutionBreachedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// runSLAScheduler escalates missed targets every SLA_CHECK_INTERVAL
+func runSLAScheduler() {
+	ticker := clock.NewTicker(slaInterval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		if err := checkSLABreaches(); err != nil {
+			log.Println("Error checking SLA breaches:", err)
+		}
+	}
+}
+
+// checkSLABreaches escalates each running timer that has passed a target it hasn't been
+// escalated for yet
+func checkSLABreaches() error {
+	rows, err := db.Query(context.Background(), `
+		SELECT t.room_id, t.started_at, t.first_response_at, t.first_response_breached_at, t.resolution_breached_at
+		FROM sla_timers t JOIN rooms r ON r.id = t.room_id
+		WHERE t.resolved_at IS NULL AND r.metadata->>'status' IN ('open', 'pending')
+			AND (t.first_response_breached_at IS NULL OR t.resolution_breached_at IS NULL)`)
+	if err != nil {
+		return err
+	}
+	timers, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (SLATimer, error) {
+		var t SLATimer
+		err := row.Scan(&t.RoomID, &t.StartedAt, &t.FirstResponseAt, &t.FirstResponseBreachedAt, &t.ResolutionBreachedAt)
+		return t, err
+	})
+	if err != nil {
+		return err
+	}
+
+	now := clock.Now()
+	for _, t := range timers {
+		room, err := getRoom(t.RoomID)
+		if err != nil {
+			log.Printf("Error fetching room %d to check its SLA: %v", t.RoomID, err)
+			continue
+		}
+		firstResponse, resolution := effectiveSLA(room)
+		if firstResponse > 0 && t.FirstResponseAt == nil && t.FirstResponseBreachedAt == nil && now.After(slaDue(room, t.StartedAt, firstResponse)) {
+			escalateSLABreach(room, t, slaKindFirstResponse, firstResponse)
+		}
+		if resolution > 0 && t.ResolutionBreachedAt == nil && now.After(slaDue(room, t.StartedAt, resolution)) {
+			escalateSLABreach(room, t, slaKindResolution, resolution)
+		}
+	}
+	return nil
+}
+
+// slaBreachColumns is the column recording each kind of breach
+var slaBreachColumns = map[string]string{
+	slaKindFirstResponse: "first_response_breached_at",
+	slaKindResolution:    "resolution_breached_at",
+}
+
+// escalateSLABreach records a breach and tells everyone who should know. Recording it is
+// conditional, so only one server escalates it.
+func escalateSLABreach(room *Room, t SLATimer, kind string, target time.Duration) {
+	event := SLABreachEvent{RoomID: room.ID, RoomName: room.Name, Kind: kind, Target: target.String(),
+		StartedAt: t.StartedAt, DueAt: slaDue(room, t.StartedAt, target), Assignee: room.Metadata.Assignee}
+	column := slaBreachColumns[kind]
+
+	recorded := false
+	ctx := context.Background()
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, "UPDATE sla_timers SET "+column+" = NOW() WHERE room_id = $1 AND "+column+` IS NULL
+			AND started_at = $2 AND resolved_at IS NULL RETURNING `+column, room.ID, t.StartedAt).Scan(&event.BreachedAt)
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		recorded = true
+		return recordEvent(ctx, tx, "sla.breached", event)
+	})
+	if err != nil {
+		log.Printf("Error recording SLA breach in room %d: %v", room.ID, err)
+		return
+	}
+	if !recorded {
+		return
+	}
+
+	log.Printf("⏱️ Room %d missed its %s SLA of %v", room.ID, kind, target)
+	addCounter("cubbychat_sla_breaches_total", "Missed SLA targets, by kind", 1, "kind", kind)
+	recordAudit("system", "", "sla.breach", strconv.Itoa(room.ID), map[string]string{"kind": kind, "target": event.Target})
+
+	notified := map[string]bool{}
+	for _, user := range append([]string{room.Metadata.Assignee}, slaNotifyUsers...) {
+		if user == "" || notified[user] {
+			continue
+		}
+		notified[user] = true
+		for _, s := range userSessions(user) {
+			if err := s.sendEvent("sla_breached", event); err != nil {
+				log.Println("Error sending sla_breached event:", err)
+			}
+		}
+	}
+	if slaWebhookURL != "" {
+		go func() {
+			if err := postSLAWebhook(event); err != nil {
+				log.Printf("Error posting SLA breach in room %d to the webhook: %v", room.ID, err)
+			}
+		}()
+	}
+}
+
+// postSLAWebhook sends a breach to SLA_WEBHOOK_URL, which must answer 2xx
+func postSLAWebhook(event SLABreachEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, slaWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if slaWebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(slaWebhookSecret))
+		mac.Write(body)
+		req.Header.Set("X-Cubbychat-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Handler for /api/rooms/{id}/sla: see the room's targets and timer with GET, override the
+// server's targets with PUT ({"first_response": "30m", "resolution": "off"}; an empty
+// field keeps the default), go back to the defaults with DELETE
+func handleRoomSLA(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req RoomSLA
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		for _, value := range []string{req.FirstResponse, req.Resolution} {
+			if value == "" {
+				continue
+			}
+			if _, err := parseSLATarget(value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		_, err = db.Exec(context.Background(),
+			"UPDATE rooms SET metadata = metadata || jsonb_build_object('sla', $2::jsonb) WHERE id = $1", room.ID, req)
+		room.Metadata.SLA = &req
+	case http.MethodDelete:
+		_, err = db.Exec(context.Background(), "UPDATE rooms SET metadata = metadata - 'sla' WHERE id = $1", room.ID)
+		room.Metadata.SLA = nil
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update room SLA", http.StatusInternalServerError)
+		log.Println("Error updating room SLA:", err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		recordAudit("moderator", clientIP(r), "room.sla", strconv.Itoa(room.ID), room.Metadata.SLA)
+	}
+
+	timer, err := getSLATimer(room.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch SLA timer", http.StatusInternalServerError)
+		log.Println("Error fetching SLA timer:", err)
+		return
+	}
+	firstResponse, resolution := effectiveSLA(room)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"first_response": firstResponse.String(),
+		"resolution":     resolution.String(),
+		"override":       room.Metadata.SLA,
+		"timer":          timer,
+	})
+}
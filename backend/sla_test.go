@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSLATarget(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"4h", 4 * time.Hour, false},
+		{"1m", time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{slaOff, 0, false},
+		{"30s", 0, true},
+		{"-1h", 0, true},
+		{"soon", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSLATarget(tt.value)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseSLATarget(%q) = %s, %v; want %s, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
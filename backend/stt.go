@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	sttEnabled  bool          // Whether voice input is accepted
+	sttBackend  string        // "whisper.cpp" (whisper-server /inference) or "openai" (/v1/audio/transcriptions)
+	sttURL      string        // Base URL of the Whisper server
+	sttModel    string        // Model name for OpenAI-compatible servers
+	sttAPIKey   string        // Optional bearer token
+	sttLanguage string        // Optional language hint (e.g. "en")
+	sttMaxBytes int           // Largest audio clip accepted
+	sttTimeout  time.Duration // Upper bound for one transcription
+)
+
+// TranscriptEvent tells the client what was heard before the message is processed
+type TranscriptEvent struct {
+	Text string `json:"text"`
+}
+
+// initSpeechToText reads the speech-to-text settings
+func initSpeechToText() {
+	sttEnabled = getEnvBool("STT_ENABLED", false)
+	sttBackend = strings.ToLower(getEnv("STT_BACKEND", "whisper.cpp"))
+	sttURL = getEnv("STT_URL", "http://whisper:8080")
+	sttModel = getEnv("STT_MODEL", "whisper-1")
+	sttAPIKey = getEnv("STT_API_KEY", "")
+	sttLanguage = getEnv("STT_LANGUAGE", "")
+	sttMaxBytes = getEnvInt("STT_MAX_BYTES", 10*1024*1024)
+	sttTimeout = getEnvDuration("STT_TIMEOUT", 60*time.Second)
+
+	if !sttEnabled {
+		return
+	}
+	if sttBackend != "whisper.cpp" && sttBackend != "openai" {
+		log.Printf("⚠️ Unknown STT_BACKEND %q, voice input disabled", sttBackend)
+		sttEnabled = false
+		return
+	}
+	log.Printf("🎙️ Speech-to-text enabled (backend: %s)", sttBackend)
+}
+
+// audioFilename picks a filename with an extension matching the audio format,
+// since Whisper servers use it to choose a decoder
+func audioFilename(data []byte) string {
+	switch contentType := http.DetectContentType(data); {
+	case strings.Contains(contentType, "wav"):
+		return "audio.wav"
+	case strings.Contains(contentType, "mpeg"):
+		return "audio.mp3"
+	case strings.Contains(contentType, "ogg"):
+		return "audio.ogg"
+	default:
+		// Browsers' MediaRecorder produces WebM/Opus by default
+		return "audio.webm"
+	}
+}
+
+// transcribe sends audio to the configured Whisper server and returns the text
+func transcribe(ctx context.Context, audio []byte) (string, error) {
+	if len(audio) > sttMaxBytes {
+		return "", fmt.Errorf("audio exceeds %d bytes", sttMaxBytes)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sttTimeout)
+	defer cancel()
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	req := newUpstreamClient().R().
+		SetContext(ctx).
+		SetFileReader("file", audioFilename(audio), bytes.NewReader(audio)).
+		SetResult(&result)
+	if sttAPIKey != "" {
+		req.SetAuthToken(sttAPIKey)
+	}
+	if sttLanguage != "" {
+		req.SetFormData(map[string]string{"language": sttLanguage})
+	}
+
+	var endpoint string
+	if sttBackend == "openai" {
+		req.SetFormData(map[string]string{"model": sttModel, "response_format": "json"})
+		endpoint = sttURL + "/v1/audio/transcriptions"
+	} else {
+		req.SetFormData(map[string]string{"response_format": "json"})
+		endpoint = sttURL + "/inference"
+	}
+
+	resp, err := req.Post(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("speech-to-text unavailable: %v", err)
+	}
+	if resp.StatusCode() != 200 {
+		return "", fmt.Errorf("speech-to-text returned status %d: %s", resp.StatusCode(), resp.String())
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// handleVoiceMessage transcribes a binary audio frame and processes it like a typed message
+func handleVoiceMessage(s *Session, audio []byte) {
+	if !sttEnabled {
+		s.sendError("voice_disabled", "Voice input is not enabled on this server")
+		return
+	}
+
+	log.Printf("🎙️ Received %d bytes of audio", len(audio))
+	text, err := transcribe(context.Background(), audio)
+	if err != nil {
+		log.Println("Error transcribing audio:", err)
+		s.sendError("transcription_failed", "Sorry, I couldn't understand that recording")
+		return
+	}
+	if text == "" {
+		s.sendError("empty_transcript", "No speech was detected in the recording")
+		return
+	}
+
+	if err := s.sendEvent("transcript", TranscriptEvent{Text: text}); err != nil {
+		log.Println("Error sending transcript event:", err)
+	}
+	handleUserMessage(s, text, "")
+}
+
+// Handler to transcribe an uploaded audio file (multipart form field "file")
+func transcribeAudio(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sttEnabled {
+		http.Error(w, "Speech-to-text is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(sttMaxBytes)+1024*1024)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing or oversized audio file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	audio, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Failed to read audio", http.StatusBadRequest)
+		return
+	}
+
+	text, err := transcribe(r.Context(), audio)
+	if err != nil {
+		http.Error(w, "Transcription failed", http.StatusBadGateway)
+		log.Println("Error transcribing audio:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TranscriptEvent{Text: text})
+}
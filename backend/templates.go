@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	templateVariablePattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+	templateNamePattern     = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+	templateArgPattern      = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*)=("(?:[^"\\]|\\.)*"|\S+)`)
+)
+
+// PromptTemplate is a reusable prompt with {{variables}}
+type PromptTemplate struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Body        string    `json:"body"`
+	Variables   []string  `json:"variables"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// initTemplates creates the templates table and registers the /template command
+func initTemplates() {
+	createTemplatesTable()
+	registerCommand("template", templateCommand)
+}
+
+// Create `prompt_templates` table if it doesn't exist
+func createTemplatesTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS prompt_templates (
+			name TEXT PRIMARY KEY,
+			description TEXT NOT NULL DEFAULT '',
+			body TEXT NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			updated_at TIMESTAMPTZ DEFAULT NOW()
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create prompt_templates table:", err)
+	}
+	log.Println("✅ Table prompt_templates is ready")
+}
+
+// templateVariables lists the distinct variables used in a template body, in order
+func templateVariables(body string) []string {
+	seen := make(map[string]bool)
+	variables := []string{}
+	for _, m := range templateVariablePattern.FindAllStringSubmatch(body, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			variables = append(variables, m[1])
+		}
+	}
+	return variables
+}
+
+// renderTemplate substitutes variables into a template body; every variable must be given
+func renderTemplate(body string, values map[string]string) (string, error) {
+	var missing []string
+	for _, name := range templateVariables(body) {
+		if _, ok := values[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing variables: %s", strings.Join(missing, ", "))
+	}
+
+	return templateVariablePattern.ReplaceAllStringFunc(body, func(match string) string {
+		name := templateVariablePattern.FindStringSubmatch(match)[1]
+		return values[name]
+	}), nil
+}
+
+// getTemplate loads a template by name
+func getTemplate(name string) (*PromptTemplate, error) {
+	var t PromptTemplate
+	err := db.QueryRow(context.Background(),
+		"SELECT name, description, body, created_at, updated_at FROM prompt_templates WHERE name = $1", name).
+		Scan(&t.Name, &t.Description, &t.Body, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	t.Variables = templateVariables(t.Body)
+	return &t, nil
+}
+
+// parseTemplateArgs reads key=value pairs; values with spaces are double-quoted
+func parseTemplateArgs(args string) map[string]string {
+	values := make(map[string]string)
+	for _, m := range templateArgPattern.FindAllStringSubmatch(args, -1) {
+		value := m[2]
+		if strings.HasPrefix(value, `"`) {
+			if unquoted, err := unquoteTemplateArg(value); err == nil {
+				value = unquoted
+			}
+		}
+		values[m[1]] = value
+	}
+	return values
+}
+
+// unquoteTemplateArg removes the quotes around a value and resolves \" and \\ escapes
+func unquoteTemplateArg(value string) (string, error) {
+	var s string
+	err := json.Unmarshal([]byte(value), &s)
+	return s, err
+}
+
+// templateCommand handles "/template name key=value ..." and sends the rendered prompt to the AI
+func templateCommand(s *Session, args string) {
+	name, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+	if name == "" {
+		s.sendText("📝 Usage: /template <name> key=value key2=\"value with spaces\"")
+		return
+	}
+
+	t, err := getTemplate(strings.ToLower(name))
+	if err == pgx.ErrNoRows {
+		s.sendText(fmt.Sprintf("📝 No template named %q", name))
+		return
+	}
+	if err != nil {
+		log.Println("Error fetching template:", err)
+		s.sendText("📝 Could not load that template, please try again later.")
+		return
+	}
+
+	// The room's settings fill in variables the command doesn't give
+	values, err := roomSettings(s.room)
+	if err != nil {
+		log.Println("Error fetching room settings:", err)
+		values = map[string]string{}
+	}
+	for name, value := range parseTemplateArgs(rest) {
+		values[name] = value
+	}
+	prompt, err := renderTemplate(t.Body, values)
+	if err != nil {
+		s.sendText(fmt.Sprintf("📝 %s (template %s uses: %s)", err, t.Name, strings.Join(t.Variables, ", ")))
+		return
+	}
+	answerPrompt(s, prompt)
+}
+
+// Handler for /api/templates: create or replace with POST, list with GET
+func handleTemplates(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		saveTemplate(w, r)
+		return
+	}
+
+	rows, err := db.Query(context.Background(),
+		"SELECT name, description, body, created_at, updated_at FROM prompt_templates ORDER BY name")
+	if err != nil {
+		http.Error(w, "Failed to fetch templates", http.StatusInternalServerError)
+		log.Println("Error fetching templates:", err)
+		return
+	}
+	defer rows.Close()
+
+	templates := []PromptTemplate{}
+	for rows.Next() {
+		var t PromptTemplate
+		if err := rows.Scan(&t.Name, &t.Description, &t.Body, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			http.Error(w, "Error processing templates", http.StatusInternalServerError)
+			log.Println("Error scanning templates:", err)
+			return
+		}
+		t.Variables = templateVariables(t.Body)
+		templates = append(templates, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+// Handler to create or update a template
+func saveTemplate(w http.ResponseWriter, r *http.Request) {
+	var req PromptTemplate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.ToLower(strings.TrimSpace(req.Name))
+	if !templateNamePattern.MatchString(req.Name) {
+		http.Error(w, "Template names use lowercase letters, digits, - and _", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		http.Error(w, "A template body is required", http.StatusBadRequest)
+		return
+	}
+
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO prompt_templates (name, description, body) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description, body = EXCLUDED.body, updated_at = NOW()
+		RETURNING created_at, updated_at`, req.Name, req.Description, req.Body).Scan(&req.CreatedAt, &req.UpdatedAt)
+	if err != nil {
+		http.Error(w, "Failed to save template", http.StatusInternalServerError)
+		log.Println("Error saving template:", err)
+		return
+	}
+	req.Variables = templateVariables(req.Body)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
+
+// Handler for /api/templates/{name}: fetch with GET, remove with DELETE
+func handleTemplate(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(r.PathValue("name"))
+
+	if r.Method == http.MethodDelete {
+		tag, err := db.Exec(context.Background(), "DELETE FROM prompt_templates WHERE name = $1", name)
+		if err != nil {
+			http.Error(w, "Failed to delete template", http.StatusInternalServerError)
+			log.Println("Error deleting template:", err)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			http.Error(w, "Template not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	t, err := getTemplate(name)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch template", http.StatusInternalServerError)
+		log.Println("Error fetching template:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// Handler to render a template with variables ({"variables": {...}})
+func renderTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Variables map[string]string `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	t, err := getTemplate(strings.ToLower(r.PathValue("name")))
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch template", http.StatusInternalServerError)
+		log.Println("Error fetching template:", err)
+		return
+	}
+
+	prompt, err := renderTemplate(t.Body, req.Variables)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"prompt": prompt})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	generationTimeout           time.Duration // Upper bound for a whole answer; 0 disables
+	generationFirstTokenTimeout time.Duration // Upper bound for the wait before the first token; 0 disables
+)
+
+// Causes of a generation being cut short, from context.Cause
+var (
+	errGenerationTimeout = errors.New("generation exceeded its time budget")
+	errFirstTokenTimeout = errors.New("no token arrived before the first-token deadline")
+)
+
+// initTimeouts reads the generation deadlines
+func initTimeouts() {
+	generationTimeout = getEnvDuration("GENERATION_TIMEOUT", 5*time.Minute)
+	generationFirstTokenTimeout = getEnvDuration("GENERATION_FIRST_TOKEN_TIMEOUT", 2*time.Minute)
+}
+
+// startDeadlines gives the generation a context that is cancelled when either deadline
+// passes; upstream requests made with it are aborted then. Call stop when done.
+func (g *generation) startDeadlines() (stop func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	stops := []func(){func() { cancel(nil) }}
+	if generationTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, generationTimeout, errGenerationTimeout)
+		stops = append(stops, cancelTimeout)
+	}
+	if generationFirstTokenTimeout > 0 {
+		g.firstTokenTimer = clock.AfterFunc(generationFirstTokenTimeout, func() { cancel(errFirstTokenTimeout) })
+		stops = append(stops, func() { g.firstTokenTimer.Stop() })
+	}
+	g.ctx = ctx
+
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
+
+// context is what upstream requests for the generation are made with
+func (g *generation) context() context.Context {
+	if g.ctx == nil {
+		return context.Background()
+	}
+	return g.ctx
+}
+
+// checkDeadlines records which deadline, if any, cut the generation short
+func (g *generation) checkDeadlines() error {
+	cause := context.Cause(g.context())
+	if cause != errGenerationTimeout && cause != errFirstTokenTimeout {
+		return nil
+	}
+	g.timeout = cause
+	log.Printf("⏰ Generation in room %d cut short after %v: %v (%d characters kept)", g.roomID, time.Since(g.started).Round(time.Millisecond), cause, len(g.response))
+	addCounter("cubbychat_generation_timeouts_total", "Generations cut short by a deadline", 1, "deadline", timeoutLabel(cause))
+	return cause
+}
+
+// timeoutLabel names a deadline for metrics
+func timeoutLabel(cause error) string {
+	if cause == errFirstTokenTimeout {
+		return "first_token"
+	}
+	return "overall"
+}
+
+// partial reports whether a deadline cut the answer short after some of it was streamed
+func (g *generation) partial() bool {
+	return g.timeout != nil && g.response != ""
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxToolRounds bounds how many times the model may call tools for one prompt
+const maxToolRounds = 3
+
+var errToolsUnsupported = errors.New("model does not support tools")
+
+// Tool is a function the model can call during generation
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{} // JSON schema for the arguments
+	Run         func(ctx context.Context, args map[string]interface{}) (*ToolResult, error)
+	Timeout     time.Duration
+}
+
+// ToolResult is what a tool hands back to the model
+type ToolResult struct {
+	Output  string
+	Sources []Source // Citable results; numbered and appended to Output for the model
+}
+
+// ToolCallSummary records a tool call on the AI message it contributed to
+type ToolCallSummary struct {
+	Tool      string                 `json:"tool"`
+	Arguments map[string]interface{} `json:"arguments"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+var (
+	registeredTools []*Tool
+	toolTableOnce   sync.Once
+)
+
+// Ollama chat API structures used for tool calling
+type OllamaChatRequest struct {
+	Model    string                 `json:"model"`
+	Messages []OllamaChatMessage    `json:"messages"`
+	Stream   bool                   `json:"stream"`
+	Tools    []OllamaTool           `json:"tools,omitempty"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+}
+
+type OllamaChatMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type OllamaToolCall struct {
+	Function struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	} `json:"function"`
+}
+
+type OllamaTool struct {
+	Type     string             `json:"type"`
+	Function OllamaToolFunction `json:"function"`
+}
+
+type OllamaToolFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+type OllamaChatStreamResponse struct {
+	Message         OllamaChatMessage `json:"message"`
+	Done            bool              `json:"done"`
+	PromptEvalCount int               `json:"prompt_eval_count,omitempty"`
+	EvalCount       int               `json:"eval_count,omitempty"`
+}
+
+// registerTool makes a tool available to the model
+func registerTool(tool *Tool) {
+	toolTableOnce.Do(createToolCallsTable)
+	registeredTools = append(registeredTools, tool)
+	log.Printf("🔧 Registered tool: %s", tool.Name)
+}
+
+// Create `tool_calls` table if it doesn't exist
+func createToolCallsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS tool_calls (
+			id SERIAL PRIMARY KEY,
+			tool TEXT NOT NULL,
+			arguments JSONB,
+			result TEXT,
+			error TEXT,
+			duration_ms INTEGER,
+			timestamp TIMESTAMPTZ DEFAULT NOW()
+		);
+		ALTER TABLE tool_calls ADD COLUMN IF NOT EXISTS message_id INTEGER REFERENCES chat_history(id) ON DELETE SET NULL;
+		ALTER TABLE tool_calls ADD COLUMN IF NOT EXISTS incognito BOOLEAN NOT NULL DEFAULT FALSE;
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create tool_calls table:", err)
+	}
+	log.Println("✅ Table tool_calls is ready")
+}
+
+// findTool looks up a registered tool by name
+func findTool(name string) *Tool {
+	for _, tool := range registeredTools {
+		if tool.Name == name {
+			return tool
+		}
+	}
+	return nil
+}
+
+// ollamaTools describes the registered tools in the format Ollama expects
+func ollamaTools() []OllamaTool {
+	var list []OllamaTool
+	for _, tool := range registeredTools {
+		list = append(list, OllamaTool{
+			Type: "function",
+			Function: OllamaToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	return list
+}
+
+// runToolCall executes a tool call, records it in tool_calls and returns the text given back to the model
+func runToolCall(gen *generation, call OllamaToolCall) (string, error) {
+	tool := findTool(call.Function.Name)
+	if tool == nil {
+		return "", fmt.Errorf("unknown tool %q", call.Function.Name)
+	}
+
+	timeout := tool.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	// The generation's deadline and the client going away stop a running tool too
+	ctx, cancel := context.WithTimeout(gen.context(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	result, err := tool.Run(ctx, call.Function.Arguments)
+	duration := time.Since(start)
+	if result == nil {
+		result = &ToolResult{}
+	}
+
+	// Number citable sources after the ones already gathered for this answer
+	output := result.Output
+	if len(result.Sources) > 0 {
+		output += formatToolSources(result.Sources, len(gen.sources))
+		gen.sources = append(gen.sources, result.Sources...)
+	}
+
+	var errText *string
+	if err != nil {
+		msg := err.Error()
+		errText = &msg
+	}
+	log.Printf("🔧 Tool %s finished in %v (error: %v)", tool.Name, duration, err)
+
+	// Every execution is audited; incognito ones without what was asked and answered
+	arguments, stored := call.Function.Arguments, &output
+	if gen.incognito {
+		arguments, stored = nil, nil
+	}
+	var id int
+	dbErr := db.QueryRow(context.Background(),
+		"INSERT INTO tool_calls (tool, arguments, result, error, duration_ms, incognito) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		tool.Name, arguments, stored, errText, duration.Milliseconds(), gen.incognito).Scan(&id)
+	if dbErr != nil {
+		log.Println("Error saving tool call:", dbErr)
+	} else if !gen.incognito {
+		gen.toolCallIDs = append(gen.toolCallIDs, id)
+	}
+
+	return output, err
+}
+
+// linkToolCalls attaches recorded tool calls to the AI message they contributed to
+func linkToolCalls(messageID int, ids []int) {
+	_, err := db.Exec(context.Background(),
+		"UPDATE tool_calls SET message_id = $1 WHERE id = ANY($2)", messageID, ids)
+	if err != nil {
+		log.Println("Error linking tool calls:", err)
+	}
+}
+
+// streamChatWithTools streams a chat completion, running any tools the model calls
+// and feeding their results back until the model produces a final answer
+func streamChatWithTools(s *Session, gen *generation) error {
+	messages := []OllamaChatMessage{{Role: "user", Content: gen.modelPrompt()}}
+
+	for round := 0; round <= maxToolRounds; round++ {
+		request := OllamaChatRequest{
+			Model:    gen.model,
+			Messages: messages,
+			Stream:   true,
+			Options:  gen.params().ollamaOptions(),
+		}
+		// On the last round the model has to answer with what it has
+		if round < maxToolRounds {
+			request.Tools = ollamaTools()
+		}
+
+		content, calls, err := streamChatRound(s, gen, request)
+		gen.response += content
+		if err != nil {
+			return err
+		}
+		if len(calls) == 0 {
+			return nil
+		}
+
+		messages = append(messages, OllamaChatMessage{Role: "assistant", Content: content, ToolCalls: calls})
+		for i, result := range runToolCalls(s, gen, calls) {
+			messages = append(messages, OllamaChatMessage{Role: "tool", Content: result, ToolName: calls[i].Function.Name})
+		}
+	}
+
+	return nil
+}
+
+// runToolCalls runs the tools the model asked for in one round, telling the client
+// about each call, and returns the text to give back to the model for each of them.
+// Every provider's tool calls are mapped onto OllamaToolCall to come through here.
+func runToolCalls(s *Session, gen *generation, calls []OllamaToolCall) []string {
+	results := make([]string, len(calls))
+	for i, call := range calls {
+		summary := ToolCallSummary{Tool: call.Function.Name, Arguments: call.Function.Arguments}
+		if err := s.sendEvent("tool_call", summary); err != nil {
+			log.Println("Error sending tool_call event:", err)
+		}
+
+		result, err := runToolCall(gen, call)
+		if err != nil {
+			summary.Error = err.Error()
+			result = fmt.Sprintf("Error: %v", err)
+		}
+		gen.toolCalls = append(gen.toolCalls, summary)
+		results[i] = result
+	}
+	return results
+}
+
+// streamChatRound runs one /api/chat request, streaming content tokens to the client
+// and collecting any tool calls
+func streamChatRound(s *Session, gen *generation, request OllamaChatRequest) (string, []OllamaToolCall, error) {
+	client := newOllamaClient()
+	ollamaChatURL := fmt.Sprintf("%s/api/chat", ollamaURL)
+
+	resp, err := client.R().
+		SetContext(gen.context()).
+		SetHeader("Content-Type", "application/json").
+		SetBody(request).
+		SetDoNotParseResponse(true).
+		Post(ollamaChatURL)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.RawBody().Close()
+
+	if resp.StatusCode() != 200 {
+		body, _ := io.ReadAll(resp.RawBody())
+		if strings.Contains(string(body), "does not support tools") {
+			return "", nil, errToolsUnsupported
+		}
+		return "", nil, &upstreamStatusError{provider: "ollama", status: resp.StatusCode(), body: string(body)}
+	}
+
+	scanner := bufio.NewScanner(resp.RawBody())
+	var content string
+	var calls []OllamaToolCall
+	for scanner.Scan() {
+		var result OllamaChatStreamResponse
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			log.Println("Error parsing Ollama response:", err)
+			continue
+		}
+
+		calls = append(calls, result.Message.ToolCalls...)
+		if result.Message.Content != "" {
+			if err := gen.sendToken(s, result.Message.Content); err != nil {
+				log.Println("Error sending message:", err)
+				break
+			}
+			content += result.Message.Content
+		}
+
+		if result.Done {
+			gen.promptTokens += result.PromptEvalCount
+			gen.completionTokens += result.EvalCount
+			break
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Println("Error reading Ollama stream:", err)
+	}
+
+	return content, calls, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Inline markdown, applied to already-escaped text
+var (
+	mdInlineCodePattern = regexp.MustCompile("`([^`\n]+)`")
+	mdBoldPattern       = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	mdItalicPattern     = regexp.MustCompile(`(^|[^*])\*([^*\n]+)\*`)
+	mdLinkPattern       = regexp.MustCompile(`\[([^\]\n]+)\]\((https?://[^\s)]+)\)`)
+	mdHeadingPattern    = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	mdOrderedPattern    = regexp.MustCompile(`^\d+[.)]\s+`)
+)
+
+// transcriptPage renders a shared conversation; highlight.js colours the code blocks
+var transcriptPage = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Room}} · Cubby Chat</title>
+<link rel="stylesheet" href="https://cdn.jsdelivr.net/gh/highlightjs/cdn-release@11.9.0/build/styles/github.min.css">
+<style>
+body { font-family: system-ui, sans-serif; max-width: 720px; margin: 2rem auto; padding: 0 1rem; color: #222; }
+header { border-bottom: 1px solid #eee; margin-bottom: 1.5rem; }
+.meta { color: #888; font-size: 0.85rem; }
+.message { margin-bottom: 1.25rem; }
+.sender { font-weight: bold; margin-bottom: 0.25rem; }
+.AI .sender { color: #ec4899; }
+.System { text-align: center; color: #888; font-size: 0.85rem; border-top: 1px dashed #eee; border-bottom: 1px dashed #eee; padding: 0.25rem 0; }
+.System p { margin: 0; }
+pre { background: #f6f8fa; padding: 0.75rem; border-radius: 6px; overflow-x: auto; }
+code { font-family: ui-monospace, monospace; font-size: 0.9em; }
+blockquote { border-left: 3px solid #ddd; margin-left: 0; padding-left: 1rem; color: #555; }
+</style>
+</head>
+<body>
+<header>
+<h1>🧸 {{.Room}}</h1>
+<p class="meta">Shared {{.SharedAt.Format "2 Jan 2006 15:04 MST"}}{{with .ExpiresAt}} · link expires {{.Format "2 Jan 2006 15:04 MST"}}{{end}}</p>
+</header>
+{{range .Messages}}<div class="message {{.Sender}}">
+{{if ne .Sender "System"}}<div class="sender">{{if eq .Sender "AI"}}Cubby{{else}}{{.Sender}}{{end}} <span class="meta">{{.Timestamp.Format "15:04"}}</span></div>{{end}}
+{{.HTML}}
+</div>
+{{else}}<p class="meta">This conversation has no messages.</p>
+{{end}}<script src="https://cdn.jsdelivr.net/gh/highlightjs/cdn-release@11.9.0/build/highlight.min.js"></script>
+<script>hljs.highlightAll();</script>
+</body>
+</html>
+`))
+
+// renderedMessage is a transcript message with its markdown turned into HTML
+type renderedMessage struct {
+	ChatMessage
+	HTML template.HTML
+}
+
+// renderInline applies inline markdown to one line of text, escaping everything else
+func renderInline(text string) string {
+	text = html.EscapeString(text)
+	// Code spans are set aside so emphasis and links inside them stay literal
+	var spans []string
+	text = mdInlineCodePattern.ReplaceAllStringFunc(text, func(m string) string {
+		spans = append(spans, "<code>"+mdInlineCodePattern.FindStringSubmatch(m)[1]+"</code>")
+		return "\x00"
+	})
+	text = mdLinkPattern.ReplaceAllString(text, `<a href="$2" rel="nofollow noopener">$1</a>`)
+	text = mdBoldPattern.ReplaceAllString(text, "<strong>$1</strong>")
+	text = mdItalicPattern.ReplaceAllString(text, "$1<em>$2</em>")
+	for _, span := range spans {
+		text = strings.Replace(text, "\x00", span, 1)
+	}
+	return text
+}
+
+// renderMarkdown converts the markdown the model writes (paragraphs, headings, lists,
+// quotes and fenced code) to HTML. Raw HTML in the text is escaped, never passed through.
+func renderMarkdown(text string) template.HTML {
+	var out strings.Builder
+	var paragraph []string
+	list := "" // "ul" or "ol" while inside a list
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			out.WriteString("<p>" + strings.Join(paragraph, "<br>\n") + "</p>\n")
+			paragraph = nil
+		}
+		if list != "" {
+			out.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		if m := fencePattern.FindStringSubmatch(line); m != nil {
+			flush()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), m[2]); i++ {
+				code = append(code, lines[i])
+			}
+			class := ""
+			if lang := normalizeLanguage(m[3]); lang != "" {
+				class = ` class="language-` + html.EscapeString(lang) + `"`
+			}
+			out.WriteString("<pre><code" + class + ">" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+			continue
+		}
+
+		switch {
+		case trimmed == "":
+			flush()
+		case mdHeadingPattern.MatchString(trimmed):
+			flush()
+			m := mdHeadingPattern.FindStringSubmatch(trimmed)
+			level := string(rune('0' + len(m[1])))
+			out.WriteString("<h" + level + ">" + renderInline(m[2]) + "</h" + level + ">\n")
+		case strings.HasPrefix(trimmed, "> "):
+			flush()
+			out.WriteString("<blockquote>" + renderInline(strings.TrimPrefix(trimmed, "> ")) + "</blockquote>\n")
+		case strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* ") || mdOrderedPattern.MatchString(trimmed):
+			kind, item := "ul", trimmed[2:]
+			if loc := mdOrderedPattern.FindStringIndex(trimmed); loc != nil {
+				kind, item = "ol", trimmed[loc[1]:]
+			}
+			if list != kind {
+				flush()
+				out.WriteString("<" + kind + ">\n")
+				list = kind
+			}
+			out.WriteString("<li>" + renderInline(item) + "</li>\n")
+		default:
+			if list != "" {
+				flush()
+			}
+			paragraph = append(paragraph, renderInline(trimmed))
+		}
+	}
+	flush()
+	return template.HTML(out.String())
+}
+
+// Handler for /t/{token}: a shared conversation as a standalone HTML page
+func renderTranscriptPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	link, err := resolveShareLink(r.PathValue("token"))
+	if err == errShareLinkInvalid {
+		http.Error(w, "This link is invalid, has expired or was revoked", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch share link", http.StatusInternalServerError)
+		log.Println("Error fetching share link:", err)
+		return
+	}
+	transcript, err := loadSharedTranscript(link)
+	if err != nil {
+		http.Error(w, "Failed to fetch transcript", http.StatusInternalServerError)
+		log.Println("Error fetching shared transcript:", err)
+		return
+	}
+
+	messages := make([]renderedMessage, len(transcript.Messages))
+	for i, msg := range transcript.Messages {
+		messages[i] = renderedMessage{ChatMessage: msg, HTML: renderMarkdown(msg.Message)}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	err = transcriptPage.Execute(w, struct {
+		*SharedTranscript
+		Messages []renderedMessage
+	}{transcript, messages})
+	if err != nil {
+		log.Println("Error rendering transcript:", err)
+	}
+}
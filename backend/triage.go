@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+)
+
+// triageInstructions tell the model how to answer an alert posted by a triage webhook;
+// "{source}" is replaced by the webhook's name
+var triageInstructions string
+
+const defaultTriageInstructions = `An alert was just posted to this incident channel by {source}. Triage it for the on-call engineer:
+1. Summarize what is failing, where and how badly, in two or three sentences.
+2. Give the likely causes you can infer from the payload.
+3. Suggest concrete next steps to investigate or mitigate, most useful first.
+Be brief and don't invent details the alert doesn't contain.`
+
+// initTriage reads the triage instructions and adds the webhook triage setting
+func initTriage() {
+	triageInstructions = getEnv("ALERT_TRIAGE_INSTRUCTIONS", defaultTriageInstructions)
+	migrateWebhookTriage()
+}
+
+// Add the room_webhooks.triage column if it doesn't exist
+func migrateWebhookTriage() {
+	query := `
+		ALTER TABLE room_webhooks ADD COLUMN IF NOT EXISTS triage BOOLEAN NOT NULL DEFAULT FALSE;
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to add webhook triage:", err)
+	}
+}
+
+// triagePrompt asks for a summary and next steps for an alert payload, pretty-printing JSON ones
+func triagePrompt(source, payload string) string {
+	var indented bytes.Buffer
+	if json.Indent(&indented, []byte(payload), "", "  ") == nil {
+		payload = indented.String()
+	}
+	return strings.ReplaceAll(triageInstructions, "{source}", source) + "\n\nAlert:\n```\n" + payload + "\n```"
+}
+
+// triageAlert has the AI answer an alert delivered to a room, posting its summary as an
+// AI message. It runs in the background so the sender isn't kept waiting.
+func triageAlert(roomID int, source, payload string) {
+	// Human-only rooms and rooms an operator took over get the alert without a triage
+	room, err := getRoom(roomID)
+	if err != nil {
+		log.Printf("Error fetching room %d for triage: %v", roomID, err)
+		addCounter("cubbychat_alert_triages_total", "Alerts the AI triaged, by result", 1, "result", "error")
+		return
+	}
+	if !roomAIAnswers(room, false) {
+		addCounter("cubbychat_alert_triages_total", "Alerts the AI triaged, by result", 1, "result", "skipped")
+		return
+	}
+
+	user := "webhook:" + source
+	gen, err := prepareGeneration(roomID, user, "", triagePrompt(source, payload))
+	if err != nil {
+		log.Printf("Error preparing triage for room %d: %v", roomID, err)
+		addCounter("cubbychat_alert_triages_total", "Alerts the AI triaged, by result", 1, "result", "error")
+		return
+	}
+
+	// Nobody is streaming the answer; the room sees it once it's stored
+	s := &Session{room: roomID, user: user, sink: func(frame outboundFrame) error { return nil }}
+	defer s.close()
+	if err := generateWithFailover(s, gen); err != nil && !gen.partial() {
+		log.Printf("Error triaging alert in room %d: %v", roomID, err)
+		recordUsage(gen, 0, err)
+		recordFailedGeneration(gen, err)
+		addCounter("cubbychat_alert_triages_total", "Alerts the AI triaged, by result", 1, "result", "error")
+		return
+	}
+
+	answer, metadata, messageID := storeAIResponse(gen)
+	publishRoomEvent(roomID, nil, "message", ChatMessage{ID: messageID, Sender: "AI", Message: answer, Timestamp: time.Now(), Metadata: metadata})
+	addCounter("cubbychat_alert_triages_total", "Alerts the AI triaged, by result", 1, "result", "ok")
+}
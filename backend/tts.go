@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ttsEnabled  bool          // Whether the server can speak AI responses
+	ttsDefault  bool          // Whether new sessions have speech turned on
+	ttsURL      string        // Base URL of an OpenAI-compatible speech server
+	ttsModel    string        // Speech model name
+	ttsVoice    string        // Voice name
+	ttsAPIKey   string        // Optional bearer token
+	ttsFormat   string        // Audio format ("mp3", "opus", "wav", ...)
+	ttsMaxChars int           // Longest text synthesized per response
+	ttsTimeout  time.Duration // Upper bound for one synthesis
+)
+
+// Content types for the audio formats speech servers return
+var ttsContentTypes = map[string]string{
+	"mp3":  "audio/mpeg",
+	"opus": "audio/ogg",
+	"aac":  "audio/aac",
+	"flac": "audio/flac",
+	"wav":  "audio/wav",
+}
+
+var (
+	speechCodeBlockPattern = regexp.MustCompile("(?s)```.*?```")
+	speechImagePattern     = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	speechLinkPattern      = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	speechMarkupPattern    = regexp.MustCompile("[*_#`>~|]+")
+)
+
+// TTSEvent points the client at the spoken version of an AI message
+type TTSEvent struct {
+	MessageID int         `json:"message_id"`
+	URL       string      `json:"url"`
+	Audio     *Attachment `json:"audio"`
+}
+
+// initTextToSpeech reads the text-to-speech settings and registers the /tts command
+func initTextToSpeech() {
+	ttsEnabled = getEnvBool("TTS_ENABLED", false)
+	ttsDefault = getEnvBool("TTS_DEFAULT", false)
+	ttsURL = getEnv("TTS_URL", "http://tts:8000")
+	ttsModel = getEnv("TTS_MODEL", "tts-1")
+	ttsVoice = getEnv("TTS_VOICE", "alloy")
+	ttsAPIKey = getEnv("TTS_API_KEY", "")
+	ttsFormat = strings.ToLower(getEnv("TTS_FORMAT", "mp3"))
+	ttsMaxChars = getEnvInt("TTS_MAX_CHARS", 4000)
+	ttsTimeout = getEnvDuration("TTS_TIMEOUT", 60*time.Second)
+
+	if !ttsEnabled {
+		return
+	}
+	if _, ok := ttsContentTypes[ttsFormat]; !ok {
+		log.Printf("⚠️ Unsupported TTS_FORMAT %q, falling back to mp3", ttsFormat)
+		ttsFormat = "mp3"
+	}
+
+	registerCommand("tts", ttsCommand)
+	log.Printf("🔊 Text-to-speech enabled (voice: %s, on by default: %v)", ttsVoice, ttsDefault)
+}
+
+// speechText turns markdown into plain text suitable for reading aloud
+func speechText(markdown string) string {
+	text := speechCodeBlockPattern.ReplaceAllString(markdown, " (code omitted) ")
+	text = speechImagePattern.ReplaceAllString(text, "")
+	text = speechLinkPattern.ReplaceAllString(text, "$1")
+	text = speechMarkupPattern.ReplaceAllString(text, "")
+	text = strings.Join(strings.Fields(text), " ")
+	return cutText(text, ttsMaxChars)
+}
+
+// synthesizeSpeech calls the speech server's /v1/audio/speech endpoint
+func synthesizeSpeech(text string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ttsTimeout)
+	defer cancel()
+
+	req := newUpstreamClient().R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(map[string]interface{}{
+			"model":           ttsModel,
+			"input":           text,
+			"voice":           ttsVoice,
+			"response_format": ttsFormat,
+		})
+	if ttsAPIKey != "" {
+		req.SetAuthToken(ttsAPIKey)
+	}
+
+	resp, err := req.Post(ttsURL + "/v1/audio/speech")
+	if err != nil {
+		return nil, fmt.Errorf("text-to-speech unavailable: %v", err)
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("text-to-speech returned status %d: %s", resp.StatusCode(), resp.String())
+	}
+	return resp.Body(), nil
+}
+
+// speakResponse synthesizes a stored AI message, attaches the audio to it and notifies the client
+func speakResponse(s *Session, messageID int, markdown string) {
+	text := speechText(markdown)
+	if text == "" {
+		return
+	}
+
+	audio, err := synthesizeSpeech(text)
+	if err != nil {
+		log.Println("Error synthesizing speech:", err)
+		return
+	}
+
+	att, err := saveAttachment(fmt.Sprintf("message-%d.%s", messageID, ttsFormat), ttsContentTypes[ttsFormat], audio)
+	if err != nil {
+		log.Println("Error saving speech audio:", err)
+		return
+	}
+
+	_, err = db.Exec(context.Background(),
+		"UPDATE chat_history SET metadata = COALESCE(metadata, '{}'::jsonb) || jsonb_build_object('audio', $2::jsonb) WHERE id = $1",
+		messageID, att)
+	if err != nil {
+		log.Println("Error linking speech audio to message:", err)
+	}
+	historyChanged(s.room)
+
+	if err := s.sendEvent("tts", TTSEvent{MessageID: messageID, URL: att.URL, Audio: att}); err != nil {
+		log.Println("Error sending tts event:", err)
+	}
+}
+
+// speaks reports whether the session's completed AI responses are spoken
+func (s *Session) speaks() bool {
+	s.prefsMu.Lock()
+	defer s.prefsMu.Unlock()
+	return s.tts
+}
+
+// ttsCommand handles "/tts on|off" to toggle speech for this session
+func ttsCommand(s *Session, args string) {
+	s.prefsMu.Lock()
+	switch strings.ToLower(args) {
+	case "on":
+		s.tts = true
+	case "off":
+		s.tts = false
+	case "":
+		s.tts = !s.tts
+	default:
+		s.prefsMu.Unlock()
+		s.sendText("🔊 Usage: /tts on|off")
+		return
+	}
+	on := s.tts
+	s.prefsMu.Unlock()
+
+	state := "off"
+	if on {
+		state = "on"
+	}
+	s.sendText(fmt.Sprintf("🔊 Spoken responses are now %s", state))
+}
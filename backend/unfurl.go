@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	unfurlEnabled   bool          // Whether to fetch previews for shared URLs
+	unfurlAllowlist []string      // If set, only these domains (and subdomains) are fetched
+	unfurlDenylist  []string      // Domains that are never fetched
+	unfurlTimeout   time.Duration // Per-URL fetch timeout
+	unfurlMaxBytes  int64         // Maximum HTML bytes read per page
+	unfurlMaxLinks  int           // Maximum URLs unfurled per message
+	unfurlClient    *http.Client
+)
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+
+// LinkPreview is the metadata stored for a shared URL
+type LinkPreview struct {
+	MessageID   int    `json:"message_id"`
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	ImageURL    string `json:"image_url"`
+	SiteName    string `json:"site_name"`
+}
+
+// initUnfurl reads link unfurling settings and prepares the hardened HTTP client
+func initUnfurl() {
+	unfurlEnabled = getEnvBool("UNFURL_ENABLED", false)
+	unfurlAllowlist = splitList(getEnv("UNFURL_ALLOWLIST", ""))
+	unfurlDenylist = splitList(getEnv("UNFURL_DENYLIST", ""))
+	unfurlTimeout = getEnvDuration("UNFURL_TIMEOUT", 5*time.Second)
+	unfurlMaxBytes = int64(getEnvInt("UNFURL_MAX_BYTES", 512*1024))
+	unfurlMaxLinks = getEnvInt("UNFURL_MAX_LINKS", 3)
+
+	if !unfurlEnabled {
+		return
+	}
+
+	// Every connection is checked after DNS resolution, so redirects and
+	// rebinding tricks can't reach internal addresses either
+	dialer := &net.Dialer{
+		Timeout: unfurlTimeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+			if !isPublicAddr(addr) {
+				return fmt.Errorf("refusing to connect to non-public address %s", addr)
+			}
+			return nil
+		},
+	}
+
+	unfurlClient = &http.Client{
+		Timeout: unfurlTimeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: unfurlTimeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			return checkUnfurlURL(req.URL)
+		},
+	}
+
+	createLinkPreviewTable()
+	log.Printf("🔗 Link unfurling enabled (allowlist: %d, denylist: %d)", len(unfurlAllowlist), len(unfurlDenylist))
+}
+
+// Create `link_previews` table if it doesn't exist
+func createLinkPreviewTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS link_previews (
+			id SERIAL PRIMARY KEY,
+			message_id INTEGER REFERENCES chat_history(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			title TEXT,
+			description TEXT,
+			image_url TEXT,
+			site_name TEXT,
+			fetched_at TIMESTAMPTZ DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS link_previews_message_id_idx ON link_previews (message_id);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create link_previews table:", err)
+	}
+	log.Println("✅ Table link_previews is ready")
+}
+
+// splitList splits a comma-separated setting into trimmed, lower-cased entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// domainMatches reports whether host equals or is a subdomain of any listed domain
+func domainMatches(host string, domains []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// nonPublicPrefixes are the special-purpose ranges of the IANA IPv4 and IPv6 registries that
+// aren't globally reachable, plus multicast and the IPv6 prefixes that embed an IPv4 address
+// (NAT64, 6to4, Teredo) and so could reach any of them
+var nonPublicPrefixes = func() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, p := range []string{
+		"0.0.0.0/8",       // "This network"
+		"10.0.0.0/8",      // Private
+		"100.64.0.0/10",   // Carrier-grade NAT
+		"127.0.0.0/8",     // Loopback
+		"169.254.0.0/16",  // Link-local, including cloud metadata endpoints
+		"172.16.0.0/12",   // Private
+		"192.0.0.0/24",    // IETF protocol assignments
+		"192.0.2.0/24",    // Documentation
+		"192.31.196.0/24", // AS112
+		"192.52.193.0/24", // AMT
+		"192.88.99.0/24",  // 6to4 relay anycast
+		"192.168.0.0/16",  // Private
+		"192.175.48.0/24", // AS112
+		"198.18.0.0/15",   // Benchmarking
+		"198.51.100.0/24", // Documentation
+		"203.0.113.0/24",  // Documentation
+		"224.0.0.0/4",     // Multicast
+		"240.0.0.0/4",     // Reserved, including broadcast
+		"::/96",           // Unspecified, loopback and IPv4-compatible
+		"64:ff9b::/96",    // NAT64
+		"64:ff9b:1::/48",  // Local-use NAT64
+		"100::/64",        // Discard-only
+		"2001::/23",       // IETF protocol assignments, including Teredo
+		"2001:db8::/32",   // Documentation
+		"2002::/16",       // 6to4
+		"3fff::/20",       // Documentation
+		"5f00::/16",       // Segment routing
+		"fc00::/7",        // Unique local
+		"fe80::/10",       // Link-local
+		"fec0::/10",       // Site-local (deprecated)
+		"ff00::/8",        // Multicast
+	} {
+		prefixes = append(prefixes, netip.MustParsePrefix(p))
+	}
+	return prefixes
+}()
+
+// isPublicAddr rejects loopback, private, link-local and other addresses that aren't
+// globally reachable
+func isPublicAddr(addr netip.Addr) bool {
+	// Prefixes never contain zoned addresses, and IPv4-mapped ones are checked as IPv4
+	addr = addr.Unmap().WithZone("")
+	if !addr.IsValid() {
+		return false
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkUnfurlURL validates the scheme and host of a URL against the unfurl policy
+func checkUnfurlURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("missing host")
+	}
+	if domainMatches(host, unfurlDenylist) {
+		return fmt.Errorf("host %s is denied", host)
+	}
+	if len(unfurlAllowlist) > 0 && !domainMatches(host, unfurlAllowlist) {
+		return fmt.Errorf("host %s is not allowed", host)
+	}
+	return nil
+}
+
+// extractURLs returns the distinct URLs found in a message
+func extractURLs(message string) []string {
+	seen := make(map[string]bool)
+	var urls []string
+	for _, match := range urlPattern.FindAllString(message, -1) {
+		match = strings.TrimRight(match, ".,;:!?)]}")
+		if seen[match] {
+			continue
+		}
+		seen[match] = true
+		urls = append(urls, match)
+	}
+	return urls
+}
+
+// fetchLinkPreview downloads a page and extracts its title, description and image
+func fetchLinkPreview(rawURL string) (*LinkPreview, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUnfurlURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "CubbyChat-Unfurler/1.0")
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := unfurlClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.Contains(ct, "text/html") {
+		return nil, fmt.Errorf("unsupported content type %q", ct)
+	}
+
+	preview := parseLinkPreview(io.LimitReader(resp.Body, unfurlMaxBytes), resp.Request.URL)
+	preview.URL = rawURL
+	return preview, nil
+}
+
+// parseLinkPreview reads <title> and OpenGraph/description meta tags from an HTML document
+func parseLinkPreview(r io.Reader, base *url.URL) *LinkPreview {
+	preview := &LinkPreview{}
+	var titleTag string
+	inTitle := false
+
+	tokenizer := html.NewTokenizer(r)
+	for {
+		tt := tokenizer.Next()
+		switch tt {
+		case html.ErrorToken:
+			if preview.Title == "" {
+				preview.Title = strings.TrimSpace(titleTag)
+			}
+			return preview
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				inTitle = true
+			case "meta":
+				applyMetaTag(preview, token, base)
+			case "body":
+				// Metadata lives in <head>; stop before reading the page body
+				if preview.Title == "" {
+					preview.Title = strings.TrimSpace(titleTag)
+				}
+				return preview
+			}
+		case html.TextToken:
+			if inTitle {
+				titleTag += string(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			if tokenizer.Token().Data == "title" {
+				inTitle = false
+			}
+		}
+	}
+}
+
+// applyMetaTag copies a recognised <meta> tag into the preview
+func applyMetaTag(preview *LinkPreview, token html.Token, base *url.URL) {
+	var key, content string
+	for _, attr := range token.Attr {
+		switch attr.Key {
+		case "property", "name":
+			key = strings.ToLower(attr.Val)
+		case "content":
+			content = strings.TrimSpace(attr.Val)
+		}
+	}
+	if content == "" {
+		return
+	}
+
+	switch key {
+	case "og:title", "twitter:title":
+		preview.Title = content
+	case "og:description", "twitter:description":
+		preview.Description = content
+	case "description":
+		if preview.Description == "" {
+			preview.Description = content
+		}
+	case "og:image", "twitter:image":
+		if img, err := base.Parse(content); err == nil && (img.Scheme == "http" || img.Scheme == "https") {
+			preview.ImageURL = img.String()
+		}
+	case "og:site_name":
+		preview.SiteName = content
+	}
+}
+
+// saveLinkPreview stores a preview for a message
+func saveLinkPreview(preview *LinkPreview) {
+	_, err := db.Exec(context.Background(),
+		"INSERT INTO link_previews (message_id, url, title, description, image_url, site_name) VALUES ($1, $2, $3, $4, $5, $6)",
+		preview.MessageID, preview.URL, preview.Title, preview.Description, preview.ImageURL, preview.SiteName)
+	if err != nil {
+		log.Println("Error saving link preview:", err)
+	}
+}
+
+// unfurlMessageLinks fetches previews for URLs in a message, stores them and sends "unfurl"
+// events to everyone in the room. It fetches pages one after another, so run it in its own
+// goroutine.
+func unfurlMessageLinks(s *Session, messageID int, message string) {
+	urls := extractURLs(message)
+	if len(urls) > unfurlMaxLinks {
+		urls = urls[:unfurlMaxLinks]
+	}
+
+	for _, link := range urls {
+		preview, err := fetchLinkPreview(link)
+		if err != nil {
+			log.Printf("⚠️ Could not unfurl %s: %v", link, err)
+			continue
+		}
+		if preview.Title == "" && preview.Description == "" && preview.ImageURL == "" {
+			continue
+		}
+		preview.MessageID = messageID
+
+		if messageID != 0 {
+			saveLinkPreview(preview)
+		}
+		if err := s.sendEvent("unfurl", preview); err != nil {
+			log.Println("Error sending unfurl event:", err)
+		}
+		publishRoomEvent(s.room, s, "unfurl", preview)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExtractURLs(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []string
+	}{
+		{"none", "no links here", nil},
+		{"one", "see https://example.com/page", []string{"https://example.com/page"}},
+		{"trailing punctuation", "read https://example.com/a. Then http://example.org/b!", []string{"https://example.com/a", "http://example.org/b"}},
+		{"in parentheses", "(https://example.com/x)", []string{"https://example.com/x"}},
+		{"duplicates once", "https://example.com https://example.com", []string{"https://example.com"}},
+		{"quotes end a URL", `<a href="https://example.com/q">`, []string{"https://example.com/q"}},
+		{"other schemes ignored", "ftp://example.com file:///etc/passwd", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractURLs(tt.message); !slices.Equal(got, tt.want) {
+				t.Errorf("extractURLs(%q) = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false}, // Cloud metadata endpoints
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false}, // Carrier-grade NAT
+		{"100.127.255.255", false},
+		{"100.128.0.1", true},
+		{"224.0.0.1", false},
+		{"ff02::1", false},
+		{"::ffff:127.0.0.1", false}, // IPv4-mapped loopback
+		{"::ffff:10.0.0.1", false},
+		{"0.1.2.3", false}, // "This network"
+		{"192.0.0.8", false},
+		{"192.0.2.1", false},  // Documentation
+		{"198.18.0.1", false}, // Benchmarking
+		{"198.19.255.255", false},
+		{"198.20.0.1", true},
+		{"198.51.100.7", false},
+		{"203.0.113.9", false},
+		{"240.0.0.1", false}, // Reserved
+		{"255.255.255.255", false},
+		{"64:ff9b::7f00:1", false},    // NAT64 of 127.0.0.1
+		{"64:ff9b::a9fe:a9fe", false}, // NAT64 of 169.254.169.254
+		{"64:ff9b:1::1", false},
+		{"2002:7f00:1::1", false},      // 6to4 of 127.0.0.1
+		{"2001:0:4136:e378::1", false}, // Teredo
+		{"2001:db8::1", false},
+		{"::7f00:1", false}, // IPv4-compatible
+		{"fec0::1", false},
+		{"fe80::1%eth0", false},
+		{"2a00:1450:4001::1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := isPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("isPublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDomainMatches(t *testing.T) {
+	domains := []string{"example.com"}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.com.", true},
+		{"www.example.com", true},
+		{"badexample.com", false},
+		{"example.com.evil.net", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := domainMatches(tt.host, domains); got != tt.want {
+				t.Errorf("domainMatches(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckUnfurlURL(t *testing.T) {
+	oldAllow, oldDeny := unfurlAllowlist, unfurlDenylist
+	t.Cleanup(func() { unfurlAllowlist, unfurlDenylist = oldAllow, oldDeny })
+
+	tests := []struct {
+		name         string
+		allow, deny  []string
+		rawURL       string
+		wantAccepted bool
+	}{
+		{"plain https", nil, nil, "https://example.com/", true},
+		{"other scheme", nil, nil, "gopher://example.com/", false},
+		{"no host", nil, nil, "http:///path", false},
+		{"denied domain", nil, []string{"example.com"}, "https://sub.example.com/", false},
+		{"outside the allowlist", []string{"example.org"}, nil, "https://example.com/", false},
+		{"inside the allowlist", []string{"example.org"}, nil, "https://docs.example.org/", true},
+		{"denylist beats allowlist", []string{"example.org"}, []string{"example.org"}, "https://example.org/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unfurlAllowlist, unfurlDenylist = tt.allow, tt.deny
+			u, err := url.Parse(tt.rawURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := checkUnfurlURL(u); (err == nil) != tt.wantAccepted {
+				t.Errorf("checkUnfurlURL(%s) = %v, want accepted %v", tt.rawURL, err, tt.wantAccepted)
+			}
+		})
+	}
+}
+
+func TestParseLinkPreview(t *testing.T) {
+	base, _ := url.Parse("https://example.com/articles/1")
+	page := `<html><head><title> Fallback </title>
+		<meta property="og:title" content="Open Graph title">
+		<meta name="description" content="Plain description">
+		<meta property="og:image" content="/img/cover.png">
+		<meta property="og:site_name" content="Example">
+		</head><body><meta property="og:description" content="ignored after body"></body></html>`
+
+	got := parseLinkPreview(strings.NewReader(page), base)
+	want := LinkPreview{Title: "Open Graph title", Description: "Plain description", ImageURL: "https://example.com/img/cover.png", SiteName: "Example"}
+	if *got != want {
+		t.Errorf("parseLinkPreview = %+v, want %+v", *got, want)
+	}
+
+	got = parseLinkPreview(strings.NewReader(`<title>Only a title</title><meta property="og:image" content="javascript:alert(1)">`), base)
+	if got.Title != "Only a title" || got.ImageURL != "" {
+		t.Errorf("parseLinkPreview = %+v, want the title and no image", *got)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Profile settings are kept on the server so they follow a user across devices. Like
+// drafts they belong to a verified user (see usertokens.go): a bare ?user= name neither
+// reads nor changes them.
+var userModelChoices []string // "provider/model" entries users may pick as their default model
+
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// UserSettings are a user's preferences
+type UserSettings struct {
+	DisplayName  string     `json:"display_name,omitempty"`  // Shown instead of the user name
+	AvatarURL    string     `json:"avatar_url,omitempty"`    // Picture shown next to the user's messages
+	Locale       string     `json:"locale,omitempty"`        // Language tag such as "de-CH"; answers are given in it
+	TTS          *bool      `json:"tts,omitempty"`           // Whether answers are spoken; unset uses TTS_DEFAULT
+	DefaultModel string     `json:"default_model,omitempty"` // "provider/model" from USER_MODEL_CHOICES, used where the room picks none
+	UpdatedAt    *time.Time `json:"updated_at,omitempty"`
+}
+
+// initUserSettings reads the model choices and adds the settings table
+func initUserSettings() {
+	userModelChoices = splitList(getEnv("USER_MODEL_CHOICES", ""))
+	createUserSettingsTable()
+}
+
+// Create `user_settings` table if it doesn't exist
+func createUserSettingsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS user_settings (
+			user_id TEXT PRIMARY KEY,
+			settings JSONB NOT NULL DEFAULT '{}',
+			updated_at TIMESTAMPTZ DEFAULT NOW()
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create user_settings table:", err)
+	}
+	log.Println("✅ Table user_settings is ready")
+}
+
+// getUserSettings returns a user's settings, empty if they haven't saved any
+func getUserSettings(user string) (*UserSettings, error) {
+	settings := &UserSettings{}
+	var updatedAt time.Time
+	err := db.QueryRow(context.Background(),
+		"SELECT settings, updated_at FROM user_settings WHERE user_id = $1", user).Scan(settings, &updatedAt)
+	if err == pgx.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	settings.UpdatedAt = &updatedAt
+	return settings, nil
+}
+
+// validate checks and tidies settings before they're saved
+func (u *UserSettings) validate() error {
+	u.DisplayName = strings.ToValidUTF8(strings.TrimSpace(u.DisplayName), "")
+	if len([]rune(u.DisplayName)) > 64 {
+		return fmt.Errorf("display_name is limited to 64 characters")
+	}
+	// Uploaded avatars live in the attachment store
+	if u.AvatarURL != "" && !strings.HasPrefix(u.AvatarURL, attachmentURL("")) {
+		parsed, err := url.Parse(u.AvatarURL)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" || len(u.AvatarURL) > 2048 {
+			return fmt.Errorf("avatar_url must be an http or https URL, or an uploaded attachment")
+		}
+	}
+	if u.Locale != "" && !localePattern.MatchString(u.Locale) {
+		return fmt.Errorf(`locale must be a language tag such as "en" or "de-CH"`)
+	}
+	if u.DefaultModel != "" && !containsString(userModelChoices, u.DefaultModel) {
+		if len(userModelChoices) == 0 {
+			return fmt.Errorf("this server doesn't let users pick a model")
+		}
+		return fmt.Errorf("default_model must be one of %s", strings.Join(userModelChoices, ", "))
+	}
+	return nil
+}
+
+// applySettings brings a session in line with its user's settings. The tts query
+// parameter, when given, wins for that connection.
+func (s *Session) applySettings(settings *UserSettings, r *http.Request) {
+	s.prefsMu.Lock()
+	defer s.prefsMu.Unlock()
+	s.settings = settings
+	if settings.TTS != nil && (r == nil || r.URL.Query().Get("tts") == "") {
+		s.tts = *settings.TTS
+	}
+}
+
+// userSettings is the settings the session runs with, nil for guests. They're replaced
+// rather than changed, so callers can read them without holding prefsMu.
+func (s *Session) userSettings() *UserSettings {
+	s.prefsMu.Lock()
+	defer s.prefsMu.Unlock()
+	return s.settings
+}
+
+// loadSettings applies the verified user's saved settings to a new session
+func (s *Session) loadSettings(r *http.Request) {
+	if s.identity == "" {
+		return
+	}
+	settings, err := getUserSettings(s.identity)
+	if err != nil {
+		log.Println("Error fetching user settings:", err)
+		return
+	}
+	s.applySettings(settings, r)
+}
+
+// sendSettings tells a verified user's client the settings the session runs with
+func sendSettings(s *Session) {
+	settings := s.userSettings()
+	if settings == nil {
+		return
+	}
+	if err := s.sendEvent("settings", settings); err != nil {
+		log.Println("Error sending settings event:", err)
+	}
+}
+
+// displayName is what others see the session's user called
+func (s *Session) displayName() string {
+	if settings := s.userSettings(); settings != nil && settings.DisplayName != "" {
+		return settings.DisplayName
+	}
+	return s.user
+}
+
+// applyUserPreferences lets the user's locale and default model shape a generation
+func applyUserPreferences(s *Session, gen *generation) {
+	settings := s.userSettings()
+	if settings == nil {
+		return
+	}
+	gen.locale = settings.Locale
+	if provider, model, ok := strings.Cut(settings.DefaultModel, "/"); ok && gen.roomProvider == "" {
+		gen.roomProvider, gen.roomModel = provider, model
+	}
+}
+
+// localeInstructions asks for answers in the user's language
+func localeInstructions(locale string) string {
+	if locale == "" {
+		return ""
+	}
+	return fmt.Sprintf("The user's locale is %s. Answer in that language unless they write in or ask for another.\n\n", locale)
+}
+
+// Handler for /api/me/settings: the settings of the user whose token the request presents;
+// fetch with GET, replace with PUT
+func handleUserSettings(w http.ResponseWriter, r *http.Request) {
+	// Anyone could claim a bare ?user= name, so only a user token says whose these are
+	user := verifiedUser(r)
+	if user == "" {
+		http.Error(w, "Present your user token (X-User-Token)", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		settings, err := getUserSettings(user)
+		if err != nil {
+			http.Error(w, "Failed to fetch settings", http.StatusInternalServerError)
+			log.Println("Error fetching user settings:", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+
+	case http.MethodPut:
+		var settings UserSettings
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&settings); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := settings.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		settings.UpdatedAt = nil
+		var updatedAt time.Time
+		err := db.QueryRow(context.Background(), `
+			INSERT INTO user_settings (user_id, settings) VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET settings = EXCLUDED.settings, updated_at = NOW()
+			RETURNING updated_at`, user, &settings).Scan(&updatedAt)
+		if err != nil {
+			http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+			log.Println("Error saving user settings:", err)
+			return
+		}
+		settings.UpdatedAt = &updatedAt
+
+		// The user's other devices pick up the change straight away
+		for _, s := range userSessions(user) {
+			s.applySettings(&settings, nil)
+			if err := s.sendEvent("settings", settings); err != nil {
+				log.Println("Error sending settings event:", err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
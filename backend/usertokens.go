@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// User tokens turn a self-reported user name into an identity that can be trusted for
+// access control. The first client to join with a name (or to ask /api/me/token for it)
+// claims the name and gets its token; from then on only requests presenting the token,
+// as an "X-User-Token" header or, for WebSockets, a "user_token" query parameter, act as
+// that user for room roles and message limits. Only a hash of each token is stored.
+// Holders and admins can rotate a token, which stops the old one working.
+
+// UserTokenEvent hands a client the token for the name it just claimed
+type UserTokenEvent struct {
+	User  string `json:"user"`
+	Token string `json:"token"`
+}
+
+// initUserTokens creates the user tokens table
+func initUserTokens() {
+	createUserTokensTable()
+}
+
+// Create `user_tokens` table if it doesn't exist
+func createUserTokensTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS user_tokens (
+			user_id TEXT PRIMARY KEY,
+			token_hash TEXT NOT NULL UNIQUE,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			rotated_at TIMESTAMPTZ DEFAULT NOW()
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create user_tokens table:", err)
+	}
+	log.Println("✅ Table user_tokens is ready")
+}
+
+// newUserToken makes a random token and the hash that's stored for it
+func newUserToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashUserToken(token), nil
+}
+
+// hashUserToken is what's stored for a token
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// claimUserName gives an unclaimed name a token, returning "" if someone already has it
+func claimUserName(user string) (string, error) {
+	token, hash, err := newUserToken()
+	if err != nil {
+		return "", err
+	}
+	tag, err := db.Exec(context.Background(),
+		"INSERT INTO user_tokens (user_id, token_hash) VALUES ($1, $2) ON CONFLICT (user_id) DO NOTHING", user, hash)
+	if err != nil || tag.RowsAffected() == 0 {
+		return "", err
+	}
+	return token, nil
+}
+
+// userNameClaimed reports whether someone holds a name's token
+func userNameClaimed(user string) (bool, error) {
+	var claimed bool
+	err := db.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM user_tokens WHERE user_id = $1)", user).Scan(&claimed)
+	return claimed, err
+}
+
+// rotateUserToken replaces a claimed name's token
+func rotateUserToken(user string) (string, error) {
+	token, hash, err := newUserToken()
+	if err != nil {
+		return "", err
+	}
+	tag, err := db.Exec(context.Background(),
+		"UPDATE user_tokens SET token_hash = $2, rotated_at = NOW() WHERE user_id = $1", user, hash)
+	if err != nil {
+		return "", err
+	}
+	if tag.RowsAffected() == 0 {
+		return "", pgx.ErrNoRows
+	}
+	return token, nil
+}
+
+// tokenUser is the user a token belongs to, or "" for none
+func tokenUser(token string) string {
+	if token == "" {
+		return ""
+	}
+	var user string
+	err := db.QueryRow(context.Background(), "SELECT user_id FROM user_tokens WHERE token_hash = $1", hashUserToken(token)).Scan(&user)
+	if err != nil && err != pgx.ErrNoRows {
+		log.Println("Error checking user token:", err)
+	}
+	return user
+}
+
+// verifiedUser is the user a request proves it is with its user token, or "" if it
+// presents none or an invalid one
+func verifiedUser(r *http.Request) string {
+	token := r.Header.Get("X-User-Token")
+	if token == "" {
+		token = r.URL.Query().Get("user_token")
+	}
+	return tokenUser(token)
+}
+
+// sendUserToken claims a newly joined user's name for them, handing their client the
+// token; names that are already claimed need theirs
+func sendUserToken(s *Session) {
+	if s.user == "" || s.identity != "" {
+		return
+	}
+	token, err := claimUserName(s.user)
+	if err != nil {
+		log.Println("Error claiming user name:", err)
+		return
+	}
+	if token == "" {
+		return
+	}
+	s.identity = s.user
+	if err := s.sendEvent("user_token", UserTokenEvent{User: s.user, Token: token}); err != nil {
+		log.Println("Error sending user_token event:", err)
+	}
+}
+
+// Handler for /api/me/token?user=name: with POST, claim the name if nobody has, or rotate
+// its token when presenting the current one or the admin token. The new token is only
+// ever shown in this response.
+func handleUserToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := requestUser(r)
+	if user == "" {
+		http.Error(w, "A user name is required", http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusCreated
+	token, err := claimUserName(user)
+	if err == nil && token == "" {
+		if verifiedUser(r) != user && !isAdmin(r) {
+			http.Error(w, "This name is already claimed; present its user token to rotate it", http.StatusConflict)
+			return
+		}
+		status = http.StatusOK
+		token, err = rotateUserToken(user)
+	}
+	if err != nil {
+		http.Error(w, "Failed to issue user token", http.StatusInternalServerError)
+		log.Println("Error issuing user token:", err)
+		return
+	}
+	if status == http.StatusOK {
+		actor := "user:" + user
+		if isAdmin(r) {
+			actor = "admin"
+		}
+		recordAudit(actor, clientIP(r), "user.token_rotate", user, nil)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(UserTokenEvent{User: user, Token: token})
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Voice sessions stream raw 16-bit little-endian mono PCM from the microphone.
+// The server detects the end of each utterance, transcribes it, answers through
+// the normal message pipeline and streams the spoken answer back as binary frames.
+
+var (
+	voiceSampleRate      int           // Default PCM sample rate (clients may override via a config frame)
+	voiceVADThreshold    float64       // RMS level (0-32768) above which a chunk counts as speech
+	voiceSilence         time.Duration // Trailing silence that ends an utterance
+	voiceMinSpeech       time.Duration // Shorter bursts are treated as noise
+	voiceMaxUtterance    time.Duration // Utterances are cut off at this length
+	voicePartialInterval time.Duration // How often partial transcripts are produced while speaking
+	voiceAudioChunkBytes int           // Size of binary frames used to stream speech back
+)
+
+// voiceControl is a JSON text frame sent by the client during a voice session
+type voiceControl struct {
+	Type       string `json:"type"` // "config" or "end"
+	SampleRate int    `json:"sample_rate,omitempty"`
+}
+
+// SpeechStreamEvent brackets the binary audio frames of a spoken answer
+type SpeechStreamEvent struct {
+	Format      string `json:"format"`
+	ContentType string `json:"content_type"`
+	Bytes       int    `json:"bytes,omitempty"`
+}
+
+// utterance accumulates microphone audio and tracks voice activity
+type utterance struct {
+	sampleRate  int
+	pcm         bytes.Buffer
+	speaking    bool
+	speech      time.Duration // Audio classified as speech so far
+	silence     time.Duration // Trailing silence since the last speech chunk
+	lastPartial time.Duration // Buffer length when the last partial transcript was taken
+}
+
+// initVoice reads voice session settings
+func initVoice() {
+	voiceSampleRate = getEnvInt("VOICE_SAMPLE_RATE", 16000)
+	voiceVADThreshold = float64(getEnvInt("VOICE_VAD_THRESHOLD", 500))
+	voiceSilence = getEnvDuration("VOICE_SILENCE", 800*time.Millisecond)
+	voiceMinSpeech = getEnvDuration("VOICE_MIN_SPEECH", 300*time.Millisecond)
+	voiceMaxUtterance = getEnvDuration("VOICE_MAX_UTTERANCE", 30*time.Second)
+	voicePartialInterval = getEnvDuration("VOICE_PARTIAL_INTERVAL", 1500*time.Millisecond)
+	voiceAudioChunkBytes = getEnvInt("VOICE_AUDIO_CHUNK_BYTES", 32*1024)
+
+	if sttEnabled && ttsEnabled {
+		log.Printf("🗣️ Voice sessions available on /api/voice (%d Hz PCM)", voiceSampleRate)
+	}
+}
+
+// duration returns how much audio is buffered
+func (u *utterance) duration() time.Duration {
+	samples := u.pcm.Len() / 2
+	return time.Duration(samples) * time.Second / time.Duration(u.sampleRate)
+}
+
+// add appends a PCM chunk and updates voice activity; it reports whether the utterance ended
+func (u *utterance) add(chunk []byte) bool {
+	samples := len(chunk) / 2
+	if samples == 0 {
+		return false
+	}
+	chunkDuration := time.Duration(samples) * time.Second / time.Duration(u.sampleRate)
+
+	var sum float64
+	for i := 0; i < samples; i++ {
+		sample := float64(int16(binary.LittleEndian.Uint16(chunk[i*2:])))
+		sum += sample * sample
+	}
+	rms := math.Sqrt(sum / float64(samples))
+
+	if rms >= voiceVADThreshold {
+		u.speaking = true
+		u.speech += chunkDuration
+		u.silence = 0
+	} else if u.speaking {
+		u.silence += chunkDuration
+	}
+
+	// Don't keep leading silence around; it only slows transcription down
+	if !u.speaking {
+		u.pcm.Reset()
+		return false
+	}
+	u.pcm.Write(chunk[:samples*2])
+
+	if u.duration() >= voiceMaxUtterance {
+		return true
+	}
+	return u.silence >= voiceSilence
+}
+
+// reset clears the buffer for the next utterance
+func (u *utterance) reset() {
+	u.pcm.Reset()
+	u.speaking = false
+	u.speech = 0
+	u.silence = 0
+	u.lastPartial = 0
+}
+
+// pcmToWAV wraps raw 16-bit mono PCM in a WAV container for the Whisper server
+func pcmToWAV(pcm []byte, sampleRate int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))           // fmt chunk size
+	binary.Write(&buf, binary.LittleEndian, uint16(1))            // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(1))            // mono
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate))   // sample rate
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate*2)) // byte rate
+	binary.Write(&buf, binary.LittleEndian, uint16(2))            // block align
+	binary.Write(&buf, binary.LittleEndian, uint16(16))           // bits per sample
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
+
+// streamSpeech synthesizes an answer and streams the audio back as binary frames
+func streamSpeech(s *Session, markdown string) {
+	text := speechText(markdown)
+	if text == "" {
+		return
+	}
+
+	audio, err := synthesizeSpeech(text)
+	if err != nil {
+		log.Println("Error synthesizing speech:", err)
+		s.sendError("tts_failed", "Sorry, I couldn't speak that answer")
+		return
+	}
+
+	info := SpeechStreamEvent{Format: ttsFormat, ContentType: ttsContentTypes[ttsFormat], Bytes: len(audio)}
+	if err := s.sendEvent("tts_start", info); err != nil {
+		log.Println("Error sending tts_start event:", err)
+		return
+	}
+	for start := 0; start < len(audio); start += voiceAudioChunkBytes {
+		end := min(start+voiceAudioChunkBytes, len(audio))
+		if err := s.sendBinary(audio[start:end]); err != nil {
+			log.Println("Error streaming speech:", err)
+			return
+		}
+	}
+	if err := s.sendEvent("tts_end", info); err != nil {
+		log.Println("Error sending tts_end event:", err)
+	}
+}
+
+// Voice WebSocket handler
+func handleVoiceSocket(w http.ResponseWriter, r *http.Request) {
+	if !sttEnabled || !ttsEnabled {
+		http.Error(w, "Voice mode requires speech-to-text and text-to-speech", http.StatusServiceUnavailable)
+		return
+	}
+	room, err := requestRoom(r)
+	if err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	if !passedChallenge(w, r) || !requireSocketMember(w, r, room) {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Failed to upgrade voice WebSocket connection:", err)
+		return
+	}
+
+	// The session's writer owns the connection and closes it
+	s := newSession(conn, r, room)
+	defer s.close()
+	s.voice = true
+	if err := registerSession(s); err != nil {
+		s.sendError("too_many_connections", err.Error())
+		return
+	}
+	defer unregisterSession(s)
+	log.Println("🗣️ Voice session connected")
+
+	u := &utterance{sampleRate: voiceSampleRate}
+	var responding atomic.Bool // Half-duplex: audio is ignored while the AI answers
+	var partialInFlight atomic.Bool
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		messageType, msg, err := conn.ReadMessage()
+		if err != nil {
+			log.Println("Voice WebSocket read error:", err)
+			break
+		}
+
+		ended := false
+		if messageType == websocket.TextMessage {
+			var ctrl voiceControl
+			if err := json.Unmarshal(msg, &ctrl); err != nil {
+				s.sendError("invalid_control", "Voice sessions accept JSON control frames and binary PCM audio")
+				continue
+			}
+			switch ctrl.Type {
+			case "config":
+				if ctrl.SampleRate >= 8000 && ctrl.SampleRate <= 48000 {
+					u.sampleRate = ctrl.SampleRate
+					u.reset()
+				}
+				continue
+			case "end":
+				ended = u.speaking
+			default:
+				continue
+			}
+		} else {
+			if responding.Load() {
+				continue
+			}
+			ended = u.add(msg)
+		}
+
+		// Offer a partial transcript every so often while the user is still talking
+		if !ended && u.speaking && u.duration()-u.lastPartial >= voicePartialInterval && partialInFlight.CompareAndSwap(false, true) {
+			u.lastPartial = u.duration()
+			wav := pcmToWAV(bytes.Clone(u.pcm.Bytes()), u.sampleRate)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer partialInFlight.Store(false)
+				text, err := transcribe(context.Background(), wav)
+				if err == nil && text != "" {
+					s.sendEvent("partial_transcript", TranscriptEvent{Text: text})
+				}
+			}()
+		}
+
+		if !ended {
+			continue
+		}
+		if u.speech < voiceMinSpeech {
+			u.reset()
+			continue
+		}
+
+		wav := pcmToWAV(bytes.Clone(u.pcm.Bytes()), u.sampleRate)
+		u.reset()
+		responding.Store(true)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer responding.Store(false)
+			handleVoiceMessage(s, wav)
+		}()
+	}
+
+	log.Println("🗣️ Voice session closed")
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	webhookMaxSkew time.Duration // How far a delivery's timestamp may be from now
+	webhookMaxBody int64         // Largest delivery body accepted
+)
+
+// RoomWebhook lets external systems post into a room, authenticated by a secret that signs each delivery
+type RoomWebhook struct {
+	RoomID         int        `json:"room_id"`
+	Name           string     `json:"name"` // Shown as the poster of delivered messages
+	URL            string     `json:"url"`
+	Secret         string     `json:"secret,omitempty"` // Only returned when the webhook is created or its secret rotated
+	Triage         bool       `json:"triage"`           // Have the AI summarize each delivery as an alert; see triage.go
+	CreatedAt      time.Time  `json:"created_at"`
+	LastDeliveryAt *time.Time `json:"last_delivery_at,omitempty"`
+}
+
+// WebhookDelivery is the body of a delivery; a text/plain body is taken as Text
+type WebhookDelivery struct {
+	Text string `json:"text"`
+	ID   string `json:"id,omitempty"` // Delivery id; a repeated id is stored once
+}
+
+// initWebhooks reads the delivery limits and creates the webhooks table
+func initWebhooks() {
+	webhookMaxSkew = getEnvDuration("WEBHOOK_MAX_SKEW", 5*time.Minute)
+	webhookMaxBody = int64(getEnvInt("WEBHOOK_MAX_BODY_BYTES", 64<<10))
+	createRoomWebhooksTable()
+}
+
+// Create `room_webhooks` table if it doesn't exist
+func createRoomWebhooksTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS room_webhooks (
+			room_id INTEGER PRIMARY KEY REFERENCES rooms(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			last_delivery_at TIMESTAMPTZ
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create room_webhooks table:", err)
+	}
+	log.Println("✅ Table room_webhooks is ready")
+}
+
+// webhookURL is where a room's deliveries are posted
+func webhookURL(roomID int) string {
+	return fmt.Sprintf("/api/webhooks/rooms/%d", roomID)
+}
+
+// webhookSignature signs a delivery: HMAC-SHA256 over "<timestamp>.<body>", hex encoded
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// loadRoomWebhook returns a room's webhook, secret included, or pgx.ErrNoRows
+func loadRoomWebhook(roomID int) (*RoomWebhook, error) {
+	hook := RoomWebhook{RoomID: roomID, URL: webhookURL(roomID)}
+	err := db.QueryRow(context.Background(),
+		"SELECT name, secret, triage, created_at, last_delivery_at FROM room_webhooks WHERE room_id = $1", roomID).
+		Scan(&hook.Name, &hook.Secret, &hook.Triage, &hook.CreatedAt, &hook.LastDeliveryAt)
+	if err != nil {
+		return nil, err
+	}
+	return &hook, nil
+}
+
+// Handler for /api/rooms/{id}/webhook: show with GET, create or rotate the secret with
+// POST ({"name": "CI", "triage": true}), remove with DELETE; all take the room's owner role
+func handleRoomWebhook(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok || !requireRoomRole(w, r, room, roleOwner, false) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		hook, err := loadRoomWebhook(room.ID)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Room has no webhook", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch webhook", http.StatusInternalServerError)
+			log.Println("Error fetching webhook:", err)
+			return
+		}
+		hook.Secret = ""
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hook)
+	case http.MethodPost:
+		createRoomWebhook(w, r, room)
+	case http.MethodDelete:
+		tag, err := db.Exec(context.Background(), "DELETE FROM room_webhooks WHERE room_id = $1", room.ID)
+		if err != nil {
+			http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+			log.Println("Error deleting webhook:", err)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			http.Error(w, "Room has no webhook", http.StatusNotFound)
+			return
+		}
+		recordAudit(roomActor(r), clientIP(r), "webhook.delete", strconv.Itoa(room.ID), nil)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createRoomWebhook sets up a room's webhook with a new secret, replacing any earlier one
+func createRoomWebhook(w http.ResponseWriter, r *http.Request, room *Room) {
+	var req struct {
+		Name   string `json:"name"`
+		Triage bool   `json:"triage"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		req.Name = "webhook"
+	}
+	if len(req.Name) > 64 {
+		http.Error(w, "Webhook names are limited to 64 characters", http.StatusBadRequest)
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		log.Println("Error generating webhook secret:", err)
+		return
+	}
+	hook := RoomWebhook{RoomID: room.ID, Name: req.Name, URL: webhookURL(room.ID), Secret: hex.EncodeToString(secret), Triage: req.Triage}
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO room_webhooks (room_id, name, secret, triage) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (room_id) DO UPDATE SET name = EXCLUDED.name, secret = EXCLUDED.secret, triage = EXCLUDED.triage, created_at = NOW()
+		RETURNING created_at, last_delivery_at`, room.ID, hook.Name, hook.Secret, hook.Triage).Scan(&hook.CreatedAt, &hook.LastDeliveryAt)
+	if err != nil {
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		log.Println("Error creating webhook:", err)
+		return
+	}
+	recordAudit(roomActor(r), clientIP(r), "webhook.create", strconv.Itoa(room.ID), map[string]interface{}{"name": hook.Name, "triage": hook.Triage})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// Handler for the public /api/webhooks/rooms/{id} delivery endpoint. Deliveries carry
+// X-Cubbychat-Timestamp (unix seconds) and X-Cubbychat-Signature (see webhookSignature).
+func deliverRoomWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	roomID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid room id", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBody))
+	if err != nil {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Unknown rooms and bad signatures look the same so room ids can't be probed
+	hook, err := loadRoomWebhook(roomID)
+	if err != nil && err != pgx.ErrNoRows {
+		http.Error(w, "Failed to fetch webhook", http.StatusInternalServerError)
+		log.Println("Error fetching webhook:", err)
+		return
+	}
+	timestamp := r.Header.Get("X-Cubbychat-Timestamp")
+	if hook == nil || !hmac.Equal([]byte(r.Header.Get("X-Cubbychat-Signature")), []byte(webhookSignature(hook.Secret, timestamp, body))) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	// Old deliveries are refused so a captured one can't be replayed later
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sent, 0)).Abs() > webhookMaxSkew {
+		http.Error(w, "Timestamp is missing or too far from the server's clock", http.StatusUnauthorized)
+		return
+	}
+
+	var delivery WebhookDelivery
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(body, &delivery); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	} else {
+		delivery.Text = string(body)
+		delivery.ID = r.Header.Get("X-Cubbychat-Delivery")
+	}
+	delivery.Text = strings.TrimSpace(strings.ToValidUTF8(delivery.Text, ""))
+	if delivery.Text == "" {
+		http.Error(w, "Text is required", http.StatusBadRequest)
+		return
+	}
+	if messageTooLarge(delivery.Text) {
+		http.Error(w, fmt.Sprintf("Messages are limited to %d characters", promptMaxChars), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(delivery.ID) > clientIDMaxLength {
+		delivery.ID = delivery.ID[:clientIDMaxLength]
+	}
+
+	room, err := getRoom(roomID)
+	if err != nil {
+		http.Error(w, "Failed to fetch room", http.StatusInternalServerError)
+		log.Println("Error fetching room:", err)
+		return
+	}
+	if room.State == roomArchived {
+		http.Error(w, "This room is archived; its history is read-only", http.StatusConflict)
+		return
+	}
+
+	ack, duplicate := saveUserMessage(roomID, "webhook:"+hook.Name, delivery.Text, delivery.ID, nil)
+	if ack.MessageID == 0 && !ack.Incognito {
+		http.Error(w, "Failed to save message", http.StatusInternalServerError)
+		return
+	}
+	if !duplicate {
+		publishRoomEvent(roomID, nil, "message", ChatMessage{ID: ack.MessageID, Sender: "User", Message: delivery.Text, Timestamp: ack.Timestamp, Incognito: ack.Incognito})
+		if _, err := db.Exec(context.Background(), "UPDATE room_webhooks SET last_delivery_at = NOW() WHERE room_id = $1", roomID); err != nil {
+			log.Println("Error recording webhook delivery:", err)
+		}
+		addCounter("cubbychat_webhook_deliveries_total", "Messages posted into rooms by webhooks", 1)
+		if hook.Triage {
+			go triageAlert(roomID, hook.Name, delivery.Text)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !duplicate {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(ack)
+}
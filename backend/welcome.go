@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultWelcome greets newcomers when neither the room, WELCOME_MESSAGE nor the branding say otherwise
+const defaultWelcome = "Welcome to {{room}}, {{user}}! I'm Cubby 🧸. Ask me anything to get started."
+
+// maxWelcomeChars bounds a room's welcome message and its prompt
+const maxWelcomeChars = 2000
+
+var (
+	welcomeEnabled bool          // Whether newcomers to a room get a welcome message
+	welcomeMessage string        // Template for the welcome text ({{user}}, {{room}})
+	welcomePrompt  string        // When set, the model writes the welcome from this prompt template
+	welcomeTimeout time.Duration // How long the model may take to write one
+)
+
+// RoomWelcome overrides the welcome message for one room
+type RoomWelcome struct {
+	Message  string `json:"message,omitempty"`
+	Prompt   string `json:"prompt,omitempty"` // Has the model write the welcome; beats Message
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// initWelcome reads the welcome settings and creates the table of who has visited which room
+func initWelcome() {
+	welcomeEnabled = getEnvBool("WELCOME_ENABLED", false)
+	if !welcomeEnabled {
+		return
+	}
+	welcomeMessage = getEnv("WELCOME_MESSAGE", "")
+	welcomePrompt = getEnv("WELCOME_PROMPT", "")
+	welcomeTimeout = getEnvDuration("WELCOME_TIMEOUT", 20*time.Second)
+	createRoomVisitorsTable()
+	log.Println("👋 Welcome messages enabled")
+}
+
+// Create `room_visitors` table if it doesn't exist
+func createRoomVisitorsTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS room_visitors (
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			visitor TEXT NOT NULL,
+			first_seen TIMESTAMPTZ DEFAULT NOW(),
+			PRIMARY KEY (room_id, visitor)
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create room_visitors table:", err)
+	}
+	log.Println("✅ Table room_visitors is ready")
+}
+
+// firstVisit records a visit and reports whether it was the client's first to the room
+func firstVisit(s *Session) (bool, error) {
+	tag, err := db.Exec(context.Background(),
+		"INSERT INTO room_visitors (room_id, visitor) VALUES ($1, $2) ON CONFLICT DO NOTHING", s.room, s.clientKey())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// composeWelcome writes the welcome for a room: the room's own, else the server's. A prompt
+// is sent to the model; if that fails the static message is used instead.
+func composeWelcome(room *Room, user string) string {
+	message, prompt := welcomeMessage, welcomePrompt
+	if message == "" {
+		message = currentBranding().WelcomeMessage
+	}
+	if message == "" {
+		message = defaultWelcome
+	}
+	if override := room.Metadata.Welcome; override != nil {
+		if override.Message != "" {
+			message, prompt = override.Message, ""
+		}
+		if override.Prompt != "" {
+			prompt = override.Prompt
+		}
+	}
+
+	name := user
+	if name == "" {
+		name = "there"
+	}
+	values := map[string]string{"user": name, "room": room.Name}
+	if prompt != "" {
+		rendered, err := renderTemplate(prompt, values)
+		if err == nil {
+			var text string
+			text, err = generateOnce(ollamaModel, rendered, "", welcomeTimeout)
+			if text = strings.TrimSpace(text); err == nil && text != "" {
+				return text
+			}
+		}
+		log.Println("Error generating welcome message, using the static one:", err)
+	}
+	text, err := renderTemplate(message, values)
+	if err != nil {
+		return message
+	}
+	return text
+}
+
+// welcomeNewcomer posts a welcome the first time a user (or, for guests, an address) joins a room
+func welcomeNewcomer(s *Session) {
+	if !welcomeEnabled {
+		return
+	}
+	room, err := getRoom(s.room)
+	if err != nil {
+		log.Println("Error fetching room for welcome message:", err)
+		return
+	}
+	if room.Metadata.Welcome != nil && room.Metadata.Welcome.Disabled {
+		return
+	}
+	first, err := firstVisit(s)
+	if err != nil {
+		log.Println("Error recording room visit:", err)
+		return
+	}
+	if !first {
+		return
+	}
+
+	text := composeWelcome(room, s.displayName())
+	id := 0
+	if !s.incognito {
+		id = saveMessage(s.room, "AI", text)
+	}
+	if err := s.sendEvent("message", ChatMessage{ID: id, Sender: "AI", Message: text, Timestamp: time.Now(), Incognito: s.incognito}); err != nil {
+		log.Println("Error sending welcome message:", err)
+	}
+}
+
+// Handler for /api/rooms/{id}/welcome: set the room's welcome with PUT, go back to the server's
+// with DELETE. Changes to a room with an owner take its moderator role.
+func handleRoomWelcome(w http.ResponseWriter, r *http.Request) {
+	room, ok := pathRoom(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireRoomRole(w, r, room, roleModerator, true) {
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodPut:
+		var req RoomWelcome
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len([]rune(req.Message)) > maxWelcomeChars || len([]rune(req.Prompt)) > maxWelcomeChars {
+			http.Error(w, fmt.Sprintf("Welcome messages and prompts are limited to %d characters", maxWelcomeChars), http.StatusBadRequest)
+			return
+		}
+		room.Metadata.Welcome = &req
+		_, err = db.Exec(context.Background(),
+			"UPDATE rooms SET metadata = metadata || jsonb_build_object('welcome', $2::jsonb) WHERE id = $1", room.ID, req)
+	case http.MethodDelete:
+		room.Metadata.Welcome = nil
+		_, err = db.Exec(context.Background(), "UPDATE rooms SET metadata = metadata - 'welcome' WHERE id = $1", room.ID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to update welcome message", http.StatusInternalServerError)
+		log.Println("Error updating welcome message:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
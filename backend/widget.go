@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
+)
+
+// widgetTokenPrefix marks widget tokens so they can't be mistaken for other credentials
+const widgetTokenPrefix = "wgt_"
+
+// WidgetToken lets a third-party website embed the chat for one room
+type WidgetToken struct {
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	RoomID         int        `json:"room_id"`
+	AllowedOrigins []string   `json:"allowed_origins"` // Empty allows any origin
+	RateLimit      int        `json:"rate_limit"`      // Messages per minute per visitor address
+	Token          string     `json:"token,omitempty"` // Only returned when the widget is created
+	CreatedAt      time.Time  `json:"created_at"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Widget messages sent per widget and visitor address in the current minute
+var (
+	widgetRateMu     sync.Mutex
+	widgetRateWindow time.Time
+	widgetRateCounts = make(map[string]int)
+)
+
+// initWidgets creates the widget tokens table
+func initWidgets() {
+	createWidgetTokensTable()
+}
+
+// Create `widget_tokens` table if it doesn't exist
+func createWidgetTokensTable() {
+	query := `
+		CREATE TABLE IF NOT EXISTS widget_tokens (
+			id SERIAL PRIMARY KEY,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			allowed_origins TEXT[] NOT NULL DEFAULT '{}',
+			rate_limit INTEGER NOT NULL DEFAULT 10,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			revoked_at TIMESTAMPTZ
+		);
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, query); err != nil {
+		log.Fatal("❌ Failed to create widget_tokens table:", err)
+	}
+	log.Println("✅ Table widget_tokens is ready")
+}
+
+// hashWidgetToken is how tokens are stored; the token itself is only shown once
+func hashWidgetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// originAllowed reports whether a browser origin may use the widget
+func (wt *WidgetToken) originAllowed(origin string) bool {
+	if len(wt.AllowedOrigins) == 0 {
+		return true
+	}
+	for _, allowed := range wt.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// widgetRateAllowed counts a message against the widget's per-visitor limit
+func widgetRateAllowed(wt *WidgetToken, ip string) bool {
+	widgetRateMu.Lock()
+	defer widgetRateMu.Unlock()
+	if minute := time.Now().Truncate(time.Minute); !minute.Equal(widgetRateWindow) {
+		widgetRateWindow = minute
+		clear(widgetRateCounts)
+	}
+	key := fmt.Sprintf("%d/%s", wt.ID, ip)
+	if widgetRateCounts[key] >= wt.RateLimit {
+		return false
+	}
+	widgetRateCounts[key]++
+	return true
+}
+
+// widgetOnly wraps a widget endpoint: it resolves the token ("Authorization: Bearer wgt_..."
+// or ?token=), enforces the widget's origins and answers CORS for them
+func widgetOnly(next func(w http.ResponseWriter, r *http.Request, wt *WidgetToken)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("token")
+		}
+		if !strings.HasPrefix(token, widgetTokenPrefix) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var wt WidgetToken
+		err := db.QueryRow(context.Background(), `
+			SELECT id, name, room_id, allowed_origins, rate_limit, created_at FROM widget_tokens
+			WHERE token_hash = $1 AND revoked_at IS NULL`, hashWidgetToken(token)).
+			Scan(&wt.ID, &wt.Name, &wt.RoomID, &wt.AllowedOrigins, &wt.RateLimit, &wt.CreatedAt)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to check widget token", http.StatusInternalServerError)
+			log.Println("Error fetching widget token:", err)
+			return
+		}
+		if origin != "" && !wt.originAllowed(origin) {
+			http.Error(w, "Origin not allowed for this widget", http.StatusForbidden)
+			return
+		}
+		next(w, r, &wt)
+	}
+}
+
+// Handler for /api/widget/history: the widget room's recent messages
+func widgetHistory(w http.ResponseWriter, r *http.Request, wt *WidgetToken) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, sender, message, timestamp FROM (
+			SELECT id, sender, `+messageText("chat_history")+` AS message, timestamp FROM chat_history
+			WHERE room_id = $1 ORDER BY timestamp DESC LIMIT 50
+		) recent ORDER BY timestamp ASC`, wt.RoomID)
+	if err != nil {
+		http.Error(w, "Failed to fetch chat history", http.StatusInternalServerError)
+		log.Println("Error fetching widget history:", err)
+		return
+	}
+	defer rows.Close()
+
+	history := []ChatMessage{}
+	for rows.Next() {
+		var msg ChatMessage
+		if err := rows.Scan(&msg.ID, &msg.Sender, &msg.Message, &msg.Timestamp); err != nil {
+			http.Error(w, "Error processing chat history", http.StatusInternalServerError)
+			log.Println("Error scanning widget history:", err)
+			return
+		}
+		history = append(history, msg)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+// Handler for POST /api/widget/messages ({"text", "client_id"}): the answer streams back as
+// server-sent events, "token" for each token and then the same events a WebSocket client gets
+func widgetMessage(w http.ResponseWriter, r *http.Request, wt *WidgetToken) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Text     string `json:"text"`
+		ClientID string `json:"client_id"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil || strings.TrimSpace(req.Text) == "" {
+		http.Error(w, "A message text is required", http.StatusBadRequest)
+		return
+	}
+	ip := clientIP(r)
+	if !widgetRateAllowed(wt, ip) {
+		http.Error(w, "Too many messages, slow down", http.StatusTooManyRequests)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	// The session writes straight to the response; it's closed before the handler
+	// returns so late events (follow-ups, unfurls) are dropped instead of written
+	s := &Session{room: wt.RoomID, ip: ip}
+	s.sink = func(frame outboundFrame) error {
+		if frame.messageType != websocket.TextMessage {
+			return nil // Audio is for voice sessions only
+		}
+		event := "text"
+		data, _ := json.Marshal(string(frame.data))
+		var wsEvent WSEvent
+		if frame.token {
+			event = "token"
+		} else if json.Unmarshal(frame.data, &wsEvent) == nil && wsEvent.Type != "" {
+			event = wsEvent.Type
+			data, _ = json.Marshal(wsEvent.Data)
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	defer s.close()
+
+	handleUserMessage(s, req.Text, req.ClientID)
+	s.sendEvent("end", struct{}{})
+}
+
+// Handler for /api/admin/widgets: create with POST ({"name", "room_id", "allowed_origins", "rate_limit"}), list with GET
+func handleWidgets(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		createWidget(w, r)
+		return
+	}
+
+	rows, err := db.Query(context.Background(),
+		"SELECT id, name, room_id, allowed_origins, rate_limit, created_at, revoked_at FROM widget_tokens ORDER BY id")
+	if err != nil {
+		http.Error(w, "Failed to fetch widgets", http.StatusInternalServerError)
+		log.Println("Error fetching widgets:", err)
+		return
+	}
+	defer rows.Close()
+
+	widgets := []WidgetToken{}
+	for rows.Next() {
+		var wt WidgetToken
+		if err := rows.Scan(&wt.ID, &wt.Name, &wt.RoomID, &wt.AllowedOrigins, &wt.RateLimit, &wt.CreatedAt, &wt.RevokedAt); err != nil {
+			http.Error(w, "Error processing widgets", http.StatusInternalServerError)
+			log.Println("Error scanning widgets:", err)
+			return
+		}
+		widgets = append(widgets, wt)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(widgets)
+}
+
+// createWidget issues a widget token; it is shown only in this response
+func createWidget(w http.ResponseWriter, r *http.Request) {
+	var req WidgetToken
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "A widget name is required", http.StatusBadRequest)
+		return
+	}
+	if _, err := getRoom(req.RoomID); err != nil {
+		http.Error(w, "A valid room_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.RateLimit <= 0 {
+		req.RateLimit = 10
+	}
+	if req.AllowedOrigins == nil {
+		req.AllowedOrigins = []string{}
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		http.Error(w, "Failed to create widget", http.StatusInternalServerError)
+		return
+	}
+	req.Token = widgetTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO widget_tokens (name, token_hash, room_id, allowed_origins, rate_limit) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`, strings.TrimSpace(req.Name), hashWidgetToken(req.Token), req.RoomID, req.AllowedOrigins, req.RateLimit).
+		Scan(&req.ID, &req.CreatedAt)
+	if err != nil {
+		http.Error(w, "Failed to create widget", http.StatusInternalServerError)
+		log.Println("Error creating widget:", err)
+		return
+	}
+	recordAudit("admin", clientIP(r), "widget.create", strconv.Itoa(req.ID), map[string]interface{}{"name": req.Name, "room_id": req.RoomID})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(req)
+}
+
+// Handler to revoke a widget token
+func revokeWidget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid widget id", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := db.Exec(context.Background(),
+		"UPDATE widget_tokens SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		http.Error(w, "Failed to revoke widget", http.StatusInternalServerError)
+		log.Println("Error revoking widget:", err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Widget not found", http.StatusNotFound)
+		return
+	}
+	recordAudit("admin", clientIP(r), "widget.revoke", strconv.Itoa(id), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
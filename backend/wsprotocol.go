@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket subprotocols. Clients name the envelope protocol they speak in the
+// Sec-WebSocket-Protocol header; those that name none (or none we know) get v1.
+const (
+	wsProtocolV1 = "cubbychat.v1" // Tokens and short replies as raw text frames, events as JSON; plain text frames are messages
+	wsProtocolV2 = "cubbychat.v2" // Every frame in both directions is a JSON envelope with a "type"
+
+	// Every frame in both directions is a binary protobuf Frame; see cubbychat.proto and protobuf.go
+	wsProtocolProto = "cubbychat.v2+proto"
+)
+
+// wsProtocols are the subprotocols the server speaks, preferred first. Clients that
+// offer the protobuf framing can decode it, so it wins over JSON.
+var wsProtocols = []string{wsProtocolProto, wsProtocolV2, wsProtocolV1}
+
+// HelloEvent opens a v2 session with the negotiated protocol and the server's version
+type HelloEvent struct {
+	Protocol string `json:"protocol"`
+	Version  string `json:"version"`
+}
+
+// negotiatedProtocol is the subprotocol chosen during the upgrade, v1 when there was none
+func negotiatedProtocol(conn *websocket.Conn) string {
+	if protocol := conn.Subprotocol(); protocol != "" {
+		return protocol
+	}
+	return wsProtocolV1
+}
+
+// encodeFrame is a frame as written to the session's client: v2 clients get raw text
+// wrapped in an envelope, such as {"type":"token","data":"Hel"}, and protobuf clients
+// get every frame as a binary Frame message
+func (s *Session) encodeFrame(frame outboundFrame) (int, []byte) {
+	if s.protocol == wsProtocolProto {
+		return websocket.BinaryMessage, protoFrameData(frame)
+	}
+	if frame.envelope == "" || s.protocol != wsProtocolV2 {
+		return frame.messageType, frame.data
+	}
+	payload, err := json.Marshal(WSEvent{Type: frame.envelope, Data: string(frame.data)})
+	if err != nil {
+		log.Println("Error encoding envelope:", err)
+		return frame.messageType, frame.data
+	}
+	return frame.messageType, payload
+}
+
+// sendHello greets v2 and protobuf clients and counts connections by protocol, so it's clear when v1 can go
+func sendHello(s *Session) {
+	addCounter("cubbychat_ws_protocol_connections_total", "WebSocket connections by negotiated subprotocol", 1, "protocol", s.protocol)
+	if s.protocol == wsProtocolV1 {
+		return
+	}
+	if err := s.sendEvent("hello", HelloEvent{Protocol: s.protocol, Version: Version}); err != nil {
+		log.Println("Error sending hello event:", err)
+	}
+}
@@ -1,18 +1,56 @@
 import React, { useState, useEffect, useRef } from "react";
-import { Button, TextInput, ScrollArea, Paper, Text } from "@mantine/core";
+import { Alert, Button, TextInput, ScrollArea, Paper, Text, Group } from "@mantine/core";
 import ReactMarkdown from "react-markdown";
-import ModelStatus from "../ModelStatus/ModelStatus";
+import ModelStatus, { ModelStatusData } from "../ModelStatus/ModelStatus";
+import { obtainPass } from "./challenge";
 
 // Use relative URLs - Vite proxy handles routing to backend in dev, nginx in production
-const WS_URL = `${window.location.protocol === "https:" ? "wss:" : "ws:"}//${window.location.host}/api/ws`;
+const WS_URL = `${window.location.protocol === "https:" ? "wss:" : "ws:"}//${window.location.host}/api/ws?acks=1`;
 const HISTORY_URL = "/api/history";
 const CONFIG_URL = "/api/config";
+const SETTINGS_URL = "/api/rooms/1/settings"; // The chat always uses the default room
+const EXPORT_PDF_URL = "/api/rooms/1/export?format=pdf";
+const feedbackURL = (messageId: number) => `/api/messages/${messageId}/feedback`;
+
+type Branding = {
+  logo_url?: string;
+  primary_color?: string;
+  accent_color?: string;
+  welcome_message?: string;
+  footer_links?: { label: string; url: string }[];
+};
+
+type Announcement = { id: number; message: string; severity: "info" | "warning" | "critical" };
+
+const announcementColors = { info: "blue", warning: "yellow", critical: "red" };
+
+const DEFAULT_WELCOME = "Hello! I'm Cubby 🧸, your friendly chat assistant. How can I help you today?";
+
+// Parse a structured {"type": ..., "data": ...} event frame, or return null for plain tokens
+const parseEvent = (data: string): { type: string; data?: any } | null => {
+  if (!data.startsWith('{"type":')) return null;
+  try {
+    const parsed = JSON.parse(data);
+    return typeof parsed.type === "string" ? parsed : null;
+  } catch {
+    return null;
+  }
+};
 
 const Chat: React.FC = () => {
-  const [messages, setMessages] = useState<{ sender: string; text: string }[]>([
-    { sender: "AI", text: "Hello! I'm Cubby 🧸, your friendly chat assistant. How can I help you today?" }
+  const [messages, setMessages] = useState<{ sender: string; text: string; id?: number; grounding?: string }[]>([
+    { sender: "AI", text: DEFAULT_WELCOME }
   ]);
   const [input, setInput] = useState("");
+  const [followUps, setFollowUps] = useState<string[]>([]);
+  const [modelStatus, setModelStatus] = useState<ModelStatusData | null>(null);
+  const [notice, setNotice] = useState<string | null>(null);
+  const [announcements, setAnnouncements] = useState<Announcement[]>([]);
+  const [roomSettings, setRoomSettings] = useState<Record<string, string>>({});
+  const [incognito, setIncognito] = useState<{ room: boolean } | null>(null);
+  const [presence, setPresence] = useState<{ online_count: number; typing?: string[]; typing_count: number } | null>(null);
+  const lastTypingSent = useRef(0);
+  const [pass, setPass] = useState<string | null | undefined>(undefined); // Undefined until the challenge check is done
   const ws = useRef<WebSocket | null>(null);
   const isConnecting = useRef(false);
   const [title, setTitle] = useState("🧸 Cubby Chat"); // Default title with mascot
@@ -21,11 +59,15 @@ const Chat: React.FC = () => {
     version: string;
     region: string;
     role: string;
+    features: Record<string, boolean>;
+    branding: Branding;
   }>({
     model: "unknown",
     version: "unknown",
     region: "unknown",
-    role: "unknown"
+    role: "unknown",
+    features: {},
+    branding: {}
   });
 
   // Ref for scrolling to bottom
@@ -41,26 +83,125 @@ const Chat: React.FC = () => {
           model: data.model || "unknown",
           version: data.version || "unknown",
           region: data.region || "unknown",
-          role: data.role || "unknown"
+          role: data.role || "unknown",
+          features: data.features || {},
+          branding: data.branding || {}
         });
+        // Swap in a branded greeting if the conversation hasn't started yet
+        if (data.branding?.welcome_message) {
+          setMessages((prev) =>
+            prev.length === 1 && prev[0].text === DEFAULT_WELCOME
+              ? [{ sender: "AI", text: data.branding.welcome_message }]
+              : prev
+          );
+        }
       })
       .catch((err) => console.error("❌ Failed to fetch config:", err));
+    fetch(SETTINGS_URL)
+      .then((res) => (res.ok ? res.json() : {}))
+      .then(setRoomSettings)
+      .catch((err) => console.error("❌ Failed to fetch room settings:", err));
+  }, []);
+
+  // Public deployments may ask guests to solve a challenge before connecting
+  useEffect(() => {
+    obtainPass().then(setPass);
   }, []);
 
   useEffect(() => {
     // Prevent duplicate connections in React Strict Mode
-    if (isConnecting.current) return;
+    if (pass === undefined || isConnecting.current) return;
     isConnecting.current = true;
 
-    console.log("Connecting to WebSocket:", WS_URL);
-    ws.current = new WebSocket(WS_URL);
+    const url = pass ? `${WS_URL}&pass=${encodeURIComponent(pass)}` : WS_URL;
+    console.log("Connecting to WebSocket:", url);
+    ws.current = new WebSocket(url);
 
     ws.current.onopen = () => {
       console.log("✅ WebSocket connection opened");
     };
 
     ws.current.onmessage = (event) => {
+      // Structured events arrive as JSON frames; everything else is a token
+      const wsEvent = parseEvent(event.data);
+      if (wsEvent) {
+        console.log("📨 Event received:", wsEvent.type);
+        if (wsEvent.type === "retrying") {
+          setNotice(`🔄 Retrying… (attempt ${wsEvent.data?.attempt} of ${wsEvent.data?.max_attempts})`);
+          return;
+        }
+        if (wsEvent.type === "queue_position" && wsEvent.data?.position > 0) {
+          setNotice(`⏳ You're #${wsEvent.data.position} in line (about ${wsEvent.data.eta_seconds}s)`);
+          return;
+        }
+        if (wsEvent.type === "progress") {
+          // Keep a slow first token from looking like a frozen chat
+          const seconds = Math.round((wsEvent.data?.elapsed_ms || 0) / 1000);
+          if (wsEvent.data?.stage === "queued") {
+            setNotice(`⏳ Waiting for a free slot… (${seconds}s)`);
+          } else if (wsEvent.data?.model_phase === "loading") {
+            setNotice(`🧠 The model is still loading… (${seconds}s)`);
+          } else {
+            setNotice(`🤔 Thinking… (${seconds}s)`);
+          }
+          return;
+        }
+        if (wsEvent.type === "announcements") {
+          setAnnouncements(wsEvent.data || []);
+          return;
+        }
+        if (wsEvent.type === "presence") {
+          setPresence(wsEvent.data);
+          return;
+        }
+        if (wsEvent.type === "incognito") {
+          setIncognito(wsEvent.data || { room: false });
+          return;
+        }
+        if (wsEvent.type === "room_settings") {
+          setRoomSettings(wsEvent.data?.settings || {});
+          return;
+        }
+        setNotice(null);
+        if (wsEvent.type === "follow_ups") {
+          setFollowUps(wsEvent.data?.suggestions || []);
+        } else if (wsEvent.type === "model_status") {
+          setModelStatus(wsEvent.data);
+        } else if (wsEvent.type === "error" && wsEvent.data?.message) {
+          // Show the problem in place of the pending AI reply
+          setMessages((prevMessages) => {
+            const lastMessage = prevMessages[prevMessages.length - 1];
+            const errorMessage = { sender: "AI", text: `⚠️ ${wsEvent.data.message}` };
+            if (lastMessage?.sender === "AI" && lastMessage.text === "") {
+              return [...prevMessages.slice(0, -1), errorMessage];
+            }
+            return [...prevMessages, errorMessage];
+          });
+        } else if (wsEvent.type === "message" && wsEvent.data?.message !== undefined) {
+          // Someone else in the room (or the AI answering them) posted a message
+          setMessages((prevMessages) => [...prevMessages, { sender: wsEvent.data.sender, text: wsEvent.data.message }]);
+        } else if (wsEvent.type === "ai_done" && wsEvent.data?.message !== undefined) {
+          // Confirm delivery so the server stops resending
+          if (wsEvent.data.message_id) {
+            ws.current?.send(JSON.stringify({ type: "ack", message_id: wsEvent.data.message_id }));
+          }
+          // Replace the streamed text with the sanitized, stored version
+          setMessages((prevMessages) => {
+            const lastMessage = prevMessages[prevMessages.length - 1];
+            if (lastMessage?.sender !== "AI") return prevMessages;
+            return [...prevMessages.slice(0, -1), {
+              ...lastMessage,
+              text: wsEvent.data.message,
+              id: wsEvent.data.message_id,
+              grounding: wsEvent.data.metadata?.grounding?.level
+            }];
+          });
+        }
+        return;
+      }
+
       console.log("📩 Streaming token received:", event.data);
+      setNotice(null);
 
       setMessages((prevMessages) => {
         let lastMessage = prevMessages[prevMessages.length - 1];
@@ -88,24 +229,50 @@ const Chat: React.FC = () => {
       ws.current?.close();
       isConnecting.current = false;
     };
-  }, []);
+  }, [pass]);
 
-  const sendMessage = () => {
-    if (input.trim() && ws.current) {
-      console.log("📤 Sending message:", input);
-      setMessages((prev) => [...prev, { sender: "You", text: input }, { sender: "AI", text: "" }]);
-      ws.current.send(input);
+  const sendMessage = (text: string = input) => {
+    if (text.trim() && ws.current) {
+      console.log("📤 Sending message:", text);
+      setMessages((prev) => [...prev, { sender: "You", text }, { sender: "AI", text: "" }]);
+      // A client id lets the server ignore the message if a flaky connection makes us resend it
+      const clientId = window.crypto?.randomUUID?.() ?? `${Date.now().toString(36)}-${Math.random().toString(36).slice(2)}`;
+      ws.current.send(JSON.stringify({ type: "message", client_id: clientId, text }));
       setInput("");
+      setFollowUps([]);
+      lastTypingSent.current = 0;
+    }
+  };
+
+  // Tell the room we're typing, at most every couple of seconds; the server expires it
+  const handleInputChange = (value: string) => {
+    setInput(value);
+    const now = Date.now();
+    if (value && ws.current?.readyState === WebSocket.OPEN && now - lastTypingSent.current > 2000) {
+      ws.current.send(JSON.stringify({ type: "typing" }));
+      lastTypingSent.current = now;
     }
   };
 
+  const typingNames = presence?.typing ?? [];
+  const typingText = typingNames.length > 0
+    ? `✍️ ${typingNames.join(", ")} ${typingNames.length === 1 ? "is" : "are"} typing…`
+    : presence && presence.typing_count > 0
+      ? `✍️ ${presence.typing_count} people are typing…`
+      : null;
+
   const loadChatHistory = async () => {
     try {
       const response = await fetch(HISTORY_URL);
       if (!response.ok) throw new Error("Failed to fetch chat history");
 
       const history = await response.json();
-      setMessages(history.map((msg: any) => ({ sender: msg.sender, text: msg.message })));
+      setMessages(history.map((msg: any) => ({
+        sender: msg.sender,
+        text: msg.message,
+        id: msg.id,
+        grounding: msg.metadata?.grounding?.level
+      })));
       console.log("✅ Chat history loaded");
     } catch (error) {
       console.error("❌ Error loading chat history:", error);
@@ -113,27 +280,58 @@ const Chat: React.FC = () => {
   };
 
 
+  // Rate an AI answer; ratings feed the fine-tuning export
+  const sendFeedback = (messageId: number, rating: 1 | -1) => {
+    fetch(feedbackURL(messageId), {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ rating })
+    }).catch((err) => console.error("❌ Failed to send feedback:", err));
+  };
+
   // Scroll to bottom when messages change
   useEffect(() => {
     messagesEndRef.current?.scrollIntoView({ behavior: "smooth" });
   }, [messages]);
 
+  const primary = config.branding.primary_color || "#ec4899";
+  const accent = config.branding.accent_color || "#f43f5e";
+
   return (
     <Paper shadow="xs" p="md" style={{ maxWidth: 600, margin: "auto", marginTop: 50 }}>
+      {config.branding.logo_url && (
+        <img src={config.branding.logo_url} alt="" style={{ maxHeight: 48, marginBottom: 8 }} />
+      )}
       <h1>{title}</h1>
+      {announcements.map((a) => (
+        <Alert key={a.id} color={announcementColors[a.severity] || "blue"} mb="sm">
+          {a.message}
+        </Alert>
+      ))}
+      {incognito && (
+        <Alert color="gray" mb="sm">
+          🕶️ Incognito: {incognito.room ? "this room doesn't save messages" : "messages in this session aren't saved"}
+        </Alert>
+      )}
       <div style={{ marginBottom: "1rem" }}>
         <Text size="sm" c="dimmed">
           Region: {config.region} | Role: {config.role}
+          {presence && ` | 👥 ${presence.online_count} online`}
         </Text>
+        {Object.keys(roomSettings).length > 0 && (
+          <Text size="sm" c="dimmed">
+            ⚙️ {Object.entries(roomSettings).map(([key, value]) => `${key}: ${value}`).join(" | ")}
+          </Text>
+        )}
         
         {/* Model Status Indicator */}
-        <ModelStatus />
+        <ModelStatus pushed={modelStatus} />
         
         <div style={{
           display: "inline-block",
           padding: "4px 12px",
           borderRadius: "20px",
-          background: "linear-gradient(90deg, #ec4899, #f43f5e)",
+          background: `linear-gradient(90deg, ${primary}, ${accent})`,
           color: "white",
           fontSize: "12px",
           fontWeight: "bold",
@@ -142,12 +340,22 @@ const Chat: React.FC = () => {
           {config.version} 🎉
         </div>
       </div>
-      <Button onClick={loadChatHistory} mb="md" fullWidth>
-        Load Chat History
-      </Button>
+      <Group grow mb="md">
+        <Button onClick={loadChatHistory}>
+          Load Chat History
+        </Button>
+        <Button component="a" href={EXPORT_PDF_URL} variant="light">
+          Export PDF
+        </Button>
+      </Group>
 
       <ScrollArea style={{ height: 400, border: "1px solid #ccc", padding: 10 }}>
-        {messages.map((msg, index) => (
+        {messages.map((msg, index) => msg.sender === "System" ? (
+          // System events such as a change of model, shown inline between messages
+          <Text key={index} size="xs" c="dimmed" ta="center" mb="0.5rem">
+            {msg.text}
+          </Text>
+        ) : (
           <div key={index} style={{ marginBottom: "0.5rem" }}>
             <Text
               fw={700}
@@ -166,21 +374,62 @@ const Chat: React.FC = () => {
             <div className="chat-message">
               <ReactMarkdown>{msg.text}</ReactMarkdown>
             </div>
+            {msg.grounding === "low" && (
+              <Text size="xs" c="orange">
+                ⚠️ This answer isn't well supported by the knowledge base; please double-check it.
+              </Text>
+            )}
+            {msg.sender === "AI" && msg.id && config.features.feedback !== false && (
+              <Group gap={4}>
+                <Button variant="subtle" size="compact-xs" onClick={() => sendFeedback(msg.id!, 1)}>👍</Button>
+                <Button variant="subtle" size="compact-xs" onClick={() => sendFeedback(msg.id!, -1)}>👎</Button>
+              </Group>
+            )}
           </div>
         ))}
+        {notice && (
+          <Text size="xs" c="dimmed">
+            {notice}
+          </Text>
+        )}
+        {typingText && (
+          <Text size="xs" c="dimmed">
+            {typingText}
+          </Text>
+        )}
         <div ref={messagesEndRef} />
       </ScrollArea>
 
+      {followUps.length > 0 && (
+        <Group gap="xs" mt="sm">
+          {followUps.map((suggestion, index) => (
+            <Button key={index} variant="light" size="xs" radius="xl" onClick={() => sendMessage(suggestion)}>
+              {suggestion}
+            </Button>
+          ))}
+        </Group>
+      )}
+
       <TextInput
         value={input}
-        onChange={(e) => setInput(e.target.value)}
+        onChange={(e) => handleInputChange(e.target.value)}
         placeholder="Type a message..."
         onKeyPress={(e) => e.key === "Enter" && sendMessage()}
         mt="md"
       />
-      <Button onClick={sendMessage} mt="md" fullWidth className="send-button">
+      <Button onClick={() => sendMessage()} mt="md" fullWidth className="send-button">
         Send 🚀
       </Button>
+
+      {config.branding.footer_links && config.branding.footer_links.length > 0 && (
+        <Group gap="md" mt="md" justify="center">
+          {config.branding.footer_links.map((link, index) => (
+            <Text key={index} size="xs" c="dimmed" component="a" href={link.url} target="_blank" rel="noopener">
+              {link.label}
+            </Text>
+          ))}
+        </Group>
+      )}
     </Paper>
   );
 };
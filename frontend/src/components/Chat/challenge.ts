@@ -0,0 +1,46 @@
+const CHALLENGE_URL = "/api/challenge";
+
+// Leading zero bits of a digest
+const leadingZeroBits = (digest: Uint8Array): number => {
+  let zeros = 0;
+  for (const byte of digest) {
+    if (byte === 0) {
+      zeros += 8;
+      continue;
+    }
+    zeros += Math.clz32(byte) - 24;
+    break;
+  }
+  return zeros;
+};
+
+// Find a solution whose sha256(challenge + ":" + solution) has enough leading zero bits
+const solvePuzzle = async (challenge: string, difficulty: number): Promise<string> => {
+  const encoder = new TextEncoder();
+  for (let n = 0; ; n++) {
+    const solution = n.toString(36);
+    const digest = await crypto.subtle.digest("SHA-256", encoder.encode(`${challenge}:${solution}`));
+    if (leadingZeroBits(new Uint8Array(digest)) >= difficulty) return solution;
+  }
+};
+
+// obtainPass solves the server's proof-of-work challenge, if it has one, and returns the
+// pass to present when connecting. CAPTCHA modes need a widget on the embedding page.
+export const obtainPass = async (): Promise<string | null> => {
+  try {
+    const info = await (await fetch(CHALLENGE_URL)).json();
+    if (info.mode !== "pow") return null;
+
+    const solution = await solvePuzzle(info.challenge, info.difficulty);
+    const response = await fetch(CHALLENGE_URL, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ challenge: info.challenge, solution })
+    });
+    if (!response.ok) throw new Error(`Challenge rejected: ${response.status}`);
+    return (await response.json()).pass;
+  } catch (err) {
+    console.error("❌ Failed to pass the challenge:", err);
+    return null;
+  }
+};
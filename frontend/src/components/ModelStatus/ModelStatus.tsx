@@ -1,21 +1,35 @@
 import React, { useState, useEffect } from "react";
 import { Badge, Progress, Group, Loader } from "@mantine/core";
 
-interface ModelStatusData {
+export interface ModelStatusData {
   ready: boolean;
   status: string;
+  phase?: string;
   model: string;
+  progress?: {
+    attempt?: number;
+    max_attempts?: number;
+    model?: string;
+    percent?: number;
+    eta_seconds?: number;
+  };
 }
 
-const ModelStatus: React.FC = () => {
+// Status pushed over the WebSocket replaces polling once it arrives
+const ModelStatus: React.FC<{ pushed?: ModelStatusData | null }> = ({ pushed }) => {
   const [status, setStatus] = useState<ModelStatusData>({
     ready: false,
     status: "initializing",
     model: "unknown"
   });
 
-  // Poll for model status updates
   useEffect(() => {
+    if (pushed) setStatus(pushed);
+  }, [pushed]);
+
+  // Poll for model status updates until the server pushes them
+  useEffect(() => {
+    if (pushed) return;
     const pollStatus = async () => {
       try {
         const response = await fetch("/api/model-status");
@@ -31,7 +45,7 @@ const ModelStatus: React.FC = () => {
     const interval = setInterval(pollStatus, 2000);
 
     return () => clearInterval(interval);
-  }, []);
+  }, [pushed]);
 
   const getStatusInfo = () => {
     switch (status.status) {
@@ -56,6 +70,16 @@ const ModelStatus: React.FC = () => {
           progress: 40,
           icon: <Loader size="xs" />
         };
+      case "pulling_model": {
+        const percent = Math.floor(status.progress?.percent || 0);
+        const eta = status.progress?.eta_seconds ? ` · ${Math.ceil(status.progress.eta_seconds / 60)} min left` : "";
+        return {
+          color: "yellow",
+          text: `📥 Downloading ${status.progress?.model || "model"}: ${percent}%${eta}`,
+          progress: Math.max(percent, 1),
+          icon: <Loader size="xs" />
+        };
+      }
       case "testing_generation":
         return {
           color: "orange",
@@ -81,6 +105,20 @@ const ModelStatus: React.FC = () => {
           progress: 50,
           icon: <Loader size="xs" />
         };
+      case "degraded":
+        return {
+          color: "orange",
+          text: `⚠️ Degraded: ${status.model}`,
+          progress: 0,
+          icon: null
+        };
+      case "disabled":
+        return {
+          color: "gray",
+          text: "🚫 AI disabled",
+          progress: 0,
+          icon: null
+        };
       case "error_connecting":
         return {
           color: "red",